@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/richvanbergen/cbox/internal/config"
+	"github.com/richvanbergen/cbox/internal/sandbox"
+	"github.com/spf13/cobra"
+)
+
+// Exit codes let scripts tell failure modes apart.
+const (
+	exitError    = 1 // any other failure
+	exitUsage    = 2 // bad arguments or flags
+	exitNotFound = 3 // sandbox, container or serve process doesn't exist
+	exitDocker   = 4 // docker CLI failure
+	exitConfig   = 5 // cbox.toml missing, invalid, or lacking a required section
+)
+
+// usageError marks an argument or flag error from cobra.
+type usageError struct {
+	err error
+}
+
+func (e *usageError) Error() string { return e.err.Error() }
+
+func (e *usageError) Unwrap() error { return e.err }
+
+// markUsageErrors makes flag errors and argument validation failures of
+// root and all its subcommands report as usage errors.
+func markUsageErrors(root *cobra.Command) {
+	root.SetFlagErrorFunc(func(_ *cobra.Command, err error) error {
+		return &usageError{err: err}
+	})
+	wrapArgs(root)
+}
+
+// wrapArgs wraps the argument validator of cmd and its subcommands.
+func wrapArgs(cmd *cobra.Command) {
+	if validate := cmd.Args; validate != nil {
+		cmd.Args = func(c *cobra.Command, args []string) error {
+			if err := validate(c, args); err != nil {
+				return &usageError{err: err}
+			}
+			return nil
+		}
+	}
+	for _, sub := range cmd.Commands() {
+		wrapArgs(sub)
+	}
+}
+
+// exitCode maps an error returned from the root command to a process exit code.
+func exitCode(err error) int {
+	var usage *usageError
+	switch {
+	case errors.As(err, &usage), strings.HasPrefix(err.Error(), "unknown command"):
+		return exitUsage
+	case errors.Is(err, sandbox.ErrNoState),
+		errors.Is(err, sandbox.ErrContainerNotRunning),
+		errors.Is(err, sandbox.ErrServeNotRunning),
+		errors.Is(err, sandbox.ErrWorktreeMissing):
+		return exitNotFound
+	case errors.Is(err, sandbox.ErrDocker):
+		return exitDocker
+	case errors.Is(err, config.ErrInvalid), errors.Is(err, sandbox.ErrNoServeConfig):
+		return exitConfig
+	}
+	return exitError
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"testing"
+
+	"github.com/richvanbergen/cbox/internal/config"
+	"github.com/richvanbergen/cbox/internal/sandbox"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"generic", errors.New("boom"), exitError},
+		{"usage", &usageError{err: errors.New("accepts 1 arg(s)")}, exitUsage},
+		{"unknown command", errors.New(`unknown command "nope" for "cbox"`), exitUsage},
+		{"no state", fmt.Errorf("%w %q", sandbox.ErrNoState, "x"), exitNotFound},
+		{"not running", fmt.Errorf("wrapped: %w", sandbox.ErrContainerNotRunning), exitNotFound},
+		{"docker", fmt.Errorf("building image: %w", &sandbox.DockerError{Err: errors.New("exit 1")}), exitDocker},
+		{"missing git", fmt.Errorf("git worktree add: %w", &exec.Error{Name: "git", Err: exec.ErrNotFound}), exitError},
+		{"config", fmt.Errorf("loading: %w", config.ErrInvalid), exitConfig},
+		{"no serve config", sandbox.ErrNoServeConfig, exitConfig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.err); got != tt.want {
+				t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRootCmd_UsageErrorsAreMarked(t *testing.T) {
+	for _, args := range [][]string{
+		{"info"},
+		{"info", "a", "b"},
+		{"list", "--no-such-flag"},
+		{"serve", "status"},
+	} {
+		root := buildRootCmd()
+		root.SetArgs(args)
+		err := root.Execute()
+		if err == nil {
+			t.Errorf("%v: expected error", args)
+			continue
+		}
+		if got := exitCode(err); got != exitUsage {
+			t.Errorf("%v: exitCode = %d, want %d (err: %v)", args, got, exitUsage, err)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+//go:build integration
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/richvanbergen/cbox/internal/docker"
+	"github.com/richvanbergen/cbox/internal/output"
+	"github.com/richvanbergen/cbox/internal/sandbox"
+)
+
+// TestMain lets the test binary stand in for cbox: up starts the MCP host
+// command server by re-executing itself with "_mcp-proxy".
+func TestMain(m *testing.M) {
+	if os.Getenv("CBOX_TEST_RUN_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// TestLifecycle runs up, info, down and clean against a real docker daemon.
+// Run it with: go test -tags integration -run TestLifecycle ./cmd/cbox
+func TestLifecycle(t *testing.T) {
+	if exec.Command("docker", "info").Run() != nil {
+		t.Skip("docker not available")
+	}
+	t.Setenv("CBOX_TEST_RUN_MAIN", "1")
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	var out bytes.Buffer
+	prev, prevErr := output.SetOutput(&out), output.SetErrOutput(&out)
+	t.Cleanup(func() {
+		output.SetOutput(prev)
+		output.SetErrOutput(prevErr)
+		if t.Failed() {
+			t.Logf("cbox output:\n%s", out.String())
+		}
+	})
+
+	dir := integrationRepo(t)
+	if err := os.WriteFile(filepath.Join(dir, "cbox.toml"), []byte("host_commands = [\"git\"]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	const branch = "cbox-integration"
+	t.Cleanup(func() {
+		sandbox.CleanWithOptions(dir, branch, sandbox.CleanOptions{Quiet: true, Force: true})
+	})
+
+	if err := sandbox.UpWithOptions(dir, branch, sandbox.UpOptions{}); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	state, err := sandbox.LoadState(dir, branch)
+	if err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+
+	if running, err := docker.IsRunning(state.RuntimeContainer); err != nil || !running {
+		t.Errorf("container %s running = %v, %v after up", state.RuntimeContainer, running, err)
+	}
+	if err := exec.Command("docker", "network", "inspect", state.NetworkName).Run(); err != nil {
+		t.Errorf("network %s missing after up: %v", state.NetworkName, err)
+	}
+	if state.MCPProxyPort == 0 {
+		t.Fatal("MCP host command server not started")
+	}
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", state.MCPProxyPort), time.Second)
+	if err != nil {
+		t.Errorf("MCP server not listening on port %d: %v", state.MCPProxyPort, err)
+	} else {
+		conn.Close()
+	}
+	if state.MCPProbeError != "" {
+		t.Errorf("container can't reach the MCP server: %s", state.MCPProbeError)
+	}
+	mcpList, err := docker.ExecOutput(state.RuntimeContainer, "claude", "claude", "mcp", "list")
+	if err != nil || !strings.Contains(string(mcpList), "cbox-host") {
+		t.Errorf("cbox-host not registered in the container (%v): %s", err, mcpList)
+	}
+
+	if err := sandbox.Info(dir, branch); err != nil {
+		t.Errorf("Info: %v", err)
+	}
+
+	if err := sandbox.Down(dir, branch); err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+	if running, _ := docker.IsRunning(state.RuntimeContainer); running {
+		t.Errorf("container %s still running after down", state.RuntimeContainer)
+	}
+
+	if err := sandbox.CleanWithOptions(dir, branch, sandbox.CleanOptions{Force: true}); err != nil {
+		t.Fatalf("Clean: %v", err)
+	}
+	if status, err := docker.ContainerStatus(state.RuntimeContainer); err != nil || status != "" {
+		t.Errorf("container %s left behind after clean: %q, %v", state.RuntimeContainer, status, err)
+	}
+	if exec.Command("docker", "network", "inspect", state.NetworkName).Run() == nil {
+		t.Errorf("network %s left behind after clean", state.NetworkName)
+	}
+	if _, err := sandbox.LoadState(dir, branch); !errors.Is(err, sandbox.ErrNoState) {
+		t.Errorf("state after clean: %v, want ErrNoState", err)
+	}
+}
+
+// integrationRepo creates a git repository with one commit.
+func integrationRepo(t *testing.T) string {
+	t.Helper()
+	repo, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "--allow-empty", "-m", "init"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	return repo
+}
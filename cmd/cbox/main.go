@@ -1,10 +1,14 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
 	"os"
 	"os/exec"
+	"slices"
 	"strings"
 	"time"
 
@@ -13,11 +17,14 @@ import (
 	"github.com/richvanbergen/cbox/internal/backend"
 	"github.com/richvanbergen/cbox/internal/bridge"
 	"github.com/richvanbergen/cbox/internal/config"
+	"github.com/richvanbergen/cbox/internal/daemon"
 	"github.com/richvanbergen/cbox/internal/docker"
 	"github.com/richvanbergen/cbox/internal/hostcmd"
 	"github.com/richvanbergen/cbox/internal/output"
+	"github.com/richvanbergen/cbox/internal/redact"
 	"github.com/richvanbergen/cbox/internal/sandbox"
 	"github.com/richvanbergen/cbox/internal/serve"
+	"github.com/richvanbergen/cbox/internal/update"
 	"github.com/richvanbergen/cbox/internal/worktree"
 	"github.com/spf13/cobra"
 )
@@ -53,37 +60,160 @@ func buildRootCmd() *cobra.Command {
 		Version:       resolveVersion(),
 		SilenceUsage:  true,
 		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := resolveProjectFlag(); err != nil {
+				return err
+			}
+			if err := applyOutputFormat(cmd); err != nil {
+				return err
+			}
+			applyOutputStyle()
+			return nil
+		},
 	}
+	root.PersistentFlags().StringVar(&projectFlag, "project", "", "Project directory to operate on instead of the current directory's")
+	root.PersistentFlags().StringVar(&outputFormat, "output", "text", "Output format: text or json (list and info; also set by CBOX_OUTPUT)")
 
 	root.AddCommand(initCmd())
 	root.AddCommand(upCmd())
 	root.AddCommand(downCmd())
+	root.AddCommand(restartCmd())
+	root.AddCommand(renameCmd())
 	root.AddCommand(chatCmd())
 	root.AddCommand(openCmd())
+	root.AddCommand(attachCmd())
 	root.AddCommand(shellCmd())
 	root.AddCommand(listCmd())
 	root.AddCommand(infoCmd())
+	root.AddCommand(logsCmd())
+	root.AddCommand(syncCmd())
 	root.AddCommand(cleanCmd())
+	root.AddCommand(killCmd())
+	root.AddCommand(gcCmd())
 	root.AddCommand(serveCmd())
 	root.AddCommand(runCmd())
 	root.AddCommand(ejectCmd())
+	root.AddCommand(schemaCmd())
+	root.AddCommand(lintCmd())
 	root.AddCommand(completionCmd())
+	root.AddCommand(updateCmd())
+	root.AddCommand(daemonCmd())
 	root.AddCommand(bridgeProxyCmd())
 	root.AddCommand(mcpProxyCmd())
 	root.AddCommand(serveRunnerCmd())
+	root.AddCommand(daemonRunCmd())
 	root.AddCommand(testOutputCmd())
 
+	markUsageErrors(root)
 	return root
 }
 
 func main() {
 	if err := buildRootCmd().Execute(); err != nil {
 		output.Error("%v", err)
-		os.Exit(1)
+		if errors.Is(err, sandbox.ErrNoState) {
+			output.RenderBlock(output.ErrWriter(), output.TextBlock{Text: "Run 'cbox list' to see existing sandboxes, or 'cbox up <branch>' to create one."})
+		}
+		os.Exit(exitCode(err))
 	}
 }
 
+// outputFormat is the --output flag.
+var outputFormat string
+
+// applyOutputFormat turns on the output package's machine mode for
+// --output json, or CBOX_OUTPUT=json when the flag isn't given.
+func applyOutputFormat(cmd *cobra.Command) error {
+	format := outputFormat
+	if env := os.Getenv("CBOX_OUTPUT"); env != "" && !cmd.Flags().Changed("output") {
+		format = env
+	}
+	switch format {
+	case "text":
+		output.SetMachine(false)
+	case "json":
+		output.SetMachine(true)
+	default:
+		return &usageError{err: fmt.Errorf("unknown output format %q (want \"text\" or \"json\")", format)}
+	}
+	return nil
+}
+
+// applyOutputStyle selects the output glyph theme from CBOX_OUTPUT_STYLE,
+// falling back to the project's output_style setting. The config is only
+// loaded once something is rendered, so commands that print none of cbox's
+// own output (completion, the internal proxies) don't read it.
+func applyOutputStyle() {
+	if style := os.Getenv("CBOX_OUTPUT_STYLE"); style != "" {
+		theme, err := output.ThemeByName(style)
+		if err != nil {
+			output.Warning("%v", err)
+			return
+		}
+		output.SetTheme(theme)
+		return
+	}
+	output.SetThemeLoader(func() (output.Theme, error) {
+		cfg, err := config.Load(projectDir())
+		if err != nil {
+			return output.UnicodeTheme, nil
+		}
+		return output.ThemeByName(cfg.OutputStyle)
+	})
+}
+
+// projectFlag is the --project directory. When set it stands in for the
+// current directory everywhere, including the default branch.
+var projectFlag string
+
+// resolveProjectFlag makes --project absolute and checks that it names a
+// project: a directory with a cbox config or inside a git repository.
+func resolveProjectFlag() error {
+	if projectFlag == "" {
+		return nil
+	}
+	dir, err := filepath.Abs(projectFlag)
+	if err != nil {
+		return fmt.Errorf("--project: %w", err)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return fmt.Errorf("--project %s is not a directory", projectFlag)
+	}
+	if !isProject(projectRoot(dir)) {
+		return fmt.Errorf("--project %s has no %s and is not in a git repository", projectFlag, config.ConfigFile)
+	}
+	projectFlag = dir
+	return nil
+}
+
+// isProject reports whether dir has a cbox config or is in a git repository.
+func isProject(dir string) bool {
+	for _, name := range []string{config.ConfigFile, config.LegacyConfigFile} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+	cmd := exec.Command("git", "rev-parse", "--git-dir")
+	cmd.Dir = dir
+	return cmd.Run() == nil
+}
+
+// projectDir returns the project the current directory (or --project)
+// belongs to, which may be a parent directory or, inside a worktree, the
+// main repository.
 func projectDir() string {
+	return projectRoot(workingDir())
+}
+
+func projectRoot(dir string) string {
+	return worktree.ProjectRoot(dir, config.ConfigFile, config.LegacyConfigFile)
+}
+
+// workingDir returns --project if given, otherwise the current directory.
+func workingDir() string {
+	if projectFlag != "" {
+		return projectFlag
+	}
 	dir, err := os.Getwd()
 	if err != nil {
 		output.Error("%v", err)
@@ -92,6 +222,16 @@ func projectDir() string {
 	return dir
 }
 
+// currentBranch returns the branch checked out in the current directory. In a
+// worktree that is the worktree's branch, not the main repository's.
+func currentBranch() (string, error) {
+	branch, err := worktree.CurrentBranch(workingDir())
+	if err != nil {
+		return "", fmt.Errorf("getting current branch: %w", err)
+	}
+	return branch, nil
+}
+
 // sandboxCompletion returns a completion function that suggests existing cbox sandboxes.
 func sandboxCompletion() func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
 	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
@@ -99,10 +239,7 @@ func sandboxCompletion() func(*cobra.Command, []string, string) ([]string, cobra
 			return nil, cobra.ShellCompDirectiveNoFileComp
 		}
 
-		dir, err := os.Getwd()
-		if err != nil {
-			return nil, cobra.ShellCompDirectiveNoFileComp
-		}
+		dir := projectDir()
 
 		states, err := sandbox.ListStates(dir)
 		if err != nil {
@@ -122,10 +259,7 @@ func sandboxCompletion() func(*cobra.Command, []string, string) ([]string, cobra
 // runCmdCompletion completes branch name first, then command name from that branch's config.
 func runCmdCompletion() func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
 	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
-		dir, err := os.Getwd()
-		if err != nil {
-			return nil, cobra.ShellCompDirectiveNoFileComp
-		}
+		dir := projectDir()
 
 		// First arg: branch name
 		if len(args) == 0 {
@@ -148,7 +282,7 @@ func runCmdCompletion() func(*cobra.Command, []string, string) ([]string, cobra.
 			if err != nil {
 				return nil, cobra.ShellCompDirectiveNoFileComp
 			}
-			cfg, err := config.Load(state.ProjectDir)
+			cfg, err := config.LoadForBranch(state.ProjectDir, state.Branch)
 			if err != nil {
 				return nil, cobra.ShellCompDirectiveNoFileComp
 			}
@@ -172,10 +306,7 @@ func configCommandCompletion() func(*cobra.Command, []string, string) ([]string,
 			return nil, cobra.ShellCompDirectiveNoFileComp
 		}
 
-		dir, err := os.Getwd()
-		if err != nil {
-			return nil, cobra.ShellCompDirectiveNoFileComp
-		}
+		dir := projectDir()
 
 		cfg, err := config.Load(dir)
 		if err != nil {
@@ -193,33 +324,66 @@ func configCommandCompletion() func(*cobra.Command, []string, string) ([]string,
 }
 
 func initCmd() *cobra.Command {
-	return &cobra.Command{
+	var noDetect bool
+
+	cmd := &cobra.Command{
 		Use:   "init",
 		Short: "Create a cbox.toml config in the current project",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			dir := projectDir()
 
-			if _, err := os.Stat(config.ConfigFile); err == nil {
+			if _, err := os.Stat(filepath.Join(dir, config.ConfigFile)); err == nil {
 				return fmt.Errorf("%s already exists", config.ConfigFile)
 			}
-			if _, err := os.Stat(config.LegacyConfigFile); err == nil {
+			if _, err := os.Stat(filepath.Join(dir, config.LegacyConfigFile)); err == nil {
 				return fmt.Errorf("%s already exists (rename to %s to use the new name)", config.LegacyConfigFile, config.ConfigFile)
 			}
 
 			cfg := config.DefaultConfig()
+			var stacks []config.Stack
+			if !noDetect {
+				stacks = config.DetectStacks(dir)
+				cfg.ApplyStacks(stacks)
+			}
 			if err := cfg.Save(dir); err != nil {
 				return err
 			}
 
 			output.Success("Created %s", config.ConfigFile)
+			for _, s := range stacks {
+				output.Text("  Detected %s from %s", s.Name, s.Marker)
+			}
+			if len(cfg.Commands) > 0 {
+				names := slices.Sorted(maps.Keys(cfg.Commands))
+				output.Text("  Added commands: %s", strings.Join(names, ", "))
+			}
+			if len(stacks) > 0 {
+				var runtimes []string
+				for _, s := range stacks {
+					runtimes = append(runtimes, s.Runtime)
+				}
+				output.Text("Commands run on the host. To run them in the container, 'cbox eject' and install %s by adding these lines to Dockerfile.cbox before WORKDIR:", strings.Join(runtimes, ", "))
+				output.Text("")
+				for _, s := range stacks {
+					output.Text("  # %s", s.Runtime)
+					for _, line := range s.Dockerfile {
+						output.Text("  %s", line)
+					}
+				}
+				output.Text("")
+			}
 			output.Text("Edit the file to configure your backend, commands, env vars, and host commands.")
 			return nil
 		},
 	}
+
+	cmd.Flags().BoolVar(&noDetect, "no-detect", false, "Don't detect the project's stack; write the bare default config")
+	return cmd
 }
 
 func upCmd() *cobra.Command {
 	var rebuild bool
+	var forceRecreate bool
 
 	cmd := &cobra.Command{
 		Use:   "up [branch]",
@@ -228,43 +392,113 @@ func upCmd() *cobra.Command {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			dir := projectDir()
 			if len(args) == 0 {
-				branch, err := worktree.CurrentBranch(dir)
+				branch, err := currentBranch()
 				if err != nil {
-					return fmt.Errorf("getting current branch: %w", err)
+					return err
 				}
-				return sandbox.UpWithOptions(dir, branch, sandbox.UpOptions{Rebuild: rebuild, NoWorktree: true})
+				// Run in place unless the current directory is a worktree of
+				// another branch, whose existing worktree Up then reuses.
+				projectBranch, _ := worktree.CurrentBranch(dir)
+				return sandbox.UpWithOptions(dir, branch, sandbox.UpOptions{Rebuild: rebuild, ForceRecreate: forceRecreate, NoWorktree: branch == projectBranch})
 			}
-			return sandbox.Up(dir, args[0], rebuild)
+			return sandbox.UpWithOptions(dir, args[0], sandbox.UpOptions{Rebuild: rebuild, ForceRecreate: forceRecreate})
 		},
 	}
 
 	cmd.Flags().BoolVar(&rebuild, "rebuild", false, "Force a clean image rebuild (--no-cache)")
+	cmd.Flags().BoolVar(&forceRecreate, "force-recreate", false, "Recreate the container even if it is already running")
 	return cmd
 }
 
 func downCmd() *cobra.Command {
-	return &cobra.Command{
+	var all bool
+	cmd := &cobra.Command{
 		Use:               "down [branch]",
 		Short:             "Stop the sandboxed container (keeps worktree)",
 		Args:              cobra.RangeArgs(0, 1),
 		ValidArgsFunction: sandboxCompletion(),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			dir := projectDir()
+			if all {
+				if len(args) != 0 {
+					return fmt.Errorf("--all takes no branch argument")
+				}
+				return sandbox.DownAll(dir)
+			}
 			if len(args) == 0 {
-				branch, err := worktree.CurrentBranch(dir)
+				branch, err := currentBranch()
 				if err != nil {
-					return fmt.Errorf("getting current branch: %w", err)
+					return err
 				}
 				return sandbox.Down(dir, branch)
 			}
 			return sandbox.Down(dir, args[0])
 		},
 	}
+	cmd.Flags().BoolVar(&all, "all", false, "Stop every running sandbox in the project")
+	return cmd
+}
+
+func restartCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:               "restart [branch]",
+		Short:             "Restart the sandbox container in place, keeping its conversation history",
+		Args:              cobra.RangeArgs(0, 1),
+		ValidArgsFunction: sandboxCompletion(),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := projectDir()
+			if len(args) == 0 {
+				branch, err := currentBranch()
+				if err != nil {
+					return err
+				}
+				return sandbox.Restart(dir, branch)
+			}
+			return sandbox.Restart(dir, args[0])
+		},
+	}
+}
+
+func renameCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:               "rename <old-branch> <new-branch>",
+		Short:             "Rename a sandbox's branch, moving its worktree and keeping uncommitted work",
+		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: sandboxCompletion(),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return sandbox.Rename(projectDir(), args[0], args[1])
+		},
+	}
+}
+
+// openContainerPrefix marks an open command that should run inside the
+// sandbox container instead of on the host.
+const openContainerPrefix = "container:"
+
+// resolveOpenTarget strips the "container:" prefix from an open command and
+// reports whether it should run inside the container. The prefix forces
+// container execution regardless of the open_in_container config.
+func resolveOpenTarget(openCmd string, inContainer bool) (string, bool) {
+	if rest, ok := strings.CutPrefix(openCmd, openContainerPrefix); ok {
+		return strings.TrimSpace(rest), true
+	}
+	return openCmd, inContainer
+}
+
+// shouldOpen reports whether chat should run the open command: when --open
+// was passed, or when open_on_chat is configured and --no-open was not.
+func shouldOpen(cfg *config.Config, openChanged, noOpen bool) bool {
+	if noOpen {
+		return false
+	}
+	return openChanged || (cfg != nil && cfg.OpenOnChat)
 }
 
 // runOpenCommand resolves and runs the open command.
-// The command only runs if openFlag is true (i.e. --open was explicitly passed).
+// The command only runs if openFlag is true (see shouldOpen).
 // When openFlag is true, flagValue is used; if empty, falls back to cfg.Open.
+// Commands run on the host by default; open_in_container or a "container:"
+// prefix runs them in the sandbox container with $Dir set to /workspace.
 // Errors are warned about but don't block execution.
 func runOpenCommand(cfg *config.Config, openFlag bool, flagValue, projectDir, branch string) {
 	if !openFlag {
@@ -284,8 +518,23 @@ func runOpenCommand(cfg *config.Config, openFlag bool, flagValue, projectDir, br
 		return
 	}
 
+	inContainer := cfg != nil && cfg.OpenInContainer
+	openCmd, inContainer = resolveOpenTarget(openCmd, inContainer)
+	var editorEnv []string
+	if cfg != nil && cfg.Editor != "" {
+		editorEnv = []string{"EDITOR=" + cfg.Editor}
+	}
+
+	if inContainer {
+		args := append([]string{"env", "Dir=/workspace"}, editorEnv...)
+		if err := docker.Exec(state.RuntimeContainer, "claude", append(args, "sh", "-c", openCmd)...); err != nil {
+			output.Warning("Open command failed in container: %v", err)
+		}
+		return
+	}
+
 	c := exec.Command("sh", "-c", openCmd)
-	c.Env = append(os.Environ(), "Dir="+state.WorktreePath)
+	c.Env = append(append(os.Environ(), "Dir="+state.WorktreePath), editorEnv...)
 	c.Stdout = os.Stdout
 	c.Stderr = os.Stderr
 	if err := c.Run(); err != nil {
@@ -305,7 +554,7 @@ func openCmd() *cobra.Command {
 			dir := projectDir()
 			branch := args[0]
 
-			cfg, _ := config.Load(dir)
+			cfg, _ := config.LoadForBranch(dir, branch)
 
 			openExpr := openCmdFlag
 			if openExpr == "" && cfg != nil {
@@ -328,6 +577,13 @@ func chatCmd() *cobra.Command {
 	var prompt string
 	var openCmd string
 	var outputFormat string
+	var chatDir string
+	var last bool
+	var history bool
+	var model string
+	var noOpen bool
+	var resume bool
+	var force bool
 
 	cmd := &cobra.Command{
 		Use:               "chat <branch>",
@@ -338,39 +594,112 @@ func chatCmd() *cobra.Command {
 			dir := projectDir()
 			branch := args[0]
 
+			if history {
+				prompts, err := sandbox.LoadPromptHistory(dir, branch)
+				if err != nil {
+					return err
+				}
+				if len(prompts) == 0 {
+					output.Text("No prompt history for %s. Enable it with prompt_history = true in %s.", branch, config.ConfigFile)
+					return nil
+				}
+				for i, p := range prompts {
+					output.Text("%3d  %s", i+1, p)
+				}
+				return nil
+			}
+
+			if last {
+				if prompt != "" {
+					return fmt.Errorf("--last and --prompt are mutually exclusive")
+				}
+				lastPrompt, err := sandbox.LastPrompt(dir, branch)
+				if err != nil {
+					return err
+				}
+				prompt = lastPrompt
+			}
+
 			var chrome bool
-			cfg, _ := config.Load(dir)
+			cfg, _ := config.LoadForBranch(dir, branch)
 			if cfg != nil {
 				chrome = cfg.Browser
 			}
 
-			openFlag := cmd.Flags().Changed("open")
+			if noOpen && cmd.Flags().Changed("open") {
+				return fmt.Errorf("--open and --no-open are mutually exclusive")
+			}
+			openFlag := shouldOpen(cfg, cmd.Flags().Changed("open"), noOpen)
 			runOpenCommand(cfg, openFlag, openCmd, dir, branch)
 
 			if prompt != "" {
-				return sandbox.ChatPrompt(dir, branch, prompt, outputFormat)
+				if resume {
+					return fmt.Errorf("--continue and --prompt are mutually exclusive")
+				}
+				return sandbox.ChatPromptWithOptions(dir, branch, sandbox.PromptOptions{
+					Prompt:       prompt,
+					OutputFormat: outputFormat,
+					Model:        model,
+				})
 			}
-			return sandbox.Chat(dir, branch, chrome, "", false)
+			return sandbox.ChatWithOptions(dir, branch, sandbox.ChatOptions{
+				Chrome: chrome,
+				Resume: resume,
+				Model:  model,
+				Dir:    chatDir,
+				Force:  force,
+			})
 		},
 	}
 
 	cmd.Flags().StringVarP(&prompt, "prompt", "p", "", "Run a one-shot prompt instead of interactive mode")
 	cmd.Flags().StringVar(&openCmd, "open", "", "Run a command before chat (use $Dir for worktree path); omit value to use config default")
+	cmd.Flags().BoolVar(&noOpen, "no-open", false, "Don't run the open command, even if open_on_chat is set")
 	cmd.Flags().StringVar(&outputFormat, "output-format", "text", "Output format for one-shot mode: text, json, stream-json")
+	cmd.Flags().StringVar(&chatDir, "dir", "", "Worktree subdirectory to start in (overrides chat_dir config)")
+	cmd.Flags().BoolVar(&last, "last", false, "Re-run the most recent one-shot prompt (requires prompt_history)")
+	cmd.Flags().BoolVar(&history, "history", false, "List recorded prompts for the branch and exit")
+	cmd.Flags().StringVar(&model, "model", "", "Model to use for this session (overrides model config)")
+	cmd.Flags().BoolVarP(&resume, "continue", "c", false, "Continue the most recent conversation")
+	cmd.Flags().BoolVar(&force, "force", false, "Join the chat session even if another terminal is attached to it")
 	cmd.Flags().Lookup("open").NoOptDefVal = " "
 	return cmd
 }
 
+func attachCmd() *cobra.Command {
+	var readOnly bool
+
+	cmd := &cobra.Command{
+		Use:               "attach <branch>",
+		Short:             "Reconnect to a running interactive chat session",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: sandboxCompletion(),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return sandbox.AttachWithOptions(projectDir(), args[0], sandbox.AttachOptions{ReadOnly: readOnly})
+		},
+	}
+
+	cmd.Flags().BoolVar(&readOnly, "read-only", false, "Watch the session without sending input")
+	return cmd
+}
+
 func shellCmd() *cobra.Command {
-	return &cobra.Command{
+	var shellDir string
+	var root bool
+
+	cmd := &cobra.Command{
 		Use:               "shell <branch>",
 		Short:             "Open a shell in the sandbox container (for debugging)",
 		Args:              cobra.ExactArgs(1),
 		ValidArgsFunction: sandboxCompletion(),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return sandbox.Shell(projectDir(), args[0])
+			return sandbox.ShellWithOptions(projectDir(), args[0], sandbox.ShellOptions{Dir: shellDir, Root: root})
 		},
 	}
+
+	cmd.Flags().StringVar(&shellDir, "dir", "", "Worktree subdirectory to start in (overrides chat_dir config)")
+	cmd.Flags().BoolVar(&root, "root", false, "Open the shell as root, e.g. to debug permissions or install a tool temporarily")
+	return cmd
 }
 
 func listCmd() *cobra.Command {
@@ -379,10 +708,21 @@ func listCmd() *cobra.Command {
 		Short: "List all tracked sandboxes",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			dir := projectDir()
-			states, err := sandbox.ListStates(dir)
+			states, warnings, err := sandbox.ListStatesWithWarnings(dir)
 			if err != nil {
 				return err
 			}
+			for _, w := range warnings {
+				output.Warning("%s", w)
+			}
+
+			if output.Machine() {
+				statuses := make([]sandbox.Status, 0, len(states))
+				for _, s := range states {
+					statuses = append(statuses, sandbox.StatusOf(s))
+				}
+				return output.JSON(statuses)
+			}
 
 			if len(states) == 0 {
 				output.Text("No active sandboxes.")
@@ -410,9 +750,26 @@ func infoCmd() *cobra.Command {
 		Args:              cobra.ExactArgs(1),
 		ValidArgsFunction: sandboxCompletion(),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return sandbox.Info(projectDir(), args[0])
+			return sandbox.InfoWithOptions(projectDir(), args[0], sandbox.InfoOptions{JSON: output.Machine()})
+		},
+	}
+}
+
+func syncCmd() *cobra.Command {
+	var push bool
+
+	cmd := &cobra.Command{
+		Use:               "sync <branch>",
+		Short:             "Copy a remote sandbox's workspace back into its worktree",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: sandboxCompletion(),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return sandbox.SyncWithOptions(projectDir(), args[0], sandbox.SyncOptions{Push: push})
 		},
 	}
+
+	cmd.Flags().BoolVar(&push, "push", false, "Copy the worktree into the sandbox instead")
+	return cmd
 }
 
 func cleanCmd() *cobra.Command {
@@ -429,9 +786,9 @@ func cleanCmd() *cobra.Command {
 			var branch string
 			if len(args) == 0 {
 				var err error
-				branch, err = worktree.CurrentBranch(dir)
+				branch, err = currentBranch()
 				if err != nil {
-					return fmt.Errorf("getting current branch: %w", err)
+					return err
 				}
 			} else {
 				branch = args[0]
@@ -448,6 +805,80 @@ func cleanCmd() *cobra.Command {
 	return cmd
 }
 
+func killCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "kill [branch]",
+		Short: "Force-remove a stuck sandbox (keeps worktree and branch)",
+		Long: `Force-remove a sandbox when down or clean hangs. Host processes are
+SIGKILLed, containers are removed with docker rm -f, and the sandbox state
+is deleted. The worktree and branch are kept; 'cbox up' reuses them.`,
+		Args:              cobra.RangeArgs(0, 1),
+		ValidArgsFunction: sandboxCompletion(),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := projectDir()
+			if len(args) == 0 {
+				branch, err := currentBranch()
+				if err != nil {
+					return err
+				}
+				return sandbox.Kill(dir, branch)
+			}
+			return sandbox.Kill(dir, args[0])
+		},
+	}
+}
+
+func logsCmd() *cobra.Command {
+	var opts sandbox.LogsOptions
+
+	cmd := &cobra.Command{
+		Use:   "logs [branch]",
+		Short: "Show the output of headless prompts",
+		Long: `Show the output of the sandbox's headless prompts ('cbox chat -p'), which
+is copied to .cbox/logs/<branch>/prompt.log as it runs. Use -f to watch a
+prompt running in another terminal, and --since to skip earlier prompts.
+Interactive chat sessions are not logged; attach to them with 'cbox chat'
+instead.`,
+		Args:              cobra.RangeArgs(0, 1),
+		ValidArgsFunction: sandboxCompletion(),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := projectDir()
+			if len(args) == 0 {
+				branch, err := currentBranch()
+				if err != nil {
+					return err
+				}
+				return sandbox.LogsWithOptions(dir, branch, opts)
+			}
+			return sandbox.LogsWithOptions(dir, args[0], opts)
+		},
+	}
+
+	cmd.Flags().BoolVarP(&opts.Follow, "follow", "f", false, "Follow log output")
+	cmd.Flags().StringVar(&opts.Tail, "tail", "", "Number of lines to show from the end")
+	cmd.Flags().StringVar(&opts.Since, "since", "", "Only show prompts started since a duration ago (e.g. 10m) or a time (e.g. 2024-01-02T15:04:05Z)")
+	return cmd
+}
+
+func gcCmd() *cobra.Command {
+	var dryRun bool
+
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Remove orphaned containers and networks",
+		Long: `Remove the project's cbox-labeled containers and networks whose sandbox
+has no state file, e.g. after .cbox was deleted. Don't run it while
+'cbox up' is in progress: the sandbox's state is written last.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return sandbox.GCWithOptions(projectDir(), sandbox.GCOptions{DryRun: dryRun})
+		},
+	}
+
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "List orphaned resources without removing them")
+	return cmd
+}
+
 func serveCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "serve",
@@ -456,6 +887,8 @@ func serveCmd() *cobra.Command {
 
 	cmd.AddCommand(serveStartCmd())
 	cmd.AddCommand(serveStopCmd())
+	cmd.AddCommand(serveRestartCmd())
+	cmd.AddCommand(serveStatusCmd())
 	cmd.AddCommand(serveLogsCmd())
 	cmd.AddCommand(serveCleanCmd())
 
@@ -486,6 +919,30 @@ func serveStopCmd() *cobra.Command {
 	}
 }
 
+func serveRestartCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:               "restart <branch>",
+		Short:             "Restart the serve process without touching the container",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: sandboxCompletion(),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return sandbox.ServeRestart(projectDir(), args[0])
+		},
+	}
+}
+
+func serveStatusCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:               "status <branch>",
+		Short:             "Show whether the serve process and Traefik route are alive",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: sandboxCompletion(),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return sandbox.ServeStatus(projectDir(), args[0])
+		},
+	}
+}
+
 func serveLogsCmd() *cobra.Command {
 	var follow bool
 
@@ -529,7 +986,7 @@ func serveCleanCmd() *cobra.Command {
 
 func runCmd() *cobra.Command {
 	return &cobra.Command{
-		Use:   "run <branch> <command>",
+		Use:   "run <branch> <command> [-- args...]",
 		Short: "Run a named command from cbox.toml in the sandbox worktree",
 		Long: `Run a named command defined in the commands section of cbox.toml.
 The command runs in the sandbox worktree directory on the host.
@@ -540,8 +997,11 @@ For example, if your config has:
   build = "go build ./..."
   test = "go test ./..."
 
-Then 'cbox run my-branch build' will execute 'go build ./...' in the worktree for my-branch.`,
-		Args:              cobra.ExactArgs(2),
+Then 'cbox run my-branch build' will execute 'go build ./...' in the worktree for my-branch.
+
+Arguments after -- are shell-quoted and appended to the command, so
+'cbox run my-branch test -- -run TestFoo' executes 'go test ./... -run TestFoo'.`,
+		Args:              cobra.MinimumNArgs(2),
 		ValidArgsFunction: runCmdCompletion(),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			dir := projectDir()
@@ -553,7 +1013,7 @@ Then 'cbox run my-branch build' will execute 'go build ./...' in the worktree fo
 				return fmt.Errorf("loading sandbox state for %q: %w", branch, err)
 			}
 
-			cfg, err := config.Load(state.ProjectDir)
+			cfg, err := config.LoadForBranch(state.ProjectDir, state.Branch)
 			if err != nil {
 				return err
 			}
@@ -570,9 +1030,13 @@ Then 'cbox run my-branch build' will execute 'go build ./...' in the worktree fo
 				return fmt.Errorf("unknown command %q (available: %s)", name, strings.Join(available, ", "))
 			}
 
+			expr = config.ShellCommand(expr)
 			expr = strings.ReplaceAll(expr, "$Port", fmt.Sprintf("%d", state.ServePort))
 			expr = strings.ReplaceAll(expr, "$Branch", state.Branch)
 			expr = strings.ReplaceAll(expr, "$Dir", state.WorktreePath)
+			if extra := args[2:]; len(extra) > 0 {
+				expr += " " + shellJoin(extra)
+			}
 
 			c := exec.Command("sh", "-c", expr)
 			c.Dir = state.WorktreePath
@@ -584,6 +1048,124 @@ Then 'cbox run my-branch build' will execute 'go build ./...' in the worktree fo
 	}
 }
 
+// shellJoin single-quotes each argument so it survives sh -c unchanged.
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = "'" + strings.ReplaceAll(a, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
+
+func updateCmd() *cobra.Command {
+	var checkOnly bool
+	var yes bool
+
+	cmd := &cobra.Command{
+		Use:   "update",
+		Short: "Check for a newer cbox release and install it",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			current := resolveVersion()
+			rel, err := update.Latest()
+			if err != nil {
+				return err
+			}
+
+			switch {
+			case update.IsNewer(current, rel.TagName):
+				output.Text("A new version is available: %s (current %s)", rel.TagName, current)
+			case update.IsRelease(current):
+				output.Success("cbox %s is up to date", current)
+				return nil
+			default:
+				output.Text("Running a development build (%s); latest release is %s", current, rel.TagName)
+			}
+
+			if checkOnly {
+				return nil
+			}
+			if !yes && !confirm(fmt.Sprintf("Install %s?", rel.TagName)) {
+				return nil
+			}
+
+			exe, err := os.Executable()
+			if err != nil {
+				return fmt.Errorf("finding executable: %w", err)
+			}
+			if err := output.SpinWithStatus(fmt.Sprintf("Installing %s", rel.TagName), func(s *output.SpinStatus) error {
+				return update.ApplyWithOptions(rel, exe, update.ApplyOptions{Status: s.Update})
+			}); err != nil {
+				return err
+			}
+			output.Success("Updated to %s", rel.TagName)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&checkOnly, "check-only", false, "Only report whether a newer release exists")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Install without asking for confirmation")
+	return cmd
+}
+
+// confirm asks a yes/no question on stdin, defaulting to no.
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func schemaCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "schema",
+		Short: "Print a JSON Schema for cbox.toml",
+		Long: `Print a JSON Schema describing cbox.toml, for editor completion and CI checks.
+The schema is generated from the config definition, so it always matches
+the running cbox version.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			data, err := json.MarshalIndent(config.Schema(), "", "  ")
+			if err != nil {
+				return fmt.Errorf("marshaling schema: %w", err)
+			}
+			fmt.Println(string(data))
+			return nil
+		},
+	}
+}
+
+func lintCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "lint [file]",
+		Short: "Check cbox.toml for unknown keys, wrong types and invalid values",
+		Long: `Check a config file (the project's cbox.toml by default) against the
+schema printed by 'cbox schema', plus checks that need the values themselves:
+the backend name, port mappings, container_commands names, and that the
+dockerfile and env_file exist. Exits with status 5 if there are problems.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := filepath.Join(projectDir(), config.ConfigFile)
+			if len(args) == 1 {
+				path = args[0]
+			}
+
+			problems, err := config.Lint(path)
+			if err != nil {
+				return err
+			}
+			if len(problems) == 0 {
+				output.Success("%s: no problems found", path)
+				return nil
+			}
+			for _, p := range problems {
+				output.Warning("%s: %s", path, p)
+			}
+			return fmt.Errorf("%w: %d problem(s) in %s", config.ErrInvalid, len(problems), path)
+		},
+	}
+}
+
 func ejectCmd() *cobra.Command {
 	return &cobra.Command{
 		Use:   "eject",
@@ -591,7 +1173,7 @@ func ejectCmd() *cobra.Command {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			dir := projectDir()
 
-			cfg, err := config.Load(dir)
+			cfg, err := config.LoadProject(dir)
 			if err != nil {
 				return fmt.Errorf("could not load %s — run 'cbox init' first: %w", config.ConfigFile, err)
 			}
@@ -619,8 +1201,7 @@ func ejectCmd() *cobra.Command {
 				return fmt.Errorf("writing %s: %w", filename, err)
 			}
 
-			cfg.Dockerfile = filename
-			if err := cfg.Save(dir); err != nil {
+			if err := config.SetKey(dir, "dockerfile", filename); err != nil {
 				return fmt.Errorf("updating %s: %w", config.ConfigFile, err)
 			}
 
@@ -684,7 +1265,9 @@ Fish:
 }
 
 func testOutputCmd() *cobra.Command {
-	return &cobra.Command{
+	var showThinking bool
+
+	cmd := &cobra.Command{
 		Use:    "_test-output",
 		Short:  "Internal: render sample structured output blocks",
 		Hidden: true,
@@ -698,6 +1281,7 @@ func testOutputCmd() *cobra.Command {
 				output.WarningBlock{Message: "Port 8080 is already in use, using 8081"},
 				output.SuccessBlock{Message: "Sandbox running (container: cbox-feature-auth)"},
 				output.ProgressBlock{Message: "Running agent prompt..."},
+				output.ThinkingBlock{Thinking: "The auth package has no login handler yet.\nRead auth.go first to see the session types.\nThen add login.go alongside it."},
 				output.TextBlock{Text: "I'll help you implement the authentication module. Let me start by reading the existing code."},
 				output.ToolUseBlock{
 					ID:    "toolu_01ABC",
@@ -713,10 +1297,13 @@ func testOutputCmd() *cobra.Command {
 				output.SuccessBlock{Message: "Agent prompt completed"},
 				output.ErrorBlock{Message: "Failed to push branch: remote rejected"},
 			}
-			output.Render(os.Stdout, blocks)
+			output.RenderWithOptions(os.Stdout, blocks, output.RenderOptions{ShowThinking: showThinking})
 			return nil
 		},
 	}
+
+	cmd.Flags().BoolVar(&showThinking, "show-thinking", false, "Render thinking blocks in full")
+	return cmd
 }
 
 func serveRunnerCmd() *cobra.Command {
@@ -725,12 +1312,14 @@ func serveRunnerCmd() *cobra.Command {
 	var dir string
 	var network string
 	var branch string
+	var project, sandboxBranch string
 
 	cmd := &cobra.Command{
 		Use:    "_serve-runner",
 		Short:  "Internal: run a serve process with PORT injection",
 		Hidden: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			redactProxyOutput(project, sandboxBranch)
 			return serve.RunServeCommand(command, port, dir, network, branch)
 		},
 	}
@@ -741,40 +1330,126 @@ func serveRunnerCmd() *cobra.Command {
 	cmd.Flags().StringVar(&dir, "dir", "", "Working directory")
 	cmd.Flags().StringVar(&network, "network", "", "Docker network name (substituted as $Network)")
 	cmd.Flags().StringVar(&branch, "branch", "", "Branch name (substituted as $Branch)")
+	cmd.Flags().StringVar(&project, "project", "", "Project directory, for resolving env_commands to redact")
+	cmd.Flags().StringVar(&sandboxBranch, "sandbox", "", "Sandbox branch, for resolving env_commands to redact")
 	return cmd
 }
 
+// redactProxyOutput registers the env_commands values a proxy redacts from
+// its logs: the ones its parent passed, or, when the daemon restarted it
+// without them, the project's env_commands resolved afresh.
+func redactProxyOutput(project, branch string) {
+	if redact.FromEnv() || project == "" || branch == "" {
+		return
+	}
+	if err := sandbox.RedactEnvCommands(project, branch); err != nil {
+		output.Warning("env_commands values will not be redacted from this log: %v", err)
+	}
+}
+
 func mcpProxyCmd() *cobra.Command {
-	var worktreePath string
-	var commandsJSON string
-	var reportDir string
-	var logDir string
-	var commandTimeout time.Duration
+	var opts hostcmd.ProxyOptions
+	var commandsJSON, timeoutsJSON string
+	var project, branch string
 
 	cmd := &cobra.Command{
 		Use:    "_mcp-proxy [host-commands...]",
 		Short:  "Internal: MCP server for host and project commands",
 		Hidden: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			var namedCommands map[string]string
+			redactProxyOutput(project, branch)
 			if commandsJSON != "" {
-				if err := json.Unmarshal([]byte(commandsJSON), &namedCommands); err != nil {
+				if err := json.Unmarshal([]byte(commandsJSON), &opts.NamedCommands); err != nil {
 					return fmt.Errorf("parsing --commands JSON: %w", err)
 				}
 			}
-			return hostcmd.RunProxyCommand(worktreePath, args, namedCommands, reportDir, logDir, commandTimeout)
+			if timeoutsJSON != "" {
+				var timeouts map[string]string
+				if err := json.Unmarshal([]byte(timeoutsJSON), &timeouts); err != nil {
+					return fmt.Errorf("parsing --command-timeouts JSON: %w", err)
+				}
+				opts.Timeouts = make(map[string]time.Duration, len(timeouts))
+				for name, value := range timeouts {
+					d, err := time.ParseDuration(value)
+					if err != nil {
+						return fmt.Errorf("--command-timeouts: %s: %w", name, err)
+					}
+					opts.Timeouts[name] = d
+				}
+			}
+			if project != "" && branch != "" {
+				opts.Env = func() hostcmd.Environment { return sandbox.Environment(project, branch) }
+			}
+			opts.HostCommands = args
+			return hostcmd.RunProxyCommand(opts)
 		},
 	}
 
-	cmd.Flags().StringVar(&worktreePath, "worktree", "", "Host worktree path for path translation")
+	cmd.Flags().StringVar(&opts.WorktreePath, "worktree", "", "Host worktree path for path translation")
 	cmd.MarkFlagRequired("worktree")
 	cmd.Flags().StringVar(&commandsJSON, "commands", "", "JSON map of named project commands")
-	cmd.Flags().StringVar(&reportDir, "report-dir", "", "Directory for cbox_report tool output")
-	cmd.Flags().StringVar(&logDir, "log-dir", "", "Directory for command log files")
-	cmd.Flags().DurationVar(&commandTimeout, "command-timeout", 0, "Timeout for command execution (0 uses default of 120s)")
+	cmd.Flags().StringVar(&opts.ReportDir, "report-dir", "", "Directory for cbox_report tool output")
+	cmd.Flags().StringVar(&opts.LogDir, "log-dir", "", "Directory for command log files")
+	cmd.Flags().DurationVar(&opts.CommandTimeout, "command-timeout", 0, "Timeout for command execution (0 uses default of 120s)")
+	cmd.Flags().StringVar(&timeoutsJSON, "command-timeouts", "", "JSON map of named command to timeout, overriding --command-timeout")
+	cmd.Flags().IntVar(&opts.MaxConcurrent, "max-concurrent", 0, "Maximum number of commands run at once (0 means unlimited)")
+	cmd.Flags().IntVar(&opts.Port, "port", 0, "Port to listen on (0 picks a random free port)")
+	cmd.Flags().StringVar(&opts.Container, "container", "", "Sandbox container for --container-commands")
+	cmd.Flags().StringSliceVar(&opts.ContainerCommands, "container-commands", nil, "Named commands to run inside the container instead of on the host")
+	cmd.Flags().StringVar(&project, "project", "", "Project directory, for the cbox_env tool and env_commands redaction")
+	cmd.Flags().StringVar(&branch, "branch", "", "Sandbox branch, for the cbox_env tool and env_commands redaction")
 	return cmd
 }
 
+func daemonCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "daemon",
+		Short: "Manage the supervisor that restarts crashed proxies",
+		Long: `The daemon is an optional per-project supervisor. While it runs, 'cbox up'
+registers the MCP server, serve process and Traefik proxy with it, and it
+restarts them if they die. Without it, proxies run standalone as before.`,
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "start",
+		Short: "Start the daemon in the background",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return sandbox.DaemonStart(projectDir())
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "stop",
+		Short: "Stop the daemon, leaving proxies running standalone",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return sandbox.DaemonStop(projectDir())
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "status",
+		Short: "Show what the daemon supervises",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return sandbox.DaemonStatus(projectDir())
+		},
+	})
+
+	return cmd
+}
+
+func daemonRunCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:    "_daemon [project-dir]",
+		Short:  "Internal: run the proxy supervisor in the foreground",
+		Hidden: true,
+		Args:   cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return daemon.Run(args[0])
+		},
+	}
+}
+
 func bridgeProxyCmd() *cobra.Command {
 	return &cobra.Command{
 		Use:    "_bridge-proxy [socket-dir]",
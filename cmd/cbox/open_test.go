@@ -2,6 +2,8 @@ package main
 
 import (
 	"testing"
+
+	"github.com/richvanbergen/cbox/internal/config"
 )
 
 func TestOpenCmd_RequiresBranchArg(t *testing.T) {
@@ -36,3 +38,48 @@ func TestOpenCmd_HasOpenFlag(t *testing.T) {
 		t.Errorf("expected --open default to be empty, got %q", f.DefValue)
 	}
 }
+
+func TestResolveOpenTarget(t *testing.T) {
+	tests := []struct {
+		cmd         string
+		inContainer bool
+		wantCmd     string
+		wantIn      bool
+	}{
+		{"code $Dir", false, "code $Dir", false},
+		{"code $Dir", true, "code $Dir", true},
+		{"container: touch /workspace/.started", false, "touch /workspace/.started", true},
+		{"container:touch $Dir/.started", false, "touch $Dir/.started", true},
+	}
+	for _, tt := range tests {
+		gotCmd, gotIn := resolveOpenTarget(tt.cmd, tt.inContainer)
+		if gotCmd != tt.wantCmd || gotIn != tt.wantIn {
+			t.Errorf("resolveOpenTarget(%q, %v) = (%q, %v), want (%q, %v)",
+				tt.cmd, tt.inContainer, gotCmd, gotIn, tt.wantCmd, tt.wantIn)
+		}
+	}
+}
+
+func TestShouldOpen(t *testing.T) {
+	onChat := &config.Config{OpenOnChat: true}
+	tests := []struct {
+		name        string
+		cfg         *config.Config
+		openChanged bool
+		noOpen      bool
+		want        bool
+	}{
+		{"default", &config.Config{}, false, false, false},
+		{"nil config", nil, false, false, false},
+		{"--open", &config.Config{}, true, false, true},
+		{"open_on_chat", onChat, false, false, true},
+		{"open_on_chat with --no-open", onChat, false, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shouldOpen(tt.cfg, tt.openChanged, tt.noOpen); got != tt.want {
+				t.Errorf("shouldOpen() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
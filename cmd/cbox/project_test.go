@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveProjectFlag(t *testing.T) {
+	configured := t.TempDir()
+	os.WriteFile(filepath.Join(configured, "cbox.toml"), nil, 0644)
+	repo := t.TempDir()
+	if out, err := exec.Command("git", "init", "-q", repo).CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v: %s", err, out)
+	}
+	file := filepath.Join(configured, "notes.txt")
+	os.WriteFile(file, nil, 0644)
+
+	tests := []struct {
+		name    string
+		flag    string
+		wantErr string
+	}{
+		{"config", configured, ""},
+		{"git repository", repo, ""},
+		{"missing", filepath.Join(repo, "missing"), "is not a directory"},
+		{"file", file, "is not a directory"},
+		{"neither", t.TempDir(), "has no cbox.toml and is not in a git repository"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			projectFlag = tt.flag
+			t.Cleanup(func() { projectFlag = "" })
+			err := resolveProjectFlag()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("resolveProjectFlag() = %v", err)
+				}
+				if workingDir() != tt.flag {
+					t.Errorf("workingDir() = %q, want %q", workingDir(), tt.flag)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("resolveProjectFlag() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolveProjectFlag_Relative(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "cbox.toml"), nil, 0644)
+	t.Chdir(filepath.Dir(dir))
+
+	projectFlag = filepath.Base(dir)
+	t.Cleanup(func() { projectFlag = "" })
+	if err := resolveProjectFlag(); err != nil {
+		t.Fatalf("resolveProjectFlag() = %v", err)
+	}
+	if got := projectDir(); got != dir {
+		t.Errorf("projectDir() = %q, want %q", got, dir)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestShellJoin(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-run", "TestFoo"}, `'-run' 'TestFoo'`},
+		{[]string{"./pkg/..."}, `'./pkg/...'`},
+		{[]string{"a b"}, `'a b'`},
+		{[]string{"it's"}, `'it'\''s'`},
+		{[]string{"$HOME"}, `'$HOME'`},
+	}
+	for _, tt := range tests {
+		if got := shellJoin(tt.args); got != tt.want {
+			t.Errorf("shellJoin(%q) = %s, want %s", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestRunCmd_AcceptsTrailingArgs(t *testing.T) {
+	cmd := runCmd()
+	if err := cmd.Args(cmd, []string{"branch", "test", "-run", "TestFoo"}); err != nil {
+		t.Errorf("expected trailing args to be accepted: %v", err)
+	}
+	if err := cmd.Args(cmd, []string{"branch"}); err == nil {
+		t.Error("expected error when command name is missing")
+	}
+}
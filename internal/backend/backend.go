@@ -2,6 +2,7 @@ package backend
 
 import (
 	"fmt"
+	"io"
 	"strings"
 
 	"github.com/richvanbergen/cbox/internal/bridge"
@@ -24,18 +25,54 @@ type RuntimeSpec struct {
 	NetworkName    string
 	GitMounts      *docker.GitMountConfig
 	EnvVars        []string
+	ExtraEnv       map[string]string // plain values set by cbox, e.g. proxy settings
+	SecretEnv      map[string]string
 	EnvFile        string
 	BridgeMappings []bridge.ProxyMapping
 	Ports          []string
+	DockerRunArgs  []string // extra docker run arguments from docker_run_args
+	GPUs           string   // docker --gpus value, see docker.GPUsFlag
+	ShellHome      string   // host directory mounted at docker.ShellHome
 	HostCommands   []string
 	Commands       map[string]string
 	MCPPort        int
+	MCPServers     []docker.MCPServer // additional servers from mcp_servers
+	Resources      docker.Resources   // limits from [resources]
+	// EgressDenied limits outbound access to AllowHosts, through the
+	// egress proxy configured in ExtraEnv.
+	EgressDenied bool
+	AllowHosts   []string
+	// WorkspaceVolume replaces the worktree bind mount with a named volume
+	// for remote docker hosts (see docker.RunOptions).
+	WorkspaceVolume string
+	// Progress receives docker run's output, see docker.RunOptions.
+	Progress func(line string)
 }
 
 type ChatOptions struct {
 	Chrome        bool
 	InitialPrompt string
 	Resume        bool
+	Model         string
+	SystemPrompt  string // appended to the agent's system prompt (Claude only)
+	Workdir       string
+	ForwardEnv    []string
+}
+
+type PromptOptions struct {
+	Prompt       string
+	OutputFormat string
+	Model        string
+	SystemPrompt string    // appended to the agent's system prompt (Claude only)
+	Stdout       io.Writer // defaults to os.Stdout
+}
+
+type ShellOptions struct {
+	Workdir    string
+	ForwardEnv []string
+	Root       bool   // Shell only: exec as root instead of the backend's user
+	RCFile     string // Shell only: bash --rcfile, see docker.ShellRCFile
+	ReadOnly   bool   // Attach only: watch without sending input
 }
 
 type Backend interface {
@@ -46,11 +83,18 @@ type Backend interface {
 	ContainerName(projectName, branch string) string
 	RunContainer(spec RuntimeSpec, imageName string) (string, error)
 	InjectInstructions(containerName string, spec RuntimeSpec) error
-	RegisterMCP(containerName string, mcpPort int) error
+	// RegisterMCP registers the host MCP server (when mcpPort > 0) and any
+	// additional servers from config with the agent.
+	RegisterMCP(containerName string, mcpPort int, servers []docker.MCPServer) error
 	Chat(containerName string, opts ChatOptions) error
-	ChatPrompt(containerName, prompt, outputFormat string) error
-	Shell(containerName string) error
+	Attach(containerName string, opts ShellOptions) error
+	// ChatSessionClients reports whether an interactive chat session is
+	// running and how many terminals are attached to it.
+	ChatSessionClients(containerName string) (running bool, clients int)
+	ChatPrompt(containerName string, opts PromptOptions) error
+	Shell(containerName string, opts ShellOptions) error
 	HasConversationHistory(containerName string) (bool, error)
+	Version(containerName string) (string, error)
 	EmbeddedDockerfile() ([]byte, error)
 }
 
@@ -1,10 +1,16 @@
 package backend
 
 import (
+	"errors"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/richvanbergen/cbox/internal/docker"
+	"github.com/richvanbergen/cbox/internal/naming"
+	"github.com/richvanbergen/cbox/internal/output"
 )
 
 type ClaudeBackend struct{}
@@ -28,59 +34,151 @@ func (ClaudeBackend) ContainerName(projectName, branch string) string {
 
 func (b ClaudeBackend) RunContainer(spec RuntimeSpec, imageName string) (string, error) {
 	containerName := b.ContainerName(spec.ProjectName, spec.Branch)
-	extraEnv := map[string]string{
-		"CBOX_BRANCH": safeBranch(spec.Branch),
+	extraEnv := maps.Clone(spec.ExtraEnv)
+	if extraEnv == nil {
+		extraEnv = map[string]string{}
 	}
+	extraEnv["CBOX_BRANCH"] = naming.SafeBranch(spec.Branch)
 	var mounts []docker.Mount
+	secretEnv := spec.SecretEnv
 
 	// Prefer bind-mounting the host credentials file so the container stays
 	// in sync with the host's login state (e.g. OAuth token refreshes).
-	// Fall back to the Keychain env-var snapshot for hosts without the file.
+	// Fall back to an env-var snapshot from the host's credential store
+	// (macOS Keychain, or the Secret Service on Linux) for hosts without
+	// the file. A remote daemon can't see host files, so it gets a
+	// snapshot instead.
+	// Either snapshot goes through secretEnv so it stays off docker's argv.
+	setCredentials := func(creds string) {
+		secretEnv = maps.Clone(spec.SecretEnv)
+		if secretEnv == nil {
+			secretEnv = map[string]string{}
+		}
+		secretEnv["CLAUDE_CODE_CREDENTIALS"] = creds
+	}
 	credsPath := filepath.Join(os.Getenv("HOME"), ".claude", ".credentials.json")
-	if _, err := os.Stat(credsPath); err == nil {
+	if spec.WorkspaceVolume != "" {
+		if creds, err := os.ReadFile(credsPath); err == nil {
+			setCredentials(string(creds))
+		} else if creds := storedCredential(claudeCredentialService); creds != "" {
+			setCredentials(creds)
+		}
+	} else if _, err := os.Stat(credsPath); err == nil {
 		mounts = append(mounts, docker.Mount{
 			Source:   credsPath,
 			Target:   "/home/claude/.claude/.credentials.json",
 			ReadOnly: true,
 		})
-	} else if creds := keychainPassword("Claude Code-credentials"); creds != "" {
-		extraEnv["CLAUDE_CODE_CREDENTIALS"] = creds
+	} else if creds := storedCredential(claudeCredentialService); creds != "" {
+		setCredentials(creds)
+	}
+	if len(mounts) == 0 && secretEnv["CLAUDE_CODE_CREDENTIALS"] == "" && !hasAPIKey(spec) {
+		output.Warning("No Claude credentials found in %s or the %s; log in with 'claude' on the host or set ANTHROPIC_API_KEY", credsPath, credentials.Name())
+	}
+
+	if spec.ShellHome != "" {
+		mounts = append(mounts, docker.Mount{Source: spec.ShellHome, Target: docker.ShellHome})
 	}
 
 	err := docker.RunContainer(docker.RunOptions{
-		Name:           containerName,
-		Image:          imageName,
-		Network:        spec.NetworkName,
-		WorktreePath:   spec.WorktreePath,
-		GitMounts:      spec.GitMounts,
-		EnvVars:        spec.EnvVars,
-		ExtraEnv:       extraEnv,
-		EnvFile:        spec.EnvFile,
-		BridgeMappings: spec.BridgeMappings,
-		Ports:          spec.Ports,
-		Mounts:         mounts,
+		Name:            containerName,
+		Image:           imageName,
+		Network:         spec.NetworkName,
+		WorktreePath:    spec.WorktreePath,
+		GitMounts:       spec.GitMounts,
+		EnvVars:         spec.EnvVars,
+		ExtraEnv:        extraEnv,
+		SecretEnv:       secretEnv,
+		EnvFile:         spec.EnvFile,
+		BridgeMappings:  spec.BridgeMappings,
+		Ports:           spec.Ports,
+		Mounts:          mounts,
+		WorkspaceVolume: spec.WorkspaceVolume,
+		ExtraArgs:       spec.DockerRunArgs,
+		GPUs:            spec.GPUs,
+		Resources:       spec.Resources,
+		Labels:          docker.ResourceLabels(spec.ProjectDir, spec.Branch),
+		Progress:        spec.Progress,
 	})
 	return containerName, err
 }
 
+// claudeCredentialService is the credential store item Claude Code saves its
+// login under.
+const claudeCredentialService = "Claude Code-credentials"
+
+// hasAPIKey reports whether the container gets an ANTHROPIC_API_KEY, which
+// makes stored login credentials unnecessary.
+func hasAPIKey(spec RuntimeSpec) bool {
+	const key = "ANTHROPIC_API_KEY"
+	if spec.SecretEnv[key] != "" || spec.ExtraEnv[key] != "" {
+		return true
+	}
+	if slices.Contains(spec.EnvVars, key) && os.Getenv(key) != "" {
+		return true
+	}
+	if spec.EnvFile != "" {
+		data, err := os.ReadFile(spec.EnvFile)
+		return err == nil && strings.Contains(string(data), key+"=")
+	}
+	return false
+}
+
 func (ClaudeBackend) InjectInstructions(containerName string, spec RuntimeSpec) error {
-	return docker.InjectClaudeMD(containerName, spec.HostCommands, spec.Commands, spec.Ports)
+	return docker.InjectClaudeMD(containerName, spec.HostCommands, spec.Commands, spec.Ports, instructionExtras(spec)...)
 }
 
-func (ClaudeBackend) RegisterMCP(containerName string, mcpPort int) error {
-	return docker.InjectMCPConfig(containerName, mcpPort)
+func (ClaudeBackend) RegisterMCP(containerName string, mcpPort int, servers []docker.MCPServer) error {
+	var errs []error
+	if mcpPort > 0 {
+		errs = append(errs, docker.InjectMCPConfig(containerName, mcpPort))
+	}
+	for _, server := range servers {
+		errs = append(errs, docker.AddMCPServer(containerName, server))
+	}
+	return errors.Join(errs...)
 }
 
 func (ClaudeBackend) Chat(containerName string, opts ChatOptions) error {
-	return docker.Chat(containerName, opts.Chrome, opts.InitialPrompt, opts.Resume)
+	return docker.Chat(containerName, docker.ChatOptions{
+		Chrome:        opts.Chrome,
+		InitialPrompt: opts.InitialPrompt,
+		Resume:        opts.Resume,
+		Model:         opts.Model,
+		SystemPrompt:  opts.SystemPrompt,
+		Workdir:       opts.Workdir,
+		ForwardEnv:    opts.ForwardEnv,
+	})
+}
+
+func (ClaudeBackend) Attach(containerName string, opts ShellOptions) error {
+	return docker.Attach(containerName, docker.ExecOptions{User: "claude", ForwardEnv: opts.ForwardEnv, ReadOnly: opts.ReadOnly})
+}
+
+func (ClaudeBackend) ChatSessionClients(containerName string) (bool, int) {
+	return docker.ChatSessionClients(containerName, "claude")
 }
 
-func (ClaudeBackend) ChatPrompt(containerName, prompt, outputFormat string) error {
-	return docker.ChatPrompt(containerName, prompt, outputFormat)
+func (ClaudeBackend) ChatPrompt(containerName string, opts PromptOptions) error {
+	return docker.ChatPrompt(containerName, docker.PromptOptions{
+		Prompt:       opts.Prompt,
+		OutputFormat: opts.OutputFormat,
+		Model:        opts.Model,
+		SystemPrompt: opts.SystemPrompt,
+		Stdout:       opts.Stdout,
+	})
+}
+
+func (ClaudeBackend) Shell(containerName string, opts ShellOptions) error {
+	execOpts := docker.ExecOptions{Workdir: opts.Workdir, ForwardEnv: opts.ForwardEnv, RCFile: opts.RCFile}
+	if opts.Root {
+		execOpts.User = "root"
+	}
+	return docker.Shell(containerName, execOpts)
 }
 
-func (ClaudeBackend) Shell(containerName string) error {
-	return docker.Shell(containerName)
+func (ClaudeBackend) Version(containerName string) (string, error) {
+	return docker.ClaudeVersion(containerName)
 }
 
 func (ClaudeBackend) HasConversationHistory(containerName string) (bool, error) {
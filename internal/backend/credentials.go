@@ -0,0 +1,67 @@
+package backend
+
+import (
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// credentialStore looks up a login token saved by a desktop app, such as
+// the Claude Code or Cursor OAuth credentials. Lookup returns "" when the
+// item doesn't exist or the store can't be queried.
+type credentialStore interface {
+	Name() string
+	Lookup(service string) string
+}
+
+// keychain reads the macOS login keychain with `security`.
+type keychain struct{}
+
+func (keychain) Name() string { return "macOS Keychain" }
+
+func (keychain) Lookup(service string) string {
+	return commandOutput("security", "find-generic-password", "-s", service, "-w")
+}
+
+// secretService reads the freedesktop Secret Service (GNOME Keyring,
+// KWallet) with libsecret's secret-tool. Items are matched on their
+// "service" attribute.
+type secretService struct{}
+
+func (secretService) Name() string { return "Secret Service keyring" }
+
+func (secretService) Lookup(service string) string {
+	return commandOutput("secret-tool", "lookup", "service", service)
+}
+
+type noCredentialStore struct{}
+
+func (noCredentialStore) Name() string         { return "system keychain" }
+func (noCredentialStore) Lookup(string) string { return "" }
+
+// credentials is the host's credential store; tests replace it.
+var credentials = hostCredentialStore()
+
+func hostCredentialStore() credentialStore {
+	switch runtime.GOOS {
+	case "darwin":
+		return keychain{}
+	case "linux", "freebsd", "openbsd":
+		return secretService{}
+	}
+	return noCredentialStore{}
+}
+
+// storedCredential returns the secret saved under service in the host's
+// credential store, or "".
+func storedCredential(service string) string {
+	return credentials.Lookup(service)
+}
+
+func commandOutput(name string, args ...string) string {
+	out, err := exec.Command(name, args...).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
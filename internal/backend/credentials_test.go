@@ -0,0 +1,87 @@
+package backend
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/richvanbergen/cbox/internal/docker"
+)
+
+type fakeStore map[string]string
+
+func (fakeStore) Name() string                   { return "fake keyring" }
+func (s fakeStore) Lookup(service string) string { return s[service] }
+
+// recordRunner captures docker commands instead of running them.
+type recordRunner struct{ args, env [][]string }
+
+func (r *recordRunner) Run(c docker.Command) docker.Result {
+	r.args = append(r.args, c.Args)
+	r.env = append(r.env, c.Env)
+	return docker.Result{}
+}
+
+// runClaude starts a Claude container against a recording runner and
+// returns the docker run arguments and the environment given to docker.
+func runClaude(t *testing.T, store credentialStore, spec RuntimeSpec) (args, env string) {
+	t.Helper()
+	prevStore := credentials
+	credentials = store
+	t.Cleanup(func() { credentials = prevStore })
+	r := &recordRunner{}
+	prev := docker.SetRunner(r)
+	t.Cleanup(func() { docker.SetRunner(prev) })
+
+	spec.ProjectName, spec.Branch = "app", "main"
+	if _, err := (ClaudeBackend{}).RunContainer(spec, "cbox-app:claude"); err != nil {
+		t.Fatalf("RunContainer: %v", err)
+	}
+	return strings.Join(r.args[0], " "), strings.Join(r.env[0], " ")
+}
+
+func TestClaudeCredentials_FromStore(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	args, env := runClaude(t, fakeStore{claudeCredentialService: `{"token":"x"}`}, RuntimeSpec{})
+	if !strings.Contains(env, `CLAUDE_CODE_CREDENTIALS={"token":"x"}`) || !strings.Contains(args, "-e CLAUDE_CODE_CREDENTIALS ") {
+		t.Errorf("stored credentials not passed by name: args %s, env %s", args, env)
+	}
+	if strings.Contains(args, "token") {
+		t.Errorf("stored credentials on docker's command line: %s", args)
+	}
+}
+
+func TestClaudeCredentials_FilePreferred(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	credsPath := filepath.Join(home, ".claude", ".credentials.json")
+	os.MkdirAll(filepath.Dir(credsPath), 0755)
+	os.WriteFile(credsPath, []byte("{}"), 0600)
+
+	args, _ := runClaude(t, fakeStore{claudeCredentialService: "from-store"}, RuntimeSpec{})
+	if !strings.Contains(args, credsPath+":/home/claude/.claude/.credentials.json:ro") {
+		t.Errorf("credentials file not mounted: %s", args)
+	}
+	if strings.Contains(args, "from-store") {
+		t.Errorf("store consulted although the file exists: %s", args)
+	}
+}
+
+func TestHasAPIKey(t *testing.T) {
+	t.Setenv("ANTHROPIC_API_KEY", "")
+	if hasAPIKey(RuntimeSpec{EnvVars: []string{"ANTHROPIC_API_KEY"}}) {
+		t.Error("unset host variable counted as an API key")
+	}
+	t.Setenv("ANTHROPIC_API_KEY", "sk-test")
+	if !hasAPIKey(RuntimeSpec{EnvVars: []string{"ANTHROPIC_API_KEY"}}) {
+		t.Error("forwarded host variable not counted")
+	}
+
+	envFile := filepath.Join(t.TempDir(), ".env")
+	os.WriteFile(envFile, []byte("ANTHROPIC_API_KEY=sk-file\n"), 0600)
+	if !hasAPIKey(RuntimeSpec{EnvFile: envFile}) {
+		t.Error("env_file key not counted")
+	}
+}
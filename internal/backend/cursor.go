@@ -1,11 +1,13 @@
 package backend
 
 import (
+	"maps"
 	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/richvanbergen/cbox/internal/docker"
+	"github.com/richvanbergen/cbox/internal/naming"
 )
 
 type CursorBackend struct{}
@@ -31,24 +33,26 @@ func (CursorBackend) ContainerName(projectName, branch string) string {
 
 func (b CursorBackend) RunContainer(spec RuntimeSpec, imageName string) (string, error) {
 	containerName := b.ContainerName(spec.ProjectName, spec.Branch)
-	extraEnv := map[string]string{
-		"CBOX_BRANCH": safeBranch(spec.Branch),
+	extraEnv := maps.Clone(spec.ExtraEnv)
+	if extraEnv == nil {
+		extraEnv = map[string]string{}
 	}
+	extraEnv["CBOX_BRANCH"] = naming.SafeBranch(spec.Branch)
 	if apiKey := strings.TrimSpace(os.Getenv("CURSOR_API_KEY")); apiKey != "" {
 		extraEnv["CURSOR_API_KEY"] = apiKey
-	} else if authToken := keychainPassword("cursor-access-token"); authToken != "" {
+	} else if authToken := storedCredential("cursor-access-token"); authToken != "" {
 		extraEnv["CURSOR_AUTH_TOKEN"] = authToken
 	}
 
 	mounts := []docker.Mount{}
 
-	if spec.MCPPort > 0 {
-		cursorDir := filepath.Join(spec.ProjectDir, ".cbox", "cursor", safeBranch(spec.Branch), ".cursor")
+	if spec.MCPPort > 0 || len(spec.MCPServers) > 0 {
+		cursorDir := filepath.Join(spec.ProjectDir, ".cbox", "cursor", naming.SafeBranch(spec.Branch), ".cursor")
 		if err := mkdirAll(cursorDir); err != nil {
 			return "", err
 		}
 		mcpPath := filepath.Join(cursorDir, "mcp.json")
-		if err := writeFile(mcpPath, buildCursorMCPConfig(spec.WorktreePath, spec.MCPPort)); err != nil {
+		if err := writeFile(mcpPath, buildCursorMCPConfig(spec.WorktreePath, spec.MCPPort, spec.MCPServers)); err != nil {
 			return "", err
 		}
 		mounts = append(mounts, docker.Mount{
@@ -58,18 +62,29 @@ func (b CursorBackend) RunContainer(spec RuntimeSpec, imageName string) (string,
 		})
 	}
 
+	if spec.ShellHome != "" {
+		mounts = append(mounts, docker.Mount{Source: spec.ShellHome, Target: docker.ShellHome})
+	}
+
 	err := docker.RunContainer(docker.RunOptions{
-		Name:           containerName,
-		Image:          imageName,
-		Network:        spec.NetworkName,
-		WorktreePath:   spec.WorktreePath,
-		GitMounts:      spec.GitMounts,
-		EnvVars:        spec.EnvVars,
-		ExtraEnv:       extraEnv,
-		EnvFile:        spec.EnvFile,
-		BridgeMappings: spec.BridgeMappings,
-		Ports:          spec.Ports,
-		Mounts:         mounts,
+		Name:            containerName,
+		Image:           imageName,
+		Network:         spec.NetworkName,
+		WorktreePath:    spec.WorktreePath,
+		GitMounts:       spec.GitMounts,
+		EnvVars:         spec.EnvVars,
+		ExtraEnv:        extraEnv,
+		SecretEnv:       spec.SecretEnv,
+		EnvFile:         spec.EnvFile,
+		BridgeMappings:  spec.BridgeMappings,
+		Ports:           spec.Ports,
+		Mounts:          mounts,
+		WorkspaceVolume: spec.WorkspaceVolume,
+		ExtraArgs:       spec.DockerRunArgs,
+		GPUs:            spec.GPUs,
+		Resources:       spec.Resources,
+		Labels:          docker.ResourceLabels(spec.ProjectDir, spec.Branch),
+		Progress:        spec.Progress,
 	})
 	return containerName, err
 }
@@ -79,35 +94,64 @@ func (CursorBackend) InjectInstructions(_ string, spec RuntimeSpec) error {
 	return writeFile(filepath.Join(spec.WorktreePath, "CLAUDE.md"), content)
 }
 
-func (CursorBackend) RegisterMCP(string, int) error {
+func (CursorBackend) RegisterMCP(string, int, []docker.MCPServer) error {
 	return nil
 }
 
 func (CursorBackend) Chat(containerName string, opts ChatOptions) error {
 	args := []string{"agent", "--force", "--approve-mcps"}
+	if opts.Model != "" {
+		args = append(args, "--model", opts.Model)
+	}
 	if opts.Resume {
 		args = append(args, "--continue")
 	} else if opts.InitialPrompt != "" {
 		args = append(args, opts.InitialPrompt)
 	}
-	return docker.ExecInteractive(containerName, cursorUser, args...)
+	return docker.ExecInSession(containerName, docker.ExecOptions{User: cursorUser, Workdir: opts.Workdir, ForwardEnv: opts.ForwardEnv}, args...)
+}
+
+func (CursorBackend) Attach(containerName string, opts ShellOptions) error {
+	return docker.Attach(containerName, docker.ExecOptions{User: cursorUser, ForwardEnv: opts.ForwardEnv, ReadOnly: opts.ReadOnly})
+}
+
+func (CursorBackend) ChatSessionClients(containerName string) (bool, int) {
+	return docker.ChatSessionClients(containerName, cursorUser)
 }
 
-func (CursorBackend) ChatPrompt(containerName, prompt, outputFormat string) error {
+func (CursorBackend) ChatPrompt(containerName string, opts PromptOptions) error {
 	args := []string{
 		"agent",
 		"--print",
-		"--output-format", outputFormat,
+		"--output-format", opts.OutputFormat,
 		"--force",
 		"--trust",
 		"--approve-mcps",
-		prompt,
+	}
+	if opts.Model != "" {
+		args = append(args, "--model", opts.Model)
+	}
+	args = append(args, opts.Prompt)
+	if opts.Stdout != nil {
+		return docker.ExecTo(opts.Stdout, containerName, cursorUser, args...)
 	}
 	return docker.Exec(containerName, cursorUser, args...)
 }
 
-func (CursorBackend) Shell(containerName string) error {
-	return docker.ExecInteractive(containerName, cursorUser, "bash")
+func (CursorBackend) Shell(containerName string, opts ShellOptions) error {
+	user := cursorUser
+	if opts.Root {
+		user = "root"
+	}
+	return docker.Shell(containerName, docker.ExecOptions{User: user, Workdir: opts.Workdir, ForwardEnv: opts.ForwardEnv, RCFile: opts.RCFile})
+}
+
+func (CursorBackend) Version(containerName string) (string, error) {
+	out, err := docker.ExecOutput(containerName, cursorUser, "agent", "--version")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
 }
 
 func (CursorBackend) HasConversationHistory(containerName string) (bool, error) {
@@ -122,10 +166,6 @@ func (CursorBackend) EmbeddedDockerfile() ([]byte, error) {
 	return docker.EmbeddedDockerfileForTemplate("templates/Dockerfile.cursor.tmpl")
 }
 
-func safeBranch(branch string) string {
-	return strings.ReplaceAll(branch, "/", "-")
-}
-
 func mkdirAll(path string) error {
 	return os.MkdirAll(path, 0755)
 }
@@ -1,30 +1,19 @@
 package backend
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"strings"
 
 	"github.com/richvanbergen/cbox/internal/docker"
 )
 
 const (
-	cboxInstructionsStart = "<!-- cbox-generated-claude-md:start -->"
-	cboxInstructionsEnd   = "<!-- cbox-generated-claude-md:end -->"
+	cboxInstructionsStart = docker.ClaudeMDStart
+	cboxInstructionsEnd   = docker.ClaudeMDEnd
 )
 
-func keychainPassword(service string) string {
-	out, err := exec.Command("security", "find-generic-password", "-s", service, "-w").Output()
-	if err != nil {
-		return ""
-	}
-	return strings.TrimSpace(string(out))
-}
-
 func writeGeneratedFile(projectDir string, parts []string, filename, content string) (string, error) {
 	dirParts := append([]string{projectDir, ".cbox"}, parts...)
 	dir := filepath.Join(dirParts...)
@@ -39,40 +28,27 @@ func writeGeneratedFile(projectDir string, parts []string, filename, content str
 }
 
 func buildInstructions(spec RuntimeSpec) string {
-	return docker.BuildClaudeMD(spec.HostCommands, spec.Commands, spec.Ports)
+	return docker.BuildClaudeMD(spec.HostCommands, spec.Commands, spec.Ports, instructionExtras(spec)...)
 }
 
-func mergeWorkspaceClaudeMD(worktreePath, generated string) string {
-	existingPath := filepath.Join(worktreePath, "CLAUDE.md")
-	existing, err := os.ReadFile(existingPath)
-	managed := cboxInstructionsStart + "\n" + strings.TrimRight(generated, "\n") + "\n" + cboxInstructionsEnd
-	if err != nil || len(bytes.TrimSpace(existing)) == 0 {
-		return managed + "\n"
+// instructionExtras returns the optional instruction sections for spec.
+func instructionExtras(spec RuntimeSpec) []string {
+	var extras []string
+	if spec.EgressDenied {
+		extras = append(extras, docker.EgressSection(spec.AllowHosts))
 	}
-
-	content := string(existing)
-	if start := strings.Index(content, cboxInstructionsStart); start >= 0 {
-		if end := strings.Index(content[start:], cboxInstructionsEnd); end >= 0 {
-			end += start + len(cboxInstructionsEnd)
-			prefix := strings.TrimRight(content[:start], "\n")
-			suffix := strings.TrimLeft(content[end:], "\n")
-			switch {
-			case prefix == "" && suffix == "":
-				return managed + "\n"
-			case prefix == "":
-				return managed + "\n\n" + suffix
-			case suffix == "":
-				return prefix + "\n\n" + managed + "\n"
-			default:
-				return prefix + "\n\n" + managed + "\n\n" + suffix
-			}
-		}
+	if spec.GPUs != "" {
+		extras = append(extras, docker.GPUSection(spec.GPUs))
 	}
+	return extras
+}
 
-	return strings.TrimRight(content, "\n") + "\n\n" + managed + "\n"
+func mergeWorkspaceClaudeMD(worktreePath, generated string) string {
+	existing, _ := os.ReadFile(filepath.Join(worktreePath, "CLAUDE.md"))
+	return docker.MergeClaudeMD(string(existing), generated)
 }
 
-func buildCursorMCPConfig(worktreePath string, port int) string {
+func buildCursorMCPConfig(worktreePath string, port int, extra []docker.MCPServer) string {
 	cfg := map[string]any{}
 	existingPath := filepath.Join(worktreePath, ".cursor", "mcp.json")
 	if existing, err := os.ReadFile(existingPath); err == nil {
@@ -83,8 +59,17 @@ func buildCursorMCPConfig(worktreePath string, port int) string {
 	if servers == nil {
 		servers = map[string]any{}
 	}
-	servers["cbox-host"] = map[string]any{
-		"url": fmt.Sprintf("http://host.docker.internal:%d/mcp", port),
+	if port > 0 {
+		servers["cbox-host"] = map[string]any{
+			"url": fmt.Sprintf("http://host.docker.internal:%d/mcp", port),
+		}
+	}
+	for _, s := range extra {
+		server := map[string]any{"url": s.URL}
+		if len(s.Headers) > 0 {
+			server["headers"] = s.Headers
+		}
+		servers[s.Name] = server
 	}
 	cfg["mcpServers"] = servers
 
@@ -5,6 +5,8 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/richvanbergen/cbox/internal/docker"
 )
 
 func TestParseNameDefaultsToClaude(t *testing.T) {
@@ -34,7 +36,7 @@ func TestMergeWorkspaceClaudeMD_AppendsGeneratedInstructions(t *testing.T) {
 
 func TestBuildCursorMCPConfig_IncludesCboxHost(t *testing.T) {
 	dir := filepath.Join("testdata-does-not-exist")
-	cfg := buildCursorMCPConfig(dir, 4321)
+	cfg := buildCursorMCPConfig(dir, 4321, nil)
 	if !strings.Contains(cfg, `"cbox-host"`) {
 		t.Fatalf("expected cbox-host server in config: %s", cfg)
 	}
@@ -43,6 +45,18 @@ func TestBuildCursorMCPConfig_IncludesCboxHost(t *testing.T) {
 	}
 }
 
+func TestBuildCursorMCPConfig_AddsConfiguredServers(t *testing.T) {
+	cfg := buildCursorMCPConfig("testdata-does-not-exist", 0, []docker.MCPServer{
+		{Name: "db", URL: "http://host.docker.internal:9000/mcp", Headers: map[string]string{"X-Token": "abc"}},
+	})
+	if strings.Contains(cfg, `"cbox-host"`) {
+		t.Errorf("cbox-host should be left out without an MCP port: %s", cfg)
+	}
+	if !strings.Contains(cfg, `"db"`) || !strings.Contains(cfg, `"X-Token": "abc"`) {
+		t.Errorf("expected the db server with its headers: %s", cfg)
+	}
+}
+
 func TestCursorInjectInstructions_WritesClaudeMD(t *testing.T) {
 	dir := t.TempDir()
 	spec := RuntimeSpec{
@@ -2,9 +2,16 @@ package config
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"math"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/BurntSushi/toml"
 )
@@ -13,18 +20,126 @@ const ConfigFile = "cbox.toml"
 const LegacyConfigFile = ".cbox.toml"
 
 type Config struct {
-	Backend        string            `toml:"backend,omitempty"`
-	Commands       map[string]string `toml:"commands,omitempty"`
-	CommandTimeout int               `toml:"command_timeout,omitempty"`
-	Env            []string          `toml:"env,omitempty"`
-	EnvFile        string            `toml:"env_file,omitempty"`
-	Browser        bool              `toml:"browser,omitempty"`
-	HostCommands   []string          `toml:"host_commands,omitempty"`
-	CopyFiles      []string          `toml:"copy_files,omitempty"`
-	Ports          []string          `toml:"ports,omitempty"`
-	Dockerfile     string            `toml:"dockerfile,omitempty"`
-	Open           string            `toml:"open,omitempty"`
-	Serve          *ServeConfig      `toml:"serve,omitempty"`
+	Backend         string            `toml:"backend,omitempty"`
+	Model           string            `toml:"model,omitempty"`
+	ClaudeVersion   string            `toml:"claude_version,omitempty"`
+	Commands        map[string]string `toml:"commands,omitempty"`
+	InContainer     []string          `toml:"container_commands,omitempty"` // names from Commands run in the container
+	CommandTimeout  int               `toml:"command_timeout,omitempty"`
+	CommandTimeouts map[string]int    `toml:"command_timeouts,omitempty"` // seconds, by name from Commands
+	MaxConcurrent   int               `toml:"max_concurrent_commands,omitempty"`
+	Env             []string          `toml:"env,omitempty"`
+	EnvFile         string            `toml:"env_file,omitempty"`
+	EnvCommands     map[string]string `toml:"env_commands,omitempty"`
+	Browser         bool              `toml:"browser,omitempty"`
+	HostCommands    []string          `toml:"host_commands,omitempty"`
+	CopyFiles       []string          `toml:"copy_files"`
+	Ignore          []string          `toml:"ignore,omitempty"`
+	Ports           []string          `toml:"ports,omitempty"`
+	DockerRunArgs   []string          `toml:"docker_run_args,omitempty"`
+	GPUs            string            `toml:"gpus,omitempty"`
+	Dockerfile      string            `toml:"dockerfile,omitempty"`
+	Open            string            `toml:"open,omitempty"`
+	OpenInContainer bool              `toml:"open_in_container,omitempty"`
+	OpenOnChat      bool              `toml:"open_on_chat,omitempty"`
+	Editor          string            `toml:"editor,omitempty"` // $EDITOR for the open command
+	ForwardEnv      []string          `toml:"forward_env,omitempty"`
+	ChatDir         string            `toml:"chat_dir,omitempty"`
+	SystemPrompt    string            `toml:"chat_system_prompt,omitempty"` // text, or a file relative to the project
+	PromptHistory   bool              `toml:"prompt_history,omitempty"`
+	Daemon          bool              `toml:"daemon,omitempty"`
+	PreUp           string            `toml:"pre_up,omitempty"`
+	PostUp          string            `toml:"post_up,omitempty"`
+	OutputStyle     string            `toml:"output_style,omitempty"`
+	Remote          bool              `toml:"remote,omitempty"`
+	Serve           *ServeConfig      `toml:"serve,omitempty"`
+	Network         *NetworkConfig    `toml:"network,omitempty"`
+	Resources       *ResourcesConfig  `toml:"resources,omitempty"`
+	MCPServers      []MCPServer       `toml:"mcp_servers,omitempty"`
+}
+
+// MCPServer is an additional MCP server registered with the agent next to
+// cbox's own host server. Only network transports are supported; URL must
+// be reachable from inside the container.
+type MCPServer struct {
+	Name      string            `toml:"name"`
+	Transport string            `toml:"transport,omitempty"` // "http" (default) or "sse"
+	URL       string            `toml:"url"`
+	Headers   map[string]string `toml:"headers,omitempty"`
+}
+
+// NetworkConfig restricts what the sandbox container can reach.
+type NetworkConfig struct {
+	// Egress is "allow" (the default: normal outbound access) or "deny":
+	// the sandbox network gets no route out and only AllowHosts are
+	// reachable, through an egress proxy.
+	Egress     string   `toml:"egress,omitempty"`
+	AllowHosts []string `toml:"allow_hosts,omitempty"`
+	ProxyImage string   `toml:"proxy_image,omitempty"`
+}
+
+// ResourcesConfig caps what the runtime container can use. Unset fields
+// leave docker's default, which is no limit.
+type ResourcesConfig struct {
+	Memory    string `toml:"memory,omitempty"`     // docker --memory, e.g. "2g"
+	CPUs      string `toml:"cpus,omitempty"`       // docker --cpus, e.g. "1.5"
+	PidsLimit int    `toml:"pids_limit,omitempty"` // docker --pids-limit
+}
+
+// memoryPattern matches the sizes docker accepts for --memory (its
+// RAMInBytes): a number, an optional space, and an optional binary unit
+// from k to p, optionally followed by i and b.
+var memoryPattern = regexp.MustCompile(`^([0-9]+(\.[0-9]+)?) ?([kKmMgGtTpP])?[iI]?[bB]?$`)
+
+// minMemory is the smallest --memory docker accepts.
+const minMemory = 6 * 1024 * 1024
+
+// memoryBytes parses a --memory size as docker does, reporting whether it
+// is valid.
+func memoryBytes(s string) (float64, bool) {
+	m := memoryPattern.FindStringSubmatch(s)
+	if m == nil {
+		return 0, false
+	}
+	n, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, false
+	}
+	if m[3] != "" {
+		n *= math.Pow(1024, float64(strings.Index("kmgtp", strings.ToLower(m[3]))+1))
+	}
+	return n, true
+}
+
+// Check reports the first limit docker would reject.
+func (r *ResourcesConfig) Check() error {
+	if r == nil {
+		return nil
+	}
+	if r.Memory != "" {
+		n, ok := memoryBytes(r.Memory)
+		if !ok {
+			return fmt.Errorf("resources.memory %q is not a size like \"512m\" or \"2g\"", r.Memory)
+		}
+		if n < minMemory {
+			return fmt.Errorf("resources.memory %q is below docker's minimum of 6m", r.Memory)
+		}
+	}
+	if r.CPUs != "" {
+		if n, err := strconv.ParseFloat(r.CPUs, 64); err != nil || n <= 0 {
+			return fmt.Errorf("resources.cpus %q is not a positive number", r.CPUs)
+		}
+	}
+	if r.PidsLimit < -1 {
+		return fmt.Errorf("resources.pids_limit must be -1 (unlimited) or more")
+	}
+	return nil
+}
+
+// EgressDenied reports whether outbound access is restricted to the
+// network.allow_hosts list.
+func (c *Config) EgressDenied() bool {
+	return c.Network != nil && c.Network.Egress == "deny"
 }
 
 type ServeConfig struct {
@@ -35,6 +150,8 @@ type ServeConfig struct {
 	Port      int    `toml:"port,omitempty"`
 	ProxyPort int    `toml:"proxy_port,omitempty"`
 	Container string `toml:"container,omitempty"`
+
+	TraefikImage string `toml:"traefik_image,omitempty"`
 }
 
 func DefaultConfig() *Config {
@@ -42,24 +159,206 @@ func DefaultConfig() *Config {
 		Backend:      "claude",
 		Env:          []string{"ANTHROPIC_API_KEY"},
 		HostCommands: []string{"git", "gh"},
-		CopyFiles:    []string{".env"},
+		CopyFiles:    defaultCopyFiles(),
+	}
+}
+
+// defaultCopyFiles is used when copy_files is absent from every config file.
+// An explicit empty list (copy_files = []) disables copying.
+func defaultCopyFiles() []string {
+	return []string{".env"}
+}
+
+// GlobalConfigPath returns the user-wide config file that Load merges under
+// every project's cbox.toml: $XDG_CONFIG_HOME/cbox/config.toml, defaulting to
+// ~/.config/cbox/config.toml. It returns "" if no home directory is known.
+func GlobalConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
 	}
+	return filepath.Join(dir, "cbox", "config.toml")
 }
 
+// Load reads the global config, if any, and then the project config on top
+// of it. Every key set in the project file wins; lists are replaced rather
+// than appended and [commands]/[env_commands] entries are merged by name.
+// copy_files defaults to [".env"] when neither file sets it.
+// [branch."<glob>"] tables are ignored; see LoadForBranch.
+// ${VAR} and ${VAR:-default} references in commands, paths and MCP server
+// settings are expanded from the environment once everything is merged.
 func Load(projectDir string) (*Config, error) {
+	return LoadForBranch(projectDir, "")
+}
+
+// LoadForBranch is Load for one sandbox branch. After each file's top-level
+// keys, its [branch."<glob>"] tables whose glob matches branch are merged
+// on top in file order, so a later match wins. Globs use path.Match, so "*"
+// does not cross a "/" ("feature/*" matches "feature/login" but not
+// "feature/a/b"). An empty branch matches no tables.
+func LoadForBranch(projectDir, branch string) (*Config, error) {
+	var cfg Config
+	if global := GlobalConfigPath(); global != "" {
+		if _, err := os.Stat(global); err == nil {
+			if err := decodeFile(global, &cfg, branch); err != nil {
+				return nil, &loadError{err: fmt.Errorf("reading %s: %w", global, err)}
+			}
+		}
+	}
+	if err := decodeProject(projectDir, &cfg, branch); err != nil {
+		return nil, err
+	}
+	// The decoder leaves an absent list nil but makes `copy_files = []` an
+	// empty, non-nil slice.
+	if cfg.CopyFiles == nil {
+		cfg.CopyFiles = defaultCopyFiles()
+	}
+	cfg.expandEnv()
+	return &cfg, nil
+}
+
+// LoadProject reads only the project config, without the global config,
+// any defaults or environment expansion.
+// Use it when the config will be saved back, so global settings aren't
+// copied into the project file.
+func LoadProject(projectDir string) (*Config, error) {
+	var cfg Config
+	if err := decodeProject(projectDir, &cfg, ""); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// decodeProject decodes the project's config file into cfg, with the
+// overrides for branch.
+func decodeProject(projectDir string, cfg *Config, branch string) error {
+	if err := decodeFile(projectFile(projectDir), cfg, branch); err != nil {
+		return &loadError{err: fmt.Errorf("reading %s: %w", ConfigFile, err)}
+	}
+	return nil
+}
+
+// projectFile returns the project's config file, falling back to the legacy
+// hidden filename for existing projects.
+func projectFile(projectDir string) string {
 	path := filepath.Join(projectDir, ConfigFile)
 	if _, err := os.Stat(path); err != nil {
-		// Fall back to legacy hidden filename for existing projects.
 		legacy := filepath.Join(projectDir, LegacyConfigFile)
 		if _, legacyErr := os.Stat(legacy); legacyErr == nil {
-			path = legacy
+			return legacy
 		}
 	}
-	var cfg Config
-	if _, err := toml.DecodeFile(path, &cfg); err != nil {
-		return nil, fmt.Errorf("reading %s: %w", ConfigFile, err)
+	return path
+}
+
+// branchOverrides holds the [branch."<glob>"] tables of a config file,
+// undecoded until a branch is known.
+type branchOverrides struct {
+	Branch map[string]toml.Primitive `toml:"branch"`
+}
+
+// decodeFile decodes a config file into cfg and then, if branch is set, the
+// file's branch tables that match it. Decoding onto cfg merges: tables such
+// as [serve] and [commands] keep keys the override doesn't set, while lists
+// are replaced.
+func decodeFile(file string, cfg *Config, branch string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
 	}
-	return &cfg, nil
+	if _, err := toml.Decode(string(data), cfg); err != nil {
+		return err
+	}
+	if branch == "" {
+		return nil
+	}
+	var overrides branchOverrides
+	md, err := toml.Decode(string(data), &overrides)
+	if err != nil {
+		return err
+	}
+	for _, glob := range branchGlobs(md) {
+		if ok, _ := path.Match(glob, branch); !ok {
+			continue
+		}
+		if err := md.PrimitiveDecode(overrides.Branch[glob], cfg); err != nil {
+			return fmt.Errorf("[branch.%q]: %w", glob, err)
+		}
+	}
+	return nil
+}
+
+// branchGlobs returns the globs of a file's [branch."<glob>"] tables in the
+// order they appear.
+func branchGlobs(md toml.MetaData) []string {
+	var globs []string
+	for _, key := range md.Keys() {
+		if len(key) == 2 && key[0] == "branch" {
+			globs = append(globs, key[1])
+		}
+	}
+	return globs
+}
+
+// ErrInvalid matches errors returned by Load when the config file is
+// missing or can't be parsed, and by Lint when it can't be read.
+var ErrInvalid = errors.New("invalid config")
+
+// loadError wraps a Load failure so it matches ErrInvalid while keeping the
+// original message and cause.
+type loadError struct {
+	err error
+}
+
+func (e *loadError) Error() string { return e.err.Error() }
+
+func (e *loadError) Unwrap() error { return e.err }
+
+func (e *loadError) Is(target error) bool { return target == ErrInvalid }
+
+// SetKey sets a top-level string key in the project's config file. Unlike
+// Save it edits the file in place, so comments and [branch."<glob>"] tables
+// are kept. An existing top-level assignment of key is replaced; otherwise
+// the key is added before the first table.
+func SetKey(projectDir, key, value string) error {
+	path := projectFile(projectDir)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var assignment bytes.Buffer
+	if err := toml.NewEncoder(&assignment).Encode(map[string]string{key: value}); err != nil {
+		return fmt.Errorf("marshaling %s: %w", key, err)
+	}
+	line := strings.TrimRight(assignment.String(), "\n")
+
+	lines := strings.Split(string(data), "\n")
+	insert := len(lines)
+	for i, l := range lines {
+		trimmed := strings.TrimSpace(l)
+		if strings.HasPrefix(trimmed, "[") {
+			insert = i
+			break
+		}
+		if k, _, ok := strings.Cut(trimmed, "="); ok && strings.TrimSpace(k) == key {
+			lines[i] = line
+			return os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0644)
+		}
+	}
+	// Keep blank lines and a comment heading the next table after the key.
+	for insert > 0 {
+		prev := strings.TrimSpace(lines[insert-1])
+		if prev != "" && !strings.HasPrefix(prev, "#") {
+			break
+		}
+		insert--
+	}
+	lines = slices.Insert(lines, insert, line)
+	return os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0644)
 }
 
 func (c *Config) Save(projectDir string) error {
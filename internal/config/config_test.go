@@ -1,11 +1,25 @@
 package config
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
+func TestMain(m *testing.M) {
+	// Keep the developer's own global config out of every test.
+	dir, err := os.MkdirTemp("", "cbox-config-test")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("XDG_CONFIG_HOME", dir)
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
 func TestDefaultConfig_CopyFilesIncludesEnv(t *testing.T) {
 	cfg := DefaultConfig()
 	if len(cfg.CopyFiles) != 1 || cfg.CopyFiles[0] != ".env" {
@@ -207,6 +221,82 @@ func TestSaveAndLoad_RoundTripPorts(t *testing.T) {
 	}
 }
 
+func TestLoad_ResourcesConfig(t *testing.T) {
+	dir := t.TempDir()
+	content := `
+[resources]
+memory = "2g"
+cpus = "1.5"
+pids_limit = 512
+`
+	if err := os.WriteFile(filepath.Join(dir, ConfigFile), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	want := ResourcesConfig{Memory: "2g", CPUs: "1.5", PidsLimit: 512}
+	if cfg.Resources == nil || *cfg.Resources != want {
+		t.Fatalf("Resources = %+v, want %+v", cfg.Resources, want)
+	}
+	if err := cfg.Resources.Check(); err != nil {
+		t.Errorf("Check: %v", err)
+	}
+}
+
+func TestLoad_NoResourcesConfig(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ConfigFile), []byte("backend = \"claude\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Resources != nil {
+		t.Errorf("Resources = %+v, want nil when [resources] is absent", cfg.Resources)
+	}
+	if err := cfg.Resources.Check(); err != nil {
+		t.Errorf("Check on nil: %v", err)
+	}
+}
+
+func TestResourcesConfig_Check(t *testing.T) {
+	tests := []struct {
+		r    ResourcesConfig
+		want string
+	}{
+		{ResourcesConfig{Memory: "512m"}, ""},
+		{ResourcesConfig{Memory: "1.5GB"}, ""},
+		{ResourcesConfig{Memory: "1 t"}, ""},
+		{ResourcesConfig{Memory: "2PiB"}, ""},
+		{ResourcesConfig{Memory: "6m"}, ""},
+		{ResourcesConfig{Memory: "1024"}, "minimum"},
+		{ResourcesConfig{Memory: "5.9m"}, "minimum"},
+		{ResourcesConfig{Memory: "2  g"}, "resources.memory"},
+		{ResourcesConfig{Memory: "2x"}, "resources.memory"},
+		{ResourcesConfig{Memory: "two gigs"}, "resources.memory"},
+		{ResourcesConfig{CPUs: "0.5"}, ""},
+		{ResourcesConfig{CPUs: "0"}, "resources.cpus"},
+		{ResourcesConfig{CPUs: "all"}, "resources.cpus"},
+		{ResourcesConfig{PidsLimit: -1}, ""},
+		{ResourcesConfig{PidsLimit: -2}, "resources.pids_limit"},
+	}
+	for _, tt := range tests {
+		err := tt.r.Check()
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("Check(%+v) = %v, want nil", tt.r, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("Check(%+v) = %v, want error mentioning %s", tt.r, err, tt.want)
+		}
+	}
+}
+
 func TestLoad_ServeConfig(t *testing.T) {
 	dir := t.TempDir()
 	content := `
@@ -276,3 +366,324 @@ func TestSaveAndLoad_RoundTripCopyFiles(t *testing.T) {
 		t.Errorf("CopyFiles = %v, want [\".env\", \"data/fixtures\"]", loaded.CopyFiles)
 	}
 }
+
+func TestLoad_ErrorsMatchErrInvalid(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, err := Load(dir); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Load with no config: error = %v, want ErrInvalid", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, ConfigFile), []byte("backend = \n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(dir); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Load with bad TOML: error = %v, want ErrInvalid", err)
+	}
+}
+
+func writeGlobalConfig(t *testing.T, content string) {
+	t.Helper()
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	path := GlobalConfigPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGlobalConfigPath_XDG(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", "/tmp/xdg")
+	if got, want := GlobalConfigPath(), filepath.Join("/tmp/xdg", "cbox", "config.toml"); got != want {
+		t.Errorf("GlobalConfigPath() = %q, want %q", got, want)
+	}
+}
+
+func TestLoad_GlobalConfigMergedUnderProject(t *testing.T) {
+	writeGlobalConfig(t, `backend = "cursor"
+browser = true
+host_commands = ["git", "gh"]
+
+[commands]
+build = "make"
+lint = "golangci-lint run"
+`)
+	dir := t.TempDir()
+	content := `backend = "claude"
+host_commands = ["git"]
+
+[commands]
+build = "go build ./..."
+`
+	if err := os.WriteFile(filepath.Join(dir, ConfigFile), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Backend != "claude" {
+		t.Errorf("Backend = %q, want project value %q", cfg.Backend, "claude")
+	}
+	if !cfg.Browser {
+		t.Error("Browser = false, want global value true")
+	}
+	if len(cfg.HostCommands) != 1 || cfg.HostCommands[0] != "git" {
+		t.Errorf("HostCommands = %v, want project list [git]", cfg.HostCommands)
+	}
+	if cfg.Commands["build"] != "go build ./..." {
+		t.Errorf("Commands[build] = %q, want project value", cfg.Commands["build"])
+	}
+	if cfg.Commands["lint"] != "golangci-lint run" {
+		t.Errorf("Commands[lint] = %q, want global value", cfg.Commands["lint"])
+	}
+}
+
+func TestLoad_InvalidGlobalConfig(t *testing.T) {
+	writeGlobalConfig(t, "backend = \n")
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ConfigFile), []byte(`backend = "claude"`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Load(dir)
+	if !errors.Is(err, ErrInvalid) {
+		t.Fatalf("Load error = %v, want ErrInvalid", err)
+	}
+}
+
+func TestLoadProject_IgnoresGlobalConfig(t *testing.T) {
+	writeGlobalConfig(t, "browser = true\n")
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ConfigFile), []byte(`backend = "claude"`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadProject(dir)
+	if err != nil {
+		t.Fatalf("LoadProject: %v", err)
+	}
+	if cfg.Browser {
+		t.Error("LoadProject picked up the global browser setting")
+	}
+}
+
+func TestLoad_CopyFilesAbsentDefaultsToEnv(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ConfigFile), []byte(`backend = "claude"`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(cfg.CopyFiles) != 1 || cfg.CopyFiles[0] != ".env" {
+		t.Errorf("CopyFiles = %v, want [\".env\"] when not configured", cfg.CopyFiles)
+	}
+}
+
+func TestLoad_CopyFilesEmptyListHonored(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ConfigFile), []byte("copy_files = []\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(cfg.CopyFiles) != 0 {
+		t.Errorf("CopyFiles = %v, want empty for copy_files = []", cfg.CopyFiles)
+	}
+
+	// Saving must keep the empty list rather than dropping the key, which
+	// would bring the default back.
+	project, err := LoadProject(dir)
+	if err != nil {
+		t.Fatalf("LoadProject: %v", err)
+	}
+	if err := project.Save(dir); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	cfg, err = Load(dir)
+	if err != nil {
+		t.Fatalf("Load after Save: %v", err)
+	}
+	if len(cfg.CopyFiles) != 0 {
+		t.Errorf("CopyFiles after Save = %v, want empty", cfg.CopyFiles)
+	}
+}
+
+func TestLoad_ProjectEmptyCopyFilesOverridesGlobal(t *testing.T) {
+	writeGlobalConfig(t, `copy_files = [".env", "node_modules"]`+"\n")
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ConfigFile), []byte("copy_files = []\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(cfg.CopyFiles) != 0 {
+		t.Errorf("CopyFiles = %v, want empty", cfg.CopyFiles)
+	}
+}
+
+const branchConfig = `ports = ["3000"]
+
+[commands]
+test = "go test ./..."
+
+[serve]
+command = "npm run dev"
+port = 3000
+
+[branch."feature/*"]
+ports = ["3000", "9229"]
+
+[branch."feature/*".serve]
+command = "npm run dev:debug"
+
+[branch."feature/login"]
+model = "opus"
+
+[branch."feature/login".commands]
+e2e = "npm run e2e"
+`
+
+func TestLoadForBranch_MergesMatchingTables(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ConfigFile), []byte(branchConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadForBranch(dir, "feature/login")
+	if err != nil {
+		t.Fatalf("LoadForBranch: %v", err)
+	}
+	if strings.Join(cfg.Ports, ",") != "3000,9229" {
+		t.Errorf("Ports = %v, want the feature/* list", cfg.Ports)
+	}
+	if cfg.Serve.Command != "npm run dev:debug" || cfg.Serve.Port != 3000 {
+		t.Errorf("Serve = %+v, want the command overridden and the port kept", cfg.Serve)
+	}
+	if cfg.Model != "opus" {
+		t.Errorf("Model = %q, want opus", cfg.Model)
+	}
+	if cfg.Commands["test"] != "go test ./..." || cfg.Commands["e2e"] != "npm run e2e" {
+		t.Errorf("Commands = %v, want both test and e2e", cfg.Commands)
+	}
+}
+
+func TestLoadForBranch_NoMatchOrNoBranch(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ConfigFile), []byte(branchConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, branch := range []string{"", "main", "feature/a/b"} {
+		cfg, err := LoadForBranch(dir, branch)
+		if err != nil {
+			t.Fatalf("LoadForBranch(%q): %v", branch, err)
+		}
+		if strings.Join(cfg.Ports, ",") != "3000" || cfg.Serve.Command != "npm run dev" || len(cfg.Commands) != 1 {
+			t.Errorf("LoadForBranch(%q) applied an override: %+v", branch, cfg)
+		}
+	}
+}
+
+func TestSetKey_KeepsBranchTables(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ConfigFile), []byte(branchConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SetKey(dir, "dockerfile", "Dockerfile.cbox"); err != nil {
+		t.Fatalf("SetKey: %v", err)
+	}
+	cfg, err := LoadForBranch(dir, "feature/login")
+	if err != nil {
+		t.Fatalf("LoadForBranch: %v", err)
+	}
+	if cfg.Dockerfile != "Dockerfile.cbox" {
+		t.Errorf("Dockerfile = %q, want Dockerfile.cbox", cfg.Dockerfile)
+	}
+	if strings.Join(cfg.Ports, ",") != "3000,9229" {
+		t.Errorf("Ports = %v, want the feature/* override kept", cfg.Ports)
+	}
+
+	if err := SetKey(dir, "dockerfile", "Dockerfile.dev"); err != nil {
+		t.Fatalf("SetKey: %v", err)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, ConfigFile))
+	if n := strings.Count(string(data), "dockerfile ="); n != 1 {
+		t.Errorf("dockerfile assigned %d times, want it replaced:\n%s", n, data)
+	}
+	if cfg, err := Load(dir); err != nil || cfg.Dockerfile != "Dockerfile.dev" {
+		t.Errorf("Load() = %+v, %v, want Dockerfile.dev", cfg, err)
+	}
+}
+
+func TestLoad_ExpandsEnv(t *testing.T) {
+	t.Setenv("CBOX_TEST_TOKEN", "s3cret")
+	t.Setenv("CBOX_TEST_EMPTY", "")
+	dir := t.TempDir()
+	content := `open = "code ${CBOX_TEST_TOKEN}"
+dockerfile = "${CBOX_TEST_MISSING:-docker}/Dockerfile"
+
+[commands]
+test = "go test ${CBOX_TEST_MISSING}./..."
+loop = "for f in *; do echo $${f}; done"
+
+[serve]
+command = "serve --token=${CBOX_TEST_EMPTY:-dev}"
+
+[[mcp_servers]]
+name = "docs"
+url = "http://host:${CBOX_TEST_PORT:-8080}/mcp"
+headers = { Authorization = "Bearer ${CBOX_TEST_TOKEN}" }
+`
+	if err := os.WriteFile(filepath.Join(dir, ConfigFile), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	for _, c := range []struct{ name, got, want string }{
+		{"open", cfg.Open, "code s3cret"},
+		{"dockerfile", cfg.Dockerfile, "docker/Dockerfile"},
+		{"commands.test", cfg.Commands["test"], "go test ${CBOX_TEST_MISSING}./..."},
+		{"commands.loop", cfg.Commands["loop"], "for f in *; do echo $${f}; done"},
+		{"serve.command", cfg.Serve.Command, "serve --token=dev"},
+		{"mcp_servers.url", cfg.MCPServers[0].URL, "http://host:8080/mcp"},
+		{"mcp_servers.headers", cfg.MCPServers[0].Headers["Authorization"], "Bearer ${CBOX_TEST_TOKEN}"},
+	} {
+		if c.got != c.want {
+			t.Errorf("%s = %q, want %q", c.name, c.got, c.want)
+		}
+	}
+
+	// Commands keep their references for the shell to expand when they run.
+	if got := ShellCommand(cfg.Commands["loop"]); got != "for f in *; do echo ${f}; done" {
+		t.Errorf("ShellCommand(loop) = %q", got)
+	}
+	if got := strings.Join(EnvRefs("a ${B} $${C} ${A:-x} ${B}"), ","); got != "A,B" {
+		t.Errorf("EnvRefs() = %q, want A,B", got)
+	}
+
+	// The project file as written, for saving back, keeps the references.
+	raw, err := LoadProject(dir)
+	if err != nil {
+		t.Fatalf("LoadProject: %v", err)
+	}
+	if raw.Open != "code ${CBOX_TEST_TOKEN}" {
+		t.Errorf("LoadProject expanded open: %q", raw.Open)
+	}
+}
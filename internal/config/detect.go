@@ -0,0 +1,173 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// Stack is a project toolchain recognised by DetectStacks.
+type Stack struct {
+	Name     string            // e.g. "Go"
+	Marker   string            // the file that identified it, e.g. "go.mod"
+	Runtime  string            // what the container needs to run the commands itself
+	Commands map[string]string // suggested [commands] entries
+
+	// Dockerfile holds the lines that install Runtime in cbox's Debian
+	// base image, for an ejected Dockerfile. They run as root.
+	Dockerfile []string
+}
+
+// aptInstall is a Dockerfile line installing Debian packages.
+func aptInstall(packages string) string {
+	return "RUN apt-get update && apt-get install -y " + packages + " && rm -rf /var/lib/apt/lists/*"
+}
+
+// DetectStacks inspects projectDir for the manifests of common toolchains
+// (go.mod, package.json, Cargo.toml, pyproject.toml) and returns a Stack for
+// each one found, in that order.
+func DetectStacks(projectDir string) []Stack {
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(projectDir, name))
+		return err == nil
+	}
+
+	var stacks []Stack
+	if exists("go.mod") {
+		stacks = append(stacks, Stack{
+			Name:    "Go",
+			Marker:  "go.mod",
+			Runtime: "Go",
+			Commands: map[string]string{
+				"setup": "go mod download",
+				"build": "go build ./...",
+				"test":  "go test ./...",
+			},
+			// Debian's golang package lags well behind what go.mod files ask for.
+			Dockerfile: []string{
+				"ARG GO_VERSION=1.24.4",
+				"RUN curl -fsSL https://go.dev/dl/go${GO_VERSION}.linux-$(dpkg --print-architecture).tar.gz | tar -C /usr/local -xz",
+				`ENV PATH="/usr/local/go/bin:/home/claude/go/bin:${PATH}"`,
+			},
+		})
+	}
+	if exists("package.json") {
+		stacks = append(stacks, nodeStack(projectDir, exists))
+	}
+	if exists("Cargo.toml") {
+		stacks = append(stacks, Stack{
+			Name:    "Rust",
+			Marker:  "Cargo.toml",
+			Runtime: "Rust (cargo)",
+			Commands: map[string]string{
+				"build": "cargo build",
+				"test":  "cargo test",
+				"run":   "cargo run",
+			},
+			// rustup installs into the home of the user that runs it.
+			Dockerfile: []string{
+				aptInstall("build-essential"),
+				"USER claude",
+				"RUN curl -fsSL https://sh.rustup.rs | sh -s -- -y --profile minimal",
+				`ENV PATH="/home/claude/.cargo/bin:${PATH}"`,
+				"USER root",
+			},
+		})
+	}
+	if exists("pyproject.toml") {
+		stacks = append(stacks, pythonStack(exists))
+	}
+	return stacks
+}
+
+// nodeStack picks the package manager from the lockfile and only suggests
+// the package.json scripts that exist.
+func nodeStack(projectDir string, exists func(string) bool) Stack {
+	pm := "npm"
+	switch {
+	case exists("pnpm-lock.yaml"):
+		pm = "pnpm"
+	case exists("yarn.lock"):
+		pm = "yarn"
+	case exists("bun.lockb"), exists("bun.lock"):
+		pm = "bun"
+	}
+	stack := Stack{
+		Name:     "Node.js (" + pm + ")",
+		Marker:   "package.json",
+		Runtime:  "Node.js",
+		Commands: map[string]string{"setup": pm + " install"},
+		Dockerfile: []string{
+			"RUN curl -fsSL https://deb.nodesource.com/setup_22.x | bash - && apt-get install -y nodejs && rm -rf /var/lib/apt/lists/*",
+		},
+	}
+	switch pm {
+	case "pnpm", "yarn":
+		stack.Dockerfile = append(stack.Dockerfile, "RUN corepack enable")
+	case "bun":
+		stack.Dockerfile = append(stack.Dockerfile, "RUN npm install -g bun")
+	}
+
+	var pkg struct {
+		Scripts map[string]string `json:"scripts"`
+	}
+	data, err := os.ReadFile(filepath.Join(projectDir, "package.json"))
+	if err != nil || json.Unmarshal(data, &pkg) != nil {
+		return stack
+	}
+	for _, s := range []struct{ name, script string }{
+		{"build", "build"},
+		{"test", "test"},
+		{"run", "dev"},
+		{"run", "start"},
+	} {
+		if _, ok := pkg.Scripts[s.script]; !ok {
+			continue
+		}
+		if _, taken := stack.Commands[s.name]; !taken {
+			stack.Commands[s.name] = pm + " run " + s.script
+		}
+	}
+	return stack
+}
+
+// pythonStack prefers the project's own tool (uv, Poetry) when its
+// lockfile is present, falling back to pip.
+func pythonStack(exists func(string) bool) Stack {
+	stack := Stack{Name: "Python", Marker: "pyproject.toml", Runtime: "Python"}
+	switch {
+	case exists("uv.lock"):
+		stack.Name += " (uv)"
+		stack.Commands = map[string]string{"setup": "uv sync", "test": "uv run pytest"}
+		// uv fetches the Python the project asks for itself.
+		stack.Dockerfile = []string{"RUN curl -LsSf https://astral.sh/uv/install.sh | env UV_INSTALL_DIR=/usr/local/bin sh"}
+	case exists("poetry.lock"):
+		stack.Name += " (Poetry)"
+		stack.Commands = map[string]string{"setup": "poetry install", "test": "poetry run pytest"}
+		stack.Dockerfile = []string{aptInstall("python3 python3-venv python3-poetry")}
+	default:
+		stack.Commands = map[string]string{"setup": "pip install -e .", "test": "pytest"}
+		// Debian's Python refuses pip installs outside a virtualenv.
+		stack.Dockerfile = []string{
+			aptInstall("python3 python3-pip python3-venv"),
+			"ENV PIP_BREAK_SYSTEM_PACKAGES=1",
+		}
+	}
+	return stack
+}
+
+// ApplyStacks adds the stacks' suggested commands to c. A command already
+// set, by c or an earlier stack, is kept.
+func (c *Config) ApplyStacks(stacks []Stack) {
+	for _, s := range stacks {
+		for name, expr := range s.Commands {
+			if _, ok := c.Commands[name]; ok {
+				continue
+			}
+			if c.Commands == nil {
+				c.Commands = make(map[string]string)
+			}
+			c.Commands[name] = expr
+		}
+	}
+}
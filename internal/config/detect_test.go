@@ -0,0 +1,108 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDetectStacks(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string]string
+		want     string
+		commands map[string]string
+	}{
+		{"go", map[string]string{"go.mod": "module x"}, "Go",
+			map[string]string{"setup": "go mod download", "build": "go build ./...", "test": "go test ./..."}},
+		{"node scripts", map[string]string{"package.json": `{"scripts":{"build":"tsc","test":"jest","start":"node ."}}`, "yarn.lock": ""}, "Node.js (yarn)",
+			map[string]string{"setup": "yarn install", "build": "yarn run build", "test": "yarn run test", "run": "yarn run start"}},
+		{"node dev over start", map[string]string{"package.json": `{"scripts":{"dev":"vite","start":"node ."}}`}, "Node.js (npm)",
+			map[string]string{"setup": "npm install", "run": "npm run dev"}},
+		{"rust", map[string]string{"Cargo.toml": ""}, "Rust",
+			map[string]string{"build": "cargo build", "test": "cargo test", "run": "cargo run"}},
+		{"python uv", map[string]string{"pyproject.toml": "", "uv.lock": ""}, "Python (uv)",
+			map[string]string{"setup": "uv sync", "test": "uv run pytest"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tt.files)
+			stacks := DetectStacks(dir)
+			if len(stacks) != 1 || stacks[0].Name != tt.want {
+				t.Fatalf("DetectStacks() = %+v, want one %s stack", stacks, tt.want)
+			}
+			if got := stacks[0].Commands; len(got) != len(tt.commands) {
+				t.Errorf("commands = %v, want %v", got, tt.commands)
+			}
+			for name, expr := range tt.commands {
+				if got := stacks[0].Commands[name]; got != expr {
+					t.Errorf("commands[%s] = %q, want %q", name, got, expr)
+				}
+			}
+		})
+	}
+
+	if stacks := DetectStacks(t.TempDir()); len(stacks) != 0 {
+		t.Errorf("empty project: DetectStacks() = %+v", stacks)
+	}
+}
+
+func TestDetectStacks_Dockerfile(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string // a line the snippet must contain
+	}{
+		{"go", map[string]string{"go.mod": "module x"}, "go.dev/dl"},
+		{"node pnpm", map[string]string{"package.json": "{}", "pnpm-lock.yaml": ""}, "RUN corepack enable"},
+		{"rust", map[string]string{"Cargo.toml": ""}, "sh.rustup.rs"},
+		{"python poetry", map[string]string{"pyproject.toml": "", "poetry.lock": ""}, "python3-poetry"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tt.files)
+			stacks := DetectStacks(dir)
+			if len(stacks) != 1 {
+				t.Fatalf("DetectStacks() = %+v, want one stack", stacks)
+			}
+			if !strings.Contains(strings.Join(stacks[0].Dockerfile, "\n"), tt.want) {
+				t.Errorf("Dockerfile lines %q don't mention %q", stacks[0].Dockerfile, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyStacks_KeepsExistingCommands(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"go.mod": "module x", "Cargo.toml": ""})
+
+	cfg := &Config{Commands: map[string]string{"test": "make test"}}
+	cfg.ApplyStacks(DetectStacks(dir))
+
+	want := map[string]string{
+		"test":  "make test",
+		"setup": "go mod download",
+		"build": "go build ./...",
+		"run":   "cargo run",
+	}
+	if len(cfg.Commands) != len(want) {
+		t.Errorf("commands = %v, want %v", cfg.Commands, want)
+	}
+	for name, expr := range want {
+		if got := cfg.Commands[name]; got != expr {
+			t.Errorf("commands[%s] = %q, want %q", name, got, expr)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"os"
+	"regexp"
+	"slices"
+)
+
+// envRef matches ${NAME} and ${NAME:-default}, with an optional extra
+// leading $ that escapes the reference.
+var envRef = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// ExpandEnv replaces ${NAME} with the value of the environment variable
+// NAME, and ${NAME:-default} with default when NAME is unset or empty. A
+// missing variable without a default expands to "". $${...} is left as the
+// literal ${...}, for shell commands that need their own variables.
+func ExpandEnv(s string) string {
+	return envRef.ReplaceAllStringFunc(s, func(ref string) string {
+		if ref[1] == '$' {
+			return ref[1:]
+		}
+		m := envRef.FindStringSubmatch(ref)
+		if v := os.Getenv(m[1]); v != "" {
+			return v
+		}
+		return m[2]
+	})
+}
+
+// expandEnv expands environment references in the config's paths, hooks,
+// serve commands and MCP server URLs. Other values, such as names and
+// images, are used as written. [commands], [env_commands] and MCP server
+// headers often carry secrets, so they keep their references until they run
+// (see ShellCommand) or are registered, and the agent and ps never see the
+// values.
+func (c *Config) expandEnv() {
+	for _, s := range []*string{&c.Dockerfile, &c.EnvFile, &c.Open, &c.ChatDir, &c.SystemPrompt, &c.PreUp, &c.PostUp} {
+		*s = ExpandEnv(*s)
+	}
+	if s := c.Serve; s != nil {
+		for _, p := range []*string{&s.Up, &s.Setup, &s.Clean, &s.Command} {
+			*p = ExpandEnv(*p)
+		}
+	}
+	for i := range c.MCPServers {
+		c.MCPServers[i].URL = ExpandEnv(c.MCPServers[i].URL)
+	}
+}
+
+// ShellCommand prepares a [commands] or [env_commands] entry for sh -c.
+// Its ${NAME} and ${NAME:-default} references are left for the shell, which
+// expands them from its environment with the same meaning, and $${...} is
+// unescaped to ${...} as for any other value.
+func ShellCommand(s string) string {
+	return envRef.ReplaceAllStringFunc(s, func(ref string) string {
+		if ref[1] == '$' {
+			return ref[1:]
+		}
+		return ref
+	})
+}
+
+// EnvRefs returns the names of the variables s refers to, sorted, for
+// passing them on to a shell that runs elsewhere (e.g. in the container).
+func EnvRefs(s string) []string {
+	var names []string
+	for _, m := range envRef.FindAllStringSubmatch(s, -1) {
+		if m[0][1] != '$' && !slices.Contains(names, m[1]) {
+			names = append(names, m[1])
+		}
+	}
+	slices.Sort(names)
+	return names
+}
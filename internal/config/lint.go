@@ -0,0 +1,221 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/richvanbergen/cbox/internal/docker"
+)
+
+// backends lists the valid values of the backend key.
+var backends = []string{"claude", "cursor"}
+
+// Lint checks a config file against the schema (every key known, every
+// value of the right type) and for values that can't work together. It
+// returns one message per problem. Relative paths in the file are checked
+// against the file's directory. Only a file that can't be read is an error.
+func Lint(file string) ([]string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, &loadError{err: fmt.Errorf("reading %s: %w", file, err)}
+	}
+
+	var doc struct {
+		Config
+		branchOverrides
+	}
+	md, err := toml.Decode(string(data), &doc)
+	if err != nil {
+		return []string{err.Error()}, nil
+	}
+	dir := filepath.Dir(file)
+	problems := doc.Config.check(dir)
+
+	// Each branch table is checked as it would apply: merged over the
+	// top-level config. Only problems the override adds are reported.
+	for _, glob := range branchGlobs(md) {
+		if _, err := path.Match(glob, ""); err != nil {
+			problems = append(problems, fmt.Sprintf("[branch.%q]: bad glob: %v", glob, err))
+		}
+		// Decode the base afresh: merging shares maps and pointers with
+		// whatever it decodes onto.
+		var merged Config
+		toml.Decode(string(data), &merged)
+		if err := md.PrimitiveDecode(doc.Branch[glob], &merged); err != nil {
+			problems = append(problems, fmt.Sprintf("[branch.%q]: %v", glob, err))
+			continue
+		}
+		for _, p := range merged.check(dir) {
+			if !slices.Contains(problems, p) {
+				problems = append(problems, fmt.Sprintf("[branch.%q]: %s", glob, p))
+			}
+		}
+	}
+
+	var unknown []string
+	for _, key := range md.Undecoded() {
+		unknown = append(unknown, fmt.Sprintf("unknown key %q", key.String()))
+	}
+	return append(unknown, problems...), nil
+}
+
+// check reports values that parse but can't work, resolving relative paths
+// against projectDir.
+func (c *Config) check(projectDir string) []string {
+	var problems []string
+	add := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if b := strings.ToLower(strings.TrimSpace(c.Backend)); b != "" && !slices.Contains(backends, b) {
+		add("backend %q is not one of: %s", c.Backend, strings.Join(backends, ", "))
+	}
+	if c.CommandTimeout < 0 {
+		add("command_timeout must not be negative")
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.CommandTimeouts)) {
+		if _, ok := c.Commands[name]; !ok {
+			add("command_timeouts lists %q, which is not in [commands]", name)
+		}
+		if c.CommandTimeouts[name] < 0 {
+			add("command_timeouts.%s must not be negative", name)
+		}
+	}
+	if c.MaxConcurrent < 0 {
+		add("max_concurrent_commands must not be negative")
+	}
+	for _, name := range c.InContainer {
+		if _, ok := c.Commands[name]; !ok {
+			add("container_commands lists %q, which is not in [commands]", name)
+		}
+	}
+	for _, p := range c.Ports {
+		if err := checkPortMapping(p); err != nil {
+			add("ports: %q: %v", p, err)
+		}
+	}
+	for _, f := range []struct{ key, file string }{
+		{"dockerfile", c.Dockerfile},
+		{"env_file", c.EnvFile},
+	} {
+		key, file := f.key, ExpandEnv(f.file)
+		if file == "" {
+			continue
+		}
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(projectDir, file)
+		}
+		if _, err := os.Stat(file); err != nil {
+			add("%s %s does not exist", key, file)
+		}
+	}
+	if n := c.Network; n != nil && n.Egress != "" && n.Egress != "allow" && n.Egress != "deny" {
+		add("network.egress %q must be \"allow\" or \"deny\"", n.Egress)
+	}
+	if err := docker.CheckExtraRunArgs(c.DockerRunArgs); err != nil {
+		add("docker_run_args: %v", err)
+	}
+	if _, err := docker.GPUsFlag(c.GPUs); err != nil {
+		add("%v", err)
+	}
+	if err := c.Resources.Check(); err != nil {
+		add("%v", err)
+	}
+	problems = append(problems, mcpServerProblems(c.MCPServers)...)
+	if s := c.Serve; s != nil {
+		if s.Command == "" {
+			add("[serve] has no command, so serve is disabled")
+		}
+		if s.Port < 0 || s.Port > 65535 {
+			add("serve.port %d is not a valid port", s.Port)
+		}
+		if s.ProxyPort < 0 || s.ProxyPort > 65535 {
+			add("serve.proxy_port %d is not a valid port", s.ProxyPort)
+		}
+	}
+	return problems
+}
+
+// CheckMCPServers reports the first mcp_servers entry that can't be
+// registered: a missing, duplicate or reserved name, an unknown transport,
+// or a URL that isn't http(s).
+func CheckMCPServers(servers []MCPServer) error {
+	if problems := mcpServerProblems(servers); len(problems) > 0 {
+		return errors.New(problems[0])
+	}
+	return nil
+}
+
+func mcpServerProblems(servers []MCPServer) []string {
+	var problems []string
+	add := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	seen := map[string]bool{}
+	for i, s := range servers {
+		switch {
+		case s.Name == "":
+			add("mcp_servers[%d] has no name", i)
+		case s.Name == "cbox-host":
+			add("mcp_servers: the name %q is reserved for cbox's host server", s.Name)
+		case seen[s.Name]:
+			add("mcp_servers: %q is listed more than once", s.Name)
+		}
+		seen[s.Name] = true
+		if s.Transport != "" && s.Transport != "http" && s.Transport != "sse" {
+			add("mcp_servers[%d].transport %q must be \"http\" or \"sse\"", i, s.Transport)
+		}
+		if u, err := url.Parse(s.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("mcp_servers[%d].url %q is not an http(s) URL", i, s.URL)
+		}
+	}
+	return problems
+}
+
+// checkPortMapping validates a docker -p value: [[ip:]host:]container with
+// an optional /tcp, /udp or /sctp suffix, where ports may be ranges.
+func checkPortMapping(p string) error {
+	spec, proto, found := strings.Cut(p, "/")
+	if found && proto != "tcp" && proto != "udp" && proto != "sctp" {
+		return fmt.Errorf("unknown protocol %q", proto)
+	}
+	parts := strings.Split(spec, ":")
+	if len(parts) > 3 {
+		return fmt.Errorf("expected [[ip:]host:]container")
+	}
+	if len(parts) == 3 {
+		parts = parts[1:] // the host IP is docker's to check
+	}
+	for i, part := range parts {
+		if part == "" && i == 0 && len(parts) == 2 {
+			continue // ip::container picks a random host port
+		}
+		if err := checkPortRange(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkPortRange(r string) error {
+	lo, hi, isRange := strings.Cut(r, "-")
+	for _, s := range []string{lo, hi} {
+		if s == "" && !isRange {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("%q is not a port", s)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeLintConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), ConfigFile)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLint_CleanConfig(t *testing.T) {
+	path := writeLintConfig(t, `backend = "claude"
+host_commands = ["git"]
+editor = "nvim"
+ports = ["3000", "8080:80", "127.0.0.1:5432:5432/tcp", "9000-9002:9000-9002"]
+container_commands = ["test"]
+
+[commands]
+test = "npm test"
+
+[serve]
+command = "npm run dev"
+port = 3000
+
+[network]
+egress = "deny"
+allow_hosts = ["api.anthropic.com"]
+
+[[mcp_servers]]
+name = "db"
+url = "http://host.docker.internal:9000/mcp"
+`)
+	problems, err := Lint(path)
+	if err != nil {
+		t.Fatalf("Lint: %v", err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("problems = %v, want none", problems)
+	}
+}
+
+func TestLint_ReportsProblems(t *testing.T) {
+	path := writeLintConfig(t, `backend = "vscode"
+host_comands = ["git"]
+ports = ["80:abc", "53/icmp"]
+container_commands = ["lint"]
+dockerfile = "Dockerfile.missing"
+docker_run_args = ["--network", "host"]
+gpus = "0,,1"
+
+[command_timeouts]
+test = 600
+
+[serve]
+port = 70000
+
+[network]
+egress = "block"
+
+[resources]
+memory = "lots"
+
+[[mcp_servers]]
+name = "cbox-host"
+transport = "stdio"
+url = "localhost:9000"
+`)
+	problems, err := Lint(path)
+	if err != nil {
+		t.Fatalf("Lint: %v", err)
+	}
+	want := []string{
+		`unknown key "host_comands"`,
+		`backend "vscode"`,
+		`ports: "80:abc"`,
+		`ports: "53/icmp"`,
+		`container_commands lists "lint"`,
+		`command_timeouts lists "test"`,
+		"dockerfile",
+		"docker_run_args: ",
+		`gpus "0,,1"`,
+		"[serve] has no command",
+		"serve.port 70000",
+		`network.egress "block"`,
+		`resources.memory "lots"`,
+		`"cbox-host" is reserved`,
+		`mcp_servers[0].transport "stdio"`,
+		`mcp_servers[0].url "localhost:9000"`,
+	}
+	joined := strings.Join(problems, "\n")
+	for _, w := range want {
+		if !strings.Contains(joined, w) {
+			t.Errorf("expected a problem mentioning %q, got:\n%s", w, joined)
+		}
+	}
+	if len(problems) != len(want) {
+		t.Errorf("got %d problems, want %d:\n%s", len(problems), len(want), joined)
+	}
+}
+
+func TestLint_TypeMismatch(t *testing.T) {
+	path := writeLintConfig(t, `browser = "yes"`+"\n")
+	problems, err := Lint(path)
+	if err != nil {
+		t.Fatalf("Lint: %v", err)
+	}
+	if len(problems) != 1 || !strings.Contains(problems[0], "browser") {
+		t.Errorf("problems = %v, want one type error for browser", problems)
+	}
+}
+
+func TestLint_MissingFile(t *testing.T) {
+	_, err := Lint(filepath.Join(t.TempDir(), ConfigFile))
+	if !errors.Is(err, ErrInvalid) {
+		t.Fatalf("Lint error = %v, want ErrInvalid", err)
+	}
+}
+
+func TestSchema_CoversConfigKeys(t *testing.T) {
+	props := Schema()["properties"].(map[string]any)
+	for _, key := range []string{"backend", "commands", "copy_files", "serve", "branch"} {
+		if _, ok := props[key]; !ok {
+			t.Errorf("schema has no %q property", key)
+		}
+	}
+
+	commands := props["commands"].(map[string]any)
+	if commands["type"] != "object" || commands["additionalProperties"].(map[string]any)["type"] != "string" {
+		t.Errorf("commands schema = %v, want a string map", commands)
+	}
+	serve := props["serve"].(map[string]any)
+	if _, ok := serve["properties"].(map[string]any)["proxy_port"]; !ok {
+		t.Errorf("serve schema = %v, want nested serve keys", serve)
+	}
+}
+
+func TestLint_BranchTables(t *testing.T) {
+	path := writeLintConfig(t, `backend = "claude"
+
+[branch."feature/*"]
+backend = "vscode"
+prots = ["3000"]
+
+[branch."[bad"]
+model = "opus"
+`)
+	problems, err := Lint(path)
+	if err != nil {
+		t.Fatalf("Lint: %v", err)
+	}
+	joined := strings.Join(problems, "\n")
+	for _, w := range []string{
+		`unknown key "branch.\"feature/*\".prots"`,
+		`[branch."feature/*"]: backend "vscode"`,
+		`[branch."[bad"]: bad glob`,
+	} {
+		if !strings.Contains(joined, w) {
+			t.Errorf("expected a problem mentioning %q, got:\n%s", w, joined)
+		}
+	}
+	if len(problems) != 3 {
+		t.Errorf("got %d problems, want 3:\n%s", len(problems), joined)
+	}
+}
+
+func TestCheckMCPServers(t *testing.T) {
+	ok := MCPServer{Name: "db", URL: "http://host.docker.internal:9000/mcp"}
+	tests := []struct {
+		servers []MCPServer
+		want    string
+	}{
+		{nil, ""},
+		{[]MCPServer{ok}, ""},
+		{[]MCPServer{{URL: ok.URL}}, "has no name"},
+		{[]MCPServer{{Name: "cbox-host", URL: ok.URL}}, "reserved"},
+		{[]MCPServer{ok, ok}, "more than once"},
+		{[]MCPServer{{Name: "db", URL: ok.URL, Transport: "stdio"}}, "transport"},
+		{[]MCPServer{{Name: "db", URL: "localhost:9000"}}, "not an http(s) URL"},
+	}
+	for _, tt := range tests {
+		err := CheckMCPServers(tt.servers)
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("CheckMCPServers(%+v) = %v, want nil", tt.servers, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("CheckMCPServers(%+v) = %v, want error mentioning %q", tt.servers, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Schema returns a JSON Schema describing cbox.toml, generated from the
+// Config struct and its TOML tags so it can't drift from what Load accepts.
+func Schema() map[string]any {
+	schema := structSchema(reflect.TypeOf(Config{}))
+	// [branch."<glob>"] tables take any top-level key.
+	schema["properties"].(map[string]any)["branch"] = map[string]any{
+		"type":                 "object",
+		"additionalProperties": structSchema(reflect.TypeOf(Config{})),
+	}
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "cbox.toml"
+	return schema
+}
+
+// structSchema describes a struct as an object whose properties are its
+// TOML keys. Unknown keys are not allowed.
+func structSchema(t reflect.Type) map[string]any {
+	props := make(map[string]any)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key := tomlKey(f)
+		if key == "" {
+			continue
+		}
+		props[key] = typeSchema(f.Type)
+	}
+	return map[string]any{
+		"type":                 "object",
+		"properties":           props,
+		"additionalProperties": false,
+	}
+}
+
+// typeSchema describes a single field type.
+func typeSchema(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem())
+	case reflect.Struct:
+		return structSchema(t)
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	default:
+		return map[string]any{}
+	}
+}
+
+// tomlKey returns the TOML key of a struct field, or "" if it isn't encoded.
+func tomlKey(f reflect.StructField) string {
+	if !f.IsExported() {
+		return ""
+	}
+	name, _, _ := strings.Cut(f.Tag.Get("toml"), ",")
+	switch name {
+	case "-":
+		return ""
+	case "":
+		return f.Name
+	}
+	return name
+}
@@ -0,0 +1,282 @@
+// Package daemon implements the optional per-project supervisor that keeps a
+// sandbox's host proxies alive. The daemon listens on a unix socket; `cbox up`
+// registers proxies with it and `cbox down`/`clean` deregister them. When no
+// daemon is running cbox falls back to standalone, PID-only tracking.
+package daemon
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// dialTimeout bounds how long a client waits for the daemon to answer.
+const dialTimeout = 2 * time.Second
+
+// request is one JSON message sent to the daemon. Each connection carries a
+// single request and its response.
+type request struct {
+	Op    string `json:"op"` // register, deregister, list or shutdown
+	Entry Entry  `json:"entry,omitempty"`
+	Name  string `json:"name,omitempty"`
+}
+
+type response struct {
+	Error    string   `json:"error,omitempty"`
+	PID      int      `json:"pid,omitempty"`
+	Statuses []Status `json:"statuses,omitempty"`
+}
+
+// SocketPath returns the control socket for a project. It lives in a
+// per-user runtime dir rather than .cbox because unix socket paths are
+// limited to ~100 bytes.
+func SocketPath(projectDir string) string {
+	sum := sha256.Sum256([]byte(projectDir))
+	return filepath.Join(socketDir(), fmt.Sprintf("%x.sock", sum[:6]))
+}
+
+// socketDir is the directory holding this user's control sockets:
+// $XDG_RUNTIME_DIR/cbox, or cbox-<uid> in the temp dir.
+func socketDir() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "cbox")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("cbox-%d", os.Getuid()))
+}
+
+// prepareSocketDir creates dir, readable only by this user, or checks that
+// an existing one is a real directory this user owns. Whoever can connect
+// to the socket can have the daemon run commands as this user, and a socket
+// another user created first would receive every request.
+func prepareSocketDir(dir string) error {
+	if err := os.Mkdir(dir, 0700); err != nil && !os.IsExist(err) {
+		return fmt.Errorf("creating socket dir: %w", err)
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return fmt.Errorf("checking socket dir: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("socket dir %s is not a directory", dir)
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok && int(st.Uid) != os.Getuid() {
+		return fmt.Errorf("socket dir %s is owned by another user", dir)
+	}
+	if info.Mode().Perm() != 0700 {
+		if err := os.Chmod(dir, 0700); err != nil {
+			return fmt.Errorf("securing socket dir: %w", err)
+		}
+	}
+	return nil
+}
+
+// LogPath returns the daemon's log file for a project.
+func LogPath(projectDir string) string {
+	return filepath.Join(projectDir, ".cbox", "daemon.log")
+}
+
+// Run serves the control socket for projectDir until a shutdown request or
+// SIGINT/SIGTERM. Supervised processes are left running when it exits.
+func Run(projectDir string) error {
+	sup := NewSupervisor()
+	defer sup.Close()
+
+	ln, err := listen(SocketPath(projectDir))
+	if err != nil {
+		return err
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sig
+		ln.Close()
+	}()
+
+	log.Printf("daemon listening on %s", ln.Addr())
+	serve(ln, sup)
+	log.Printf("daemon stopped")
+	return nil
+}
+
+// listen opens the control socket, clearing a stale socket file left by a
+// daemon that died without cleaning up.
+func listen(path string) (net.Listener, error) {
+	if err := prepareSocketDir(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", path)
+	if err == nil {
+		return ln, nil
+	}
+	if conn, dialErr := net.DialTimeout("unix", path, dialTimeout); dialErr == nil {
+		conn.Close()
+		return nil, fmt.Errorf("daemon already running on %s", path)
+	}
+	os.Remove(path)
+	ln, err = net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("listening on %s: %w", path, err)
+	}
+	return ln, nil
+}
+
+// serve handles connections until ln is closed.
+func serve(ln net.Listener, sup *Supervisor) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		if handle(conn, sup) {
+			ln.Close()
+		}
+	}
+}
+
+// handle answers a single request. It returns true on shutdown.
+func handle(conn net.Conn, sup *Supervisor) bool {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+
+	var req request
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		json.NewEncoder(conn).Encode(response{Error: fmt.Sprintf("decoding request: %v", err)})
+		return false
+	}
+
+	var resp response
+	shutdown := false
+	switch req.Op {
+	case "register":
+		if err := sup.Register(req.Entry); err != nil {
+			resp.Error = err.Error()
+		} else {
+			log.Printf("registered %s", req.Entry.Name)
+		}
+	case "deregister":
+		resp.PID, _ = sup.Deregister(req.Name)
+		log.Printf("deregistered %s", req.Name)
+	case "list":
+		resp.Statuses = sup.List()
+	case "shutdown":
+		shutdown = true
+	default:
+		resp.Error = fmt.Sprintf("unknown op %q", req.Op)
+	}
+	json.NewEncoder(conn).Encode(resp)
+	return shutdown
+}
+
+// call sends req to the project's daemon and returns its response.
+func call(projectDir string, req request) (*response, error) {
+	path := SocketPath(projectDir)
+	if err := prepareSocketDir(filepath.Dir(path)); err != nil {
+		return nil, fmt.Errorf("daemon not running: %w", err)
+	}
+	conn, err := net.DialTimeout("unix", path, dialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("daemon not running: %w", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, fmt.Errorf("sending request: %w", err)
+	}
+	var resp response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("reading response: %w", err)
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	return &resp, nil
+}
+
+// Running reports whether a daemon is answering for projectDir.
+func Running(projectDir string) bool {
+	_, err := call(projectDir, request{Op: "list"})
+	return err == nil
+}
+
+// Register asks the daemon to supervise e.
+func Register(projectDir string, e Entry) error {
+	_, err := call(projectDir, request{Op: "register", Entry: e})
+	return err
+}
+
+// Deregister stops supervision of the named entry and returns its current
+// PID, which differs from the registered one if the daemon restarted it.
+// A PID of 0 means the daemon did not know the entry.
+func Deregister(projectDir, name string) (int, error) {
+	resp, err := call(projectDir, request{Op: "deregister", Name: name})
+	if err != nil {
+		return 0, err
+	}
+	return resp.PID, nil
+}
+
+// List returns the status of every entry the daemon supervises.
+func List(projectDir string) ([]Status, error) {
+	resp, err := call(projectDir, request{Op: "list"})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Statuses, nil
+}
+
+// Shutdown stops the daemon. Supervised processes keep running standalone.
+func Shutdown(projectDir string) error {
+	_, err := call(projectDir, request{Op: "shutdown"})
+	return err
+}
+
+// Start launches `cbox _daemon` in the background for projectDir and waits
+// for its socket to answer. It is a no-op if a daemon is already running.
+func Start(projectDir string) error {
+	if Running(projectDir) {
+		return nil
+	}
+
+	selfPath, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("finding executable: %w", err)
+	}
+
+	logPath := LogPath(projectDir)
+	os.MkdirAll(filepath.Dir(logPath), 0755)
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("creating daemon log: %w", err)
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(selfPath, "_daemon", projectDir)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	// Start as a new session so it outlives this process and the terminal.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("starting daemon: %w", err)
+	}
+	cmd.Process.Release()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if Running(projectDir) {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("daemon did not start (see %s)", logPath)
+}
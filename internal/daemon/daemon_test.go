@@ -0,0 +1,171 @@
+package daemon
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSupervisor returns a supervisor whose processes are simulated: alive
+// reports the PIDs in the returned set, and spawn hands out new PIDs.
+func fakeSupervisor() (*Supervisor, *sync.Map) {
+	live := &sync.Map{}
+	nextPID := 1000
+	var mu sync.Mutex
+
+	s := NewSupervisor()
+	s.interval = 5 * time.Millisecond
+	s.alive = func(pid int) bool {
+		_, ok := live.Load(pid)
+		return ok
+	}
+	s.spawn = func(Entry) (int, <-chan struct{}, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		nextPID++
+		live.Store(nextPID, true)
+		return nextPID, nil, nil
+	}
+	return s, live
+}
+
+func TestSupervisor_RestartsDeadProcess(t *testing.T) {
+	s, live := fakeSupervisor()
+	defer s.Close()
+
+	live.Store(42, true)
+	if err := s.Register(Entry{Name: "mcp", PID: 42, Command: []string{"cbox", "_mcp-proxy"}}); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	live.Delete(42)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		statuses := s.List()
+		if len(statuses) == 1 && statuses[0].Restarts == 1 && statuses[0].Running {
+			if statuses[0].PID == 42 {
+				t.Fatal("PID not updated after restart")
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("process was not restarted: %+v", s.List())
+}
+
+func TestSupervisor_DeregisterReturnsCurrentPID(t *testing.T) {
+	s, live := fakeSupervisor()
+	defer s.Close()
+
+	live.Store(7, true)
+	s.Register(Entry{Name: "serve", PID: 7, Command: []string{"true"}})
+
+	pid, ok := s.Deregister("serve")
+	if !ok || pid != 7 {
+		t.Errorf("Deregister = (%d, %v), want (7, true)", pid, ok)
+	}
+	if _, ok := s.Deregister("serve"); ok {
+		t.Error("second Deregister should report unknown entry")
+	}
+	if len(s.List()) != 0 {
+		t.Errorf("List after deregister = %+v, want empty", s.List())
+	}
+}
+
+func TestSupervisor_RegisterValidates(t *testing.T) {
+	s, _ := fakeSupervisor()
+	defer s.Close()
+
+	if err := s.Register(Entry{Command: []string{"true"}}); err == nil {
+		t.Error("expected error for entry without a name")
+	}
+	if err := s.Register(Entry{Name: "x"}); err == nil {
+		t.Error("expected error for entry without command or container")
+	}
+}
+
+func TestClientServerRoundTrip(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	projectDir := t.TempDir()
+	s, live := fakeSupervisor()
+	defer s.Close()
+
+	ln, err := listen(SocketPath(projectDir))
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		serve(ln, s)
+		close(done)
+	}()
+
+	if !Running(projectDir) {
+		t.Fatal("Running = false, want true")
+	}
+
+	live.Store(99, true)
+	if err := Register(projectDir, Entry{Name: "feat/mcp", PID: 99, Command: []string{"true"}}); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if err := Register(projectDir, Entry{Name: "bad"}); err == nil {
+		t.Error("Register of invalid entry should return the daemon's error")
+	}
+
+	statuses, err := List(projectDir)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(statuses) != 1 || statuses[0].Name != "feat/mcp" || statuses[0].PID != 99 {
+		t.Errorf("List = %+v, want one feat/mcp entry with PID 99", statuses)
+	}
+
+	pid, err := Deregister(projectDir, "feat/mcp")
+	if err != nil || pid != 99 {
+		t.Errorf("Deregister = (%d, %v), want (99, nil)", pid, err)
+	}
+
+	if err := Shutdown(projectDir); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("server did not stop after shutdown")
+	}
+	if Running(projectDir) {
+		t.Error("Running = true after shutdown")
+	}
+}
+
+func TestSocketDirIsPrivate(t *testing.T) {
+	runtimeDir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+
+	ln, err := listen(SocketPath(t.TempDir()))
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	ln.Close()
+	info, err := os.Stat(socketDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0700 {
+		t.Errorf("socket dir mode = %o, want 700", perm)
+	}
+
+	// A socket dir someone else put in place, here a symlink, is refused.
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	if err := os.Symlink(runtimeDir, socketDir()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listen(SocketPath(t.TempDir())); err == nil {
+		t.Error("listen accepted a symlinked socket dir")
+	}
+	if Running(t.TempDir()) {
+		t.Error("Running = true through a symlinked socket dir")
+	}
+}
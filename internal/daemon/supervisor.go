@@ -0,0 +1,278 @@
+package daemon
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/richvanbergen/cbox/internal/docker"
+	"github.com/richvanbergen/cbox/internal/process"
+)
+
+const (
+	// minBackoff is the delay before the first restart of a dead entry.
+	minBackoff = time.Second
+	// maxBackoff caps the delay between restarts of an entry that keeps dying.
+	maxBackoff = 30 * time.Second
+	// stableAfter is how long a restarted entry must stay up before its
+	// backoff resets.
+	stableAfter = time.Minute
+)
+
+// Entry describes something the daemon keeps alive. An entry is either a host
+// process (Command) or a docker container (Container).
+type Entry struct {
+	Name      string   `json:"name"`
+	PID       int      `json:"pid,omitempty"`       // already-running process to adopt
+	Command   []string `json:"command,omitempty"`   // argv used to restart the process
+	Env       []string `json:"env,omitempty"`       // added to the daemon's environment on restart
+	Dir       string   `json:"dir,omitempty"`       // working directory for restarts
+	LogPath   string   `json:"log_path,omitempty"`  // restarted output is appended here
+	Container string   `json:"container,omitempty"` // docker container to keep running
+}
+
+// Status reports the current state of a supervised entry.
+type Status struct {
+	Name      string `json:"name"`
+	PID       int    `json:"pid,omitempty"`
+	Container string `json:"container,omitempty"`
+	Running   bool   `json:"running"`
+	Restarts  int    `json:"restarts"`
+}
+
+// proc is a supervised entry and its watcher state.
+type proc struct {
+	entry    Entry
+	pid      int
+	exited   <-chan struct{} // closed when a process we spawned exits; nil when adopted
+	restarts int
+	stop     chan struct{}
+}
+
+// Supervisor watches registered entries and restarts them when they die.
+type Supervisor struct {
+	mu       sync.Mutex
+	procs    map[string]*proc
+	interval time.Duration
+
+	// Hooks for process and container control, replaced in tests.
+	spawn          func(Entry) (int, <-chan struct{}, error)
+	alive          func(pid int) bool
+	containerUp    func(name string) bool
+	startContainer func(name string) error
+}
+
+// NewSupervisor returns a supervisor that polls entries every two seconds.
+func NewSupervisor() *Supervisor {
+	return &Supervisor{
+		procs:          make(map[string]*proc),
+		interval:       2 * time.Second,
+		spawn:          spawnProcess,
+		alive:          process.Alive,
+		containerUp:    containerRunning,
+		startContainer: startContainer,
+	}
+}
+
+// Register starts supervising e, replacing any entry with the same name.
+func (s *Supervisor) Register(e Entry) error {
+	if e.Name == "" {
+		return fmt.Errorf("entry has no name")
+	}
+	if e.Container == "" && len(e.Command) == 0 {
+		return fmt.Errorf("entry %q needs a command or a container", e.Name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.procs[e.Name]; ok {
+		close(old.stop)
+	}
+	p := &proc{entry: e, pid: e.PID, stop: make(chan struct{})}
+	s.procs[e.Name] = p
+	go s.watch(p)
+	return nil
+}
+
+// Deregister stops supervising the named entry and returns its current PID so
+// the caller can stop it. The process itself is left running.
+func (s *Supervisor) Deregister(name string) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.procs[name]
+	if !ok {
+		return 0, false
+	}
+	close(p.stop)
+	delete(s.procs, name)
+	return p.pid, true
+}
+
+// List returns the status of every supervised entry, sorted by name.
+func (s *Supervisor) List() []Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	statuses := make([]Status, 0, len(s.procs))
+	for _, p := range s.procs {
+		st := Status{Name: p.entry.Name, PID: p.pid, Container: p.entry.Container, Restarts: p.restarts}
+		if p.entry.Container != "" {
+			st.Running = s.containerUp(p.entry.Container)
+		} else {
+			st.Running = s.alive(p.pid)
+		}
+		statuses = append(statuses, st)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// Close stops supervising every entry. Supervised processes keep running.
+func (s *Supervisor) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, p := range s.procs {
+		close(p.stop)
+		delete(s.procs, name)
+	}
+}
+
+// watch waits for p to die and restarts it, backing off while it keeps
+// crashing. It returns when p is deregistered.
+func (s *Supervisor) watch(p *proc) {
+	backoff := minBackoff
+	started := time.Now()
+	for {
+		if !s.waitDead(p) {
+			return
+		}
+		if time.Since(started) > stableAfter {
+			backoff = minBackoff
+		}
+		log.Printf("%s died, restarting in %s", p.entry.Name, backoff)
+
+		select {
+		case <-p.stop:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+		started = time.Now()
+
+		if err := s.restart(p); err != nil {
+			log.Printf("%s: restart failed: %v", p.entry.Name, err)
+		}
+	}
+}
+
+// waitDead blocks until p's process or container is gone. It returns false
+// if p is deregistered first.
+func (s *Supervisor) waitDead(p *proc) bool {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.mu.Lock()
+		exited := p.exited
+		s.mu.Unlock()
+
+		select {
+		case <-p.stop:
+			return false
+		case <-exited:
+			return true
+		case <-ticker.C:
+		}
+
+		if exited != nil {
+			continue
+		}
+		s.mu.Lock()
+		pid := p.pid
+		s.mu.Unlock()
+		if p.entry.Container != "" {
+			if !s.containerUp(p.entry.Container) {
+				return true
+			}
+		} else if !s.alive(pid) {
+			return true
+		}
+	}
+}
+
+// restart brings p back up unless it has been deregistered in the meantime.
+func (s *Supervisor) restart(p *proc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-p.stop:
+		return nil
+	default:
+	}
+
+	if p.entry.Container != "" {
+		if err := s.startContainer(p.entry.Container); err != nil {
+			return err
+		}
+		p.restarts++
+		return nil
+	}
+
+	pid, exited, err := s.spawn(p.entry)
+	if err != nil {
+		return err
+	}
+	p.pid = pid
+	p.exited = exited
+	p.restarts++
+	log.Printf("%s restarted (PID %d)", p.entry.Name, pid)
+	return nil
+}
+
+// spawnProcess starts e.Command in its own process group so it outlives the
+// daemon, and returns a channel that is closed when it exits.
+func spawnProcess(e Entry) (int, <-chan struct{}, error) {
+	cmd := exec.Command(e.Command[0], e.Command[1:]...)
+	cmd.Dir = e.Dir
+	if len(e.Env) > 0 {
+		cmd.Env = append(os.Environ(), e.Env...)
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if e.LogPath != "" {
+		os.MkdirAll(filepath.Dir(e.LogPath), 0755)
+		logFile, err := os.OpenFile(e.LogPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return 0, nil, fmt.Errorf("opening log: %w", err)
+		}
+		defer logFile.Close()
+		cmd.Stdout = logFile
+		cmd.Stderr = logFile
+	}
+	if err := cmd.Start(); err != nil {
+		return 0, nil, err
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+	return cmd.Process.Pid, exited, nil
+}
+
+func containerRunning(name string) bool {
+	running, err := docker.IsRunning(name)
+	return err == nil && running
+}
+
+func startContainer(name string) error {
+	out, err := exec.Command("docker", "start", name).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("docker start %s: %s: %w", name, string(out), err)
+	}
+	return nil
+}
@@ -1,12 +1,18 @@
 package docker
 
 import (
+	"bytes"
 	"embed"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
+
+	"github.com/richvanbergen/cbox/internal/output"
+	"github.com/richvanbergen/cbox/internal/redact"
 )
 
 //go:embed templates/Dockerfile.claude.tmpl templates/Dockerfile.cursor.tmpl templates/entrypoint.sh
@@ -14,8 +20,13 @@ var claudeFiles embed.FS
 
 // BuildOptions controls how a backend image is built.
 type BuildOptions struct {
-	ProjectDockerfile string // absolute path to a custom Dockerfile; empty = use embedded
-	NoCache           bool   // pass --no-cache to docker build
+	ProjectDockerfile string            // absolute path to a custom Dockerfile; empty = use embedded
+	NoCache           bool              // pass --no-cache to docker build
+	BuildArgs         map[string]string // passed as --build-arg KEY=VALUE
+	Labels            map[string]string // passed as --label KEY=VALUE
+	// Progress, if set, receives the build's output a line at a time
+	// instead of the terminal, e.g. to show it beside a spinner.
+	Progress func(line string)
 }
 
 // BuildImage builds a backend container image from an embedded template or a
@@ -54,27 +65,100 @@ func BuildImage(imageName, embeddedTemplate string, opts BuildOptions) error {
 		return fmt.Errorf("writing %s: %w", dockerfileName, err)
 	}
 
-	buildArgs := []string{"build",
-		"-f", filepath.Join(tmpDir, dockerfileName),
-		"-t", imageName,
-	}
-	if opts.NoCache {
-		buildArgs = append(buildArgs, "--no-cache")
+	cmd := exec.Command("docker", dockerBuildArgs(filepath.Join(tmpDir, dockerfileName), imageName, tmpDir, opts)...)
+	if opts.Progress != nil {
+		pw := &progressWriter{fn: opts.Progress}
+		cmd.Stdout, cmd.Stderr = pw, pw
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("building image: %w\n%s", cliMissing(err), pw.tail())
+		}
+		return nil
 	}
-	buildArgs = append(buildArgs, tmpDir)
-
-	cmd := exec.Command("docker", buildArgs...)
-	cmd.Stdout = os.Stdout
+	// The build stays attached to the terminal so BuildKit can render its
+	// progress, unless output has been redirected with output.SetOutput.
+	w := output.Writer()
+	cmd.Stdout = w
 	cmd.Stderr = os.Stderr
-	fmt.Fprintln(os.Stdout)
-	err = cmd.Run()
-	fmt.Fprintln(os.Stdout)
+	if w != io.Writer(os.Stdout) {
+		cmd.Stderr = w
+	}
+	fmt.Fprintln(w)
+	err = cliMissing(cmd.Run())
+	fmt.Fprintln(w)
 	if err != nil {
 		return fmt.Errorf("building image: %w", err)
 	}
 	return nil
 }
 
+// dockerBuildArgs returns the `docker build` arguments for a Dockerfile and
+// build context. Build args are sorted so the command is deterministic.
+func dockerBuildArgs(dockerfile, imageName, contextDir string, opts BuildOptions) []string {
+	args := []string{"build",
+		"-f", dockerfile,
+		"-t", imageName,
+	}
+	if opts.NoCache {
+		args = append(args, "--no-cache")
+	}
+	if opts.Progress != nil {
+		args = append(args, "--progress=plain")
+	}
+	keys := make([]string, 0, len(opts.BuildArgs))
+	for k := range opts.BuildArgs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		args = append(args, "--build-arg", k+"="+opts.BuildArgs[k])
+	}
+	args = append(args, labelArgs(opts.Labels)...)
+	return append(args, contextDir)
+}
+
+// progressWriter passes each line of a command's output to fn, ending a
+// line at a carriage return too so progress bars report their latest
+// state, and keeps the last lines to explain a failure.
+type progressWriter struct {
+	fn   func(line string)
+	buf  []byte
+	last []string
+}
+
+// progressTailLines is how many lines of output a failure includes.
+const progressTailLines = 20
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexAny(w.buf, "\r\n")
+		if i < 0 {
+			break
+		}
+		w.line(string(w.buf[:i]))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (w *progressWriter) line(s string) {
+	if s = strings.TrimSpace(redact.String(s)); s == "" {
+		return
+	}
+	w.fn(s)
+	w.last = append(w.last, s)
+	if len(w.last) > progressTailLines {
+		w.last = w.last[1:]
+	}
+}
+
+// tail returns the last lines written, including an unterminated one.
+func (w *progressWriter) tail() string {
+	w.line(string(w.buf))
+	w.buf = nil
+	return strings.Join(w.last, "\n")
+}
+
 // BuildClaudeImage builds the Claude container image from the embedded template
 // or a custom Dockerfile specified in opts.
 func BuildClaudeImage(imageName string, opts BuildOptions) error {
@@ -0,0 +1,58 @@
+package docker
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDockerBuildArgs(t *testing.T) {
+	got := dockerBuildArgs("/tmp/ctx/Dockerfile", "cbox-app:claude", "/tmp/ctx", BuildOptions{
+		NoCache:   true,
+		BuildArgs: map[string]string{"CLAUDE_VERSION": "1.0.58", "A": "b"},
+	})
+	want := "build -f /tmp/ctx/Dockerfile -t cbox-app:claude --no-cache --build-arg A=b --build-arg CLAUDE_VERSION=1.0.58 /tmp/ctx"
+	if strings.Join(got, " ") != want {
+		t.Errorf("dockerBuildArgs() = %q, want %q", strings.Join(got, " "), want)
+	}
+}
+
+func TestDockerBuildArgs_PlainProgress(t *testing.T) {
+	got := dockerBuildArgs("/tmp/ctx/Dockerfile", "cbox-app:claude", "/tmp/ctx", BuildOptions{
+		Progress: func(string) {},
+	})
+	if !strings.Contains(strings.Join(got, " "), " --progress=plain ") {
+		t.Errorf("dockerBuildArgs() = %q, want plain progress for a Progress callback", got)
+	}
+}
+
+func TestProgressWriter(t *testing.T) {
+	var lines []string
+	w := &progressWriter{fn: func(line string) { lines = append(lines, line) }}
+	w.Write([]byte("#1 [internal] load\n\n#2 pull 10%\r#2 pu"))
+	w.Write([]byte("ll 90%\r\n#3 DONE"))
+	want := []string{"#1 [internal] load", "#2 pull 10%", "#2 pull 90%"}
+	if strings.Join(lines, "|") != strings.Join(want, "|") {
+		t.Errorf("lines = %q, want %q", lines, want)
+	}
+	if got := w.tail(); got != "#1 [internal] load\n#2 pull 10%\n#2 pull 90%\n#3 DONE" {
+		t.Errorf("tail() = %q, want every line including the unterminated one", got)
+	}
+
+	for i := range 30 {
+		fmt.Fprintf(w, "line %d\n", i)
+	}
+	if tail := w.tail(); strings.Count(tail, "\n") != progressTailLines-1 || !strings.HasSuffix(tail, "line 29") {
+		t.Errorf("tail() = %q, want the last %d lines", tail, progressTailLines)
+	}
+}
+
+func TestClaudeTemplateAcceptsVersionArg(t *testing.T) {
+	data, err := EmbeddedDockerfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "ARG CLAUDE_VERSION=latest") {
+		t.Error("embedded Dockerfile should declare ARG CLAUDE_VERSION with a latest default")
+	}
+}
@@ -1,13 +1,21 @@
 package docker
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"maps"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
-	"syscall"
+
+	"github.com/richvanbergen/cbox/internal/naming"
+	"github.com/richvanbergen/cbox/internal/output"
 )
 
 // GitMountConfig holds the paths needed to make git work inside the container.
@@ -22,65 +30,189 @@ type GitMountConfig struct {
 
 // ContainerName returns a deterministic container name with a role suffix.
 func ContainerName(project, branch, role string) string {
-	safeBranch := strings.ReplaceAll(branch, "/", "-")
+	safeBranch := naming.SafeBranch(branch)
 	return "cbox-" + project + "-" + safeBranch + "-" + role
 }
 
 // NetworkName returns a deterministic network name.
 func NetworkName(project, branch string) string {
-	safeBranch := strings.ReplaceAll(branch, "/", "-")
+	safeBranch := naming.SafeBranch(branch)
 	return "cbox-" + project + "-" + safeBranch
 }
 
+// NetworkLabel marks the networks cbox creates. An existing network is only
+// reused, and RemoveNetwork only removes it, when it carries this label, so
+// a user network that happens to share a sandbox's name is never touched.
+const NetworkLabel = "cbox"
+
+// NetworkOptions configures CreateNetworkWithOptions.
+type NetworkOptions struct {
+	Project  string // project directory, recorded as in ResourceLabels
+	Branch   string // recorded in the cbox.branch label
+	Internal bool   // no route out of docker, for network.egress = "deny"
+	// Owned marks a network cbox recorded as its own, e.g. in a state
+	// file, so one created before networks were labeled is replaced by a
+	// labeled one instead of being refused.
+	Owned bool
+}
+
 // CreateNetwork creates a Docker bridge network.
 func CreateNetwork(name string) error {
-	cmd := exec.Command("docker", "network", "create", name)
-	out, err := cmd.CombinedOutput()
+	return CreateNetworkWithOptions(name, NetworkOptions{})
+}
+
+// CreateNetworkWithOptions creates a labeled Docker bridge network. An
+// existing cbox network with the same settings is reused; one with other
+// settings is recreated if nothing is attached to it. A network cbox didn't
+// create is an error.
+func CreateNetworkWithOptions(name string, opts NetworkOptions) error {
+	args := networkCreateArgs(name, opts)
+	res := runDocker(args...)
+	err := res.Failure()
+	if err == nil {
+		return nil
+	}
+	if !strings.Contains(res.Message(), "already exists") {
+		return fmt.Errorf("docker network create: %s: %w", res.Message(), err)
+	}
+
+	existing, err := inspectNetwork(name)
 	if err != nil {
-		// Ignore if network already exists
-		if strings.Contains(string(out), "already exists") {
-			return nil
-		}
-		return fmt.Errorf("docker network create: %s: %w", strings.TrimSpace(string(out)), err)
+		return err
+	}
+	switch {
+	case !existing.cbox && !opts.Owned:
+		return fmt.Errorf("network %s already exists but was not created by cbox — remove or rename it (docker network rm %s)", name, name)
+	case existing.cbox && existing.internal == opts.Internal:
+		return nil
+	case existing.containers > 0 && existing.internal == opts.Internal:
+		return nil // unlabeled but in use; relabeled once it is free
+	case existing.containers > 0:
+		return fmt.Errorf("network %s has different settings (internal=%t) and containers attached — run 'cbox down' first", name, existing.internal)
+	}
+	if res := runDocker("network", "rm", name); res.Failure() != nil {
+		return fmt.Errorf("docker network rm: %s: %w", res.Message(), res.Failure())
+	}
+	if res := runDocker(args...); res.Failure() != nil {
+		return fmt.Errorf("docker network create: %s: %w", res.Message(), res.Failure())
 	}
 	return nil
 }
 
-// RemoveNetwork removes a Docker network.
+func networkCreateArgs(name string, opts NetworkOptions) []string {
+	labels := map[string]string{NetworkLabel: "1"}
+	if opts.Project != "" {
+		maps.Copy(labels, ResourceLabels(opts.Project, opts.Branch))
+	}
+	args := append([]string{"network", "create"}, labelArgs(labels)...)
+	if opts.Internal {
+		args = append(args, "--internal")
+	}
+	return append(args, name)
+}
+
+type networkInfo struct {
+	cbox       bool
+	internal   bool
+	containers int
+}
+
+// inspectNetwork reads the properties CreateNetworkWithOptions and
+// RemoveNetwork check before touching an existing network.
+func inspectNetwork(name string) (networkInfo, error) {
+	format := `{{index .Labels "` + NetworkLabel + `"}} {{.Internal}} {{len .Containers}}`
+	res := runDocker("network", "inspect", "--format", format, name)
+	if err := res.Failure(); err != nil {
+		return networkInfo{}, fmt.Errorf("docker network inspect: %s: %w", res.Message(), err)
+	}
+	return parseNetworkInfo(res.Stdout)
+}
+
+func parseNetworkInfo(out string) (networkInfo, error) {
+	// A missing label prints as "" or "<no value>", depending on whether
+	// the network has any labels at all.
+	fields := strings.Fields(strings.ReplaceAll(out, "<no value>", ""))
+	if len(fields) == 2 {
+		fields = append([]string{""}, fields...)
+	}
+	if len(fields) != 3 {
+		return networkInfo{}, fmt.Errorf("unexpected docker network inspect output %q", strings.TrimSpace(out))
+	}
+	containers, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return networkInfo{}, fmt.Errorf("unexpected docker network inspect output %q", strings.TrimSpace(out))
+	}
+	return networkInfo{
+		cbox:       fields[0] == "1",
+		internal:   fields[1] == "true",
+		containers: containers,
+	}, nil
+}
+
+// RemoveNetwork removes a Docker network created by cbox. Missing networks
+// and networks without NetworkLabel are left alone.
 func RemoveNetwork(name string) error {
-	cmd := exec.Command("docker", "network", "rm", name)
-	cmd.Run() // ignore error if network doesn't exist
+	info, err := inspectNetwork(name)
+	if err != nil || !info.cbox {
+		return nil
+	}
+	runDocker("network", "rm", name) // best effort, e.g. if still in use
+	return nil
+}
+
+// RemoveOwnedNetwork removes a network cbox recorded as its own (see
+// NetworkOptions.Owned), labeled or not. A missing network is left alone.
+func RemoveOwnedNetwork(name string) error {
+	if _, err := inspectNetwork(name); err != nil {
+		return nil
+	}
+	runDocker("network", "rm", name) // best effort, e.g. if still in use
 	return nil
 }
 
 // NetworkConnect connects a container to a network. It is idempotent.
 func NetworkConnect(network, container string) {
-	exec.Command("docker", "network", "connect", network, container).Run()
-}
-
-// terminalEnvArgs returns docker exec -e flags for host terminal environment
-// variables. These allow applications inside the container to detect the
-// terminal emulator and enable features like enhanced keyboard protocols
-// (e.g. kitty keyboard protocol for Shift+Enter) and inline image display.
-func terminalEnvArgs() []string {
-	vars := []string{
-		"COLORTERM",
-		"TERM_PROGRAM",
-		"TERM_PROGRAM_VERSION",
-		"LC_TERMINAL",
-		"LC_TERMINAL_VERSION",
-		"KITTY_WINDOW_ID",
-		"KITTY_PID",
-		"ITERM_SESSION_ID",
-		"WT_SESSION",
-		"WT_PROFILE_ID",
-		"TERMINAL_EMULATOR",
-		"WEZTERM_PANE",
-		"KONSOLE_VERSION",
-		"VTE_VERSION",
-	}
+	runDocker("network", "connect", network, container)
+}
+
+// defaultTerminalEnv lists the host environment variables forwarded to
+// interactive docker exec sessions. These allow applications inside the
+// container to detect the terminal emulator and enable features like enhanced
+// keyboard protocols (e.g. kitty keyboard protocol for Shift+Enter) and inline
+// image display. TERM and LANG affect rendering directly. COLUMNS and LINES
+// are left out: docker exec -t sizes the terminal itself, and values frozen
+// at exec time would be wrong as soon as the window is resized.
+var defaultTerminalEnv = []string{
+	"TERM",
+	"LANG",
+	"COLORTERM",
+	"TERM_PROGRAM",
+	"TERM_PROGRAM_VERSION",
+	"LC_TERMINAL",
+	"LC_TERMINAL_VERSION",
+	"KITTY_WINDOW_ID",
+	"KITTY_PID",
+	"ITERM_SESSION_ID",
+	"WT_SESSION",
+	"WT_PROFILE_ID",
+	"TERMINAL_EMULATOR",
+	"WEZTERM_PANE",
+	"KONSOLE_VERSION",
+	"VTE_VERSION",
+}
+
+// terminalEnvArgs returns docker exec -e flags for the host terminal
+// environment variables in defaultTerminalEnv, followed by any extra variable
+// names (e.g. from the forward_env config). Unset variables and duplicates are
+// skipped.
+func terminalEnvArgs(extra ...string) []string {
+	seen := make(map[string]bool, len(defaultTerminalEnv)+len(extra))
 	var args []string
-	for _, v := range vars {
+	for _, v := range append(append([]string{}, defaultTerminalEnv...), extra...) {
+		if v == "" || seen[v] {
+			continue
+		}
+		seen[v] = true
 		if val := os.Getenv(v); val != "" {
 			args = append(args, "-e", v+"="+val)
 		}
@@ -88,52 +220,252 @@ func terminalEnvArgs() []string {
 	return args
 }
 
+// fallbackTerm replaces the host's TERM in the container when the image has
+// no terminfo entry for it (e.g. xterm-kitty or xterm-ghostty on a stock
+// Debian image), which would leave curses programs unusable.
+const fallbackTerm = "xterm-256color"
+
+// termFallbackScript runs its arguments with TERM set to fallbackTerm if
+// infocmp can't find TERM in the image. An image without infocmp keeps TERM.
+const termFallbackScript = `if command -v infocmp >/dev/null 2>&1 && ! infocmp "$TERM" >/dev/null 2>&1; then export TERM=` + fallbackTerm + `; fi; exec "$@"`
+
+// withTermFallback wraps commandArgs in termFallbackScript when TERM is
+// forwarded from the host.
+func withTermFallback(commandArgs []string) []string {
+	if os.Getenv("TERM") == "" {
+		return commandArgs
+	}
+	return append([]string{"sh", "-c", termFallbackScript, "sh"}, commandArgs...)
+}
+
+// ExecOptions controls how an interactive shell is started in a container.
+type ExecOptions struct {
+	User       string   // user to exec as; empty uses the container default
+	Workdir    string   // container working directory; empty uses the image default
+	ForwardEnv []string // extra host env var names to forward
+	RCFile     string   // bash --rcfile for Shell; empty uses the user's ~/.bashrc
+	ReadOnly   bool     // Attach only: watch the session without sending input
+}
+
+// ShellHome is where a sandbox's persistent shell directory is mounted. It
+// holds the bash history and the rc file Shell starts bash with.
+const ShellHome = "/home/claude/.cbox"
+
+// ChownShellHome gives the directory mounted at ShellHome, and the history
+// kept in it, to the container user, so it can write its history there
+// whatever its uid on the host. The mode is reset too, since earlier
+// versions made it world-writable.
+func ChownShellHome(container string) error {
+	history := ShellHome + "/bash_history"
+	script := "chown claude:claude " + ShellHome + " && chmod 0755 " + ShellHome +
+		" && if [ -e " + history + " ]; then chown claude:claude " + history + "; fi"
+	res := runDocker(dockerExecArgs(container, "root", "sh", "-c", script)...)
+	if err := res.Failure(); err != nil {
+		return fmt.Errorf("chown %s: %s: %w", ShellHome, res.Message(), err)
+	}
+	return nil
+}
+
+// ReleaseShellHome hands the directory mounted at ShellHome back to the host
+// user uid:gid before the container goes away, so the host can remove it
+// once no container is left to do it as root.
+func ReleaseShellHome(container string, uid, gid int) error {
+	script := fmt.Sprintf("chown -R %d:%d %s", uid, gid, ShellHome)
+	res := runDocker(dockerExecArgs(container, "root", "sh", "-c", script)...)
+	if err := res.Failure(); err != nil {
+		return fmt.Errorf("chown %s: %s: %w", ShellHome, res.Message(), err)
+	}
+	return nil
+}
+
+// ShellRCFile is the rc file inside ShellHome, for ExecOptions.RCFile.
+const ShellRCFile = ShellHome + "/bashrc"
+
+// ShellProjectRC is where the project's own bashrc is copied inside
+// ShellHome; ShellRC sources it last.
+const ShellProjectRC = ShellHome + "/project.bashrc"
+
+// ShellRC returns the contents of ShellRCFile: the user's normal ~/.bashrc,
+// history kept in ShellHome, then the project's bashrc if there is one.
+func ShellRC() string {
+	return `# Generated by cbox; cbox shell starts bash with this file.
+[ -f ~/.bashrc ] && . ~/.bashrc
+HISTFILE=` + ShellHome + `/bash_history
+HISTSIZE=10000
+HISTFILESIZE=10000
+shopt -s histappend
+PROMPT_COMMAND="history -a${PROMPT_COMMAND:+; $PROMPT_COMMAND}"
+[ -f ` + ShellProjectRC + ` ] && . ` + ShellProjectRC + `
+`
+}
+
+// ChatOptions controls how Claude Code is launched interactively.
+type ChatOptions struct {
+	Chrome        bool
+	InitialPrompt string
+	Resume        bool
+	Model         string   // passed as --model when set
+	SystemPrompt  string   // passed as --append-system-prompt when set
+	Workdir       string   // container working directory; empty uses the image default
+	ForwardEnv    []string // extra host env var names to forward
+}
+
 // Shell execs into a running container with an interactive shell.
-func Shell(name string) error {
-	dockerPath, err := exec.LookPath("docker")
-	if err != nil {
-		return fmt.Errorf("docker not found: %w", err)
+func Shell(name string, opts ExecOptions) error {
+	if opts.User == "" {
+		opts.User = "claude"
 	}
+	return ExecInteractive(name, opts, shellArgs(opts)...)
+}
 
-	args := []string{"docker", "exec", "-it"}
-	args = append(args, terminalEnvArgs()...)
-	args = append(args, "-u", "claude", name, "bash")
-	return syscall.Exec(dockerPath, args, os.Environ())
+func shellArgs(opts ExecOptions) []string {
+	if opts.RCFile != "" {
+		return []string{"bash", "--rcfile", opts.RCFile}
+	}
+	return []string{"bash"}
 }
 
 // Chat execs into the Claude container and launches Claude Code interactively.
-// If resume is true, passes --continue to resume the last conversation.
-// Otherwise, if initialPrompt is provided, it is sent as the first message.
-func Chat(name string, chrome bool, initialPrompt string, resume bool) error {
-	dockerPath, err := exec.LookPath("docker")
-	if err != nil {
-		return fmt.Errorf("docker not found: %w", err)
-	}
+// If opts.Resume is true, passes --continue to resume the last conversation.
+// Otherwise, if opts.InitialPrompt is provided, it is sent as the first message.
+func Chat(name string, opts ChatOptions) error {
+	return ExecInSession(name, ExecOptions{User: "claude", Workdir: opts.Workdir, ForwardEnv: opts.ForwardEnv}, chatArgs(opts)...)
+}
 
-	args := []string{"docker", "exec", "-it"}
-	args = append(args, terminalEnvArgs()...)
-	args = append(args, "-u", "claude", name, "claude", "--dangerously-skip-permissions")
-	if chrome {
+func chatArgs(opts ChatOptions) []string {
+	args := []string{"claude", "--dangerously-skip-permissions"}
+	if opts.Chrome {
 		args = append(args, "--chrome")
 	}
-	if resume {
+	if opts.Model != "" {
+		args = append(args, "--model", opts.Model)
+	}
+	if opts.SystemPrompt != "" {
+		args = append(args, "--append-system-prompt", opts.SystemPrompt)
+	}
+	if opts.Resume {
 		args = append(args, "--continue")
-	} else if initialPrompt != "" {
-		args = append(args, initialPrompt)
+	} else if opts.InitialPrompt != "" {
+		args = append(args, opts.InitialPrompt)
 	}
-	return syscall.Exec(dockerPath, args, os.Environ())
+	return args
+}
+
+// ChatSession is the tmux session that interactive chats run in. Running
+// inside tmux keeps the agent alive when the host terminal goes away, so
+// the session can be reattached with Attach.
+const ChatSession = "cbox-chat"
+
+// sessionArgs wraps commandArgs so they run in the ChatSession tmux session.
+// If the session already exists it is attached instead of starting a new one.
+func sessionArgs(commandArgs ...string) []string {
+	args := []string{"tmux", "new-session", "-A", "-s", ChatSession, "--"}
+	return append(args, commandArgs...)
+}
+
+// ExecInSession runs commandArgs interactively inside the ChatSession tmux
+// session. If the session is already running it is attached instead and
+// commandArgs don't run. An image without tmux (e.g. an ejected Dockerfile
+// that dropped it) runs commandArgs directly, with nothing to reattach.
+func ExecInSession(container string, opts ExecOptions, commandArgs ...string) error {
+	return ExecInteractive(container, opts, sessionExecArgs(container, opts.User, commandArgs)...)
+}
+
+// sessionExecArgs returns what ExecInSession execs, warning when that is not
+// a new session running commandArgs.
+func sessionExecArgs(container, user string, commandArgs []string) []string {
+	if runDocker(dockerExecArgs(container, user, "tmux", "-V")...).Failure() != nil {
+		output.Warning("tmux is not installed in %s, so this session ends when the terminal closes (add tmux to the image to keep it running)", container)
+		return commandArgs
+	}
+	if runDocker(dockerExecArgs(container, user, "tmux", "has-session", "-t", ChatSession)...).Failure() == nil {
+		output.Warning("Attaching to the session already running in %s; %s was not started again", container, commandArgs[0])
+	}
+	return sessionArgs(commandArgs...)
+}
+
+// Attach reconnects to a running ChatSession in the container.
+func Attach(container string, opts ExecOptions) error {
+	if _, err := ExecCombinedOutput(container, opts.User, "tmux", "has-session", "-t", ChatSession); err != nil {
+		return fmt.Errorf("no interactive chat session running in %s", container)
+	}
+	args := []string{"tmux", "attach-session", "-t", ChatSession}
+	if opts.ReadOnly {
+		args = append(args, "-r")
+	}
+	return ExecInteractive(container, opts, args...)
+}
+
+// ChatSessionClients reports whether user's ChatSession is running in the
+// container and how many terminals are attached to it. If docker can't be
+// asked, the session is reported as not running.
+func ChatSessionClients(container, user string) (running bool, clients int) {
+	out, err := ExecOutput(container, user, "tmux", "list-clients", "-t", ChatSession, "-F", "#{client_tty}")
+	if err != nil {
+		return false, 0
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.TrimSpace(line) != "" {
+			clients++
+		}
+	}
+	return true, clients
+}
+
+// PromptOptions controls a headless Claude Code run.
+type PromptOptions struct {
+	Prompt       string
+	OutputFormat string
+	Model        string    // passed as --model when set
+	SystemPrompt string    // passed as --append-system-prompt when set
+	Stdout       io.Writer // defaults to os.Stdout
 }
 
 // ChatPrompt runs Claude in headless mode with a prompt inside the Claude container.
-func ChatPrompt(name, prompt, outputFormat string) error {
-	cmd := exec.Command("docker", "exec", "-u", "claude", name,
+func ChatPrompt(name string, opts PromptOptions) error {
+	args := []string{"exec", "-u", "claude", name,
 		"claude", "--dangerously-skip-permissions",
-		"-p", prompt,
-		"--output-format", outputFormat,
-	)
+		"-p", opts.Prompt,
+		"--output-format", opts.OutputFormat,
+	}
+	if opts.Model != "" {
+		args = append(args, "--model", opts.Model)
+	}
+	if opts.SystemPrompt != "" {
+		args = append(args, "--append-system-prompt", opts.SystemPrompt)
+	}
+	cmd := exec.Command("docker", args...)
 	cmd.Stdout = os.Stdout
+	if opts.Stdout != nil {
+		cmd.Stdout = opts.Stdout
+	}
 	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	return cliMissing(cmd.Run())
+}
+
+// EgressSection returns the CLAUDE.md section for a sandbox whose outbound
+// access is limited to allowHosts, for passing to BuildClaudeMD as an extra.
+func EgressSection(allowHosts []string) string {
+	allowed := "Nothing outside the sandbox is reachable except the host MCP server."
+	if len(allowHosts) > 0 {
+		allowed = "Only these hosts are reachable: " + strings.Join(allowHosts, ", ") +
+			` (a leading "." includes subdomains).`
+	}
+	return `## Network
+
+Outbound network access is restricted. All HTTP(S) traffic goes through the
+proxy in HTTPS_PROXY; direct connections fail. ` + allowed + `
+If a request is blocked, tell the user which host you need instead of trying
+to work around the restriction.`
+}
+
+// GPUSection returns the CLAUDE.md section for a sandbox started with
+// --gpus, for passing to BuildClaudeMD as an extra.
+func GPUSection(gpus string) string {
+	return `## GPUs
+
+Host GPUs are passed through to this container (docker --gpus ` + strings.Trim(gpus, `"`) + `).
+Run ` + "`nvidia-smi`" + ` to see which devices are visible before starting GPU work.`
 }
 
 // wellKnownCommands lists the command names that cbox recognises out of the
@@ -164,7 +496,6 @@ isolated development. You do NOT have direct access to the host machine.
 
 - No language runtimes (no node, bun, python, go, cargo, etc.)
 - No package managers beyond apt (no npm, pip, brew, etc.)
-- No direct internet access beyond Docker networking
 - No direct access to the host filesystem, git, or CLI tools
 - Do NOT run apt-get install — the container is ephemeral and changes are lost on rebuild`)
 
@@ -178,7 +509,8 @@ Whitelisted commands: %s
 IMPORTANT:
 - You MUST use the run_command MCP tool for these — do not run them directly
 - Direct execution will fail or produce wrong results (wrong filesystem, wrong git repo)
-- The run_command tool executes in the host worktree, not inside this container`, strings.Join(hostCommands, ", "))
+- The run_command tool executes in the host worktree, not inside this container
+- Its output is cut to the last 20 lines of each stream (40 on failure); the full output is saved on the host`, strings.Join(hostCommands, ", "))
 
 		// Add gh-specific tips if gh is in the whitelist
 		for _, cmd := range hostCommands {
@@ -224,8 +556,10 @@ These MCP tools run on the host and are your primary way to build, test, and run
 
 Use these instead of trying to run build/test commands directly in the container.
 
-Each tool response includes the exit code and the most recent output inline (last 20 lines
-on success, last 40 lines on failure). Full logs are saved on the host for human operators.`, strings.Join(availableLines, "\n"))
+Each tool response includes the exit code and the most recent output inline, with stdout
+and stderr in separate "stdout:" and "stderr:" sections (last 20 lines of each on success,
+last 40 on failure, with a note of how much was cut). Full logs are saved on the host for
+human operators.`, strings.Join(availableLines, "\n"))
 	} else {
 		cmdSection = `## Project Commands (MCP)
 
@@ -248,6 +582,15 @@ To add them, the user can define them in cbox.toml under [commands].`, strings.J
 
 	sections = append(sections, cmdSection)
 
+	// The MCP server only runs when there are host or project commands.
+	if len(hostCommands) > 0 || len(namedCommands) > 0 {
+		sections = append(sections, `## Environment check (MCP)
+
+Call the cbox_env MCP tool for a JSON summary of this sandbox: which runtimes are
+installed, the host and project commands you can use, exposed ports and the serve
+URL. Prefer it to probing the container with shell commands.`)
+	}
+
 	// Exposed ports section
 	if len(ports) > 0 {
 		var portLines []string
@@ -318,38 +661,174 @@ and references it in cbox.toml. This makes the tools available directly in the c
 	return strings.Join(sections, "\n\n") + "\n"
 }
 
-// InjectClaudeMD writes a system-level CLAUDE.md into the Claude container at
-// ~/.claude/CLAUDE.md so Claude Code understands the container environment.
+// Markers delimiting the cbox-managed section of a CLAUDE.md. Content outside
+// them belongs to the user and is preserved when cbox rewrites its section.
+const (
+	ClaudeMDStart = "<!-- cbox-generated-claude-md:start -->"
+	ClaudeMDEnd   = "<!-- cbox-generated-claude-md:end -->"
+)
+
+// claudeMDPath is the user-level CLAUDE.md inside the Claude container.
+const claudeMDPath = "/home/claude/.claude/CLAUDE.md"
+
+// InjectClaudeMD writes the cbox environment section into ~/.claude/CLAUDE.md
+// in the Claude container so Claude Code understands the container
+// environment. Anything already in the file outside the cbox markers is kept,
+// and injecting again replaces the previous section.
 func InjectClaudeMD(claudeContainer string, hostCommands []string, namedCommands map[string]string, ports []string, extras ...string) error {
 	claudeMD := BuildClaudeMD(hostCommands, namedCommands, ports, extras...)
+	existing := readClaudeMD(claudeContainer)
+	// Containers started by older versions hold an unmarked cbox file;
+	// replace it rather than keeping a second copy of the instructions.
+	if _, _, _, found := splitManagedClaudeMD(existing); !found && strings.HasPrefix(existing, "# CBox Container Environment") {
+		existing = ""
+	}
+	return writeClaudeMD(claudeContainer, MergeClaudeMD(existing, claudeMD))
+}
 
-	writeCmd := "mkdir -p /home/claude/.claude && cat > /home/claude/.claude/CLAUDE.md && chown -R claude:claude /home/claude/.claude"
-	cmd := exec.Command("docker", "exec", "-i", claudeContainer, "sh", "-c", writeCmd)
-	cmd.Stdin = strings.NewReader(claudeMD)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("writing CLAUDE.md: %s: %w", strings.TrimSpace(string(out)), err)
+// AppendClaudeMD adds text to the end of the cbox-managed section of the
+// CLAUDE.md inside the Claude container, creating the section if needed.
+func AppendClaudeMD(claudeContainer, text string) error {
+	existing := readClaudeMD(claudeContainer)
+	return writeClaudeMD(claudeContainer, appendManagedClaudeMD(existing, text))
+}
+
+// MergeClaudeMD replaces the cbox-managed section of existing with generated,
+// or appends a new section if there is none.
+func MergeClaudeMD(existing, generated string) string {
+	managed := ClaudeMDStart + "\n" + strings.TrimRight(generated, "\n") + "\n" + ClaudeMDEnd
+	if strings.TrimSpace(existing) == "" {
+		return managed + "\n"
+	}
+
+	prefix, _, suffix, found := splitManagedClaudeMD(existing)
+	if !found {
+		return strings.TrimRight(existing, "\n") + "\n\n" + managed + "\n"
+	}
+	switch {
+	case prefix == "" && suffix == "":
+		return managed + "\n"
+	case prefix == "":
+		return managed + "\n\n" + suffix
+	case suffix == "":
+		return prefix + "\n\n" + managed + "\n"
+	default:
+		return prefix + "\n\n" + managed + "\n\n" + suffix
+	}
+}
+
+// appendManagedClaudeMD adds text to the end of the managed section.
+func appendManagedClaudeMD(existing, text string) string {
+	_, body, _, _ := splitManagedClaudeMD(existing)
+	body = strings.TrimRight(body, "\n")
+	if body != "" {
+		body += "\n\n"
+	}
+	return MergeClaudeMD(existing, body+strings.TrimRight(text, "\n"))
+}
+
+// splitManagedClaudeMD splits content around the cbox markers. prefix and
+// suffix are trimmed of the blank lines that separate them from the section.
+func splitManagedClaudeMD(content string) (prefix, body, suffix string, found bool) {
+	start := strings.Index(content, ClaudeMDStart)
+	if start < 0 {
+		return "", "", "", false
+	}
+	end := strings.Index(content[start:], ClaudeMDEnd)
+	if end < 0 {
+		return "", "", "", false
+	}
+	end += start
+	prefix = strings.TrimRight(content[:start], "\n")
+	body = strings.Trim(content[start+len(ClaudeMDStart):end], "\n")
+	suffix = strings.TrimLeft(content[end+len(ClaudeMDEnd):], "\n")
+	return prefix, body, suffix, true
+}
+
+// readClaudeMD returns the container's CLAUDE.md, or "" if it doesn't exist.
+func readClaudeMD(claudeContainer string) string {
+	res := runDocker("exec", claudeContainer, "cat", claudeMDPath)
+	if res.Failure() != nil {
+		return ""
+	}
+	return res.Stdout
+}
+
+// writeClaudeMD replaces the container's CLAUDE.md with content.
+func writeClaudeMD(claudeContainer, content string) error {
+	writeCmd := "mkdir -p /home/claude/.claude && cat > " + claudeMDPath + " && chown -R claude:claude /home/claude/.claude"
+	res := runner.Run(Command{
+		Args:  []string{"exec", "-i", claudeContainer, "sh", "-c", writeCmd},
+		Stdin: strings.NewReader(content),
+	})
+	if err := res.Failure(); err != nil {
+		return fmt.Errorf("writing CLAUDE.md: %s: %w", res.Message(), err)
 	}
 	return nil
 }
 
-// AppendClaudeMD appends text to the CLAUDE.md file inside the Claude container.
-func AppendClaudeMD(claudeContainer, text string) error {
-	cmd := exec.Command("docker", "exec", claudeContainer,
-		"sh", "-c", `printf '\n%s\n' "$0" >> /home/claude/.claude/CLAUDE.md`, text)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("appending to CLAUDE.md: %s: %w", strings.TrimSpace(string(out)), err)
+// knownRuntimes are the language runtimes and tools ContainerRuntimes looks
+// for on the container's PATH.
+var knownRuntimes = []string{
+	"node", "npm", "pnpm", "yarn", "bun", "deno",
+	"python3", "pip3", "uv",
+	"go", "cargo", "rustc",
+	"ruby", "java", "php", "dotnet",
+	"make", "git", "docker",
+}
+
+// ContainerRuntimes returns which of the known runtimes are installed in the
+// container.
+func ContainerRuntimes(container string) ([]string, error) {
+	script := `for c in "$@"; do command -v "$c" >/dev/null 2>&1 && echo "$c"; done; true`
+	args := append([]string{"exec", "-u", "claude", container, "sh", "-c", script, "sh"}, knownRuntimes...)
+	res := runDocker(args...)
+	if err := res.Failure(); err != nil {
+		return nil, fmt.Errorf("listing runtimes in %s: %w", container, err)
+	}
+	return strings.Fields(res.Stdout), nil
+}
+
+// ErrNoProbeTool is returned by ProbeHost when the image has neither curl
+// nor bash to probe with.
+var ErrNoProbeTool = errors.New("neither curl nor bash is installed in the container")
+
+// ProbeHost checks that the container can reach an HTTP endpoint on the host
+// at host.docker.internal:port. It returns curl's output on failure. Images
+// without curl get a plain TCP connect through bash's /dev/tcp instead.
+func ProbeHost(container string, port int, path string) error {
+	url := fmt.Sprintf("http://host.docker.internal:%d%s", port, path)
+	res := runDocker("exec", container,
+		"curl", "-fsS", "--max-time", "3", "-o", "/dev/null", url)
+	if commandMissing(res) {
+		script := fmt.Sprintf("exec 3<>/dev/tcp/host.docker.internal/%d", port)
+		res = runDocker("exec", container, "timeout", "3", "bash", "-c", script)
+		if commandMissing(res) {
+			return ErrNoProbeTool
+		}
+	}
+	if err := res.Failure(); err != nil {
+		msg := res.Message()
+		if msg == "" {
+			msg = err.Error()
+		}
+		return fmt.Errorf("%s: %s", url, msg)
 	}
 	return nil
 }
 
+// commandMissing reports whether a docker exec failed because the command
+// isn't installed in the container.
+func commandMissing(res Result) bool {
+	return res.Code == 127 || strings.Contains(res.Message(), "executable file not found")
+}
+
 // InjectMCPConfig registers the host MCP server with Claude Code inside the container
 // using `claude mcp add`. This stores the config in Claude Code's internal settings
 // rather than a .mcp.json file in the workspace.
 func InjectMCPConfig(claudeContainer string, mcpPort int) error {
 	url := fmt.Sprintf("http://host.docker.internal:%d/mcp", mcpPort)
-	cmd := exec.Command("docker", "exec", "-u", "claude",
+	res := runDocker("exec", "-u", "claude",
 		"-e", "CLAUDECODE=",
 		claudeContainer,
 		"claude", "mcp", "add",
@@ -357,39 +836,143 @@ func InjectMCPConfig(claudeContainer string, mcpPort int) error {
 		"--scope", "local",
 		"cbox-host", url,
 	)
-	out, err := cmd.CombinedOutput()
+	if err := res.Failure(); err != nil {
+		return fmt.Errorf("registering MCP server: %s: %w", res.Message(), err)
+	}
+	return nil
+}
+
+// MCPServer is an additional MCP server, reached over the network, to
+// register with the agent alongside cbox's own host server.
+type MCPServer struct {
+	Name      string
+	Transport string // "http" (the default) or "sse"
+	URL       string
+	Headers   map[string]string
+}
+
+// AddMCPServer registers server with Claude Code inside the container, like
+// InjectMCPConfig does for the host server. An existing registration with
+// the same name is replaced so config changes apply on the next up.
+func AddMCPServer(claudeContainer string, server MCPServer) error {
+	runDocker("exec", "-u", "claude", "-e", "CLAUDECODE=", claudeContainer,
+		"claude", "mcp", "remove", "--scope", "local", server.Name)
+
+	cmd, err := mcpAddCommand(claudeContainer, server)
 	if err != nil {
-		return fmt.Errorf("registering MCP server: %s: %w", strings.TrimSpace(string(out)), err)
+		return err
+	}
+	res := runner.Run(cmd)
+	if err := res.Failure(); err != nil {
+		return fmt.Errorf("registering MCP server %s: %s: %w", server.Name, res.Message(), err)
 	}
 	return nil
 }
 
+// mcpServerEnv carries an MCP server's JSON config into the container.
+const mcpServerEnv = "CBOX_MCP_SERVER"
+
+// mcpAddCommand builds the `claude mcp add-json` exec for server. Headers
+// often hold tokens, so the config is passed through the docker CLI's
+// environment by name rather than on its command line, where ps shows it.
+func mcpAddCommand(claudeContainer string, server MCPServer) (Command, error) {
+	transport := server.Transport
+	if transport == "" {
+		transport = "http"
+	}
+	config, err := json.Marshal(struct {
+		Type    string            `json:"type"`
+		URL     string            `json:"url"`
+		Headers map[string]string `json:"headers,omitempty"`
+	}{transport, server.URL, server.Headers})
+	if err != nil {
+		return Command{}, fmt.Errorf("encoding MCP server %s: %w", server.Name, err)
+	}
+	return Command{
+		Args: []string{"exec", "-u", "claude",
+			"-e", "CLAUDECODE=",
+			"-e", mcpServerEnv,
+			claudeContainer,
+			"sh", "-c", `claude mcp add-json --scope local "$1" "$` + mcpServerEnv + `"`, "sh", server.Name,
+		},
+		Env: []string{mcpServerEnv + "=" + string(config)},
+	}, nil
+}
+
 // InjectFile writes arbitrary content to a path inside a running container.
 // Parent directories are created automatically and ownership is set to claude:claude.
 func InjectFile(container, path, content string) error {
 	dir := filepath.Dir(path)
 	writeCmd := fmt.Sprintf("mkdir -p %s && cat > %s && chown claude:claude %s", dir, path, path)
-	cmd := exec.Command("docker", "exec", "-i", container, "sh", "-c", writeCmd)
-	cmd.Stdin = strings.NewReader(content)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("writing %s: %s: %w", path, strings.TrimSpace(string(out)), err)
+	res := runner.Run(Command{
+		Args:  []string{"exec", "-i", container, "sh", "-c", writeCmd},
+		Stdin: strings.NewReader(content),
+	})
+	if err := res.Failure(); err != nil {
+		return fmt.Errorf("writing %s: %s: %w", path, res.Message(), err)
 	}
 	return nil
 }
 
+// ClaudeVersion returns the output of `claude --version` inside the container,
+// e.g. "1.0.44 (Claude Code)".
+func ClaudeVersion(container string) (string, error) {
+	out, err := ExecOutput(container, "claude", "claude", "--version")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
 // HasConversationHistory checks if Claude Code has any conversation history
 // inside the given container. It runs `claude conversation list` and returns
 // true if any conversations exist.
 func HasConversationHistory(containerName string) (bool, error) {
-	cmd := exec.Command("docker", "exec", "-u", "claude", containerName,
+	res := runDocker("exec", "-u", "claude", containerName,
 		"claude", "conversation", "list", "--output-format", "json")
-	out, err := cmd.Output()
-	if err != nil {
+	if err := res.Failure(); err != nil {
 		return false, fmt.Errorf("checking conversation history: %w", err)
 	}
 
-	return parseConversationList(out), nil
+	return parseConversationList([]byte(res.Stdout)), nil
+}
+
+// conversationDir holds Claude Code's per-project conversation transcripts.
+const conversationDir = "/home/claude/.claude/projects"
+
+// ExportConversations returns a tar of Claude Code's conversation history in
+// the container, or nil when there is none, for ImportConversations to put
+// into a replacement container. docker cp works on a stopped container too.
+func ExportConversations(container string) ([]byte, error) {
+	res := runDocker("cp", container+":"+conversationDir, "-")
+	if res.Code != 0 && strings.Contains(res.Stderr, "Could not find the file") {
+		return nil, nil
+	}
+	if err := res.Failure(); err != nil {
+		return nil, fmt.Errorf("exporting conversation history: %s: %w", res.Message(), err)
+	}
+	if res.Stdout == "" {
+		return nil, nil
+	}
+	return []byte(res.Stdout), nil
+}
+
+// ImportConversations unpacks a tar from ExportConversations into the
+// container, where `claude --continue` picks it up.
+func ImportConversations(container string, history []byte) error {
+	if len(history) == 0 {
+		return nil
+	}
+	home := filepath.Dir(conversationDir)
+	script := "mkdir -p " + home + " && tar -x -C " + home + " && chown -R claude:claude " + home
+	res := runner.Run(Command{
+		Args:  []string{"exec", "-i", "-u", "root", container, "sh", "-c", script},
+		Stdin: bytes.NewReader(history),
+	})
+	if err := res.Failure(); err != nil {
+		return fmt.Errorf("importing conversation history: %s: %w", res.Message(), err)
+	}
+	return nil
 }
 
 // parseConversationList returns true if the output from
@@ -401,52 +984,80 @@ func parseConversationList(output []byte) bool {
 
 // IsRunning checks if a container is currently running.
 func IsRunning(name string) (bool, error) {
-	cmd := exec.Command("docker", "inspect", "-f", "{{.State.Running}}", name)
-	out, err := cmd.Output()
-	if err != nil {
+	res := runDocker("inspect", "-f", "{{.State.Running}}", name)
+	if err := res.Failure(); err != nil {
 		return false, err
 	}
-	return strings.TrimSpace(string(out)) == "true", nil
+	return strings.TrimSpace(res.Stdout) == "true", nil
+}
+
+// ContainerStatus returns the container's docker status ("running",
+// "exited", ...), or "" if the container does not exist.
+func ContainerStatus(name string) (string, error) {
+	res := runDocker("inspect", "-f", "{{.State.Status}}", name)
+	if err := res.Failure(); err != nil {
+		if strings.Contains(strings.ToLower(res.Message()), "no such") {
+			return "", nil
+		}
+		return "", fmt.Errorf("docker inspect: %s: %w", res.Message(), err)
+	}
+	return strings.TrimSpace(res.Stdout), nil
+}
+
+// ContainerImageID returns the ID of the image a container was created from.
+func ContainerImageID(name string) (string, error) {
+	res := runDocker("inspect", "-f", "{{.Image}}", name)
+	if err := res.Failure(); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(res.Stdout), nil
+}
+
+// ImageID returns the ID the given image reference currently points to.
+func ImageID(image string) (string, error) {
+	res := runDocker("image", "inspect", "-f", "{{.Id}}", image)
+	if err := res.Failure(); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(res.Stdout), nil
+}
+
+// ForceRemove removes a container with docker rm -f, killing it without a
+// graceful stop. It returns nil if the container did not exist.
+func ForceRemove(name string) error {
+	res := runDocker("rm", "-f", name)
+	if err := res.Failure(); err != nil {
+		if strings.Contains(strings.ToLower(res.Message()), "no such container") {
+			return nil
+		}
+		return fmt.Errorf("docker rm -f: %s: %w", res.Message(), err)
+	}
+	return nil
 }
 
 // StopAndRemove stops and removes a container.
 // It returns nil if the container was successfully removed or did not exist.
 func StopAndRemove(name string) error {
-	stop := exec.Command("docker", "stop", name)
-	stop.Run() // ignore error — container may already be stopped
+	runDocker("stop", name) // ignore error — container may already be stopped
 
-	rm := exec.Command("docker", "rm", name)
-	out, err := rm.CombinedOutput()
-	if err != nil {
-		outStr := strings.TrimSpace(string(out))
+	res := runDocker("rm", name)
+	if err := res.Failure(); err != nil {
 		// Not an error if the container doesn't exist
-		if strings.Contains(outStr, "No such container") ||
-			strings.Contains(outStr, "no such container") {
+		if strings.Contains(strings.ToLower(res.Message()), "no such container") {
 			return nil
 		}
-		return fmt.Errorf("docker rm: %s: %w", outStr, err)
+		return fmt.Errorf("docker rm: %s: %w", res.Message(), err)
 	}
 	return nil
 }
 
-// GenerateEnvFile writes a temporary env file from the host environment for the given var names.
-func GenerateEnvFile(dir string, envVars []string) (string, error) {
-	var lines []string
-	for _, env := range envVars {
-		val := os.Getenv(env)
-		if val != "" {
-			lines = append(lines, env+"="+val)
-		}
-	}
-
-	if len(lines) == 0 {
-		return "", nil
-	}
-
-	path := filepath.Join(dir, ".cbox.env")
-	err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600)
-	if err != nil {
-		return "", fmt.Errorf("writing env file: %w", err)
+// Restart restarts a container, starting it if it has stopped. Unlike
+// recreating it, this keeps the container's filesystem, including the
+// agent's conversation history.
+func Restart(name string) error {
+	res := runDocker("restart", name)
+	if err := res.Failure(); err != nil {
+		return fmt.Errorf("docker restart: %s: %w", res.Message(), err)
 	}
-	return path, nil
+	return nil
 }
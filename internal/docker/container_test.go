@@ -1,8 +1,11 @@
 package docker
 
 import (
+	"errors"
+	"io"
 	"os"
 	"os/exec"
+	"slices"
 	"strings"
 	"testing"
 )
@@ -11,21 +14,21 @@ func hasDocker() bool {
 	return exec.Command("docker", "info").Run() == nil
 }
 
+// clearTerminalEnv unsets every default forwarded terminal env var so tests
+// start from a known state.
+func clearTerminalEnv(t *testing.T) {
+	t.Helper()
+	for _, v := range defaultTerminalEnv {
+		t.Setenv(v, "")
+		os.Unsetenv(v)
+	}
+}
+
 // TestTerminalEnvArgs verifies that terminalEnvArgs returns -e flags only for
 // terminal env vars that are actually set in the environment.
 func TestTerminalEnvArgs(t *testing.T) {
 	// Clear all terminal env vars to start from a known state.
-	termVars := []string{
-		"COLORTERM", "TERM_PROGRAM", "TERM_PROGRAM_VERSION",
-		"LC_TERMINAL", "LC_TERMINAL_VERSION",
-		"KITTY_WINDOW_ID", "KITTY_PID", "ITERM_SESSION_ID",
-		"WT_SESSION", "WT_PROFILE_ID", "TERMINAL_EMULATOR",
-		"WEZTERM_PANE", "KONSOLE_VERSION", "VTE_VERSION",
-	}
-	for _, v := range termVars {
-		t.Setenv(v, "")
-		os.Unsetenv(v)
-	}
+	clearTerminalEnv(t)
 
 	// With nothing set, should return nil.
 	args := terminalEnvArgs()
@@ -51,17 +54,7 @@ func TestTerminalEnvArgs(t *testing.T) {
 
 // TestTerminalEnvArgsSingleVar verifies correct output when exactly one var is set.
 func TestTerminalEnvArgsSingleVar(t *testing.T) {
-	termVars := []string{
-		"COLORTERM", "TERM_PROGRAM", "TERM_PROGRAM_VERSION",
-		"LC_TERMINAL", "LC_TERMINAL_VERSION",
-		"KITTY_WINDOW_ID", "KITTY_PID", "ITERM_SESSION_ID",
-		"WT_SESSION", "WT_PROFILE_ID", "TERMINAL_EMULATOR",
-		"WEZTERM_PANE", "KONSOLE_VERSION", "VTE_VERSION",
-	}
-	for _, v := range termVars {
-		t.Setenv(v, "")
-		os.Unsetenv(v)
-	}
+	clearTerminalEnv(t)
 
 	t.Setenv("KITTY_WINDOW_ID", "42")
 
@@ -77,6 +70,50 @@ func TestTerminalEnvArgsSingleVar(t *testing.T) {
 	}
 }
 
+// TestTerminalEnvArgsExtraVars verifies that extra forward_env names are
+// appended after the defaults and that duplicates are only emitted once.
+func TestTerminalEnvArgsExtraVars(t *testing.T) {
+	clearTerminalEnv(t)
+
+	t.Setenv("TERM", "xterm-ghostty")
+	t.Setenv("GHOSTTY_RESOURCES_DIR", "/opt/ghostty")
+
+	args := terminalEnvArgs("GHOSTTY_RESOURCES_DIR", "TERM", "CBOX_UNSET_VAR")
+	expected := []string{"-e", "TERM=xterm-ghostty", "-e", "GHOSTTY_RESOURCES_DIR=/opt/ghostty"}
+	if len(args) != len(expected) {
+		t.Fatalf("expected %d args, got %d: %v", len(expected), len(args), args)
+	}
+	for i, want := range expected {
+		if args[i] != want {
+			t.Errorf("args[%d] = %q, want %q", i, args[i], want)
+		}
+	}
+}
+
+// TestWithTermFallback verifies that a forwarded TERM the image doesn't know
+// is replaced with xterm-256color, and a known one is kept.
+func TestWithTermFallback(t *testing.T) {
+	clearTerminalEnv(t)
+	if got := withTermFallback([]string{"bash"}); !slices.Equal(got, []string{"bash"}) {
+		t.Errorf("without TERM: %q, want the command unchanged", got)
+	}
+
+	if _, err := exec.LookPath("infocmp"); err != nil {
+		t.Skip("infocmp not installed")
+	}
+	for term, want := range map[string]string{"xterm": "xterm", "cbox-unknown-term": fallbackTerm} {
+		t.Setenv("TERM", term)
+		args := withTermFallback([]string{"sh", "-c", "echo $TERM"})
+		out, err := exec.Command(args[0], args[1:]...).Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.TrimSpace(string(out)); got != want {
+			t.Errorf("TERM=%s: command saw TERM=%s, want %s", term, got, want)
+		}
+	}
+}
+
 // TestBuildClaudeMD_AllCommands verifies that when all well-known commands are
 // configured, none appear in the "not available" section.
 func TestBuildClaudeMD_AllCommands(t *testing.T) {
@@ -208,6 +245,50 @@ func TestBuildClaudeMD_SetupInHelpText(t *testing.T) {
 	}
 }
 
+func TestMergeClaudeMD_PreservesUserContent(t *testing.T) {
+	user := "# My preferences\n\nUse tabs."
+
+	first := MergeClaudeMD(user, "cbox v1")
+	if !strings.HasPrefix(first, user) {
+		t.Errorf("user content should come first, got:\n%s", first)
+	}
+	if !strings.Contains(first, ClaudeMDStart+"\ncbox v1\n"+ClaudeMDEnd) {
+		t.Errorf("expected managed section, got:\n%s", first)
+	}
+
+	second := MergeClaudeMD(first+"\nMore notes.\n", "cbox v2")
+	if strings.Contains(second, "cbox v1") {
+		t.Errorf("re-injection should replace the old section, got:\n%s", second)
+	}
+	if strings.Count(second, ClaudeMDStart) != 1 {
+		t.Errorf("expected exactly one managed section, got:\n%s", second)
+	}
+	if !strings.Contains(second, "Use tabs.") || !strings.HasSuffix(second, "More notes.\n") {
+		t.Errorf("user content around the section should be kept, got:\n%s", second)
+	}
+}
+
+func TestAppendManagedClaudeMD_InsertsInsideSection(t *testing.T) {
+	content := MergeClaudeMD("user notes", "cbox base")
+	content += "\ntrailing user notes\n"
+
+	got := appendManagedClaudeMD(content, "extra instructions")
+	_, body, suffix, found := splitManagedClaudeMD(got)
+	if !found {
+		t.Fatalf("managed section missing:\n%s", got)
+	}
+	if body != "cbox base\n\nextra instructions" {
+		t.Errorf("section body = %q", body)
+	}
+	if suffix != "trailing user notes\n" {
+		t.Errorf("suffix = %q, want trailing user notes", suffix)
+	}
+
+	if fresh := appendManagedClaudeMD("", "only"); fresh != ClaudeMDStart+"\nonly\n"+ClaudeMDEnd+"\n" {
+		t.Errorf("append to empty file = %q", fresh)
+	}
+}
+
 // TestParseConversationList verifies parsing of claude conversation list output.
 func TestParseConversationList(t *testing.T) {
 	tests := []struct {
@@ -278,3 +359,344 @@ func TestStopAndRemoveRunning(t *testing.T) {
 		t.Error("container still exists after StopAndRemove")
 	}
 }
+
+func TestSessionArgs(t *testing.T) {
+	got := sessionArgs("claude", "--continue")
+	want := []string{"tmux", "new-session", "-A", "-s", ChatSession, "--", "claude", "--continue"}
+	if len(got) != len(want) {
+		t.Fatalf("sessionArgs() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("sessionArgs()[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestSessionExecArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		tmux    bool
+		session bool
+		want    string
+	}{
+		{"new session", true, false, "tmux new-session -A -s " + ChatSession + " -- claude"},
+		{"existing session", true, true, "tmux new-session -A -s " + ChatSession + " -- claude"},
+		{"no tmux", false, false, "claude"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFakeRunner(t, func(args string) Result {
+				switch {
+				case strings.HasSuffix(args, "tmux -V") && !tt.tmux:
+					return Result{Stderr: "exec: \"tmux\": executable file not found in $PATH", Code: 127}
+				case strings.Contains(args, "has-session") && !tt.session:
+					return Result{Stderr: "can't find session", Code: 1}
+				}
+				return Result{}
+			})
+			if got := strings.Join(sessionExecArgs("c", "claude", []string{"claude"}), " "); got != tt.want {
+				t.Errorf("sessionExecArgs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConversationsRoundTrip(t *testing.T) {
+	f := useFakeRunner(t, func(args string) Result {
+		switch args {
+		case "cp old:/home/claude/.claude/projects -":
+			return Result{Stdout: "tar-bytes"}
+		case "cp fresh:/home/claude/.claude/projects -":
+			return Result{Code: 1, Stderr: "Error response from daemon: Could not find the file /home/claude/.claude/projects in container fresh"}
+		}
+		return Result{}
+	})
+	history, err := ExportConversations("old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ImportConversations("new", history); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.calls) != 2 {
+		t.Fatalf("commands = %q", f.commands())
+	}
+	imp := f.calls[1]
+	if !strings.HasPrefix(strings.Join(imp.Args, " "), "exec -i -u root new sh -c ") || imp.Stdin == nil {
+		t.Fatalf("import ran %q, want the tar on stdin of a root exec", imp.Args)
+	}
+	if data, _ := io.ReadAll(imp.Stdin); string(data) != "tar-bytes" {
+		t.Errorf("import stdin = %q, want the exported tar", data)
+	}
+
+	// No history, nothing to import.
+	if history, err := ExportConversations("fresh"); err != nil || history != nil {
+		t.Errorf("ExportConversations(fresh) = %q, %v; want no history", history, err)
+	}
+	f.calls = nil
+	if err := ImportConversations("new", nil); err != nil || len(f.calls) != 0 {
+		t.Errorf("ImportConversations(nil) = %v with %d commands, want a no-op", err, len(f.calls))
+	}
+}
+
+func TestShellHomeOwnership(t *testing.T) {
+	f := useFakeRunner(t, nil)
+	if err := ChownShellHome("c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReleaseShellHome("c", 1000, 1000); err != nil {
+		t.Fatal(err)
+	}
+	cmds := f.commands()
+	if len(cmds) != 2 {
+		t.Fatalf("commands = %q", cmds)
+	}
+	if !strings.Contains(cmds[0], "-u root c sh -c chown claude:claude "+ShellHome+" ") || !strings.Contains(cmds[0], ShellHome+"/bash_history") {
+		t.Errorf("ChownShellHome ran %q, want the directory and history given to claude as root", cmds[0])
+	}
+	if !strings.HasSuffix(cmds[1], "-u root c sh -c chown -R 1000:1000 "+ShellHome) {
+		t.Errorf("ReleaseShellHome ran %q, want a recursive chown to the host user", cmds[1])
+	}
+}
+
+func TestProbeHost_FallsBackWithoutCurl(t *testing.T) {
+	missing := Result{Stderr: "exec: \"curl\": executable file not found in $PATH", Code: 127}
+	tests := []struct {
+		name    string
+		bash    Result
+		wantErr error
+	}{
+		{"bash connects", Result{}, nil},
+		{"no bash either", Result{Code: 127}, ErrNoProbeTool},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := useFakeRunner(t, func(args string) Result {
+				if strings.Contains(args, " curl ") {
+					return missing
+				}
+				return tt.bash
+			})
+			if err := ProbeHost("c", 8080, "/healthz"); !errors.Is(err, tt.wantErr) {
+				t.Errorf("ProbeHost() = %v, want %v", err, tt.wantErr)
+			}
+			cmds := f.commands()
+			if len(cmds) != 2 || !strings.Contains(cmds[1], "/dev/tcp/host.docker.internal/8080") {
+				t.Errorf("commands = %q, want a /dev/tcp fallback", cmds)
+			}
+		})
+	}
+}
+
+// TestDockerRunArgs_NoSecretValuesInArgv verifies that host env vars and
+// secrets are passed by name so their values never appear on the docker
+// command line.
+func TestDockerRunArgs_NoSecretValuesInArgv(t *testing.T) {
+	clearTerminalEnv(t)
+	t.Setenv("CBOX_TEST_API_KEY", "sk-host-value")
+
+	args, env := dockerRunArgs(RunOptions{
+		Name:      "cbox-test",
+		Image:     "cbox:test",
+		EnvVars:   []string{"CBOX_TEST_API_KEY", "CBOX_TEST_UNSET"},
+		SecretEnv: map[string]string{"GH_TOKEN": "ghp-secret"},
+	})
+
+	joined := strings.Join(args, " ")
+	for _, secret := range []string{"sk-host-value", "ghp-secret"} {
+		if strings.Contains(joined, secret) {
+			t.Errorf("secret %q leaked into argv: %v", secret, args)
+		}
+	}
+	if !strings.Contains(joined, "-e CBOX_TEST_API_KEY") {
+		t.Errorf("expected CBOX_TEST_API_KEY passed by name, got %v", args)
+	}
+	if strings.Contains(joined, "CBOX_TEST_UNSET") {
+		t.Errorf("unset env var should be skipped, got %v", args)
+	}
+	if len(env) != 1 || env[0] != "GH_TOKEN=ghp-secret" {
+		t.Errorf("secret env = %v, want [GH_TOKEN=ghp-secret]", env)
+	}
+	if args[len(args)-1] != "cbox:test" {
+		t.Errorf("image should be the last arg, got %v", args)
+	}
+}
+
+// TestDockerRunArgs_WorkspaceVolume verifies that a volume-backed workspace
+// replaces the worktree bind mount and drops host-path mounts.
+func TestDockerRunArgs_WorkspaceVolume(t *testing.T) {
+	clearTerminalEnv(t)
+
+	args, _ := dockerRunArgs(RunOptions{
+		Name:            "cbox-test",
+		Image:           "cbox:test",
+		WorktreePath:    "/host/worktree",
+		GitMounts:       &GitMountConfig{ProjectGitDir: "/host/.git", ContainerGitFile: "/host/gitfile"},
+		Mounts:          []Mount{{Source: "/host/creds", Target: "/home/claude/creds"}},
+		WorkspaceVolume: "cbox-test-workspace",
+	})
+
+	joined := strings.Join(args, " ")
+	if !strings.Contains(joined, "-v cbox-test-workspace:/workspace") {
+		t.Errorf("expected workspace volume mount, got %v", args)
+	}
+	if strings.Contains(joined, "/host/") {
+		t.Errorf("host paths should not be mounted for a remote workspace, got %v", args)
+	}
+}
+
+func TestDockerRunArgs_ExtraArgsBeforeImage(t *testing.T) {
+	clearTerminalEnv(t)
+
+	args, _ := dockerRunArgs(RunOptions{
+		Name:      "cbox-test",
+		Image:     "cbox:test",
+		ExtraArgs: []string{"--shm-size", "2g", "--gpus=all"},
+	})
+
+	n := len(args)
+	if n < 4 || args[n-1] != "cbox:test" || strings.Join(args[n-4:n-1], " ") != "--shm-size 2g --gpus=all" {
+		t.Errorf("expected extra args just before the image, got %v", args)
+	}
+}
+
+func TestCheckExtraRunArgs(t *testing.T) {
+	tests := []struct {
+		args    []string
+		wantErr bool
+	}{
+		{[]string{"--gpus", "all", "--shm-size=2g", "--ulimit", "nofile=1024"}, false},
+		{[]string{"-v", "/data:/data:ro"}, false},
+		{[]string{"--mount", "type=bind,src=/data,dst=/data"}, false},
+		{[]string{"--name", "other"}, true},
+		{[]string{"--network=host"}, true},
+		{[]string{"--net", "host"}, true},
+		{[]string{"-v", "/tmp/x:/workspace"}, true},
+		{[]string{"--volume=/tmp/x:/workspace/sub:ro"}, true},
+		{[]string{"--mount", "type=volume,source=v,target=/workspace"}, true},
+		{[]string{"-v/tmp/x:/workspace"}, true},
+		{[]string{"-v=/tmp/x:/workspace"}, true},
+		{[]string{"-v/:/host"}, true},
+		{[]string{"--volume=/:/host"}, true},
+		{[]string{"--mount=type=bind,src=/,dst=/host"}, true},
+		{[]string{"-e", "FOO=bar", "-v/data:/data"}, false},
+	}
+	for _, tt := range tests {
+		err := CheckExtraRunArgs(tt.args)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("CheckExtraRunArgs(%v) = %v, wantErr %v", tt.args, err, tt.wantErr)
+		}
+	}
+}
+
+func TestGPUsFlag(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{"all", "all", false},
+		{"2", "2", false},
+		{"0", "", true},
+		{"0,1", `"device=0,1"`, false},
+		{"device=GPU-3a23c669", `"device=GPU-3a23c669"`, false},
+		{"0,,1", "", true},
+		{"first gpu", "", true},
+	}
+	for _, tt := range tests {
+		got, err := GPUsFlag(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("GPUsFlag(%q) = %q, %v; want %q, wantErr %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestGPUSection_Unquoted(t *testing.T) {
+	gpus, _ := GPUsFlag("0,1")
+	if got := GPUSection(gpus); !strings.Contains(got, "--gpus device=0,1)") {
+		t.Errorf("GPUSection(%q) = %q, want the device list without docker's CSV quoting", gpus, got)
+	}
+}
+
+// TestMCPAddCommand verifies that an MCP server's headers, which often
+// hold tokens, reach the container through the environment and never
+// appear on the docker command line.
+func TestMCPAddCommand(t *testing.T) {
+	cmd, err := mcpAddCommand("cbox-test", MCPServer{
+		Name:    "db",
+		URL:     "http://host.docker.internal:9000/mcp",
+		Headers: map[string]string{"X-Token": "abc", "Accept": "json"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(cmd.Args, " ")
+	if strings.Contains(got, "abc") {
+		t.Errorf("args contain the header value: %s", got)
+	}
+	if !strings.Contains(got, "-e "+mcpServerEnv+" cbox-test sh -c") || !strings.HasSuffix(got, " sh db") {
+		t.Errorf("args = %s, want the config passed by name to claude mcp add-json", got)
+	}
+	want := mcpServerEnv + `={"type":"http","url":"http://host.docker.internal:9000/mcp","headers":{"Accept":"json","X-Token":"abc"}}`
+	if len(cmd.Env) != 1 || cmd.Env[0] != want {
+		t.Errorf("env = %q, want %q", cmd.Env, want)
+	}
+}
+
+func TestChatArgs_SystemPrompt(t *testing.T) {
+	got := strings.Join(chatArgs(ChatOptions{SystemPrompt: "Follow docs/STYLE.md.", InitialPrompt: "hi"}), " ")
+	want := "claude --dangerously-skip-permissions --append-system-prompt Follow docs/STYLE.md. hi"
+	if got != want {
+		t.Errorf("chatArgs() = %q, want %q", got, want)
+	}
+}
+
+func TestNetworkCreateArgs(t *testing.T) {
+	got := strings.Join(networkCreateArgs("cbox-app-feat-x", NetworkOptions{Project: "/src/app", Branch: "feat/x", Internal: true}), " ")
+	want := "network create --label cbox=1 --label cbox.branch=feat-x --label cbox.project=app --label cbox.project_dir=/src/app --internal cbox-app-feat-x"
+	if got != want {
+		t.Errorf("networkCreateArgs() = %q, want %q", got, want)
+	}
+}
+
+func TestParseNetworkInfo(t *testing.T) {
+	tests := []struct {
+		out     string
+		want    networkInfo
+		wantErr bool
+	}{
+		{"1 true 2\n", networkInfo{cbox: true, internal: true, containers: 2}, false},
+		{" false 0\n", networkInfo{}, false},
+		{"<no value> false 0\n", networkInfo{}, false},
+		{"garbage", networkInfo{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseNetworkInfo(tt.out)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseNetworkInfo(%q) = %+v, %v; want %+v, wantErr %v", tt.out, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestDockerRunArgs_Resources(t *testing.T) {
+	clearTerminalEnv(t)
+
+	args, _ := dockerRunArgs(RunOptions{
+		Name:      "cbox-test",
+		Image:     "cbox:test",
+		Resources: Resources{Memory: "2g", CPUs: "1.5", PidsLimit: 512},
+	})
+	joined := strings.Join(args, " ")
+	if !strings.Contains(joined, "--memory 2g --cpus 1.5 --pids-limit 512") {
+		t.Errorf("resource limits missing: %s", joined)
+	}
+
+	args, _ = dockerRunArgs(RunOptions{Name: "cbox-test", Image: "cbox:test"})
+	for _, flag := range []string{"--memory", "--cpus", "--pids-limit"} {
+		if slices.Contains(args, flag) {
+			t.Errorf("%s passed without [resources]: %v", flag, args)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package docker
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// DefaultEgressProxyImage is the forward proxy that enforces an egress allow
+// list for sandboxes on an internal network.
+const DefaultEgressProxyImage = "ubuntu/squid:latest"
+
+// EgressProxyPort is the port the egress proxy listens on.
+const EgressProxyPort = 3128
+
+// EgressProxyName returns the name of the egress proxy container serving a
+// sandbox network.
+func EgressProxyName(network string) string {
+	return network + "-egress"
+}
+
+// EgressProxyOptions configures StartEgressProxy.
+type EgressProxyOptions struct {
+	Name       string
+	Network    string   // internal sandbox network the proxy serves
+	Image      string   // defaults to DefaultEgressProxyImage
+	AllowHosts []string // squid dstdomain entries; ".example.com" includes subdomains
+	Labels     map[string]string
+}
+
+// StartEgressProxy starts a forward proxy that sits on both the default
+// bridge network and the sandbox's internal network, and only lets requests
+// for the allowed hosts (and host.docker.internal, for the MCP server)
+// through. The config is copied in rather than bind-mounted so this also
+// works against a remote daemon.
+func StartEgressProxy(opts EgressProxyOptions) error {
+	image := opts.Image
+	if image == "" {
+		image = DefaultEgressProxyImage
+	}
+	runDocker("rm", "-f", opts.Name)
+
+	args := []string{"create",
+		"--name", opts.Name,
+		"--add-host", "host.docker.internal:host-gateway",
+	}
+	args = append(args, labelArgs(opts.Labels)...)
+	res := runDocker(append(args, image)...)
+	if err := res.Failure(); err != nil {
+		return fmt.Errorf("creating egress proxy: %s: %w", res.Message(), err)
+	}
+
+	if err := setupEgressProxy(opts); err != nil {
+		runDocker("rm", "-f", opts.Name)
+		return err
+	}
+	return nil
+}
+
+func setupEgressProxy(opts EgressProxyOptions) error {
+	f, err := os.CreateTemp("", "cbox-squid-*.conf")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(egressProxyConfig(opts.AllowHosts)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	for _, args := range [][]string{
+		{"cp", f.Name(), opts.Name + ":/etc/squid/squid.conf"},
+		{"network", "connect", opts.Network, opts.Name},
+		{"start", opts.Name},
+	} {
+		if res := runDocker(args...); res.Failure() != nil {
+			return fmt.Errorf("docker %s: %s: %w", args[0], res.Message(), res.Failure())
+		}
+	}
+	return nil
+}
+
+// egressProxyConfig renders a squid config that allows the given hosts and
+// denies everything else.
+func egressProxyConfig(allowHosts []string) string {
+	hosts := append([]string{"host.docker.internal"}, allowHosts...)
+	return fmt.Sprintf(`http_port %d
+acl allowed dstdomain %s
+http_access allow allowed
+http_access deny all
+cache deny all
+access_log stdio:/dev/stdout
+`, EgressProxyPort, strings.Join(hosts, " "))
+}
+
+// EgressProxyEnv returns the environment that routes a container's HTTP and
+// HTTPS traffic through the egress proxy. Both spellings are set because
+// tools disagree on which one they read.
+func EgressProxyEnv(name string) map[string]string {
+	url := fmt.Sprintf("http://%s:%d", name, EgressProxyPort)
+	noProxy := "localhost,127.0.0.1"
+	return map[string]string{
+		"HTTP_PROXY":  url,
+		"HTTPS_PROXY": url,
+		"NO_PROXY":    noProxy,
+		"http_proxy":  url,
+		"https_proxy": url,
+		"no_proxy":    noProxy,
+	}
+}
@@ -0,0 +1,40 @@
+package docker
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEgressProxyConfig_AllowsOnlyListedHosts(t *testing.T) {
+	conf := egressProxyConfig([]string{"api.anthropic.com", ".github.com"})
+
+	if !strings.Contains(conf, "acl allowed dstdomain host.docker.internal api.anthropic.com .github.com\n") {
+		t.Errorf("expected an allow list with the host gateway and configured hosts, got:\n%s", conf)
+	}
+	allow := strings.Index(conf, "http_access allow allowed")
+	deny := strings.Index(conf, "http_access deny all")
+	if allow < 0 || deny < allow {
+		t.Errorf("expected allow rule followed by deny all, got:\n%s", conf)
+	}
+}
+
+func TestEgressProxyEnv(t *testing.T) {
+	env := EgressProxyEnv("cbox-app-main-egress")
+	want := "http://cbox-app-main-egress:3128"
+	for _, key := range []string{"HTTP_PROXY", "HTTPS_PROXY", "http_proxy", "https_proxy"} {
+		if env[key] != want {
+			t.Errorf("%s = %q, want %q", key, env[key], want)
+		}
+	}
+}
+
+func TestBuildClaudeMD_EgressSection(t *testing.T) {
+	md := BuildClaudeMD(nil, nil, nil, EgressSection([]string{"api.anthropic.com"}))
+	if !strings.Contains(md, "## Network") || !strings.Contains(md, "api.anthropic.com") {
+		t.Errorf("expected a network section listing allowed hosts, got:\n%s", md)
+	}
+
+	if md := BuildClaudeMD(nil, nil, nil); strings.Contains(md, "## Network") {
+		t.Errorf("unrestricted sandbox should have no network section, got:\n%s", md)
+	}
+}
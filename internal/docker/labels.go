@@ -0,0 +1,110 @@
+package docker
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/richvanbergen/cbox/internal/naming"
+)
+
+// Labels put on the resources cbox creates, so they can be found (and
+// garbage collected) without relying on name conventions.
+// ProjectLabel holds the project's directory name for display;
+// ProjectDirLabel holds its absolute path, which tells apart two projects
+// with the same directory name.
+const (
+	ProjectLabel    = NetworkLabel + ".project"
+	ProjectDirLabel = NetworkLabel + ".project_dir"
+	BranchLabel     = NetworkLabel + ".branch"
+)
+
+// ResourceLabels returns the labels for a sandbox's containers. The branch
+// is stored in its SafeBranch form, matching the state file name.
+func ResourceLabels(projectDir, branch string) map[string]string {
+	labels := map[string]string{
+		ProjectLabel:    filepath.Base(projectDir),
+		ProjectDirLabel: absDir(projectDir),
+	}
+	if branch != "" {
+		labels[BranchLabel] = naming.SafeBranch(branch)
+	}
+	return labels
+}
+
+// absDir makes dir absolute so the same project is always labeled alike.
+func absDir(dir string) string {
+	if abs, err := filepath.Abs(dir); err == nil {
+		return abs
+	}
+	return dir
+}
+
+// labelArgs turns labels into sorted --label arguments.
+func labelArgs(labels map[string]string) []string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var args []string
+	for _, k := range keys {
+		args = append(args, "--label", k+"="+labels[k])
+	}
+	return args
+}
+
+// LabeledResource is a container or network found by ListLabeled.
+type LabeledResource struct {
+	Name    string
+	Project string
+	Branch  string // SafeBranch form; empty for project-wide resources
+}
+
+// ListLabeled returns the resources of a kind ("container" or "network")
+// that carry ProjectDirLabel. A non-empty projectDir restricts the list to
+// that project. Stopped containers are included.
+func ListLabeled(kind, projectDir string) ([]LabeledResource, error) {
+	filter := "label=" + ProjectDirLabel
+	if projectDir != "" {
+		filter += "=" + absDir(projectDir)
+	}
+	format := `{{.Names}}\t{{.Label "` + ProjectLabel + `"}}\t{{.Label "` + BranchLabel + `"}}`
+
+	var args []string
+	switch kind {
+	case "container":
+		args = []string{"ps", "-a", "--filter", filter, "--format", format}
+	case "network":
+		format = strings.Replace(format, ".Names", ".Name", 1)
+		args = []string{"network", "ls", "--filter", filter, "--format", format}
+	default:
+		return nil, fmt.Errorf("unsupported resource kind %q", kind)
+	}
+
+	res := runDocker(args...)
+	if err := res.Failure(); err != nil {
+		return nil, fmt.Errorf("listing cbox %ss: %w", kind, err)
+	}
+	return parseLabeled(res.Stdout), nil
+}
+
+func parseLabeled(out string) []LabeledResource {
+	var resources []LabeledResource
+	for _, line := range strings.Split(out, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		for len(fields) < 3 {
+			fields = append(fields, "")
+		}
+		r := LabeledResource{Name: fields[0], Project: fields[1], Branch: fields[2]}
+		if r.Branch == "<no value>" {
+			r.Branch = ""
+		}
+		resources = append(resources, r)
+	}
+	return resources
+}
@@ -0,0 +1,61 @@
+package docker
+
+import (
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDockerRunArgs_Labels(t *testing.T) {
+	clearTerminalEnv(t)
+
+	args, _ := dockerRunArgs(RunOptions{
+		Name:   "cbox-app-feat-x-claude",
+		Image:  "cbox:test",
+		Labels: ResourceLabels("/home/me/app", "feat/x"),
+	})
+	joined := strings.Join(args, " ")
+	if !strings.Contains(joined, "--label cbox.branch=feat-x --label cbox.project=app --label cbox.project_dir=/home/me/app cbox:test") {
+		t.Errorf("labels missing or after the image: %s", joined)
+	}
+}
+
+func TestParseLabeled(t *testing.T) {
+	out := "cbox-app-main-claude\tapp\tmain\ncbox-app-feat-x\tapp\t<no value>\n\n"
+	want := []LabeledResource{
+		{Name: "cbox-app-main-claude", Project: "app", Branch: "main"},
+		{Name: "cbox-app-feat-x", Project: "app"},
+	}
+	if got := parseLabeled(out); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseLabeled() = %+v, want %+v", got, want)
+	}
+}
+
+func TestListLabeled(t *testing.T) {
+	if !hasDocker() {
+		t.Skip("docker not available")
+	}
+
+	const name = "cbox-test-labeled-network-12345"
+	if err := CreateNetworkWithOptions(name, NetworkOptions{Project: "/tmp/a/cbox-test-labeled", Branch: "feat/x"}); err != nil {
+		t.Fatalf("CreateNetworkWithOptions: %v", err)
+	}
+	defer exec.Command("docker", "network", "rm", name).Run()
+
+	if got, _ := ListLabeled("network", "/tmp/b/cbox-test-labeled"); len(got) != 0 {
+		t.Errorf("ListLabeled() for another project with the same name = %+v, want none", got)
+	}
+	got, err := ListLabeled("network", "/tmp/a/cbox-test-labeled")
+	if err != nil {
+		t.Fatalf("ListLabeled: %v", err)
+	}
+	want := []LabeledResource{{Name: name, Project: "cbox-test-labeled", Branch: "feat-x"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ListLabeled() = %+v, want %+v", got, want)
+	}
+
+	if _, err := ListLabeled("image", ""); err == nil {
+		t.Error("ListLabeled(image) should be rejected")
+	}
+}
@@ -0,0 +1,125 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Runner runs docker CLI commands. The package's non-interactive docker calls
+// go through it, so tests can swap in a fake with SetRunner and check the
+// arguments and error handling without a docker daemon. Interactive sessions
+// (chat, shell, exec) and streamed builds and syncs use exec directly.
+type Runner interface {
+	Run(c Command) Result
+}
+
+// Command is a docker invocation.
+type Command struct {
+	Args   []string
+	Env    []string  // KEY=VALUE pairs added to the docker CLI's environment
+	Stdin  io.Reader // optional
+	Output io.Writer // when set, stdout and stderr are streamed here instead of captured
+}
+
+// Result is the outcome of a Command.
+type Result struct {
+	Stdout string
+	Stderr string
+	Code   int   // exit code; -1 if docker couldn't be started
+	Err    error // the exec error, if any; a fake may set only Code
+}
+
+// Failure returns why the command failed, or nil if it succeeded.
+func (r Result) Failure() error {
+	if r.Err != nil {
+		return r.Err
+	}
+	if r.Code != 0 {
+		return fmt.Errorf("exit status %d", r.Code)
+	}
+	return nil
+}
+
+// Message returns docker's trimmed error output for an error message: stderr,
+// or stdout when nothing was written to stderr.
+func (r Result) Message() string {
+	if msg := strings.TrimSpace(r.Stderr); msg != "" {
+		return msg
+	}
+	return strings.TrimSpace(r.Stdout)
+}
+
+// ErrCLI marks failures to run the docker CLI. sandbox.ErrDocker is the same
+// error, so cbox exits with its docker exit code when docker is missing.
+var ErrCLI = errors.New("docker command failed")
+
+// missingError is the error from starting a docker binary that isn't on the
+// PATH. It keeps the exec error's message and matches ErrCLI.
+type missingError struct {
+	err error
+}
+
+func (e *missingError) Error() string { return e.err.Error() }
+
+func (e *missingError) Unwrap() error { return e.err }
+
+func (e *missingError) Is(target error) bool { return target == ErrCLI }
+
+// cliMissing marks err as ErrCLI if docker couldn't be run because it isn't
+// on the PATH, and returns any other err unchanged.
+func cliMissing(err error) error {
+	if errors.Is(err, exec.ErrNotFound) {
+		return &missingError{err: err}
+	}
+	return err
+}
+
+// ExecRunner runs the docker binary on the PATH.
+type ExecRunner struct{}
+
+func (ExecRunner) Run(c Command) Result {
+	cmd := exec.Command("docker", c.Args...)
+	if len(c.Env) > 0 {
+		cmd.Env = append(os.Environ(), c.Env...)
+	}
+	cmd.Stdin = c.Stdin
+	var stdout, stderr strings.Builder
+	if c.Output != nil {
+		cmd.Stdout = c.Output
+		cmd.Stderr = c.Output
+	} else {
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+	}
+
+	err := cliMissing(cmd.Run())
+	res := Result{Stdout: stdout.String(), Stderr: stderr.String(), Err: err}
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+	case errors.As(err, &exitErr):
+		res.Code = exitErr.ExitCode()
+	default:
+		res.Code = -1
+	}
+	return res
+}
+
+var runner Runner = ExecRunner{}
+
+// SetRunner replaces the Runner docker calls go through and returns the
+// previous one, for tests.
+func SetRunner(r Runner) Runner {
+	prev := runner
+	runner = r
+	return prev
+}
+
+// runDocker runs docker with args through the package Runner.
+func runDocker(args ...string) Result {
+	return runner.Run(Command{Args: args})
+}
@@ -0,0 +1,312 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// fakeRunner records docker commands and answers them with respond, which
+// gets the joined arguments. A nil respond succeeds with no output.
+type fakeRunner struct {
+	calls   []Command
+	respond func(args string) Result
+}
+
+func (f *fakeRunner) Run(c Command) Result {
+	f.calls = append(f.calls, c)
+	if f.respond == nil {
+		return Result{}
+	}
+	return f.respond(strings.Join(c.Args, " "))
+}
+
+func (f *fakeRunner) commands() []string {
+	var out []string
+	for _, c := range f.calls {
+		out = append(out, strings.Join(c.Args, " "))
+	}
+	return out
+}
+
+// useFakeRunner swaps in a fake for the duration of the test.
+func useFakeRunner(t *testing.T, respond func(args string) Result) *fakeRunner {
+	t.Helper()
+	f := &fakeRunner{respond: respond}
+	prev := SetRunner(f)
+	t.Cleanup(func() { SetRunner(prev) })
+	return f
+}
+
+func TestResult_Failure(t *testing.T) {
+	if err := (Result{}).Failure(); err != nil {
+		t.Errorf("success: Failure() = %v", err)
+	}
+	if err := (Result{Code: 1}).Failure(); err == nil || err.Error() != "exit status 1" {
+		t.Errorf("exit 1: Failure() = %v", err)
+	}
+	if err := (Result{Code: -1, Err: exec.ErrNotFound}).Failure(); !errors.Is(err, exec.ErrNotFound) {
+		t.Errorf("not found: Failure() = %v, want exec.ErrNotFound", err)
+	}
+	if msg := (Result{Stdout: "out\n", Code: 1}).Message(); msg != "out" {
+		t.Errorf("Message() = %q, want stdout fallback", msg)
+	}
+}
+
+func TestExecRunner_MissingDocker(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	err := ExecRunner{}.Run(Command{Args: []string{"version"}}).Failure()
+	if !errors.Is(err, ErrCLI) || !errors.Is(err, exec.ErrNotFound) {
+		t.Errorf("Failure() = %v, want it to match ErrCLI and exec.ErrNotFound", err)
+	}
+}
+
+func TestCreateNetwork_ReusesMatchingNetwork(t *testing.T) {
+	f := useFakeRunner(t, func(args string) Result {
+		switch {
+		case strings.HasPrefix(args, "network create"):
+			return Result{Stderr: "Error response from daemon: network with name n already exists", Code: 1}
+		case strings.HasPrefix(args, "network inspect"):
+			return Result{Stdout: "1 false 2\n"}
+		}
+		return Result{}
+	})
+
+	if err := CreateNetworkWithOptions("n", NetworkOptions{}); err != nil {
+		t.Fatalf("CreateNetworkWithOptions() = %v", err)
+	}
+	if got := len(f.calls); got != 2 {
+		t.Errorf("ran %d commands, want create and inspect only: %q", got, f.commands())
+	}
+}
+
+func TestCreateNetwork_RecreatesIdleNetwork(t *testing.T) {
+	creates := 0
+	f := useFakeRunner(t, func(args string) Result {
+		switch {
+		case strings.HasPrefix(args, "network create"):
+			creates++
+			if creates == 1 {
+				return Result{Stderr: "network with name n already exists", Code: 1}
+			}
+		case strings.HasPrefix(args, "network inspect"):
+			return Result{Stdout: "1 false 0\n"}
+		}
+		return Result{}
+	})
+
+	if err := CreateNetworkWithOptions("n", NetworkOptions{Internal: true}); err != nil {
+		t.Fatalf("CreateNetworkWithOptions() = %v", err)
+	}
+	cmds := f.commands()
+	if len(cmds) != 4 || cmds[2] != "network rm n" || !strings.HasSuffix(cmds[3], "--internal n") {
+		t.Errorf("commands = %q, want create, inspect, rm, create --internal", cmds)
+	}
+}
+
+func TestCreateNetwork_RelabelsOwnedNetwork(t *testing.T) {
+	creates := 0
+	f := useFakeRunner(t, func(args string) Result {
+		switch {
+		case strings.HasPrefix(args, "network create"):
+			creates++
+			if creates == 1 {
+				return Result{Stderr: "network with name n already exists", Code: 1}
+			}
+		case strings.HasPrefix(args, "network inspect"):
+			return Result{Stdout: "<no value> false 0\n"}
+		}
+		return Result{}
+	})
+
+	if err := CreateNetworkWithOptions("n", NetworkOptions{Owned: true}); err != nil {
+		t.Fatalf("CreateNetworkWithOptions() = %v", err)
+	}
+	cmds := f.commands()
+	if len(cmds) != 4 || cmds[2] != "network rm n" || !strings.Contains(cmds[3], "--label cbox=1") {
+		t.Errorf("commands = %q, want create, inspect, rm, labeled create", cmds)
+	}
+}
+
+func TestCreateNetwork_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		inspect string
+		create  string
+		want    string
+	}{
+		{"foreign network", " false 0\n", "already exists", "not created by cbox"},
+		{"attached containers", "1 false 3\n", "already exists", "containers attached"},
+		{"daemon error", "", "Cannot connect to the Docker daemon", "docker network create: Cannot connect"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFakeRunner(t, func(args string) Result {
+				if strings.HasPrefix(args, "network inspect") {
+					return Result{Stdout: tt.inspect}
+				}
+				return Result{Stderr: tt.create, Code: 1}
+			})
+			err := CreateNetworkWithOptions("n", NetworkOptions{Internal: true})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("CreateNetworkWithOptions() = %v, want error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemoveNetwork_SkipsUnlabeled(t *testing.T) {
+	f := useFakeRunner(t, func(args string) Result {
+		return Result{Stdout: "<no value> false 0\n"}
+	})
+	RemoveNetwork("n")
+	for _, cmd := range f.commands() {
+		if strings.HasPrefix(cmd, "network rm") {
+			t.Errorf("removed a network cbox didn't create: %q", f.commands())
+		}
+	}
+}
+
+func TestRemoveOwnedNetwork_RemovesUnlabeled(t *testing.T) {
+	f := useFakeRunner(t, func(args string) Result {
+		return Result{Stdout: "<no value> false 0\n"}
+	})
+	RemoveOwnedNetwork("n")
+	if cmds := f.commands(); len(cmds) != 2 || cmds[1] != "network rm n" {
+		t.Errorf("commands = %q, want inspect, rm", cmds)
+	}
+}
+
+func TestStopAndRemove_Fake(t *testing.T) {
+	f := useFakeRunner(t, func(args string) Result {
+		if strings.HasPrefix(args, "rm") {
+			return Result{Stderr: "Error: No such container: c", Code: 1}
+		}
+		return Result{}
+	})
+	if err := StopAndRemove("c"); err != nil {
+		t.Errorf("StopAndRemove on a missing container = %v, want nil", err)
+	}
+	if got := strings.Join(f.commands(), "; "); got != "stop c; rm c" {
+		t.Errorf("commands = %q", got)
+	}
+
+	useFakeRunner(t, func(args string) Result {
+		return Result{Stderr: "removal in progress", Code: 1}
+	})
+	if err := StopAndRemove("c"); err == nil || !strings.Contains(err.Error(), "docker rm: removal in progress") {
+		t.Errorf("StopAndRemove() = %v, want docker rm error", err)
+	}
+}
+
+func TestIsRunning_Fake(t *testing.T) {
+	useFakeRunner(t, func(args string) Result {
+		return Result{Stdout: "true\n"}
+	})
+	if running, err := IsRunning("c"); err != nil || !running {
+		t.Errorf("IsRunning() = %v, %v, want true", running, err)
+	}
+
+	useFakeRunner(t, func(args string) Result {
+		return Result{Stderr: "No such object: c", Code: 1}
+	})
+	if _, err := IsRunning("c"); err == nil {
+		t.Error("IsRunning on a missing container should fail")
+	}
+}
+
+func TestRunContainer_Fake(t *testing.T) {
+	clearTerminalEnv(t)
+	f := useFakeRunner(t, nil)
+
+	err := RunContainer(RunOptions{
+		Name:      "cbox-app-main-claude",
+		Image:     "cbox:test",
+		SecretEnv: map[string]string{"GH_TOKEN": "ghp-secret"},
+	})
+	if err != nil {
+		t.Fatalf("RunContainer() = %v", err)
+	}
+	c := f.calls[0]
+	if c.Args[0] != "run" || c.Args[len(c.Args)-1] != "cbox:test" {
+		t.Errorf("args = %q", c.Args)
+	}
+	if len(c.Env) != 1 || c.Env[0] != "GH_TOKEN=ghp-secret" {
+		t.Errorf("env = %q, want the secret passed through the environment", c.Env)
+	}
+	if c.Output == nil {
+		t.Error("docker run output should be streamed")
+	}
+
+	useFakeRunner(t, func(args string) Result {
+		return Result{Code: 125}
+	})
+	err = RunContainer(RunOptions{Name: "cbox-app-main-claude", Image: "cbox:test"})
+	if err == nil || !strings.Contains(err.Error(), "docker run (cbox-app-main-claude): exit status 125") {
+		t.Errorf("RunContainer() = %v", err)
+	}
+	if err != nil && strings.Contains(err.Error(), "gpus") {
+		t.Errorf("RunContainer() = %v, want no GPU hint without gpus", err)
+	}
+	err = RunContainer(RunOptions{Name: "cbox-app-main-claude", Image: "cbox:test", GPUs: "all"})
+	if err == nil || !strings.Contains(err.Error(), "NVIDIA") {
+		t.Errorf("RunContainer() with gpus = %v, want a hint about the NVIDIA tooling", err)
+	}
+
+	// With a Progress callback, output goes to it and a failure quotes it.
+	var failing *fakeRunner
+	failing = useFakeRunner(t, func(string) Result {
+		fmt.Fprintln(failing.calls[0].Output, "Unable to find image 'cbox:test' locally")
+		return Result{Code: 125}
+	})
+	var progress []string
+	err = RunContainer(RunOptions{Name: "cbox-app-main-claude", Image: "cbox:test", Progress: func(line string) {
+		progress = append(progress, line)
+	}})
+	if len(progress) != 1 || err == nil || !strings.HasSuffix(err.Error(), "\nUnable to find image 'cbox:test' locally") {
+		t.Errorf("RunContainer() = %v with progress %q, want the output reported and quoted", err, progress)
+	}
+}
+
+func TestRestart_Fake(t *testing.T) {
+	f := useFakeRunner(t, nil)
+	if err := Restart("c"); err != nil {
+		t.Fatalf("Restart() = %v", err)
+	}
+	if got := strings.Join(f.commands(), "; "); got != "restart c" {
+		t.Errorf("commands = %q", got)
+	}
+
+	useFakeRunner(t, func(args string) Result {
+		return Result{Stderr: "Error: No such container: c", Code: 1}
+	})
+	if err := Restart("c"); err == nil || !strings.Contains(err.Error(), "docker restart: Error: No such container") {
+		t.Errorf("Restart() = %v, want docker restart error", err)
+	}
+}
+
+func TestChatSessionClients_Fake(t *testing.T) {
+	f := useFakeRunner(t, func(args string) Result {
+		return Result{Stdout: "/dev/pts/0\n/dev/pts/3\n"}
+	})
+	if running, clients := ChatSessionClients("c", "claude"); !running || clients != 2 {
+		t.Errorf("ChatSessionClients() = %v, %d, want running with 2 clients", running, clients)
+	}
+	if got := f.commands()[0]; got != "exec -u claude c tmux list-clients -t cbox-chat -F #{client_tty}" {
+		t.Errorf("command = %q", got)
+	}
+
+	useFakeRunner(t, func(args string) Result { return Result{} })
+	if running, clients := ChatSessionClients("c", "claude"); !running || clients != 0 {
+		t.Errorf("detached session: ChatSessionClients() = %v, %d", running, clients)
+	}
+
+	useFakeRunner(t, func(args string) Result {
+		return Result{Stderr: "can't find session: cbox-chat", Code: 1}
+	})
+	if running, _ := ChatSessionClients("c", "claude"); running {
+		t.Error("ChatSessionClients reported a session tmux can't find")
+	}
+}
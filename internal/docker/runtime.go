@@ -2,8 +2,10 @@ package docker
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 	"syscall"
 
@@ -27,37 +29,108 @@ type RunOptions struct {
 	GitMounts      *GitMountConfig
 	EnvVars        []string
 	ExtraEnv       map[string]string
+	SecretEnv      map[string]string // passed by name only so values stay out of argv
 	EnvFile        string
 	BridgeMappings []bridge.ProxyMapping
 	Ports          []string
 	Mounts         []Mount
+	// WorkspaceVolume, when set, mounts a named volume at /workspace
+	// instead of bind-mounting WorktreePath. Used for remote docker hosts,
+	// where host paths do not exist on the daemon's machine; the volume is
+	// filled with SyncToContainer.
+	WorkspaceVolume string
+	// GPUs is the docker --gpus value (see GPUsFlag); empty means none.
+	GPUs      string
+	Resources Resources
+	// Labels are added with --label, see ResourceLabels.
+	Labels map[string]string
+	// ExtraArgs are passed to docker run verbatim, just before the image.
+	// Check them with CheckExtraRunArgs first.
+	ExtraArgs []string
+	// Progress, if set, receives docker's output (image pulls, the
+	// container ID) a line at a time instead of the terminal.
+	Progress func(line string)
 }
 
 // RunContainer starts a backend runtime container with the shared cbox mounts.
 func RunContainer(opts RunOptions) error {
+	args, secretEnv := dockerRunArgs(opts)
+	if opts.Progress != nil {
+		pw := &progressWriter{fn: opts.Progress}
+		res := runner.Run(Command{Args: args, Env: secretEnv, Output: pw})
+		if err := res.Failure(); err != nil {
+			return fmt.Errorf("docker run (%s): %w%s\n%s", opts.Name, err, gpuHint(opts.GPUs), pw.tail())
+		}
+		return nil
+	}
+
+	// Docker output is never truncated: pull errors and image digests are
+	// worth seeing in full. On a terminal, render pull progress in place.
+	w := output.Writer()
+	cw := output.NewCommandWriterWithOptions(w, output.CommandWriterOptions{})
+	if f, ok := w.(*os.File); ok {
+		if fi, err := f.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+			cw = output.NewLiveCommandWriter(w)
+		}
+	}
+	res := runner.Run(Command{Args: args, Env: secretEnv, Output: cw})
+	cw.Close()
+	if err := res.Failure(); err != nil {
+		return fmt.Errorf("docker run (%s): %w%s", opts.Name, err, gpuHint(opts.GPUs))
+	}
+	return nil
+}
+
+// gpuHint is added to a failed docker run that asked for GPUs. Whether
+// --gpus works can't be told in advance: the nvidia runtime, CDI specs and
+// Docker Desktop each provide it differently, so cbox only says something
+// once docker has refused.
+func gpuHint(gpus string) string {
+	if gpus == "" {
+		return ""
+	}
+	return " (gpus is set: the docker host needs the NVIDIA driver and Container Toolkit)"
+}
+
+// dockerRunArgs builds the `docker run` arguments for opts, plus the
+// KEY=VALUE pairs that must be added to the docker CLI's environment for
+// secrets passed by name.
+func dockerRunArgs(opts RunOptions) ([]string, []string) {
 	currentUser := os.Getenv("USER")
 
+	workspace := opts.WorktreePath
+	if opts.WorkspaceVolume != "" {
+		workspace = opts.WorkspaceVolume
+	}
+
 	args := []string{
 		"run", "-d",
 		"--name", opts.Name,
 		"--network", opts.Network,
-		"-v", opts.WorktreePath + ":/workspace",
+		// Docker Desktop resolves host.docker.internal by itself; Docker
+		// Engine on Linux needs it mapped to reach the host MCP server.
+		"--add-host", "host.docker.internal:host-gateway",
+		"-v", workspace + ":/workspace",
 		"-v", "/var/run/docker.sock:/var/run/docker.sock",
 	}
 
-	if opts.GitMounts != nil && opts.GitMounts.ProjectGitDir != "" && opts.GitMounts.ContainerGitFile != "" {
-		args = append(args,
-			"-v", opts.GitMounts.ProjectGitDir+":/repo/.git",
-			"-v", opts.GitMounts.ContainerGitFile+":/workspace/.git:ro",
-		)
-	}
+	// Host bind mounts only make sense when the daemon shares our
+	// filesystem, so they are skipped for a volume-backed workspace.
+	if opts.WorkspaceVolume == "" {
+		if opts.GitMounts != nil && opts.GitMounts.ProjectGitDir != "" && opts.GitMounts.ContainerGitFile != "" {
+			args = append(args,
+				"-v", opts.GitMounts.ProjectGitDir+":/repo/.git",
+				"-v", opts.GitMounts.ContainerGitFile+":/workspace/.git:ro",
+			)
+		}
 
-	for _, m := range opts.Mounts {
-		mount := m.Source + ":" + m.Target
-		if m.ReadOnly {
-			mount += ":ro"
+		for _, m := range opts.Mounts {
+			mount := m.Source + ":" + m.Target
+			if m.ReadOnly {
+				mount += ":ro"
+			}
+			args = append(args, "-v", mount)
 		}
-		args = append(args, "-v", mount)
 	}
 
 	for _, p := range opts.Ports {
@@ -72,10 +145,11 @@ func RunContainer(opts RunOptions) error {
 		}
 	}
 
+	// Host env vars are passed by name too: docker copies the value from its
+	// own (inherited) environment, so API keys never show up in argv.
 	for _, env := range opts.EnvVars {
-		val := os.Getenv(env)
-		if val != "" {
-			args = append(args, "-e", env+"="+val)
+		if os.Getenv(env) != "" {
+			args = append(args, "-e", env)
 		}
 	}
 
@@ -85,59 +159,203 @@ func RunContainer(opts RunOptions) error {
 		}
 	}
 
+	// Secrets are exported into the docker CLI's own environment and passed
+	// as "-e KEY" so their values never appear on the command line.
+	var secretEnv []string
+	for key, value := range opts.SecretEnv {
+		args = append(args, "-e", key)
+		secretEnv = append(secretEnv, key+"="+value)
+	}
+
 	if opts.EnvFile != "" {
 		if _, err := os.Stat(opts.EnvFile); err == nil {
 			args = append(args, "--env-file", opts.EnvFile)
 		}
 	}
 
+	if opts.GPUs != "" {
+		args = append(args, "--gpus", opts.GPUs)
+	}
+	args = append(args, opts.Resources.args()...)
+
+	args = append(args, labelArgs(opts.Labels)...)
+	args = append(args, opts.ExtraArgs...)
 	args = append(args, opts.Image)
+	return args, secretEnv
+}
 
-	cmd := exec.Command("docker", args...)
-	cw := output.NewCommandWriter(os.Stdout)
-	cmd.Stdout = cw
-	cmd.Stderr = cw
-	runErr := cmd.Run()
-	cw.Close()
-	if runErr != nil {
-		return fmt.Errorf("docker run (%s): %w", opts.Name, runErr)
+// Resources limits a container's memory, CPUs and process count. Zero
+// values leave docker's defaults.
+type Resources struct {
+	Memory    string `json:"memory,omitempty"`     // --memory, e.g. "2g"
+	CPUs      string `json:"cpus,omitempty"`       // --cpus, e.g. "1.5"
+	PidsLimit int    `json:"pids_limit,omitempty"` // --pids-limit
+}
+
+// IsZero reports whether no limit is set.
+func (r Resources) IsZero() bool {
+	return r == Resources{}
+}
+
+// String describes the limits for display, e.g. "memory 2g, cpus 1.5".
+func (r Resources) String() string {
+	var parts []string
+	if r.Memory != "" {
+		parts = append(parts, "memory "+r.Memory)
+	}
+	if r.CPUs != "" {
+		parts = append(parts, "cpus "+r.CPUs)
+	}
+	if r.PidsLimit != 0 {
+		parts = append(parts, "pids "+strconv.Itoa(r.PidsLimit))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (r Resources) args() []string {
+	var args []string
+	if r.Memory != "" {
+		args = append(args, "--memory", r.Memory)
+	}
+	if r.CPUs != "" {
+		args = append(args, "--cpus", r.CPUs)
+	}
+	if r.PidsLimit != 0 {
+		args = append(args, "--pids-limit", strconv.Itoa(r.PidsLimit))
+	}
+	return args
+}
+
+// GPUsFlag converts a gpus config value into a docker --gpus value: "all",
+// a GPU count ("2"), or a comma-separated device list ("0,1" or
+// "GPU-<uuid>") which becomes "device=0,1".
+func GPUsFlag(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	switch {
+	case value == "":
+		return "", nil
+	case value == "all":
+		return value, nil
+	case strings.HasPrefix(value, "device="):
+		value = strings.TrimPrefix(value, "device=")
+	default:
+		if n, err := strconv.Atoi(value); err == nil {
+			if n < 1 {
+				return "", fmt.Errorf("gpus count must be at least 1")
+			}
+			return value, nil
+		}
+	}
+	for _, dev := range strings.Split(value, ",") {
+		if dev == "" || strings.ContainsAny(dev, " =\"") {
+			return "", fmt.Errorf("gpus %q: expected \"all\", a count, or a comma-separated device list", value)
+		}
+	}
+	// docker parses --gpus as CSV, so a device list with commas must be quoted.
+	return `"device=` + value + `"`, nil
+}
+
+// CheckExtraRunArgs rejects user-supplied docker run arguments that would
+// override a flag cbox manages: the container name, its network, or what is
+// mounted at /workspace. It also rejects mounting the host's root directory,
+// which would hand the agent the whole host filesystem.
+func CheckExtraRunArgs(args []string) error {
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := splitRunArg(args[i])
+		switch name {
+		case "--name", "--network", "--net":
+			return fmt.Errorf("%s is managed by cbox", name)
+		case "-v", "--volume", "--mount":
+			if !hasValue && i+1 < len(args) {
+				i++
+				value = args[i]
+			}
+			source, target := mountPaths(name, value)
+			if target == "/workspace" || strings.HasPrefix(target, "/workspace/") {
+				return fmt.Errorf("%s %s: /workspace is managed by cbox", name, value)
+			}
+			if source == "/" {
+				return fmt.Errorf("%s %s: mounting the host's root directory is not allowed", name, value)
+			}
+		}
 	}
 	return nil
 }
 
+// splitRunArg splits a docker run argument into its flag and any value
+// attached to it, as `--flag=value` or a short flag's `-vvalue`/`-v=value`.
+func splitRunArg(arg string) (name, value string, hasValue bool) {
+	if strings.HasPrefix(arg, "--") {
+		return strings.Cut(arg, "=")
+	}
+	if len(arg) > 2 && arg[0] == '-' {
+		return arg[:2], strings.TrimPrefix(arg[2:], "="), true
+	}
+	return arg, "", false
+}
+
+// mountPaths returns the host source and container target of a -v or
+// --mount value. Either is empty when the value doesn't give it.
+func mountPaths(flag, value string) (source, target string) {
+	if flag == "--mount" {
+		for _, field := range strings.Split(value, ",") {
+			key, v, _ := strings.Cut(field, "=")
+			switch key {
+			case "source", "src":
+				source = v
+			case "target", "dst", "destination":
+				target = v
+			}
+		}
+		return source, target
+	}
+	parts := strings.Split(value, ":")
+	if len(parts) < 2 {
+		return "", ""
+	}
+	return parts[0], parts[1]
+}
+
 // ExecInteractive replaces the current process with `docker exec -it`.
-func ExecInteractive(container, user string, commandArgs ...string) error {
+func ExecInteractive(container string, opts ExecOptions, commandArgs ...string) error {
 	dockerPath, err := exec.LookPath("docker")
 	if err != nil {
-		return fmt.Errorf("docker not found: %w", err)
+		return fmt.Errorf("docker not found: %w", cliMissing(err))
 	}
 
 	args := []string{"docker", "exec", "-it"}
-	args = append(args, terminalEnvArgs()...)
-	if user != "" {
-		args = append(args, "-u", user)
+	args = append(args, terminalEnvArgs(opts.ForwardEnv...)...)
+	if opts.User != "" {
+		args = append(args, "-u", opts.User)
+	}
+	if opts.Workdir != "" {
+		args = append(args, "-w", opts.Workdir)
 	}
 	args = append(args, container)
-	args = append(args, commandArgs...)
+	args = append(args, withTermFallback(commandArgs)...)
 	return syscall.Exec(dockerPath, args, os.Environ())
 }
 
 // Exec runs a command inside a container and streams stdout/stderr.
 func Exec(container, user string, commandArgs ...string) error {
+	return ExecTo(os.Stdout, container, user, commandArgs...)
+}
+
+// ExecTo is Exec with stdout sent to w instead of os.Stdout.
+func ExecTo(w io.Writer, container, user string, commandArgs ...string) error {
 	cmd := exec.Command("docker", dockerExecArgs(container, user, commandArgs...)...)
-	cmd.Stdout = os.Stdout
+	cmd.Stdout = w
 	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	return cliMissing(cmd.Run())
 }
 
 // ExecOutput runs a command inside a container and returns stdout only.
 func ExecOutput(container, user string, commandArgs ...string) ([]byte, error) {
-	cmd := exec.Command("docker", dockerExecArgs(container, user, commandArgs...)...)
-	out, err := cmd.Output()
-	if err != nil {
+	res := runDocker(dockerExecArgs(container, user, commandArgs...)...)
+	if err := res.Failure(); err != nil {
 		return nil, fmt.Errorf("docker exec output (%s): %w", strings.Join(commandArgs, " "), err)
 	}
-	return out, nil
+	return []byte(res.Stdout), nil
 }
 
 // ExecCombinedOutput runs a command inside a container and returns combined output.
@@ -145,7 +363,7 @@ func ExecCombinedOutput(container, user string, commandArgs ...string) ([]byte,
 	cmd := exec.Command("docker", dockerExecArgs(container, user, commandArgs...)...)
 	out, err := cmd.CombinedOutput()
 	if err != nil {
-		return out, fmt.Errorf("docker exec combined output (%s): %w", strings.Join(commandArgs, " "), err)
+		return out, fmt.Errorf("docker exec combined output (%s): %w", strings.Join(commandArgs, " "), cliMissing(err))
 	}
 	return out, nil
 }
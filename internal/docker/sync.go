@@ -0,0 +1,349 @@
+package docker
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// WorkspaceVolumeName returns the named volume that backs /workspace for a
+// container running against a remote docker host.
+func WorkspaceVolumeName(container string) string {
+	return container + "-workspace"
+}
+
+// RemoveVolume removes a docker volume. It returns nil if the volume did not
+// exist.
+func RemoveVolume(name string) error {
+	res := runDocker("volume", "rm", name)
+	if err := res.Failure(); err != nil {
+		if strings.Contains(strings.ToLower(res.Message()), "no such volume") {
+			return nil
+		}
+		return fmt.Errorf("docker volume rm: %s: %w", res.Message(), err)
+	}
+	return nil
+}
+
+// VolumeExists reports whether a docker volume exists.
+func VolumeExists(name string) (bool, error) {
+	res := runDocker("volume", "inspect", name)
+	if err := res.Failure(); err != nil {
+		if strings.Contains(strings.ToLower(res.Message()), "no such volume") {
+			return false, nil
+		}
+		return false, fmt.Errorf("docker volume inspect: %s: %w", res.Message(), err)
+	}
+	return true, nil
+}
+
+// SyncOptions configures a workspace sync.
+type SyncOptions struct {
+	// Skip reports whether a path (relative to the workspace root) should be
+	// left out. Skipped directories are not descended into.
+	Skip func(rel string, isDir bool) bool
+}
+
+// SyncToContainer copies the contents of srcDir into the container's
+// /workspace and hands ownership to the claude user.
+func SyncToContainer(container, srcDir string) error {
+	return SyncToContainerWithOptions(container, srcDir, SyncOptions{})
+}
+
+// SyncToContainerWithOptions is SyncToContainer with additional options. The
+// files are streamed to `docker cp` as a tar archive through the docker API,
+// so this works against a remote daemon. Files already in /workspace but
+// absent from srcDir are left in place.
+func SyncToContainerWithOptions(container, srcDir string, opts SyncOptions) error {
+	return copyToWorkspace(container, "/workspace", func(tw *tar.Writer) error {
+		return addDirToTar(tw, srcDir, "", opts.Skip)
+	})
+}
+
+// SyncGitToContainer installs a standalone copy of a worktree's repository
+// as the container's /workspace/.git: the shared git directory commonDir,
+// without the other worktrees' admin directories, and HEAD and index from
+// the worktree's own adminDir. A worktree's .git file names a host path,
+// which a remote daemon can't see.
+func SyncGitToContainer(container, commonDir, adminDir string) error {
+	return copyToWorkspace(container, "/workspace/.git", func(tw *tar.Writer) error {
+		return addGitToTar(tw, commonDir, adminDir)
+	})
+}
+
+// addGitToTar adds the standalone repository described at
+// SyncGitToContainer to tw under ".git/".
+func addGitToTar(tw *tar.Writer, commonDir, adminDir string) error {
+	ownFiles := []string{"HEAD", "index"}
+	err := addDirToTar(tw, commonDir, ".git/", func(rel string, isDir bool) bool {
+		return rel == "worktrees" || slices.Contains(ownFiles, rel)
+	})
+	if err != nil {
+		return err
+	}
+	for _, name := range ownFiles {
+		if err := addFileToTar(tw, filepath.Join(adminDir, name), ".git/"+name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyToWorkspace streams the archive written by fill into the container's
+// /workspace and hands owned, a path under it, to the claude user.
+func copyToWorkspace(container, owned string, fill func(*tar.Writer) error) error {
+	pr, pw := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(pw)
+		err := fill(tw)
+		if err == nil {
+			err = tw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	cmd := exec.Command("docker", "cp", "-", container+":/workspace")
+	cmd.Stdin = pr
+	out, err := cmd.CombinedOutput()
+	pr.Close()
+	if err != nil {
+		return fmt.Errorf("docker cp to %s: %s: %w", container, strings.TrimSpace(string(out)), cliMissing(err))
+	}
+	out, err = exec.Command("docker", "exec", container, "chown", "-R", "claude:claude", owned).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("chown %s: %s: %w", owned, strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
+
+// SyncFromContainer copies the container's /workspace back into dstDir.
+func SyncFromContainer(container, dstDir string) error {
+	return SyncFromContainerWithOptions(container, dstDir, SyncOptions{})
+}
+
+// SyncFromContainerWithOptions is SyncFromContainer with additional options.
+// Files that exist in both places are overwritten; files deleted inside the
+// container are not removed from dstDir.
+func SyncFromContainerWithOptions(container, dstDir string, opts SyncOptions) error {
+	return copyFromContainer(container, "/workspace", dstDir, opts.Skip)
+}
+
+// SyncGitFromContainer copies the container's /workspace/.git, as installed
+// by SyncGitToContainer, into dstDir.
+func SyncGitFromContainer(container, dstDir string) error {
+	return copyFromContainer(container, "/workspace/.git", dstDir, nil)
+}
+
+// copyFromContainer copies the container directory src into dstDir.
+func copyFromContainer(container, src, dstDir string, skip func(string, bool) bool) error {
+	cmd := exec.Command("docker", "cp", container+":"+src, "-")
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("docker cp from %s: %w", container, cliMissing(err))
+	}
+	extractErr := extractWorkspaceTar(stdout, dstDir, skip)
+	// Drain whatever is left so docker cp can exit if extraction stopped early.
+	io.Copy(io.Discard, stdout)
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("docker cp from %s: %s: %w", container, strings.TrimSpace(stderr.String()), err)
+	}
+	if extractErr != nil {
+		return fmt.Errorf("extracting workspace: %w", extractErr)
+	}
+	return nil
+}
+
+// addDirToTar adds the contents of srcDir to tw, named by their path
+// relative to srcDir with prefix prepended. Special files are left out, as
+// is anything skip reports.
+func addDirToTar(tw *tar.Writer, srcDir, prefix string, skip func(string, bool) bool) error {
+	return filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		if skip != nil && skip(rel, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		var link string
+		switch {
+		case d.IsDir(), info.Mode().IsRegular():
+		case info.Mode()&fs.ModeSymlink != 0:
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = prefix + filepath.ToSlash(rel)
+		if d.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+}
+
+// addFileToTar adds the regular file at path to tw as name.
+func addFileToTar(tw *tar.Writer, path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// writeWorkspaceTar writes srcDir as a tar archive whose entries are relative
+// to srcDir, leaving out anything skip reports.
+func writeWorkspaceTar(w io.Writer, srcDir string, skip func(string, bool) bool) error {
+	tw := tar.NewWriter(w)
+	if err := addDirToTar(tw, srcDir, "", skip); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// extractWorkspaceTar unpacks the archive produced by `docker cp
+// <container>:/workspace -` into dstDir. docker names every entry after the
+// copied directory, so the first path component is dropped.
+func extractWorkspaceTar(r io.Reader, dstDir string, skip func(string, bool) bool) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "/"))
+		_, rel, found := strings.Cut(name, "/")
+		if !found || rel == "" {
+			continue // the workspace directory itself
+		}
+		if !filepath.IsLocal(rel) {
+			return fmt.Errorf("refusing to extract %q outside %s", hdr.Name, dstDir)
+		}
+		isDir := hdr.Typeflag == tar.TypeDir
+		if skip != nil && skip(rel, isDir) {
+			continue
+		}
+		if throughSymlink(dstDir, rel) {
+			return fmt.Errorf("refusing to extract %q through a symlink", hdr.Name)
+		}
+
+		target := filepath.Join(dstDir, filepath.FromSlash(rel))
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := extractFile(tr, target, hdr.FileInfo().Mode().Perm()); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// throughSymlink reports whether any parent directory of rel inside dstDir is
+// a symlink, which would let an entry land outside dstDir.
+func throughSymlink(dstDir, rel string) bool {
+	dir := dstDir
+	parents := strings.Split(rel, "/")
+	for _, name := range parents[:len(parents)-1] {
+		dir = filepath.Join(dir, name)
+		info, err := os.Lstat(dir)
+		if err != nil {
+			return false
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// extractFile writes the current tar entry to target. An existing symlink at
+// target is replaced rather than written through.
+func extractFile(r io.Reader, target string, perm fs.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if info, err := os.Lstat(target); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+		os.Remove(target)
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,164 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// workspaceArchive re-roots a writeWorkspaceTar archive under "workspace/",
+// the way `docker cp <container>:/workspace -` names its entries.
+func workspaceArchive(t *testing.T, srcDir string, skip func(string, bool) bool) *bytes.Buffer {
+	t.Helper()
+	var flat bytes.Buffer
+	if err := writeWorkspaceTar(&flat, srcDir, skip); err != nil {
+		t.Fatalf("writeWorkspaceTar: %v", err)
+	}
+
+	var out bytes.Buffer
+	tr := tar.NewReader(&flat)
+	tw := tar.NewWriter(&out)
+	tw.WriteHeader(&tar.Header{Name: "workspace/", Typeflag: tar.TypeDir, Mode: 0755})
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		hdr.Name = "workspace/" + hdr.Name
+		tw.WriteHeader(hdr)
+		io.Copy(tw, tr)
+	}
+	tw.Close()
+	return &out
+}
+
+func TestWorkspaceTar_RoundTripWithSkip(t *testing.T) {
+	src := t.TempDir()
+	for name, content := range map[string]string{
+		"main.go":                 "package main",
+		"sub/util.go":             "package sub",
+		"node_modules/x/index.js": "x",
+	} {
+		path := filepath.Join(src, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("main.go", filepath.Join(src, "link.go")); err != nil {
+		t.Fatal(err)
+	}
+
+	skip := func(rel string, isDir bool) bool { return isDir && rel == "node_modules" }
+	dst := t.TempDir()
+	if err := extractWorkspaceTar(workspaceArchive(t, src, skip), dst, nil); err != nil {
+		t.Fatalf("extractWorkspaceTar: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dst, "sub", "util.go"))
+	if err != nil || string(got) != "package sub" {
+		t.Errorf("sub/util.go = %q, %v", got, err)
+	}
+	if target, err := os.Readlink(filepath.Join(dst, "link.go")); err != nil || target != "main.go" {
+		t.Errorf("link.go -> %q, %v; want main.go", target, err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "node_modules")); !os.IsNotExist(err) {
+		t.Errorf("node_modules should have been skipped, got err=%v", err)
+	}
+}
+
+func TestExtractWorkspaceTar_SkipOnExtract(t *testing.T) {
+	src := t.TempDir()
+	os.WriteFile(filepath.Join(src, "keep.txt"), []byte("k"), 0644)
+	os.WriteFile(filepath.Join(src, "build.log"), []byte("l"), 0644)
+
+	dst := t.TempDir()
+	skip := func(rel string, _ bool) bool { return strings.HasSuffix(rel, ".log") }
+	if err := extractWorkspaceTar(workspaceArchive(t, src, nil), dst, skip); err != nil {
+		t.Fatalf("extractWorkspaceTar: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "keep.txt")); err != nil {
+		t.Errorf("keep.txt missing: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "build.log")); !os.IsNotExist(err) {
+		t.Errorf("build.log should have been skipped, got err=%v", err)
+	}
+}
+
+func TestExtractWorkspaceTar_RejectsSymlinkEscape(t *testing.T) {
+	outside := t.TempDir()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "workspace/out", Typeflag: tar.TypeSymlink, Linkname: outside})
+	tw.WriteHeader(&tar.Header{Name: "workspace/out/evil", Typeflag: tar.TypeReg, Mode: 0644})
+	tw.Close()
+
+	if err := extractWorkspaceTar(&buf, t.TempDir(), nil); err == nil {
+		t.Error("expected an error for an entry written through a symlink")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "evil")); !os.IsNotExist(err) {
+		t.Errorf("entry escaped the destination, got err=%v", err)
+	}
+}
+
+func TestAddGitToTar(t *testing.T) {
+	common := t.TempDir()
+	for name, content := range map[string]string{
+		"HEAD":                  "ref: refs/heads/main\n",
+		"index":                 "main index",
+		"config":                "[core]\n",
+		"refs/heads/feat":       "abc\n",
+		"worktrees/feat/HEAD":   "ref: refs/heads/feat\n",
+		"worktrees/other/index": "other index",
+	} {
+		path := filepath.Join(common, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	admin := filepath.Join(common, "worktrees", "feat")
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := addGitToTar(tw, common, admin); err != nil {
+		t.Fatalf("addGitToTar: %v", err)
+	}
+	tw.Close()
+
+	got := map[string]string{}
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(tr)
+		got[hdr.Name] = string(data)
+	}
+	if got[".git/HEAD"] != "ref: refs/heads/feat\n" {
+		t.Errorf(".git/HEAD = %q, want the worktree's HEAD", got[".git/HEAD"])
+	}
+	if _, ok := got[".git/index"]; ok {
+		t.Error("main index copied although the worktree has none")
+	}
+	if got[".git/refs/heads/feat"] != "abc\n" || got[".git/config"] != "[core]\n" {
+		t.Errorf("shared files missing: %v", got)
+	}
+	for name := range got {
+		if strings.HasPrefix(name, ".git/worktrees") {
+			t.Errorf("worktree admin dirs copied: %s", name)
+		}
+	}
+}
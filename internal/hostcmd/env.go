@@ -0,0 +1,73 @@
+package hostcmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Environment is the structured report returned by the cbox_env tool: a
+// machine-readable version of the environment section of CLAUDE.md.
+type Environment struct {
+	Branch       string            `json:"branch,omitempty"`
+	Workspace    string            `json:"workspace"`
+	Runtimes     []string          `json:"runtimes,omitempty"`      // tools found on the container's PATH
+	HostCommands []string          `json:"host_commands,omitempty"` // allowed via run_command
+	Commands     map[string]string `json:"commands,omitempty"`      // MCP tool name -> shell expression
+	Ports        []string          `json:"ports,omitempty"`
+	ServeURL     string            `json:"serve_url,omitempty"`
+	ServePort    int               `json:"serve_port,omitempty"`
+}
+
+// SetEnvironment sets the function that supplies the sandbox details for
+// cbox_env. It is called on every request so the report reflects the
+// sandbox's current state (e.g. a serve process started after up).
+func (s *Server) SetEnvironment(fn func() Environment) {
+	s.env = fn
+}
+
+func (s *Server) envToolDefinition() mcp.Tool {
+	return mcp.NewTool(
+		"cbox_env",
+		mcp.WithDescription("Describe the sandbox environment as JSON: available runtimes, "+
+			"host commands, project command tools, exposed ports and the serve URL. "+
+			"Call this instead of probing the container with shell commands."),
+	)
+}
+
+func (s *Server) handleEnv(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	data, err := json.MarshalIndent(s.environment(), "", "  ")
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("marshaling environment: %v", err)), nil
+	}
+	return mcp.NewToolResultText(string(data)), nil
+}
+
+// environment merges the server's own command configuration into the
+// details supplied by SetEnvironment.
+func (s *Server) environment() Environment {
+	var env Environment
+	if s.env != nil {
+		env = s.env()
+	}
+	if env.Workspace == "" {
+		env.Workspace = "/workspace"
+	}
+
+	env.HostCommands = make([]string, 0, len(s.allowedCmds))
+	for name := range s.allowedCmds {
+		env.HostCommands = append(env.HostCommands, name)
+	}
+	sort.Strings(env.HostCommands)
+
+	if len(s.namedCommands) > 0 {
+		env.Commands = make(map[string]string, len(s.namedCommands))
+		for name, expr := range s.namedCommands {
+			env.Commands["cbox_"+name] = expr
+		}
+	}
+	return env
+}
@@ -14,18 +14,52 @@ type proxyOutput struct {
 	Port int `json:"port"`
 }
 
+// ProxyOptions configures the MCP server started by RunProxyCommand.
+type ProxyOptions struct {
+	WorktreePath   string
+	HostCommands   []string          // allowed via run_command
+	NamedCommands  map[string]string // exposed as cbox_<name> tools
+	ReportDir      string
+	LogDir         string
+	CommandTimeout time.Duration // 0 uses the default (120s)
+	MaxConcurrent  int           // 0 runs commands without a limit
+	Port           int           // 0 picks a random free port
+
+	// Timeouts overrides CommandTimeout for individual named commands.
+	Timeouts map[string]time.Duration
+
+	// Container is the sandbox container that ContainerCommands (a subset
+	// of NamedCommands) are run in with docker exec.
+	Container         string
+	ContainerCommands []string
+
+	// Env, if non-nil, supplies the sandbox details reported by the
+	// cbox_env tool.
+	Env func() Environment
+}
+
 // RunProxyCommand starts the MCP server, prints the port as JSON, and blocks until signaled.
-// commandTimeout of 0 uses the default (120s).
-func RunProxyCommand(worktreePath string, commands []string, namedCommands map[string]string, reportDir, logDir string, commandTimeout time.Duration) error {
-	srv := NewServer(worktreePath, commands, namedCommands)
-	if reportDir != "" {
-		srv.SetReportDir(reportDir)
+func RunProxyCommand(opts ProxyOptions) error {
+	srv := NewServer(opts.WorktreePath, opts.HostCommands, opts.NamedCommands)
+	srv.SetPort(opts.Port)
+	if opts.Env != nil {
+		srv.SetEnvironment(opts.Env)
+	}
+	if opts.ReportDir != "" {
+		srv.SetReportDir(opts.ReportDir)
+	}
+	if opts.LogDir != "" {
+		srv.SetLogDir(opts.LogDir)
+	}
+	if opts.CommandTimeout > 0 {
+		srv.SetCommandTimeout(opts.CommandTimeout)
 	}
-	if logDir != "" {
-		srv.SetLogDir(logDir)
+	if len(opts.Timeouts) > 0 {
+		srv.SetCommandTimeouts(opts.Timeouts)
 	}
-	if commandTimeout > 0 {
-		srv.SetCommandTimeout(commandTimeout)
+	srv.SetMaxConcurrent(opts.MaxConcurrent)
+	if len(opts.ContainerCommands) > 0 {
+		srv.SetContainerCommands(opts.Container, opts.ContainerCommands)
 	}
 
 	port, err := srv.Start()
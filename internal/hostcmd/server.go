@@ -1,20 +1,29 @@
 package hostcmd
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
+
+	"github.com/richvanbergen/cbox/internal/config"
+	"github.com/richvanbergen/cbox/internal/redact"
 )
 
 const defaultCommandTimeout = 120 * time.Second
@@ -24,6 +33,7 @@ type Report struct {
 	Type      string    `json:"type"`
 	Title     string    `json:"title"`
 	Body      string    `json:"body"`
+	Artifacts []string  `json:"artifacts,omitempty"` // worktree-relative paths
 	CreatedAt time.Time `json:"created_at"`
 }
 
@@ -33,9 +43,15 @@ type Server struct {
 	worktreePath   string
 	allowedCmds    map[string]bool
 	namedCommands  map[string]string
+	container      string          // sandbox container for containerCmds
+	containerCmds  map[string]bool // named commands run inside the container
 	reportDir      string
 	logDir         string // directory for command log files (defaults to <worktreePath>/.cbox/logs)
 	commandTimeout time.Duration
+	timeouts       map[string]time.Duration
+	slots          chan struct{}      // limits concurrent commands; nil means unlimited
+	port           int                // fixed listen port; 0 picks a random free port
+	env            func() Environment // sandbox details for cbox_env
 	listener       net.Listener
 	httpServer     *http.Server
 }
@@ -59,6 +75,124 @@ func (s *Server) SetCommandTimeout(d time.Duration) {
 	s.commandTimeout = d
 }
 
+// SetCommandTimeouts sets timeouts for individual named commands, which
+// take precedence over the SetCommandTimeout default.
+func (s *Server) SetCommandTimeouts(timeouts map[string]time.Duration) {
+	s.timeouts = timeouts
+}
+
+// timeoutFor returns the timeout for the named command ("" for
+// run_command) and the setting it comes from, for timeout errors.
+func (s *Server) timeoutFor(name string) (time.Duration, string) {
+	if d := s.timeouts[name]; name != "" && d > 0 {
+		return d, "command_timeouts." + name
+	}
+	return s.commandTimeout, "command_timeout"
+}
+
+// timeoutError reports that a command hit its timeout and which setting
+// to raise.
+func timeoutError(timeout time.Duration, setting string) *mcp.CallToolResult {
+	return mcp.NewToolResultError(fmt.Sprintf("command timed out after %s (raise %s to allow longer)", timeout, setting))
+}
+
+// SetContainerCommands makes the given named commands run inside container
+// (with docker exec, in /workspace) instead of on the host worktree, so they
+// can use the container's runtimes.
+func (s *Server) SetContainerCommands(container string, names []string) {
+	s.container = container
+	s.containerCmds = make(map[string]bool, len(names))
+	for _, name := range names {
+		s.containerCmds[name] = true
+	}
+}
+
+// SetMaxConcurrent limits how many commands (run_command and named tools)
+// run at once. Calls beyond the limit wait for a free slot. n <= 0 means
+// unlimited.
+func (s *Server) SetMaxConcurrent(n int) {
+	if n <= 0 {
+		s.slots = nil
+		return
+	}
+	s.slots = make(chan struct{}, n)
+}
+
+// acquireSlot waits for a free command slot and returns a func that frees
+// it. While waiting it sends a progress notification, if the client asked for
+// them, so the caller knows the command is queued rather than hung.
+func (s *Server) acquireSlot(ctx context.Context, request mcp.CallToolRequest) (func(), error) {
+	if s.slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case s.slots <- struct{}{}:
+		return func() { <-s.slots }, nil
+	default:
+	}
+
+	notifyProgress(ctx, request, 0, fmt.Sprintf("waiting: %d commands already running", cap(s.slots)))
+
+	select {
+	case s.slots <- struct{}{}:
+		return func() { <-s.slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// notifyProgress sends a progress notification for request, if the client
+// asked for them by passing a progress token. It is best-effort.
+func notifyProgress(ctx context.Context, request mcp.CallToolRequest, progress int, message string) {
+	meta := request.Params.Meta
+	if meta == nil || meta.ProgressToken == nil {
+		return
+	}
+	if srv := server.ServerFromContext(ctx); srv != nil {
+		srv.SendNotificationToClient(ctx, "notifications/progress", map[string]any{
+			"progressToken": meta.ProgressToken,
+			"progress":      progress,
+			"message":       message,
+		})
+	}
+}
+
+// progressInterval is how often a running command reports its latest
+// output line to clients that asked for progress notifications.
+var progressInterval = 5 * time.Second
+
+// reportProgress sends out's byte count and latest line as a progress
+// notification every progressInterval, so a client watching a long build
+// sees it move. The returned func stops it.
+func reportProgress(ctx context.Context, request mcp.CallToolRequest, out *commandOutput) (stop func()) {
+	if meta := request.Params.Meta; meta == nil || meta.ProgressToken == nil {
+		return func() {}
+	}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				n, line := out.progress()
+				notifyProgress(ctx, request, n, line)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
 // SetReportDir enables the cbox_report tool and sets where reports are stored.
 func (s *Server) SetReportDir(dir string) {
 	s.reportDir = dir
@@ -69,9 +203,16 @@ func (s *Server) SetLogDir(dir string) {
 	s.logDir = dir
 }
 
-// Start listens on a random port and serves the MCP protocol. Returns the port.
+// SetPort makes Start listen on a fixed port instead of a random one. This
+// lets a restarted server keep the port the container was configured with.
+func (s *Server) SetPort(port int) {
+	s.port = port
+}
+
+// Start listens on the configured port (random by default) and serves the
+// MCP protocol. Returns the port.
 func (s *Server) Start() (int, error) {
-	ln, err := net.Listen("tcp", "0.0.0.0:0")
+	ln, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", s.port))
 	if err != nil {
 		return 0, fmt.Errorf("listening: %w", err)
 	}
@@ -92,6 +233,8 @@ func (s *Server) Start() (int, error) {
 		mcpServer.AddTool(s.namedToolDefinition(name, expr), s.makeNamedCommandHandler(name, expr))
 	}
 
+	mcpServer.AddTool(s.envToolDefinition(), s.handleEnv)
+
 	// Register report tool if report dir is set
 	if s.reportDir != "" {
 		mcpServer.AddTool(s.reportToolDefinition(), s.handleReport)
@@ -101,6 +244,11 @@ func (s *Server) Start() (int, error) {
 
 	mux := http.NewServeMux()
 	mux.Handle("/mcp", httpTransport)
+	// healthz lets cbox check from inside the container that the host is
+	// reachable at all, independently of the MCP protocol.
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
 
 	s.httpServer = &http.Server{Handler: mux}
 
@@ -180,35 +328,40 @@ func (s *Server) handleRunCommand(ctx context.Context, request mcp.CallToolReque
 		return mcp.NewToolResultError("working directory must be within the workspace"), nil
 	}
 
-	execCtx, cancel := context.WithTimeout(ctx, s.commandTimeout)
+	release, err := s.acquireSlot(ctx, request)
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("gave up waiting for a free command slot: %v", err)), nil
+	}
+	defer release()
+
+	timeout, setting := s.timeoutFor("")
+	execCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	cmd := exec.CommandContext(execCtx, command, args...)
 	cmd.Dir = cwd
 
-	output, err := cmd.CombinedOutput()
-
-	exitCode := 0
-	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			exitCode = exitErr.ExitCode()
-		} else if execCtx.Err() == context.DeadlineExceeded {
-			return mcp.NewToolResultError(fmt.Sprintf("command timed out after %s", s.commandTimeout)), nil
-		} else {
-			return mcp.NewToolResultError(fmt.Sprintf("failed to execute command: %v", err)), nil
-		}
-	}
-
-	result := fmt.Sprintf("exit_code: %d\n%s", exitCode, string(output))
-	if exitCode != 0 {
-		return mcp.NewToolResultError(result), nil
-	}
-	return mcp.NewToolResultText(result), nil
+	// Each call gets its own log, since run_command output isn't tied to
+	// a name like the project commands' is.
+	var out commandOutput
+	var logPath string
+	logName := fmt.Sprintf("%s-%s.log", time.Now().Format("20060102-150405.000"), filepath.Base(command))
+	if logFile := createLog(filepath.Join(s.runLogDir(), logName)); logFile != nil {
+		defer logFile.Close()
+		log := redact.NewWriter(logFile)
+		defer log.Close()
+		out.log, logPath = log, s.logLocation(logFile.Name())
+		pruneLogs(filepath.Dir(logFile.Name()), maxRunCommandLogs)
+	}
+	return runTool(ctx, execCtx, request, cmd, &out, logPath, timeout, setting), nil
 }
 
 // namedToolDefinition creates an MCP tool definition for a named project command.
 func (s *Server) namedToolDefinition(name, expr string) mcp.Tool {
 	desc := fmt.Sprintf("Run the project's %s command: %s", name, expr)
+	if s.containerCmds[name] {
+		desc = fmt.Sprintf("Run the project's %s command inside the sandbox container: %s", name, expr)
+	}
 	return mcp.NewTool(
 		"cbox_"+name,
 		mcp.WithDescription(desc),
@@ -218,66 +371,370 @@ func (s *Server) namedToolDefinition(name, expr string) mcp.Tool {
 	)
 }
 
-// makeNamedCommandHandler returns an MCP handler that runs the given shell expression.
-// Output is written to a log file on the host and the response includes inline output
-// (last 20 lines on success, last 40 lines on failure) so the inner Claude doesn't
-// need to read log files from the workspace.
+// makeNamedCommandHandler returns an MCP handler that runs the given shell expression,
+// on the host or, for container commands, inside the sandbox container.
+// Output is streamed to a log file on the host as it is written, so an operator can
+// follow it, and the response includes inline output (last 20 lines on success, last
+// 40 lines on failure) so the inner Claude doesn't need to read log files from the
+// workspace.
 func (s *Server) makeNamedCommandHandler(name, expr string) server.ToolHandlerFunc {
 	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		execCtx, cancel := context.WithTimeout(ctx, s.commandTimeout)
+		release, err := s.acquireSlot(ctx, request)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("gave up waiting for a free command slot: %v", err)), nil
+		}
+		defer release()
+
+		timeout, setting := s.timeoutFor(name)
+		execCtx, cancel := context.WithTimeout(ctx, timeout)
 		defer cancel()
 
 		argsVal := request.GetString("args", "")
-		resolvedExpr := strings.ReplaceAll(expr, "$Args", argsVal)
-		cmd := exec.CommandContext(execCtx, "sh", "-c", resolvedExpr)
-		cmd.Dir = s.worktreePath
+		resolvedExpr := strings.ReplaceAll(config.ShellCommand(expr), "$Args", argsVal)
+		var cmd *exec.Cmd
+		if s.containerCmds[name] {
+			cmd = s.containerCommand(execCtx, config.EnvRefs(expr), resolvedExpr, timeout)
+		} else {
+			cmd = exec.CommandContext(execCtx, "sh", "-c", resolvedExpr)
+			cmd.Dir = s.worktreePath
+		}
 
-		output, err := cmd.CombinedOutput()
+		var out commandOutput
+		var logPath string
+		if logFile := s.openLog(name); logFile != nil {
+			defer logFile.Close()
+			log := redact.NewWriter(logFile)
+			defer log.Close()
+			out.log, logPath = log, s.logLocation(logFile.Name())
+		}
+		return runTool(ctx, execCtx, request, cmd, &out, logPath, timeout, setting), nil
+	}
+}
 
-		exitCode := 0
-		if err != nil {
-			if exitErr, ok := err.(*exec.ExitError); ok {
-				exitCode = exitErr.ExitCode()
-			} else if execCtx.Err() == context.DeadlineExceeded {
-				return mcp.NewToolResultError(fmt.Sprintf("command timed out after %s", s.commandTimeout)), nil
-			} else {
-				return mcp.NewToolResultError(fmt.Sprintf("failed to execute command: %v", err)), nil
-			}
+// runTool runs cmd, which was created with execCtx, with its output
+// captured in out, and turns the outcome into a tool result. logPath is
+// where out's log is, or "" if there is none; timeout and setting describe
+// execCtx's deadline for the timeout error.
+func runTool(ctx, execCtx context.Context, request mcp.CallToolRequest, cmd *exec.Cmd, out *commandOutput, logPath string, timeout time.Duration, setting string) *mcp.CallToolResult {
+	out.attach(cmd)
+	stopProgress := reportProgress(ctx, request, out)
+	err := cmd.Run()
+	stopProgress()
+
+	exitCode := 0
+	if err != nil {
+		// A killed command also exits with an ExitError, so check for the
+		// timeout first.
+		if execCtx.Err() == context.DeadlineExceeded {
+			return timeoutError(timeout, setting)
+		} else if exitErr, ok := err.(*exec.ExitError); ok {
+			exitCode = exitErr.ExitCode()
+		} else {
+			return mcp.NewToolResultError(fmt.Sprintf("failed to execute command: %v", err))
 		}
+	}
+
+	result := out.result(exitCode, tailLines(exitCode), logPath)
+	if exitCode != 0 {
+		return mcp.NewToolResultError(result)
+	}
+	return mcp.NewToolResultText(result)
+}
 
-		// Write output to log file for human operators
-		logDir := s.logDir
-		if logDir == "" {
-			logDir = filepath.Join(s.worktreePath, ".cbox", "logs")
+// callSeq numbers container command calls, for their kill markers.
+var callSeq atomic.Int64
+
+// containerKillScript sends SIGTERM to every process in the container whose
+// command line carries the marker passed as $0, other than itself. That is
+// the timeout wrapper and the command's shell; timeout passes the signal on.
+const containerKillScript = `for d in /proc/[0-9]*; do
+	p=${d#/proc/}
+	[ "$p" = "$$" ] && continue
+	case " $(tr '\0' ' ' 2>/dev/null <"$d/cmdline")" in
+	*" $0 "*) kill -TERM "$p" 2>/dev/null ;;
+	esac
+done`
+
+// containerCommand returns the docker exec that runs shell command expr
+// inside the sandbox container. Killing docker exec doesn't stop what it
+// started, so the command is bounded by timeout inside the container too,
+// and cancelling ctx also signals it there, found by a marker set as its
+// shell's $0. The container's shell expands the command's ${VAR}
+// references, so the host's values for envRefs are passed through by name.
+func (s *Server) containerCommand(ctx context.Context, envRefs []string, expr string, timeout time.Duration) *exec.Cmd {
+	marker := fmt.Sprintf("cbox-call-%d-%d", os.Getpid(), callSeq.Add(1))
+	args := []string{"exec", "-u", "claude", "-w", "/workspace"}
+	for _, ref := range envRefs {
+		args = append(args, "-e", ref)
+	}
+	args = append(args, s.container,
+		"timeout", "-k", "10", strconv.FormatFloat(timeout.Seconds(), 'f', -1, 64),
+		"sh", "-c", expr, marker)
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Cancel = func() error {
+		killCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		exec.CommandContext(killCtx, "docker", "exec", "-u", "claude", s.container,
+			"sh", "-c", containerKillScript, marker).Run()
+		return cmd.Process.Kill()
+	}
+	return cmd
+}
+
+// openLog creates (truncating) the log file <name>.log in the log directory,
+// for human operators. Logging is best-effort: it returns nil if the file
+// can't be created.
+func (s *Server) openLog(name string) *os.File {
+	return createLog(filepath.Join(s.logDirectory(), name+".log"))
+}
+
+// logDirectory is the log directory, defaulting to <worktreePath>/.cbox/logs.
+func (s *Server) logDirectory() string {
+	if s.logDir != "" {
+		return s.logDir
+	}
+	return filepath.Join(s.worktreePath, ".cbox", "logs")
+}
+
+// runLogDir is where each run_command call's full output is saved: beside
+// the reports when a report dir is set, otherwise in the log directory.
+func (s *Server) runLogDir() string {
+	if s.reportDir != "" {
+		return filepath.Join(s.reportDir, "run_command")
+	}
+	return filepath.Join(s.logDirectory(), "run_command")
+}
+
+// createLog creates (truncating) the log file at path, and its directory.
+// It returns nil if the file can't be created.
+func createLog(path string) *os.File {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil
+	}
+	return f
+}
+
+// maxRunCommandLogs is how many run_command logs are kept; older ones are
+// removed as new calls are logged.
+const maxRunCommandLogs = 50
+
+// pruneLogs removes all but the newest keep logs in dir. Their names start
+// with a timestamp, so name order is age order.
+func pruneLogs(dir string, keep int) {
+	logs, err := filepath.Glob(filepath.Join(dir, "*.log"))
+	if err != nil || len(logs) <= keep {
+		return
+	}
+	sort.Strings(logs)
+	for _, p := range logs[:len(logs)-keep] {
+		os.Remove(p)
+	}
+}
+
+// logLocation is how a result refers to a log file: its /workspace path
+// when the log is in the worktree, otherwise its host path, marked as such
+// since the agent can't open it.
+func (s *Server) logLocation(p string) string {
+	if cp := s.containerPath(p); cp != p {
+		return cp
+	}
+	return p + " (host path, not visible in the container)"
+}
+
+// commandOutput keeps the tails of a command's stdout and stderr
+// separately, and copies the two interleaved, as they are written, to an
+// optional log, which is the only place the full output is kept.
+type commandOutput struct {
+	mu       sync.Mutex
+	stdout   tailBuffer
+	stderr   tailBuffer
+	log      io.Writer // nil for no log
+	written  int       // bytes written to either stream
+	lastLine string    // most recent complete, non-empty line
+}
+
+// attach points cmd's stdout and stderr at o.
+func (o *commandOutput) attach(cmd *exec.Cmd) {
+	cmd.Stdout = &streamWriter{o: o, buf: &o.stdout}
+	cmd.Stderr = &streamWriter{o: o, buf: &o.stderr}
+}
+
+// tailLines is how many lines of each stream a tool response keeps: 20 on
+// success, 40 on failure, where the extra context usually holds the error.
+func tailLines(exitCode int) int {
+	if exitCode != 0 {
+		return 40
+	}
+	return 20
+}
+
+// result formats the output for a tool response: the exit code followed by
+// labeled stdout and stderr sections, each omitted when empty. Each stream
+// is cut to its last tail lines (at most maxTailLines), and if anything was
+// cut a pointer to logPath (when set) follows.
+func (o *commandOutput) result(exitCode, tail int, logPath string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "exit_code: %d\n", exitCode)
+	truncated := false
+	for _, stream := range []struct {
+		name string
+		buf  *tailBuffer
+	}{
+		{"stdout", &o.stdout},
+		{"stderr", &o.stderr},
+	} {
+		text, lines, size := stream.buf.tail(tail)
+		var omitted string
+		if lines > 0 {
+			omitted = fmt.Sprintf("[%d earlier lines (%d bytes) omitted]\n", lines, size)
+			truncated = true
 		}
-		if mkErr := os.MkdirAll(logDir, 0755); mkErr == nil {
-			logFile := filepath.Join(logDir, name+".log")
-			os.WriteFile(logFile, output, 0644) // best-effort
+		text = strings.TrimRight(text, "\n")
+		if text == "" {
+			continue
 		}
+		fmt.Fprintf(&b, "\n%s:\n%s%s\n", stream.name, omitted, text)
+	}
+	if truncated && logPath != "" {
+		fmt.Fprintf(&b, "\nfull log: %s\n", logPath)
+	}
+	return redact.String(b.String())
+}
 
-		if exitCode != 0 {
-			tail := lastNLines(string(output), 40)
-			result := fmt.Sprintf("exit_code: %d\n\n%s", exitCode, tail)
-			return mcp.NewToolResultError(result), nil
+// progress returns how many bytes the command has written and its latest
+// output line.
+func (o *commandOutput) progress() (int, string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.written, o.lastLine
+}
+
+// streamWriter writes one stream into its own tail buffer and the shared
+// log. exec copies stdout and stderr on separate goroutines, so writes are
+// serialized.
+type streamWriter struct {
+	o   *commandOutput
+	buf *tailBuffer
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	w.o.mu.Lock()
+	defer w.o.mu.Unlock()
+	if line := w.buf.write(p); line != "" {
+		w.o.lastLine = redact.String(line)
+	}
+	w.o.written += len(p)
+	if w.o.log != nil {
+		w.o.log.Write(p) // best-effort
+	}
+	return len(p), nil
+}
+
+// maxTailLines is how many complete lines a tailBuffer keeps: the most any
+// response shows (see tailLines).
+const maxTailLines = 40
+
+// maxLineBytes bounds how much of a single line a tailBuffer keeps, so
+// output without newlines can't grow it without limit either.
+const maxLineBytes = 64 << 10
+
+// tailBuffer keeps the last maxTailLines lines written to it, and counts
+// the lines and bytes that have scrolled out.
+type tailBuffer struct {
+	lines   []tailLine // ring of complete lines, oldest at start
+	start   int
+	partial []byte // the unfinished last line, up to maxLineBytes
+	cut     int    // bytes of the partial line beyond maxLineBytes
+	dropped int    // lines that have scrolled out
+	size    int    // bytes of those lines, newlines included
+}
+
+// tailLine is a line in a tailBuffer and how many bytes it was written as.
+type tailLine struct {
+	text string
+	size int
+}
+
+// write appends p and returns the latest complete, non-empty line in it,
+// trimmed, or "" if it finished none.
+func (b *tailBuffer) write(p []byte) string {
+	var last string
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			b.appendPartial(p)
+			break
 		}
-		tail := lastNLines(string(output), 20)
-		result := fmt.Sprintf("exit_code: 0\n\n%s", tail)
-		return mcp.NewToolResultText(result), nil
+		b.appendPartial(p[:i])
+		if line := strings.TrimSpace(b.finishLine()); line != "" {
+			last = line
+		}
+		p = p[i+1:]
 	}
+	return last
 }
 
-// lastNLines returns the last n lines from s. If s has fewer than n lines, it
-// returns s unchanged.
-func lastNLines(s string, n int) string {
-	lines := strings.Split(s, "\n")
-	// Trim a trailing empty element produced by a final newline
-	if len(lines) > 0 && lines[len(lines)-1] == "" {
-		lines = lines[:len(lines)-1]
+func (b *tailBuffer) appendPartial(p []byte) {
+	if room := maxLineBytes - len(b.partial); len(p) > room {
+		b.cut += len(p) - room
+		p = p[:room]
 	}
-	if len(lines) <= n {
-		return strings.Join(lines, "\n")
+	b.partial = append(b.partial, p...)
+}
+
+// finishLine moves the partial line, ended by a newline, into the ring,
+// pushing out the oldest line when it is full, and returns it.
+func (b *tailBuffer) finishLine() string {
+	line := b.pending()
+	line.size++
+	b.partial, b.cut = b.partial[:0], 0
+	if len(b.lines) < maxTailLines {
+		b.lines = append(b.lines, line)
+		return line.text
+	}
+	b.dropped++
+	b.size += b.lines[b.start].size
+	b.lines[b.start] = line
+	b.start = (b.start + 1) % len(b.lines)
+	return line.text
+}
+
+// pending returns the unfinished line, noting how much of it was cut.
+func (b *tailBuffer) pending() tailLine {
+	line := tailLine{text: string(b.partial), size: len(b.partial) + b.cut}
+	if b.cut > 0 {
+		line.text += fmt.Sprintf(" [%d more bytes]", b.cut)
 	}
-	return strings.Join(lines[len(lines)-n:], "\n")
+	return line
+}
+
+// tail returns the last n lines kept, an unfinished last line included,
+// and how many lines and bytes came before them. n <= 0 keeps every line
+// still held.
+func (b *tailBuffer) tail(n int) (string, int, int) {
+	lines := make([]tailLine, 0, len(b.lines)+1)
+	lines = append(lines, b.lines[b.start:]...)
+	lines = append(lines, b.lines[:b.start]...)
+	if len(b.partial) > 0 || b.cut > 0 {
+		lines = append(lines, b.pending())
+	}
+	dropped, size := b.dropped, b.size
+	if n > 0 && len(lines) > n {
+		for _, l := range lines[:len(lines)-n] {
+			dropped++
+			size += l.size
+		}
+		lines = lines[len(lines)-n:]
+	}
+	texts := make([]string, len(lines))
+	for i, l := range lines {
+		texts[i] = l.text
+	}
+	return strings.Join(texts, "\n"), dropped, size
 }
 
 func (s *Server) reportToolDefinition() mcp.Tool {
@@ -298,6 +755,10 @@ func (s *Server) reportToolDefinition() mcp.Tool {
 			mcp.Description("Detailed content (plan, progress update, or completion summary)"),
 			mcp.Required(),
 		),
+		mcp.WithArray("artifacts",
+			mcp.Description("Files to attach, e.g. a coverage report or screenshot (paths under /workspace or relative to it; optional)"),
+			mcp.Items(map[string]any{"type": "string"}),
+		),
 	)
 }
 
@@ -317,6 +778,15 @@ func (s *Server) handleReport(ctx context.Context, request mcp.CallToolRequest)
 		return mcp.NewToolResultError("missing required parameter: body"), nil
 	}
 
+	var artifacts []string
+	for _, p := range request.GetStringSlice("artifacts", nil) {
+		rel, err := s.artifactPath(p)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		artifacts = append(artifacts, rel)
+	}
+
 	if err := os.MkdirAll(s.reportDir, 0755); err != nil {
 		return mcp.NewToolResultError(fmt.Sprintf("creating report dir: %v", err)), nil
 	}
@@ -328,6 +798,7 @@ func (s *Server) handleReport(ctx context.Context, request mcp.CallToolRequest)
 		Type:      reportType,
 		Title:     title,
 		Body:      body,
+		Artifacts: artifacts,
 		CreatedAt: time.Now(),
 	}
 
@@ -400,6 +871,18 @@ func LoadReports(reportDir string) ([]Report, error) {
 	return reports, nil
 }
 
+// artifactPath resolves a report artifact to a path relative to the worktree,
+// rejecting anything outside it.
+func (s *Server) artifactPath(p string) (string, error) {
+	absWorktree, _ := filepath.Abs(s.worktreePath)
+	abs, _ := filepath.Abs(s.translatePath(p))
+	rel, err := filepath.Rel(absWorktree, abs)
+	if err != nil || rel == "." || !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("artifact %s must be within the workspace", p)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
 // translatePath converts /workspace/... paths to the host worktree path.
 func (s *Server) translatePath(p string) string {
 	if strings.HasPrefix(p, "/workspace") {
@@ -411,3 +894,14 @@ func (s *Server) translatePath(p string) string {
 	}
 	return p
 }
+
+// containerPath is the reverse of translatePath: a host path inside the
+// worktree becomes its /workspace path. Other paths are returned as they
+// are, for the host operator.
+func (s *Server) containerPath(p string) string {
+	rel, err := filepath.Rel(s.worktreePath, p)
+	if err != nil || !filepath.IsLocal(rel) {
+		return p
+	}
+	return path.Join("/workspace", filepath.ToSlash(rel))
+}
@@ -2,6 +2,7 @@ package hostcmd
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -9,8 +10,13 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+
+	"github.com/richvanbergen/cbox/internal/redact"
 )
 
 // sendMCPRequest sends a JSON-RPC request to the MCP server and returns the response body.
@@ -227,6 +233,21 @@ func TestNamedCommandExecutes(t *testing.T) {
 	}
 }
 
+func TestNamedCommandExpandsEnvWhenRun(t *testing.T) {
+	t.Setenv("CBOX_TEST_TOKEN", "s3cret")
+	expr := "echo token=${CBOX_TEST_TOKEN} default=${CBOX_TEST_UNSET:-none} loop=$${CBOX_TEST_TOKEN}"
+	srv := NewServer(t.TempDir(), nil, map[string]string{"show": expr})
+
+	result, err := srv.makeNamedCommandHandler("show", expr)(context.Background(), mcp.CallToolRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "token=s3cret default=none loop=s3cret"
+	if content := result.Content[0].(mcp.TextContent).Text; !strings.Contains(content, want) {
+		t.Errorf("expected %q in the output, got: %s", want, content)
+	}
+}
+
 func TestNamedCommandFailure(t *testing.T) {
 	dir := t.TempDir()
 	url, _ := startTestServerWithNamedCommands(t, dir, nil, map[string]string{
@@ -270,6 +291,31 @@ func TestNamedCommandLogFileCreated(t *testing.T) {
 	}
 }
 
+func TestNamedCommandRedactsSecrets(t *testing.T) {
+	redact.Add("op-resolved-secret")
+	logDir := t.TempDir()
+	expr := "echo token=op-resolved-secret"
+	srv := NewServer(t.TempDir(), nil, map[string]string{"leak": expr})
+	srv.SetLogDir(logDir)
+
+	result, err := srv.makeNamedCommandHandler("leak", expr)(context.Background(), mcp.CallToolRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(logDir, "leak.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, got := range map[string]string{
+		"result": result.Content[0].(mcp.TextContent).Text,
+		"log":    string(data),
+	} {
+		if strings.Contains(got, "op-resolved-secret") || !strings.Contains(got, "token="+redact.Mask) {
+			t.Errorf("%s = %q, want the secret masked", name, got)
+		}
+	}
+}
+
 func TestNamedCommandFailureTail(t *testing.T) {
 	dir := t.TempDir()
 	// Generate 50 lines of output then fail — the response should contain only the last 40
@@ -296,6 +342,32 @@ func TestNamedCommandFailureTail(t *testing.T) {
 	}
 }
 
+func TestNamedCommandSeparatesStreams(t *testing.T) {
+	dir := t.TempDir()
+	// 30 lines on stdout, 5 on stderr — stdout is cut to its own last 20 lines
+	// while stderr is kept whole.
+	expr := "for i in $(seq 1 30); do echo \"out-$i\"; done; for i in $(seq 1 5); do echo \"err-$i\" >&2; done"
+	url, _ := startTestServerWithNamedCommands(t, dir, nil, map[string]string{
+		"gen": expr,
+	})
+
+	content := extractTextContent(t, callNamedTool(t, url, "cbox_gen"))
+
+	stdout, stderr, found := strings.Cut(content, "\nstderr:\n")
+	if !found {
+		t.Fatalf("expected a stderr section, got: %s", content)
+	}
+	if !strings.Contains(stdout, "stdout:\n[10 earlier lines (61 bytes) omitted]\nout-11\n") || !strings.Contains(stdout, "out-30") {
+		t.Errorf("expected stdout section with the last 20 lines, got: %s", stdout)
+	}
+	if strings.Contains(stdout, "err-") {
+		t.Errorf("stderr leaked into the stdout section: %s", stdout)
+	}
+	if !strings.Contains(stderr, "err-1") || !strings.Contains(stderr, "err-5") || strings.Contains(stderr, "out-") {
+		t.Errorf("expected stderr section with only stderr lines, got: %s", stderr)
+	}
+}
+
 func extractTextContent(t *testing.T, response map[string]any) string {
 	t.Helper()
 
@@ -317,3 +389,453 @@ func extractTextContent(t *testing.T, response map[string]any) string {
 	text, _ := first["text"].(string)
 	return text
 }
+
+func TestHealthz(t *testing.T) {
+	url, _ := startTestServer(t, t.TempDir(), nil)
+
+	resp, err := http.Get(strings.TrimSuffix(url, "/mcp") + "/healthz")
+	if err != nil {
+		t.Fatalf("GET /healthz: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestEnvToolReportsEnvironment(t *testing.T) {
+	url, srv := startTestServerWithNamedCommands(t, t.TempDir(), []string{"gh", "git"}, map[string]string{"test": "go test ./..."})
+	srv.SetEnvironment(func() Environment {
+		return Environment{Branch: "feat", Runtimes: []string{"go"}, ServeURL: "http://feat.app.dev.localhost"}
+	})
+
+	result := sendMCPRequest(t, url, "tools/call", map[string]any{
+		"name":      "cbox_env",
+		"arguments": map[string]any{},
+	})
+
+	var env Environment
+	if err := json.Unmarshal([]byte(extractTextContent(t, result)), &env); err != nil {
+		t.Fatalf("cbox_env did not return JSON: %v", err)
+	}
+	if env.Branch != "feat" || env.ServeURL != "http://feat.app.dev.localhost" || env.Workspace != "/workspace" {
+		t.Errorf("unexpected environment: %+v", env)
+	}
+	if strings.Join(env.HostCommands, ",") != "gh,git" {
+		t.Errorf("host commands = %v, want [gh git]", env.HostCommands)
+	}
+	if env.Commands["cbox_test"] != "go test ./..." {
+		t.Errorf("commands = %v, want cbox_test entry", env.Commands)
+	}
+}
+
+func TestArtifactPath(t *testing.T) {
+	srv := NewServer("/host/project", nil, nil)
+
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"/workspace/coverage/index.html", "coverage/index.html", false},
+		{"shots/home.png", "shots/home.png", false},
+		{"/host/project/out.txt", "out.txt", false},
+		{"../secrets.txt", "", true},
+		{"/workspace/../etc/passwd", "", true},
+		{"/etc/passwd", "", true},
+		{"/workspace", "", true},
+	}
+	for _, tt := range tests {
+		got, err := srv.artifactPath(tt.input)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("artifactPath(%q) = (%q, %v), want (%q, err=%v)", tt.input, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestReportStoresArtifacts(t *testing.T) {
+	reportDir := t.TempDir()
+	srv := NewServer(t.TempDir(), []string{"echo"}, nil)
+	srv.SetReportDir(reportDir)
+	port, err := srv.Start()
+	if err != nil {
+		t.Fatalf("start server: %v", err)
+	}
+	t.Cleanup(func() { srv.Stop() })
+	url := fmt.Sprintf("http://127.0.0.1:%d/mcp", port)
+	time.Sleep(50 * time.Millisecond)
+	initSession(t, url)
+
+	sendMCPRequest(t, url, "tools/call", map[string]any{
+		"name": "cbox_report",
+		"arguments": map[string]any{
+			"type":      "done",
+			"title":     "Coverage",
+			"body":      "See attached",
+			"artifacts": []string{"/workspace/coverage.html"},
+		},
+	})
+
+	data, err := os.ReadFile(filepath.Join(reportDir, "001-done.json"))
+	if err != nil {
+		t.Fatalf("reading report: %v", err)
+	}
+	var report Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Artifacts) != 1 || report.Artifacts[0] != "coverage.html" {
+		t.Errorf("artifacts = %v, want [coverage.html]", report.Artifacts)
+	}
+
+	result := sendMCPRequest(t, url, "tools/call", map[string]any{
+		"name": "cbox_report",
+		"arguments": map[string]any{
+			"type":      "done",
+			"title":     "Bad",
+			"body":      "x",
+			"artifacts": []string{"/etc/passwd"},
+		},
+	})
+	if content := extractTextContent(t, result); !strings.Contains(content, "within the workspace") {
+		t.Errorf("expected rejection of artifact outside the workspace, got: %s", content)
+	}
+}
+
+func TestMaxConcurrentSerializesCommands(t *testing.T) {
+	dir := t.TempDir()
+	// mkdir fails if another command is still running, so any overlap
+	// shows up as a non-zero exit code.
+	expr := "mkdir running && sleep 0.1 && rmdir running"
+	srv := NewServer(dir, nil, map[string]string{"one": expr})
+	srv.SetMaxConcurrent(1)
+	handler := srv.makeNamedCommandHandler("one", expr)
+
+	const calls = 4
+	results := make(chan string, calls)
+	var wg sync.WaitGroup
+	for range calls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := handler(context.Background(), mcp.CallToolRequest{})
+			if err != nil {
+				results <- err.Error()
+				return
+			}
+			results <- result.Content[0].(mcp.TextContent).Text
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	n := 0
+	for content := range results {
+		n++
+		if !strings.HasPrefix(content, "exit_code: 0") {
+			t.Errorf("command overlapped another or failed: %s", content)
+		}
+	}
+	if n != calls {
+		t.Errorf("got %d results, want %d", n, calls)
+	}
+}
+
+func TestMaxConcurrentGivesUpWhenCancelled(t *testing.T) {
+	srv := NewServer(t.TempDir(), nil, nil)
+	srv.SetMaxConcurrent(1)
+	release, err := srv.acquireSlot(context.Background(), mcp.CallToolRequest{})
+	if err != nil {
+		t.Fatalf("acquireSlot: %v", err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := srv.acquireSlot(ctx, mcp.CallToolRequest{}); err == nil {
+		t.Error("acquireSlot succeeded while the only slot was taken")
+	}
+}
+
+func TestContainerCommandRunsInContainer(t *testing.T) {
+	// A fake docker on PATH echoes its arguments, showing how the command
+	// was routed.
+	bin := t.TempDir()
+	script := "#!/bin/sh\necho \"docker $*\"\n"
+	if err := os.WriteFile(filepath.Join(bin, "docker"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	srv := NewServer(t.TempDir(), nil, map[string]string{
+		"test": "npm test",
+		"host": "echo on-host",
+	})
+	srv.SetContainerCommands("cbox-app-main", []string{"test"})
+
+	result, err := srv.makeNamedCommandHandler("test", "npm test")(context.Background(), mcp.CallToolRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := result.Content[0].(mcp.TextContent).Text
+	if !strings.Contains(content, "docker exec -u claude -w /workspace cbox-app-main timeout -k 10 120 sh -c npm test cbox-call-") {
+		t.Errorf("expected the command to run via docker exec, got: %s", content)
+	}
+
+	// Referenced variables are passed to the container by name.
+	expr := "npm publish --token ${NPM_TOKEN}"
+	result, err = srv.makeNamedCommandHandler("test", expr)(context.Background(), mcp.CallToolRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content = result.Content[0].(mcp.TextContent).Text
+	if !strings.Contains(content, "docker exec -u claude -w /workspace -e NPM_TOKEN cbox-app-main timeout -k 10 120 sh -c "+expr) {
+		t.Errorf("expected NPM_TOKEN passed by name, got: %s", content)
+	}
+
+	result, err = srv.makeNamedCommandHandler("host", "echo on-host")(context.Background(), mcp.CallToolRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content := result.Content[0].(mcp.TextContent).Text; !strings.Contains(content, "stdout:\non-host") {
+		t.Errorf("expected the host command to run on the host, got: %s", content)
+	}
+}
+
+func TestContainerCommandKilledInContainer(t *testing.T) {
+	// A fake docker records each call; the command itself hangs, as a
+	// docker exec would while the container runs it.
+	bin := t.TempDir()
+	calls := filepath.Join(t.TempDir(), "calls")
+	script := "#!/bin/sh\necho \"$*\" >> " + calls + "\ncase \"$*\" in *timeout*) exec sleep 5 ;; esac\n"
+	if err := os.WriteFile(filepath.Join(bin, "docker"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	srv := NewServer(t.TempDir(), nil, map[string]string{"test": "npm test"})
+	srv.SetContainerCommands("cbox-app-main", []string{"test"})
+	srv.SetCommandTimeout(100 * time.Millisecond)
+
+	result, err := srv.makeNamedCommandHandler("test", "npm test")(context.Background(), mcp.CallToolRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content := result.Content[0].(mcp.TextContent).Text; !strings.Contains(content, "timed out after 100ms") {
+		t.Errorf("expected a timeout, got: %s", content)
+	}
+
+	data, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	command, kill, _ := strings.Cut(strings.TrimSpace(string(data)), "\n")
+	if !strings.Contains(command, "cbox-app-main timeout -k 10 0.1 sh -c npm test") {
+		t.Errorf("expected the command bounded by timeout in the container, got: %s", command)
+	}
+	fields := strings.Fields(command)
+	marker := fields[len(fields)-1]
+	if !strings.HasPrefix(kill, "exec -u claude cbox-app-main sh -c") || !strings.HasSuffix(kill, " "+marker) {
+		t.Errorf("expected a kill of %s inside the container, got:\n%s", marker, kill)
+	}
+}
+
+func TestNamedCommandTimeout(t *testing.T) {
+	dir := t.TempDir()
+	commands := map[string]string{"slow": "exec sleep 5", "fast": "exec sleep 5"}
+	srv := NewServer(dir, nil, commands)
+	srv.SetCommandTimeout(50 * time.Millisecond)
+	srv.SetCommandTimeouts(map[string]time.Duration{"slow": 100 * time.Millisecond})
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"slow", "timed out after 100ms (raise command_timeouts.slow"},
+		{"fast", "timed out after 50ms (raise command_timeout "},
+	}
+	for _, tt := range tests {
+		result, err := srv.makeNamedCommandHandler(tt.name, commands[tt.name])(context.Background(), mcp.CallToolRequest{})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if content := result.Content[0].(mcp.TextContent).Text; !strings.Contains(content, tt.want) {
+			t.Errorf("%s: got %q, want it to contain %q", tt.name, content, tt.want)
+		}
+	}
+}
+
+func TestNamedCommandStreamsLog(t *testing.T) {
+	logDir := t.TempDir()
+	expr := "echo started; sleep 1; echo finished"
+	srv := NewServer(t.TempDir(), nil, map[string]string{"build": expr})
+	srv.SetLogDir(logDir)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.makeNamedCommandHandler("build", expr)(context.Background(), mcp.CallToolRequest{})
+	}()
+
+	logPath := filepath.Join(logDir, "build.log")
+	deadline := time.Now().Add(time.Second)
+	for {
+		data, _ := os.ReadFile(logPath)
+		if strings.Contains(string(data), "started") {
+			break
+		}
+		select {
+		case <-done:
+			t.Fatal("command finished before its output reached the log")
+		default:
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("log not written while the command ran: %q", data)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	<-done
+	if data, _ := os.ReadFile(logPath); !strings.Contains(string(data), "started\nfinished\n") {
+		t.Errorf("log = %q, want the full output", data)
+	}
+}
+
+func TestCommandOutputProgress(t *testing.T) {
+	var out commandOutput
+	w := &streamWriter{o: &out, buf: &out.stdout}
+	w.Write([]byte("compiling a\ncompil"))
+	w.Write([]byte("ing b\npartial"))
+
+	n, line := out.progress()
+	if n != 31 || line != "compiling b" {
+		t.Errorf("progress() = %d, %q, want 31, %q", n, line, "compiling b")
+	}
+}
+
+func TestTailBufferIsBounded(t *testing.T) {
+	var b tailBuffer
+	for i := 1; i <= 1000; i++ {
+		b.write([]byte(fmt.Sprintf("%d\n", i)))
+	}
+	b.write(bytes.Repeat([]byte("x"), maxLineBytes+10))
+	if len(b.lines) != maxTailLines || len(b.partial) != maxLineBytes {
+		t.Fatalf("kept %d lines and a %d-byte partial line, want %d and %d", len(b.lines), len(b.partial), maxTailLines, maxLineBytes)
+	}
+
+	text, lines, size := b.tail(2)
+	if want := "1000\n" + strings.Repeat("x", maxLineBytes) + " [10 more bytes]"; text != want {
+		t.Errorf("tail(2) = %q..., want it to start with 1000 and end with the cut note", text[:10])
+	}
+	// Lines 1 to 999 are cut: 9*2 + 90*3 + 900*4 bytes.
+	if lines != 999 || size != 3888 {
+		t.Errorf("tail(2) cut %d lines (%d bytes), want 999 (3888 bytes)", lines, size)
+	}
+}
+
+func TestRunCommandTruncatesAndSavesLog(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   string
+		omitted  string
+		first    string
+		exitCode string
+	}{
+		{"success", "seq 1 100", "[80 earlier lines (231 bytes) omitted]\n81\n", "\n80\n", "exit_code: 0"},
+		{"failure", "seq 1 100; exit 3", "[60 earlier lines (171 bytes) omitted]\n61\n", "\n60\n", "exit_code: 3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			url, srv := startTestServer(t, dir, []string{"sh"})
+
+			content := extractTextContent(t, callTool(t, url, map[string]any{
+				"command": "sh",
+				"args":    []string{"-c", tt.script},
+			}))
+			if !strings.HasPrefix(content, tt.exitCode) {
+				t.Errorf("expected %s, got: %s", tt.exitCode, content)
+			}
+			if !strings.Contains(content, "stdout:\n"+tt.omitted) || !strings.Contains(content, "\n100\n") {
+				t.Errorf("expected the tail of stdout after %q, got: %s", tt.omitted, content)
+			}
+			if strings.Contains(content, tt.first) {
+				t.Errorf("expected earlier lines to be cut, got: %s", content)
+			}
+
+			_, logPath, found := strings.Cut(content, "full log: ")
+			logPath = strings.TrimSpace(logPath)
+			if !found || !strings.HasPrefix(logPath, "/workspace/.cbox/logs/run_command/") || !strings.HasSuffix(logPath, "-sh.log") {
+				t.Fatalf("expected a full log pointer under /workspace/.cbox/logs/run_command, got: %s", content)
+			}
+			data, err := os.ReadFile(srv.translatePath(logPath))
+			if err != nil {
+				t.Fatalf("reading full log: %v", err)
+			}
+			if lines := strings.Count(string(data), "\n"); lines != 100 {
+				t.Errorf("full log has %d lines, want 100", lines)
+			}
+		})
+	}
+}
+
+func TestRunCommandSavesLogUnderReportDir(t *testing.T) {
+	worktree := t.TempDir()
+	srv := NewServer(worktree, []string{"seq"}, nil)
+	srv.SetReportDir(filepath.Join(worktree, "reports"))
+	port, err := srv.Start()
+	if err != nil {
+		t.Fatalf("start server: %v", err)
+	}
+	t.Cleanup(func() { srv.Stop() })
+	url := fmt.Sprintf("http://127.0.0.1:%d/mcp", port)
+	time.Sleep(50 * time.Millisecond)
+	initSession(t, url)
+
+	content := extractTextContent(t, callTool(t, url, map[string]any{
+		"command": "seq",
+		"args":    []string{"1", "100"},
+	}))
+	_, logPath, _ := strings.Cut(content, "full log: ")
+	logPath = strings.TrimSpace(logPath)
+	if !strings.HasPrefix(logPath, "/workspace/reports/run_command/") {
+		t.Fatalf("expected a full log pointer under the report dir, got: %s", content)
+	}
+	if _, err := os.Stat(srv.translatePath(logPath)); err != nil {
+		t.Errorf("full log not saved: %v", err)
+	}
+}
+
+func TestPruneLogs(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"20260101-120000.000-go.log", "20260101-120001.000-go.log", "20260101-120002.000-sh.log"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pruneLogs(dir, 2)
+	logs, _ := filepath.Glob(filepath.Join(dir, "*.log"))
+	if len(logs) != 2 || filepath.Base(logs[0]) != "20260101-120001.000-go.log" {
+		t.Errorf("after pruning: %v, want the two newest", logs)
+	}
+}
+
+func TestLogLocation(t *testing.T) {
+	srv := &Server{worktreePath: "/src/app--feat"}
+	if got := srv.logLocation("/src/app--feat/.cbox/logs/build.log"); got != "/workspace/.cbox/logs/build.log" {
+		t.Errorf("log in the worktree: %q", got)
+	}
+	if got := srv.logLocation("/src/app/.cbox/logs/feat/build.log"); !strings.Contains(got, "host path") {
+		t.Errorf("log outside the worktree not marked host-only: %q", got)
+	}
+}
+
+func TestRunCommandShortOutputHasNoLogPointer(t *testing.T) {
+	url, _ := startTestServer(t, t.TempDir(), []string{"echo"})
+	content := extractTextContent(t, callTool(t, url, map[string]any{
+		"command": "echo",
+		"args":    []string{"hello"},
+	}))
+	if strings.Contains(content, "full log:") || strings.Contains(content, "omitted") {
+		t.Errorf("untruncated output should not point at the log, got: %s", content)
+	}
+}
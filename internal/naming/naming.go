@@ -0,0 +1,52 @@
+// Package naming derives the filesystem, Docker and DNS-safe names cbox uses
+// for a branch. Every place that turns a branch into a name (worktree paths,
+// containers, networks, state files, serve subdomains) goes through SafeBranch
+// so the schemes never drift apart.
+package naming
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// MaxSafeBranchLen caps the length of a safe branch name. It keeps container
+// names manageable and leaves the serve subdomain well within the 63-byte
+// DNS label limit.
+const MaxSafeBranchLen = 48
+
+// hashLen is the number of hex characters used to disambiguate truncated names.
+const hashLen = 8
+
+// SafeBranch converts a branch name into a name usable in paths, Docker
+// resource names and hostnames. Slashes become "-", and any other character
+// outside [A-Za-z0-9_.-] is replaced with "-". Names longer than
+// MaxSafeBranchLen are truncated and given a short hash of the full branch
+// name so distinct long branches stay distinct.
+func SafeBranch(branch string) string {
+	var b strings.Builder
+	for _, r := range branch {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '.', r == '-':
+			b.WriteRune(r)
+		default:
+			b.WriteByte('-')
+		}
+	}
+	safe := b.String()
+	if len(safe) <= MaxSafeBranchLen {
+		return safe
+	}
+
+	sum := sha256.Sum256([]byte(branch))
+	suffix := hex.EncodeToString(sum[:])[:hashLen]
+	prefix := strings.TrimRight(safe[:MaxSafeBranchLen-hashLen-1], "-")
+	return prefix + "-" + suffix
+}
+
+// LegacySafeBranch is the name scheme cbox used before SafeBranch, which
+// only replaced slashes. It is used to find sandboxes created with it; it
+// differs from SafeBranch only for long names and unusual characters.
+func LegacySafeBranch(branch string) string {
+	return strings.ReplaceAll(branch, "/", "-")
+}
@@ -0,0 +1,40 @@
+package naming
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSafeBranch(t *testing.T) {
+	tests := []struct {
+		branch string
+		want   string
+	}{
+		{"main", "main"},
+		{"feature/auth", "feature-auth"},
+		{"feature/team/thing", "feature-team-thing"},
+		{"fix_1.2", "fix_1.2"},
+		{"user@host+tag", "user-host-tag"},
+	}
+	for _, tt := range tests {
+		if got := SafeBranch(tt.branch); got != tt.want {
+			t.Errorf("SafeBranch(%q) = %q, want %q", tt.branch, got, tt.want)
+		}
+	}
+}
+
+func TestSafeBranch_LongNamesAreCappedAndDistinct(t *testing.T) {
+	base := "feature/team/" + strings.Repeat("very-long-branch-name-", 4)
+	a := SafeBranch(base + "one")
+	b := SafeBranch(base + "two")
+
+	if len(a) > MaxSafeBranchLen || len(b) > MaxSafeBranchLen {
+		t.Fatalf("lengths %d and %d exceed cap %d", len(a), len(b), MaxSafeBranchLen)
+	}
+	if a == b {
+		t.Errorf("long branches collided: %q", a)
+	}
+	if SafeBranch(base+"one") != a {
+		t.Error("SafeBranch is not deterministic")
+	}
+}
@@ -23,6 +23,15 @@ type ToolUseBlock struct {
 
 func (b ToolUseBlock) BlockType() string { return "tool_use" }
 
+// ThinkingBlock represents a thinking (extended reasoning) content block
+// from Claude. It is rendered dimmed and collapsed so it stands apart from
+// the final answer text.
+type ThinkingBlock struct {
+	Thinking string
+}
+
+func (b ThinkingBlock) BlockType() string { return "thinking" }
+
 // ProgressBlock represents a cbox operational progress message.
 type ProgressBlock struct {
 	Message string
@@ -0,0 +1,42 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// machine is set when a command writes machine-readable data to stdout.
+var machine bool
+
+// SetMachine turns machine mode on or off. In machine mode stdout carries
+// only the data written with JSON: progress, success and text messages go
+// undecorated to ErrWriter(), and spinners print a plain line there
+// instead of animating.
+func SetMachine(on bool) {
+	machine = on
+}
+
+// Machine reports whether machine mode is on.
+func Machine() bool {
+	return machine
+}
+
+// JSON writes v to Writer() as indented JSON.
+func JSON(v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling JSON: %w", err)
+	}
+	_, err = fmt.Fprintf(Writer(), "%s\n", data)
+	return err
+}
+
+// message writes a progress, success or text message: styled to Writer(),
+// or in machine mode as plain text to ErrWriter().
+func message(b Block, text string) {
+	if machine {
+		fmt.Fprintln(ErrWriter(), text)
+		return
+	}
+	RenderBlock(Writer(), b)
+}
@@ -7,11 +7,12 @@ import (
 
 // rawBlock is used to partially decode Claude's JSON content blocks.
 type rawBlock struct {
-	Type  string          `json:"type"`
-	Text  string          `json:"text"`
-	ID    string          `json:"id"`
-	Name  string          `json:"name"`
-	Input json.RawMessage `json:"input"`
+	Type     string          `json:"type"`
+	Text     string          `json:"text"`
+	Thinking string          `json:"thinking"`
+	ID       string          `json:"id"`
+	Name     string          `json:"name"`
+	Input    json.RawMessage `json:"input"`
 }
 
 // ParseClaudeBlocks parses the JSON content block array from Claude's
@@ -28,6 +29,8 @@ func ParseClaudeBlocks(data []byte) ([]Block, error) {
 		switch r.Type {
 		case "text":
 			blocks = append(blocks, TextBlock{Text: r.Text})
+		case "thinking":
+			blocks = append(blocks, ThinkingBlock{Thinking: r.Thinking})
 		case "tool_use":
 			blocks = append(blocks, ToolUseBlock{
 				ID:    r.ID,
@@ -6,9 +6,14 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
 	"sync"
 
 	"github.com/charmbracelet/lipgloss/v2"
+	"github.com/charmbracelet/x/ansi"
+	"github.com/charmbracelet/x/term"
+
+	"github.com/richvanbergen/cbox/internal/redact"
 )
 
 var (
@@ -20,29 +25,46 @@ var (
 	toolHeader = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("4"))
 	toolBorder = lipgloss.NewStyle().
 			BorderLeft(true).
-			BorderStyle(lipgloss.NormalBorder()).
 			BorderForeground(lipgloss.Color("4")).
 			PaddingLeft(1)
 	toolInput = lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
 
 	cmdBorder = lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
+
+	thinkingStyle = lipgloss.NewStyle().Faint(true).Italic(true)
 )
 
+// RenderOptions configures optional rendering behavior.
+type RenderOptions struct {
+	ShowThinking bool // Render thinking blocks in full instead of collapsed
+}
+
 // Render writes all blocks to w in order, with a blank line between blocks.
 func Render(w io.Writer, blocks []Block) {
+	RenderWithOptions(w, blocks, RenderOptions{})
+}
+
+// RenderWithOptions is Render with explicit options.
+func RenderWithOptions(w io.Writer, blocks []Block, opts RenderOptions) {
 	for i, b := range blocks {
 		if i > 0 {
 			fmt.Fprintln(w)
 		}
-		RenderBlock(w, b)
+		renderBlock(w, b, opts)
 	}
 }
 
 // RenderBlock writes a single block to w.
 func RenderBlock(w io.Writer, b Block) {
+	renderBlock(w, b, RenderOptions{})
+}
+
+func renderBlock(w io.Writer, b Block, opts RenderOptions) {
 	switch v := b.(type) {
 	case TextBlock:
 		fmt.Fprintln(w, v.Text)
+	case ThinkingBlock:
+		fmt.Fprintln(w, thinkingStyle.Render(thinkingText(v.Thinking, opts.ShowThinking)))
 	case ToolUseBlock:
 		header := toolHeader.Render(v.Name) + " " + v.ID
 		var body string
@@ -58,59 +80,189 @@ func RenderBlock(w io.Writer, b Block) {
 		} else {
 			content = header
 		}
-		fmt.Fprintln(w, toolBorder.Render(content))
+		fmt.Fprintln(w, toolBorder.BorderStyle(glyphs().Tool).Render(content))
 	case ProgressBlock:
-		fmt.Fprintln(w, progressPrefix.Render("›")+" "+v.Message)
+		fmt.Fprintln(w, progressPrefix.Render(glyphs().Progress)+" "+v.Message)
 	case SuccessBlock:
-		fmt.Fprintln(w, successPrefix.Render("✓")+" "+v.Message)
+		fmt.Fprintln(w, successPrefix.Render(glyphs().Success)+" "+v.Message)
 	case WarningBlock:
-		fmt.Fprintln(w, warningPrefix.Render("!")+" "+v.Message)
+		fmt.Fprintln(w, warningPrefix.Render(glyphs().Warning)+" "+v.Message)
 	case ErrorBlock:
-		fmt.Fprintln(w, errorPrefix.Render("✗")+" "+v.Message)
+		fmt.Fprintln(w, errorPrefix.Render(glyphs().Error)+" "+v.Message)
+	}
+}
+
+// thinkingText labels thinking content. Unless full is set it is collapsed
+// to its first line with a count of the lines hidden.
+func thinkingText(text string, full bool) string {
+	text = strings.TrimSpace(text)
+	if full {
+		return "Thinking: " + text
+	}
+	first, rest, found := strings.Cut(text, "\n")
+	if !found {
+		return "Thinking: " + first
 	}
+	hidden := strings.Count(rest, "\n") + 1
+	return fmt.Sprintf("Thinking: %s %s (%d more lines)", first, glyphs().Ellipsis, hidden)
 }
 
-// Progress writes a styled progress message to stdout.
+// stdout receives progress, success and text messages, spinners, and the
+// framed output of the commands cbox runs along the way (docker run, docker
+// build). nil means os.Stdout, looked up on each write.
+var stdout io.Writer
+
+// stderr receives warnings and errors, so they stay out of data written to
+// stdout (e.g. JSON piped into another tool). nil means os.Stderr.
+var stderr io.Writer
+
+// Writer returns where cbox's own output goes: os.Stdout unless changed
+// with SetOutput.
+func Writer() io.Writer {
+	if stdout == nil {
+		return os.Stdout
+	}
+	return stdout
+}
+
+// Interactive reports whether Writer() is a terminal outside machine mode,
+// where spinners animate and can stand in for streamed command output.
+func Interactive() bool {
+	return !machine && isTerminal(Writer())
+}
+
+// SetOutput sends cbox's own output to w, e.g. os.Stderr, a log file or
+// io.Discard, and returns the previous writer. nil restores os.Stdout.
+// Warnings and errors are redirected separately with SetErrOutput.
+func SetOutput(w io.Writer) io.Writer {
+	prev := stdout
+	stdout = w
+	return prev
+}
+
+// ErrWriter returns where warnings and errors go: os.Stderr unless changed
+// with SetErrOutput.
+func ErrWriter() io.Writer {
+	if stderr == nil {
+		return os.Stderr
+	}
+	return stderr
+}
+
+// SetErrOutput sends warnings and errors to w and returns the previous
+// writer. nil restores os.Stderr.
+func SetErrOutput(w io.Writer) io.Writer {
+	prev := stderr
+	stderr = w
+	return prev
+}
+
+// formatMessage formats a message for display, masking registered secrets.
+func formatMessage(format string, args ...any) string {
+	return redact.String(fmt.Sprintf(format, args...))
+}
+
+// Progress writes a styled progress message to Writer().
 func Progress(format string, args ...any) {
-	RenderBlock(os.Stdout, ProgressBlock{Message: fmt.Sprintf(format, args...)})
+	msg := formatMessage(format, args...)
+	message(ProgressBlock{Message: msg}, msg)
 }
 
-// Success writes a styled success message to stdout.
+// Success writes a styled success message to Writer().
 func Success(format string, args ...any) {
-	RenderBlock(os.Stdout, SuccessBlock{Message: fmt.Sprintf(format, args...)})
+	msg := formatMessage(format, args...)
+	message(SuccessBlock{Message: msg}, msg)
 }
 
-// Warning writes a styled warning message to stdout.
+// Warning writes a styled warning message to ErrWriter().
 func Warning(format string, args ...any) {
-	RenderBlock(os.Stdout, WarningBlock{Message: fmt.Sprintf(format, args...)})
+	RenderBlock(ErrWriter(), WarningBlock{Message: formatMessage(format, args...)})
 }
 
-// Error writes a styled error message to stdout.
+// Error writes a styled error message to ErrWriter().
 func Error(format string, args ...any) {
-	RenderBlock(os.Stdout, ErrorBlock{Message: fmt.Sprintf(format, args...)})
+	RenderBlock(ErrWriter(), ErrorBlock{Message: formatMessage(format, args...)})
 }
 
-// Text writes a styled text message to stdout.
+// Text writes a styled text message to Writer().
 func Text(format string, args ...any) {
-	RenderBlock(os.Stdout, TextBlock{Text: fmt.Sprintf(format, args...)})
+	msg := formatMessage(format, args...)
+	message(TextBlock{Text: msg}, msg)
 }
 
-// CommandWriter wraps an io.Writer and prepends a dim "│ " border to each
-// line of output. It is used to visually frame third-party command output
-// (e.g. docker run) so it's easy to distinguish from cbox messages.
+// CommandWriter wraps an io.Writer and prepends a dim "│ " border (in the
+// active Theme) to each line of output. It is used to visually frame
+// third-party command output (e.g. docker run) so it's easy to distinguish
+// from cbox messages.
 //
 // For commands with interactive terminal output (e.g. docker build), connect
 // cmd.Stdout/cmd.Stderr directly to os.Stdout/os.Stderr to preserve TTY.
 type CommandWriter struct {
-	w     io.Writer
-	buf   []byte
-	once  sync.Once
-	wrote bool
+	w        io.Writer
+	log      io.Writer
+	maxWidth int
+	buf      []byte
+	once     sync.Once
+	wrote    bool
+
+	// Live mode state: the content of the line currently on screen, and
+	// whether anything has been drawn for it yet.
+	live  bool
+	line  []byte
+	drawn bool
+}
+
+// CommandWriterOptions configures optional CommandWriter behavior.
+type CommandWriterOptions struct {
+	// Live renders \r-based in-place updates (progress bars) by redrawing
+	// the current line behind the border, and drops cursor-movement escapes
+	// that would otherwise break out of the frame. Use it for terminals.
+	Live bool
+	// MaxWidth truncates displayed lines, border included, to this many
+	// columns and appends "…". Zero disables truncation.
+	MaxWidth int
+	// Log, if set, receives the full untruncated output as written.
+	Log io.Writer
 }
 
 // NewCommandWriter returns a CommandWriter that writes bordered lines to w.
+// When w is a terminal, lines are truncated to its width so a single huge
+// line (minified JS, a base64 blob) can't flood the screen.
 func NewCommandWriter(w io.Writer) *CommandWriter {
-	return &CommandWriter{w: w}
+	return NewCommandWriterWithOptions(w, CommandWriterOptions{MaxWidth: terminalWidth(w)})
+}
+
+// NewLiveCommandWriter returns a live-mode CommandWriter without truncation.
+func NewLiveCommandWriter(w io.Writer) *CommandWriter {
+	return NewCommandWriterWithOptions(w, CommandWriterOptions{Live: true})
+}
+
+// NewCommandWriterWithOptions returns a CommandWriter with explicit options.
+func NewCommandWriterWithOptions(w io.Writer, opts CommandWriterOptions) *CommandWriter {
+	return &CommandWriter{w: w, live: opts.Live, maxWidth: opts.MaxWidth, log: opts.Log}
+}
+
+// terminalWidth returns the width of w in columns if it is a terminal, or 0.
+func terminalWidth(w io.Writer) int {
+	if !isTerminal(w) {
+		return 0
+	}
+	width, _, err := term.GetSize(w.(*os.File).Fd())
+	if err != nil {
+		return 0
+	}
+	return width
+}
+
+// truncate masks registered secrets in a line and shortens its content so
+// the bordered line fits maxWidth. Every displayed line goes through it.
+func (cw *CommandWriter) truncate(line string) string {
+	line = redact.String(line)
+	if cw.maxWidth <= 0 {
+		return line
+	}
+	// Leave room for the border and its trailing space.
+	return ansi.Truncate(line, max(cw.maxWidth-ansi.StringWidth(glyphs().Border)-1, 1), glyphs().Ellipsis)
 }
 
 func (cw *CommandWriter) Write(p []byte) (int, error) {
@@ -119,7 +271,15 @@ func (cw *CommandWriter) Write(p []byte) (int, error) {
 		cw.wrote = true
 	})
 
+	if cw.log != nil {
+		cw.log.Write(p)
+	}
+
 	cw.buf = append(cw.buf, p...)
+	if cw.live {
+		cw.writeLive()
+		return len(p), nil
+	}
 	for {
 		idx := bytes.IndexByte(cw.buf, '\n')
 		if idx < 0 {
@@ -127,22 +287,115 @@ func (cw *CommandWriter) Write(p []byte) (int, error) {
 		}
 		line := cw.buf[:idx]
 		cw.buf = cw.buf[idx+1:]
-		prefix := cmdBorder.Render("│") + " "
-		fmt.Fprintln(cw.w, prefix+string(line))
+		prefix := cmdBorder.Render(glyphs().Border) + " "
+		fmt.Fprintln(cw.w, prefix+cw.truncate(string(line)))
 	}
 	return len(p), nil
 }
 
+// writeLive consumes cw.buf, applying carriage returns and escape sequences
+// to the current line, then redraws it. A trailing \r or partial escape
+// sequence stays buffered until the next Write completes it.
+func (cw *CommandWriter) writeLive() {
+	i := 0
+loop:
+	for i < len(cw.buf) {
+		c := cw.buf[i]
+		switch c {
+		case '\n':
+			cw.redraw()
+			fmt.Fprintln(cw.w)
+			cw.line = cw.line[:0]
+			cw.drawn = false
+			i++
+		case '\r':
+			if i+1 == len(cw.buf) {
+				break loop // might be the first half of \r\n
+			}
+			if cw.buf[i+1] != '\n' {
+				cw.line = cw.line[:0]
+			}
+			i++
+		case 0x1b:
+			n := escapeLen(cw.buf[i:])
+			if n == 0 {
+				break loop // incomplete sequence
+			}
+			cw.applyEscape(cw.buf[i : i+n])
+			i += n
+		default:
+			cw.line = append(cw.line, c)
+			i++
+		}
+	}
+	cw.buf = cw.buf[i:]
+	if len(cw.line) > 0 || cw.drawn {
+		cw.redraw()
+	}
+}
+
+// escapeLen returns the length of the escape sequence at the start of seq,
+// or 0 if it is incomplete.
+func escapeLen(seq []byte) int {
+	if len(seq) < 2 {
+		return 0
+	}
+	if seq[1] != '[' {
+		return 2 // two-byte escape such as ESC 7 (save cursor)
+	}
+	for j := 2; j < len(seq); j++ {
+		if seq[j] >= 0x40 && seq[j] <= 0x7e {
+			return j + 1
+		}
+	}
+	return 0
+}
+
+// applyEscape keeps colour (SGR) sequences, treats erase-line and
+// move-to-column-1 as clearing the current line, and drops every other
+// sequence, since cursor movement can't be honoured inside the frame.
+func (cw *CommandWriter) applyEscape(seq []byte) {
+	if len(seq) < 3 || seq[1] != '[' {
+		return
+	}
+	params := string(seq[2 : len(seq)-1])
+	switch seq[len(seq)-1] {
+	case 'm':
+		cw.line = append(cw.line, seq...)
+	case 'K':
+		if params == "1" || params == "2" {
+			cw.line = cw.line[:0]
+		}
+	case 'G':
+		if params == "" || params == "0" || params == "1" {
+			cw.line = cw.line[:0]
+		}
+	}
+}
+
+// redraw clears the terminal line and draws the border and current content.
+func (cw *CommandWriter) redraw() {
+	prefix := cmdBorder.Render(glyphs().Border) + " "
+	fmt.Fprintf(cw.w, "\r\033[2K%s%s", prefix, cw.truncate(string(cw.line)))
+	cw.drawn = true
+}
+
 // Close flushes any remaining buffered content and adds a trailing blank line
 // to visually separate command output from subsequent messages.
 func (cw *CommandWriter) Close() {
-	if len(cw.buf) > 0 {
-		prefix := cmdBorder.Render("│") + " "
-		fmt.Fprintln(cw.w, prefix+string(cw.buf))
+	if cw.live {
+		// A buffered lone \r or partial escape can't be completed now.
+		cw.buf = nil
+		if len(cw.line) > 0 || cw.drawn {
+			cw.redraw()
+			fmt.Fprintln(cw.w)
+		}
+	} else if len(cw.buf) > 0 {
+		prefix := cmdBorder.Render(glyphs().Border) + " "
+		fmt.Fprintln(cw.w, prefix+cw.truncate(string(cw.buf)))
 		cw.buf = nil
 	}
 	if cw.wrote {
 		fmt.Fprintln(cw.w)
 	}
 }
-
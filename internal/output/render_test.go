@@ -182,17 +182,11 @@ func TestConvenienceFunctions(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			r, w, _ := os.Pipe()
-			old := os.Stdout
-			os.Stdout = w
-
-			tt.call()
-
-			w.Close()
-			os.Stdout = old
-
 			var buf bytes.Buffer
-			buf.ReadFrom(r)
+			prev, prevErr := SetOutput(&buf), SetErrOutput(&buf)
+			tt.call()
+			SetOutput(prev)
+			SetErrOutput(prevErr)
 			got := buf.String()
 
 			if tt.prefix != "" && !strings.Contains(got, tt.prefix) {
@@ -286,3 +280,220 @@ func TestCommandWriterCloseWithoutWrite(t *testing.T) {
 	}
 }
 
+// liveScreen simulates what a terminal shows for live CommandWriter output:
+// "\r\033[2K" clears the current line, "\n" starts a new one. The border is
+// replaced with "|" and the leading blank separator is dropped.
+func liveScreen(out string) []string {
+	out = strings.ReplaceAll(out, cmdBorder.Render("│"), "|")
+	var lines []string
+	for _, l := range strings.Split(out, "\n") {
+		if i := strings.LastIndex(l, "\r\033[2K"); i >= 0 {
+			l = l[i+len("\r\033[2K"):]
+		}
+		lines = append(lines, l)
+	}
+	return lines
+}
+
+func TestLiveCommandWriterCarriageReturn(t *testing.T) {
+	var buf bytes.Buffer
+	cw := NewLiveCommandWriter(&buf)
+	cw.Write([]byte("Pulling\n"))
+	cw.Write([]byte("\r 10%"))
+	cw.Write([]byte("\r 50%"))
+	cw.Write([]byte("\r100%\ndone\n"))
+	cw.Close()
+
+	got := liveScreen(buf.String())
+	want := []string{"", "| Pulling", "| 100%", "| done", "", ""}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("screen = %q, want %q", got, want)
+	}
+}
+
+func TestLiveCommandWriterRedrawsPartialLine(t *testing.T) {
+	var buf bytes.Buffer
+	cw := NewLiveCommandWriter(&buf)
+	cw.Write([]byte("\r 42%"))
+
+	// The in-progress update is visible before any newline arrives.
+	if !strings.HasSuffix(buf.String(), "\r\033[2K"+cmdBorder.Render("│")+"  42%") {
+		t.Errorf("expected bordered redraw of partial line, got %q", buf.String())
+	}
+}
+
+func TestLiveCommandWriterCRLF(t *testing.T) {
+	var buf bytes.Buffer
+	cw := NewLiveCommandWriter(&buf)
+	// Split the \r\n across writes to exercise buffering of a trailing \r.
+	cw.Write([]byte("one\r"))
+	cw.Write([]byte("\ntwo\r\n"))
+	cw.Close()
+
+	got := liveScreen(buf.String())
+	want := []string{"", "| one", "| two", "", ""}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("screen = %q, want %q", got, want)
+	}
+}
+
+func TestLiveCommandWriterCursorEscapes(t *testing.T) {
+	var buf bytes.Buffer
+	cw := NewLiveCommandWriter(&buf)
+	// Cursor-up and save/restore are dropped, erase-line clears, colours stay.
+	cw.Write([]byte("\x1b[1Aold\x1b[2K\x1b[32mnew\x1b"))
+	cw.Write([]byte("[0m\x1b7\n"))
+	cw.Close()
+
+	out := buf.String()
+	if strings.Contains(out, "\x1b[1A") || strings.Contains(out, "\x1b7") {
+		t.Errorf("cursor movement escaped the frame: %q", out)
+	}
+	got := liveScreen(out)
+	if got[1] != "| \x1b[32mnew\x1b[0m" {
+		t.Errorf("line = %q, want coloured %q", got[1], "new")
+	}
+}
+
+func TestLiveCommandWriterColumnReset(t *testing.T) {
+	var buf bytes.Buffer
+	cw := NewLiveCommandWriter(&buf)
+	cw.Write([]byte("step 1\x1b[Gstep 2\x1b[0K\n"))
+	cw.Close()
+
+	got := liveScreen(buf.String())
+	if got[1] != "| step 2" {
+		t.Errorf("line = %q, want %q", got[1], "| step 2")
+	}
+}
+
+func TestCommandWriterTruncatesLongLines(t *testing.T) {
+	var buf, log bytes.Buffer
+	cw := NewCommandWriterWithOptions(&buf, CommandWriterOptions{MaxWidth: 20, Log: &log})
+	long := strings.Repeat("x", 10000)
+	cw.Write([]byte(long + "\nshort\n"))
+	cw.Close()
+
+	lines := strings.Split(strings.ReplaceAll(buf.String(), cmdBorder.Render("│"), "|"), "\n")
+	if lines[1] != "| "+strings.Repeat("x", 17)+"…" {
+		t.Errorf("long line = %q, want 20 columns ending in …", lines[1])
+	}
+	if lines[2] != "| short" {
+		t.Errorf("short line = %q, want it untouched", lines[2])
+	}
+	if log.String() != long+"\nshort\n" {
+		t.Errorf("log sink got %d bytes, want the full %d byte output", log.Len(), len(long)+7)
+	}
+}
+
+func TestCommandWriterNoTruncationByDefault(t *testing.T) {
+	var buf bytes.Buffer
+	cw := NewCommandWriter(&buf) // not a terminal, so no width cap
+	long := strings.Repeat("y", 5000)
+	cw.Write([]byte(long + "\n"))
+	cw.Close()
+
+	if !strings.Contains(buf.String(), long) || strings.Contains(buf.String(), "…") {
+		t.Error("expected the long line to pass through untruncated")
+	}
+}
+
+func TestParseClaudeBlocksThinkingRoundtrip(t *testing.T) {
+	input := `[
+		{"type":"thinking","thinking":"The user wants tests.\nFirst check the layout.\nThen write them.","signature":"abc"},
+		{"type":"text","text":"Here are the tests."}
+	]`
+	blocks, err := ParseClaudeBlocks([]byte(input))
+	if err != nil {
+		t.Fatalf("ParseClaudeBlocks: %v", err)
+	}
+	if len(blocks) != 2 {
+		t.Fatalf("expected 2 blocks, got %d", len(blocks))
+	}
+	thinking, ok := blocks[0].(ThinkingBlock)
+	if !ok {
+		t.Fatalf("block 0: got %T, want ThinkingBlock", blocks[0])
+	}
+	if !strings.HasPrefix(thinking.Thinking, "The user wants tests.") {
+		t.Errorf("thinking text = %q", thinking.Thinking)
+	}
+
+	// Collapsed by default: first line plus a count of hidden lines.
+	var buf bytes.Buffer
+	Render(&buf, blocks)
+	out := buf.String()
+	if !strings.Contains(out, "Thinking: The user wants tests.") || !strings.Contains(out, "(2 more lines)") {
+		t.Errorf("expected collapsed thinking, got %q", out)
+	}
+	if strings.Contains(out, "Then write them.") {
+		t.Errorf("collapsed thinking should hide later lines, got %q", out)
+	}
+	if !strings.Contains(out, "Here are the tests.") {
+		t.Errorf("missing answer text, got %q", out)
+	}
+
+	buf.Reset()
+	RenderWithOptions(&buf, blocks, RenderOptions{ShowThinking: true})
+	if !strings.Contains(buf.String(), "Then write them.") {
+		t.Errorf("ShowThinking should render the full block, got %q", buf.String())
+	}
+}
+
+func TestSetOutput(t *testing.T) {
+	var buf bytes.Buffer
+	prev := SetOutput(&buf)
+	Progress("quiet %d", 1)
+	SetOutput(prev)
+
+	if !strings.Contains(buf.String(), "quiet 1") {
+		t.Errorf("Progress wrote %q to the replacement writer", buf.String())
+	}
+	if Writer() != os.Stdout {
+		t.Error("Writer() should be os.Stdout after restoring")
+	}
+}
+
+func TestSetErrOutput(t *testing.T) {
+	var out, errOut bytes.Buffer
+	prev, prevErr := SetOutput(&out), SetErrOutput(&errOut)
+	Progress("working")
+	Warning("careful")
+	Error("failed")
+	SetOutput(prev)
+	SetErrOutput(prevErr)
+
+	if got := out.String(); !strings.Contains(got, "working") || strings.Contains(got, "careful") || strings.Contains(got, "failed") {
+		t.Errorf("stdout = %q, want only the progress message", got)
+	}
+	if got := errOut.String(); !strings.Contains(got, "careful") || !strings.Contains(got, "failed") {
+		t.Errorf("stderr = %q, want the warning and the error", got)
+	}
+	if ErrWriter() != os.Stderr {
+		t.Error("ErrWriter() should be os.Stderr after restoring")
+	}
+}
+
+func TestMachineMode(t *testing.T) {
+	var out, errOut bytes.Buffer
+	prev, prevErr := SetOutput(&out), SetErrOutput(&errOut)
+	SetMachine(true)
+	t.Cleanup(func() {
+		SetMachine(false)
+		SetOutput(prev)
+		SetErrOutput(prevErr)
+	})
+
+	Progress("working")
+	Spin("spinning", func() error { return nil })
+	if err := JSON(map[string]int{"port": 8080}); err != nil {
+		t.Fatalf("JSON() = %v", err)
+	}
+
+	var got map[string]int
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil || got["port"] != 8080 {
+		t.Errorf("stdout = %q, want only the JSON document (%v)", out.String(), err)
+	}
+	if got := errOut.String(); got != "working\nspinning\n" {
+		t.Errorf("stderr = %q, want plain messages", got)
+	}
+}
@@ -8,19 +8,22 @@ import (
 	"sync"
 	"syscall"
 	"time"
-)
 
-// spinnerFrames are the characters cycled through for the spinner animation.
-var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+	"github.com/charmbracelet/x/term"
+)
 
 // LineSpinner manages a set of lines where some have a spinning indicator
-// that updates in-place until resolved.
+// that updates in-place until resolved. When the output is not a terminal it
+// prints each line once, as soon as it and the lines above it are resolved,
+// without any escape sequences.
 type LineSpinner struct {
-	mu    sync.Mutex
-	w     io.Writer
-	lines []spinnerLine
-	done  chan struct{}
-	frame int
+	mu      sync.Mutex
+	w       io.Writer
+	tty     bool // animate in place; false prints plain lines
+	lines   []spinnerLine
+	printed int // lines already written in plain mode
+	done    chan struct{}
+	frame   int
 }
 
 type spinnerLine struct {
@@ -29,10 +32,11 @@ type spinnerLine struct {
 	resolved bool
 }
 
-// NewLineSpinner creates a spinner that writes to stdout.
+// NewLineSpinner creates a spinner that writes to Writer().
 func NewLineSpinner(count int) *LineSpinner {
 	return &LineSpinner{
-		w:     os.Stdout,
+		w:     spinnerWriter(),
+		tty:   !machine && isTerminal(Writer()),
 		lines: make([]spinnerLine, count),
 		done:  make(chan struct{}),
 	}
@@ -91,6 +95,11 @@ func (s *LineSpinner) Run() {
 		s.mu.Unlock()
 		return
 	}
+	if !s.tty {
+		s.mu.Unlock()
+		s.runPlain()
+		return
+	}
 	// Hide cursor and save position before initial print
 	fmt.Fprintf(s.w, "\033[?25l\0337")
 	// Ensure cursor is always restored, even on signal or panic
@@ -98,7 +107,7 @@ func (s *LineSpinner) Run() {
 
 	// Print all lines initially
 	for _, l := range s.lines {
-		status := progressPrefix.Render(spinnerFrames[0])
+		status := progressPrefix.Render(glyphs().Spinner[0])
 		if l.resolved {
 			status = l.status
 		}
@@ -110,8 +119,8 @@ func (s *LineSpinner) Run() {
 	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
 	defer signal.Stop(sig)
 
-	ticker := time.NewTicker(80 * time.Millisecond)
-	defer ticker.Stop()
+	ticks, stop := newTicker(80 * time.Millisecond)
+	defer stop()
 
 	for {
 		select {
@@ -121,17 +130,77 @@ func (s *LineSpinner) Run() {
 		case <-sig:
 			s.redraw()
 			return
-		case <-ticker.C:
+		case <-ticks:
 			s.frame++
 			s.redraw()
 		}
 	}
 }
 
+// runPlain is Run for non-terminal output: no animation, no escapes, each
+// line written exactly once in order.
+func (s *LineSpinner) runPlain() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	ticks, stop := newTicker(80 * time.Millisecond)
+	defer stop()
+
+	for {
+		s.printResolved(false)
+		select {
+		case <-s.done:
+			s.printResolved(true)
+			return
+		case <-sig:
+			s.printResolved(true)
+			return
+		case <-ticks:
+		}
+	}
+}
+
+// printResolved writes the next run of resolved lines. With all set, it
+// writes every remaining line, using the theme's ellipsis as the status of unresolved ones.
+func (s *LineSpinner) printResolved(all bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ; s.printed < len(s.lines); s.printed++ {
+		l := s.lines[s.printed]
+		status := l.status
+		if !l.resolved {
+			if !all {
+				return
+			}
+			status = glyphs().Ellipsis
+		}
+		fmt.Fprintf(s.w, "%s\n", fmt.Sprintf(l.text, status))
+	}
+}
+
+// spinnerWriter is where line spinners write: Writer(), or ErrWriter() in
+// machine mode.
+func spinnerWriter() io.Writer {
+	if machine {
+		return ErrWriter()
+	}
+	return Writer()
+}
+
+// isTerminal reports whether w is a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	return ok && term.IsTerminal(f.Fd())
+}
+
 // Spin displays a spinner animation alongside msg while fn executes.
+// Once the operation has run for a second the line also shows the elapsed
+// time, so a long build visibly isn't hung.
 // On success the spinner line is replaced with "✓ <msg>".
 // On error it is replaced with "› <msg>" so subsequent error output
-// reads naturally.
+// reads naturally. Either way the total duration is appended when it
+// reached a second.
 //
 // Example:
 //
@@ -139,44 +208,115 @@ func (s *LineSpinner) Run() {
 //	    return sandbox.Up(...)
 //	})
 func Spin(msg string, fn func() error) error {
-	return spinTo(os.Stdout, msg, fn)
+	return SpinWithStatus(msg, func(*SpinStatus) error { return fn() })
+}
+
+// SpinWithStatus is like Spin, but passes fn a SpinStatus it can use to
+// report the current sub-phase while it runs. The final ✓/› line shows msg.
+//
+// Example:
+//
+//	err := output.SpinWithStatus("Installing v1.2.0", func(s *output.SpinStatus) error {
+//	    s.Update("downloading")
+//	    ...
+//	})
+func SpinWithStatus(msg string, fn func(*SpinStatus) error) error {
+	if machine {
+		fmt.Fprintln(ErrWriter(), msg)
+		return fn(&SpinStatus{})
+	}
+	return spinTo(Writer(), msg, fn)
+}
+
+// SpinStatus holds the sub-status shown next to a running spinner.
+type SpinStatus struct {
+	mu     sync.Mutex
+	status string
+}
+
+// Update replaces the sub-status shown after the spinner's message.
+func (s *SpinStatus) Update(format string, args ...any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = fmt.Sprintf(format, args...)
+}
+
+// line returns msg followed by the current sub-status, if any.
+func (s *SpinStatus) line(msg string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status == "" {
+		return msg
+	}
+	return msg + ": " + s.status
+}
+
+// now is the clock used for elapsed times; tests replace it.
+var now = time.Now
+
+// newTicker returns the channel that drives spinner frames and a func to
+// stop it; tests replace it to step the animation themselves.
+var newTicker = func(d time.Duration) (<-chan time.Time, func()) {
+	t := time.NewTicker(d)
+	return t.C, t.Stop
+}
+
+// formatElapsed formats a duration as "42s" or "1m05s".
+func formatElapsed(d time.Duration) string {
+	secs := int(d / time.Second)
+	if secs < 60 {
+		return fmt.Sprintf("%ds", secs)
+	}
+	return fmt.Sprintf("%dm%02ds", secs/60, secs%60)
+}
+
+// elapsedSuffix returns " (42s)" for the time since start, or "" during the
+// first second so quick operations stay uncluttered.
+func elapsedSuffix(start time.Time) string {
+	d := now().Sub(start)
+	if d < time.Second {
+		return ""
+	}
+	return " " + cmdBorder.Render("("+formatElapsed(d)+")")
 }
 
 // spinTo is the testable core of Spin, accepting an explicit writer.
-func spinTo(w io.Writer, msg string, fn func() error) error {
+func spinTo(w io.Writer, msg string, fn func(*SpinStatus) error) error {
+	start := now()
+	status := &SpinStatus{}
 	ch := make(chan error, 1)
 	go func() {
-		ch <- fn()
+		ch <- fn(status)
 	}()
 
 	frame := 0
-	fmt.Fprintf(w, "%s %s", progressPrefix.Render(spinnerFrames[frame]), msg)
+	fmt.Fprintf(w, "%s %s", progressPrefix.Render(glyphs().Spinner[frame]), msg)
 
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
 	defer signal.Stop(sig)
 
-	ticker := time.NewTicker(80 * time.Millisecond)
-	defer ticker.Stop()
+	ticks, stop := newTicker(80 * time.Millisecond)
+	defer stop()
 
 	for {
 		select {
 		case err := <-ch:
 			fmt.Fprintf(w, "\r\033[2K")
 			if err != nil {
-				fmt.Fprintf(w, "%s %s\n", progressPrefix.Render("›"), msg)
+				fmt.Fprintf(w, "%s %s%s\n", progressPrefix.Render(glyphs().Progress), msg, elapsedSuffix(start))
 			} else {
-				fmt.Fprintf(w, "%s %s\n", successPrefix.Render("✓"), msg)
+				fmt.Fprintf(w, "%s %s%s\n", successPrefix.Render(glyphs().Success), msg, elapsedSuffix(start))
 			}
 			return err
 		case <-sig:
 			fmt.Fprintf(w, "\r\033[2K")
-			fmt.Fprintf(w, "%s %s\n", progressPrefix.Render("›"), msg)
+			fmt.Fprintf(w, "%s %s%s\n", progressPrefix.Render(glyphs().Progress), msg, elapsedSuffix(start))
 			return nil
-		case <-ticker.C:
+		case <-ticks:
 			frame++
-			char := spinnerFrames[frame%len(spinnerFrames)]
-			fmt.Fprintf(w, "\r\033[2K%s %s", progressPrefix.Render(char), msg)
+			char := glyphs().Spinner[frame%len(glyphs().Spinner)]
+			fmt.Fprintf(w, "\r\033[2K%s %s%s", progressPrefix.Render(char), status.line(msg), elapsedSuffix(start))
 		}
 	}
 }
@@ -191,7 +331,7 @@ func (s *LineSpinner) redraw() {
 	// in narrow terminals, unlike \033[nA which counts display rows.
 	fmt.Fprintf(s.w, "\0338\033[J")
 
-	frameChar := spinnerFrames[s.frame%len(spinnerFrames)]
+	frameChar := glyphs().Spinner[s.frame%len(glyphs().Spinner)]
 	for _, l := range s.lines {
 		status := progressPrefix.Render(frameChar)
 		if l.resolved {
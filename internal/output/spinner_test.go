@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"errors"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestLineSpinner_AllResolvedBeforeRun(t *testing.T) {
@@ -16,6 +18,7 @@ func TestLineSpinner_AllResolvedBeforeRun(t *testing.T) {
 
 	var buf bytes.Buffer
 	spinner.w = &buf
+	spinner.tty = true
 	spinner.Run()
 
 	out := buf.String()
@@ -34,6 +37,7 @@ func TestLineSpinner_ResolvesDuringRun(t *testing.T) {
 
 	var buf bytes.Buffer
 	spinner.w = &buf
+	spinner.tty = true
 
 	// Resolve immediately after Run starts — the spinner should exit
 	// once all lines are resolved.
@@ -61,6 +65,7 @@ func TestLineSpinner_SingleLine(t *testing.T) {
 
 	var buf bytes.Buffer
 	spinner.w = &buf
+	spinner.tty = true
 	spinner.Run()
 
 	out := buf.String()
@@ -77,6 +82,7 @@ func TestLineSpinner_PartialResolve(t *testing.T) {
 
 	var buf bytes.Buffer
 	spinner.w = &buf
+	spinner.tty = true
 
 	// Only resolving 2 of 3 initially — spinner should keep running
 	spinner.Resolve(0, "ok")
@@ -113,6 +119,7 @@ func TestLineSpinner_CursorSaveRestore(t *testing.T) {
 
 	var buf bytes.Buffer
 	spinner.w = &buf
+	spinner.tty = true
 	spinner.Run()
 
 	out := buf.String()
@@ -140,6 +147,7 @@ func TestLineSpinner_NoCursorUpEscape(t *testing.T) {
 
 	var buf bytes.Buffer
 	spinner.w = &buf
+	spinner.tty = true
 	spinner.Run()
 
 	out := buf.String()
@@ -153,6 +161,7 @@ func TestLineSpinner_ZeroLines(t *testing.T) {
 
 	var buf bytes.Buffer
 	spinner.w = &buf
+	spinner.tty = true
 
 	// Run should return immediately without blocking.
 	spinner.Run()
@@ -171,6 +180,7 @@ func TestLineSpinner_StopBeforeAllResolved(t *testing.T) {
 
 	var buf bytes.Buffer
 	spinner.w = &buf
+	spinner.tty = true
 
 	done := make(chan struct{})
 	go func() {
@@ -200,6 +210,7 @@ func TestLineSpinner_StopIdempotent(t *testing.T) {
 
 	var buf bytes.Buffer
 	spinner.w = &buf
+	spinner.tty = true
 	spinner.Run()
 
 	// Calling Stop after Run has already returned should not panic
@@ -209,7 +220,7 @@ func TestLineSpinner_StopIdempotent(t *testing.T) {
 
 func TestSpin_Success(t *testing.T) {
 	var buf bytes.Buffer
-	err := spinTo(&buf, "Doing work", func() error {
+	err := spinTo(&buf, "Doing work", func(*SpinStatus) error {
 		return nil
 	})
 	if err != nil {
@@ -229,7 +240,7 @@ func TestSpin_Success(t *testing.T) {
 func TestSpin_Error(t *testing.T) {
 	var buf bytes.Buffer
 	testErr := errors.New("something broke")
-	err := spinTo(&buf, "Failing task", func() error {
+	err := spinTo(&buf, "Failing task", func(*SpinStatus) error {
 		return testErr
 	})
 	if !errors.Is(err, testErr) {
@@ -248,3 +259,134 @@ func TestSpin_Error(t *testing.T) {
 		t.Errorf("should not contain success marker on error, got: %s", out)
 	}
 }
+
+func TestLineSpinner_NonTTYPrintsPlainLines(t *testing.T) {
+	spinner := NewLineSpinner(3)
+	spinner.SetLine(0, "a %s")
+	spinner.SetLine(1, "b %s")
+	spinner.SetLine(2, "c %s")
+
+	var buf bytes.Buffer
+	spinner.w = &buf
+	spinner.tty = false
+
+	go func() {
+		// Resolve out of order; output must still follow line order.
+		spinner.Resolve(2, "three")
+		spinner.Resolve(0, "one")
+		spinner.Resolve(1, "two")
+	}()
+	spinner.Run()
+
+	out := buf.String()
+	if strings.Contains(out, "\033") {
+		t.Errorf("expected no escape sequences for non-TTY output, got: %q", out)
+	}
+	if out != "a one\nb two\nc three\n" {
+		t.Errorf("output = %q, want each line once in order", out)
+	}
+}
+
+func TestLineSpinner_NonTTYStopPrintsRemaining(t *testing.T) {
+	spinner := NewLineSpinner(2)
+	spinner.SetLine(0, "a %s")
+	spinner.SetLine(1, "b %s")
+	spinner.Resolve(0, "ok")
+
+	var buf bytes.Buffer
+	spinner.w = &buf
+	spinner.tty = false
+
+	go spinner.Stop()
+	spinner.Run()
+
+	if got := buf.String(); got != "a ok\nb …\n" {
+		t.Errorf("output = %q, want %q", got, "a ok\nb …\n")
+	}
+}
+
+func TestFormatElapsed(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "0s"},
+		{1500 * time.Millisecond, "1s"},
+		{42 * time.Second, "42s"},
+		{65 * time.Second, "1m05s"},
+		{10*time.Minute + 3*time.Second, "10m03s"},
+	}
+	for _, tt := range tests {
+		if got := formatElapsed(tt.d); got != tt.want {
+			t.Errorf("formatElapsed(%v) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestSpin_ShowsElapsedTime(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	var mu sync.Mutex
+	current := start
+	now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return current
+	}
+	defer func() { now = time.Now }()
+
+	var buf bytes.Buffer
+	err := spinTo(&buf, "Building image", func(*SpinStatus) error {
+		mu.Lock()
+		current = start.Add(65 * time.Second)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	out := buf.String()
+	if !strings.Contains(out, "✓") || !strings.Contains(out, "Building image") {
+		t.Errorf("expected success line, got: %q", out)
+	}
+	if !strings.Contains(out, "(1m05s)") {
+		t.Errorf("expected total duration on completion, got: %q", out)
+	}
+}
+
+func TestElapsedSuffix_HiddenUnderASecond(t *testing.T) {
+	start := time.Now()
+	now = func() time.Time { return start.Add(500 * time.Millisecond) }
+	defer func() { now = time.Now }()
+
+	if got := elapsedSuffix(start); got != "" {
+		t.Errorf("elapsedSuffix under a second = %q, want empty", got)
+	}
+}
+
+func TestSpin_StatusUpdates(t *testing.T) {
+	ticks := make(chan time.Time)
+	defer func(prev func(time.Duration) (<-chan time.Time, func())) { newTicker = prev }(newTicker)
+	newTicker = func(time.Duration) (<-chan time.Time, func()) { return ticks, func() {} }
+
+	var buf bytes.Buffer
+	err := spinTo(&buf, "Installing", func(s *SpinStatus) error {
+		s.Update("downloading %s", "v1.2.0")
+		// The second tick is only taken once the first has been drawn.
+		ticks <- time.Time{}
+		ticks <- time.Time{}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	out := buf.String()
+	if !strings.Contains(out, "Installing: downloading v1.2.0") {
+		t.Errorf("expected sub-status in spinner line, got: %q", out)
+	}
+	final := out[strings.LastIndex(out, "\r\033[2K"):]
+	if strings.Contains(final, "downloading") || !strings.Contains(final, "✓") || !strings.HasSuffix(final, " Installing\n") {
+		t.Errorf("final line = %q, want the original message", final)
+	}
+}
@@ -0,0 +1,129 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// writeTools are the tools whose file_path (or notebook_path) input counts as
+// a file written.
+var writeTools = map[string]bool{"Write": true, "Edit": true, "MultiEdit": true, "NotebookEdit": true}
+
+// ToolSummary tallies what a headless agent run did: tool calls by name and
+// the distinct files it wrote.
+type ToolSummary struct {
+	Calls map[string]int
+	Files map[string]bool
+}
+
+// NewToolSummary returns an empty summary.
+func NewToolSummary() *ToolSummary {
+	return &ToolSummary{Calls: make(map[string]int), Files: make(map[string]bool)}
+}
+
+// Add tallies the tool_use blocks in blocks.
+func (s *ToolSummary) Add(blocks []Block) {
+	for _, b := range blocks {
+		tu, ok := b.(ToolUseBlock)
+		if !ok {
+			continue
+		}
+		s.Calls[tu.Name]++
+		if !writeTools[tu.Name] {
+			continue
+		}
+		var input struct {
+			FilePath     string `json:"file_path"`
+			NotebookPath string `json:"notebook_path"`
+		}
+		if json.Unmarshal(tu.Input, &input) == nil {
+			if path := input.FilePath + input.NotebookPath; path != "" {
+				s.Files[path] = true
+			}
+		}
+	}
+}
+
+// AddStreamLine tallies one line of Claude's --output-format stream-json
+// output. Lines that aren't assistant messages are ignored.
+func (s *ToolSummary) AddStreamLine(line []byte) {
+	var event struct {
+		Type    string `json:"type"`
+		Message struct {
+			Content json.RawMessage `json:"content"`
+		} `json:"message"`
+	}
+	if json.Unmarshal(line, &event) != nil || event.Type != "assistant" || len(event.Message.Content) == 0 {
+		return
+	}
+	if blocks, err := ParseClaudeBlocks(event.Message.Content); err == nil {
+		s.Add(blocks)
+	}
+}
+
+// Tee returns a writer that passes output through to w while tallying each
+// complete stream-json line.
+func (s *ToolSummary) Tee(w io.Writer) io.Writer {
+	return &summaryTee{w: w, s: s}
+}
+
+type summaryTee struct {
+	w   io.Writer
+	s   *ToolSummary
+	buf []byte
+}
+
+func (t *summaryTee) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	for {
+		idx := bytes.IndexByte(t.buf, '\n')
+		if idx < 0 {
+			break
+		}
+		t.s.AddStreamLine(t.buf[:idx])
+		t.buf = t.buf[idx+1:]
+	}
+	return t.w.Write(p)
+}
+
+// Line formats the summary as one line, e.g.
+// "7 tool calls (Bash 4, Edit 2, Read 1), 2 files written in 1m05s".
+// Tools are listed by call count, then name.
+func (s *ToolSummary) Line(d time.Duration) string {
+	total := 0
+	names := make([]string, 0, len(s.Calls))
+	for name, n := range s.Calls {
+		total += n
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if s.Calls[names[i]] != s.Calls[names[j]] {
+			return s.Calls[names[i]] > s.Calls[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d tool %s", total, plural(total, "call", "calls"))
+	if total > 0 {
+		parts := make([]string, len(names))
+		for i, name := range names {
+			parts[i] = fmt.Sprintf("%s %d", name, s.Calls[name])
+		}
+		fmt.Fprintf(&b, " (%s)", strings.Join(parts, ", "))
+	}
+	fmt.Fprintf(&b, ", %d %s written in %s", len(s.Files), plural(len(s.Files), "file", "files"), formatElapsed(d))
+	return b.String()
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
@@ -0,0 +1,57 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestToolSummary_Line(t *testing.T) {
+	s := NewToolSummary()
+	s.Add([]Block{
+		TextBlock{Text: "Looking around"},
+		ToolUseBlock{Name: "Read", Input: json.RawMessage(`{"file_path":"/workspace/a.go"}`)},
+		ToolUseBlock{Name: "Bash", Input: json.RawMessage(`{"command":"go test ./..."}`)},
+		ToolUseBlock{Name: "Edit", Input: json.RawMessage(`{"file_path":"/workspace/a.go"}`)},
+		ToolUseBlock{Name: "Edit", Input: json.RawMessage(`{"file_path":"/workspace/a.go"}`)},
+		ToolUseBlock{Name: "Write", Input: json.RawMessage(`{"file_path":"/workspace/b.go"}`)},
+		ToolUseBlock{Name: "Bash", Input: json.RawMessage(`{"command":"go vet ./..."}`)},
+	})
+
+	got := s.Line(65 * time.Second)
+	want := "6 tool calls (Bash 2, Edit 2, Read 1, Write 1), 2 files written in 1m05s"
+	if got != want {
+		t.Errorf("Line = %q, want %q", got, want)
+	}
+}
+
+func TestToolSummary_Empty(t *testing.T) {
+	got := NewToolSummary().Line(3 * time.Second)
+	if want := "0 tool calls, 0 files written in 3s"; got != want {
+		t.Errorf("Line = %q, want %q", got, want)
+	}
+}
+
+func TestToolSummary_TeeStreamJSON(t *testing.T) {
+	stream := `{"type":"system","subtype":"init"}
+{"type":"assistant","message":{"content":[{"type":"text","text":"hi"},{"type":"tool_use","id":"t1","name":"Write","input":{"file_path":"/w/x.go"}}]}}
+{"type":"user","message":{"content":[{"type":"tool_result","tool_use_id":"t1"}]}}
+{"type":"assistant","message":{"content":[{"type":"tool_use","id":"t2","name":"Bash","input":{"command":"ls"}}]}}
+{"type":"result","result":"done"}
+`
+	s := NewToolSummary()
+	var out bytes.Buffer
+	tee := s.Tee(&out)
+	// Write in awkward chunks to exercise line buffering.
+	for i := 0; i < len(stream); i += 7 {
+		tee.Write([]byte(stream[i:min(i+7, len(stream))]))
+	}
+
+	if out.String() != stream {
+		t.Error("Tee should pass output through unchanged")
+	}
+	if got, want := s.Line(time.Second), "2 tool calls (Bash 1, Write 1), 1 file written in 1s"; got != want {
+		t.Errorf("Line = %q, want %q", got, want)
+	}
+}
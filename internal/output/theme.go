@@ -0,0 +1,106 @@
+package output
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/lipgloss/v2"
+)
+
+// Theme is the set of glyphs used for message markers, command frames and
+// spinners. Colours are unaffected.
+type Theme struct {
+	Name     string
+	Progress string // "›" marker for progress messages
+	Success  string
+	Warning  string
+	Error    string
+	Border   string          // left frame of command output
+	Tool     lipgloss.Border // border around tool-use blocks
+	Spinner  []string        // animation frames
+	Ellipsis string          // marks truncated or unresolved content
+}
+
+// UnicodeTheme is the default glyph set.
+var UnicodeTheme = Theme{
+	Name:     "unicode",
+	Progress: "›",
+	Success:  "✓",
+	Warning:  "!",
+	Error:    "✗",
+	Border:   "│",
+	Tool:     lipgloss.NormalBorder(),
+	Spinner:  []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"},
+	Ellipsis: "…",
+}
+
+// ASCIITheme uses only 7-bit ASCII, for terminals and log viewers that
+// render box-drawing and braille characters poorly.
+var ASCIITheme = Theme{
+	Name:     "ascii",
+	Progress: ">",
+	Success:  "+",
+	Warning:  "!",
+	Error:    "x",
+	Border:   "|",
+	Tool:     lipgloss.ASCIIBorder(),
+	Spinner:  []string{"|", "/", "-", "\\"},
+	Ellipsis: "...",
+}
+
+var (
+	themeMu sync.Mutex
+	// theme is the active glyph set; read it with glyphs.
+	theme = UnicodeTheme
+	// loadTheme, if set, picks the theme the first time one is needed.
+	loadTheme func() (Theme, error)
+)
+
+// SetTheme makes t the active glyph set for all output.
+func SetTheme(t Theme) {
+	themeMu.Lock()
+	defer themeMu.Unlock()
+	theme, loadTheme = t, nil
+}
+
+// SetThemeLoader defers choosing the glyph set until output first needs
+// one, so a command that renders nothing never runs load. An error from
+// load is reported as a warning and the current theme is kept.
+func SetThemeLoader(load func() (Theme, error)) {
+	themeMu.Lock()
+	defer themeMu.Unlock()
+	loadTheme = load
+}
+
+// glyphs returns the active glyph set, running a pending loader first.
+func glyphs() Theme {
+	themeMu.Lock()
+	load := loadTheme
+	loadTheme = nil
+	var err error
+	if load != nil {
+		var t Theme
+		if t, err = load(); err == nil {
+			theme = t
+		}
+	}
+	t := theme
+	themeMu.Unlock()
+	if err != nil {
+		Warning("%v", err)
+	}
+	return t
+}
+
+// ThemeByName returns the theme for an output_style value. An empty name
+// selects the default unicode theme.
+func ThemeByName(name string) (Theme, error) {
+	switch strings.ToLower(name) {
+	case "", "unicode":
+		return UnicodeTheme, nil
+	case "ascii":
+		return ASCIITheme, nil
+	}
+	return Theme{}, fmt.Errorf("unknown output style %q (want \"unicode\" or \"ascii\")", name)
+}
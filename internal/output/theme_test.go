@@ -0,0 +1,112 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestThemeByName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"", "unicode"},
+		{"unicode", "unicode"},
+		{"ASCII", "ascii"},
+	}
+	for _, tt := range tests {
+		got, err := ThemeByName(tt.name)
+		if err != nil {
+			t.Fatalf("ThemeByName(%q): %v", tt.name, err)
+		}
+		if got.Name != tt.want {
+			t.Errorf("ThemeByName(%q) = %q, want %q", tt.name, got.Name, tt.want)
+		}
+	}
+
+	if _, err := ThemeByName("emoji"); err == nil {
+		t.Error("ThemeByName(\"emoji\") should fail")
+	}
+}
+
+// assertASCII fails if out contains any byte outside 7-bit ASCII.
+func assertASCII(t *testing.T, what, out string) {
+	t.Helper()
+	for _, r := range out {
+		if r > 0x7f {
+			t.Errorf("%s contains non-ASCII %q: %q", what, r, out)
+			return
+		}
+	}
+}
+
+func TestASCIITheme(t *testing.T) {
+	SetTheme(ASCIITheme)
+	defer SetTheme(UnicodeTheme)
+
+	var buf bytes.Buffer
+	Render(&buf, []Block{
+		ProgressBlock{Message: "working"},
+		SuccessBlock{Message: "done"},
+		WarningBlock{Message: "careful"},
+		ErrorBlock{Message: "broke"},
+		ToolUseBlock{Name: "Bash", ID: "t1", Input: json.RawMessage(`{"command":"ls"}`)},
+	})
+	assertASCII(t, "RenderBlock", buf.String())
+
+	buf.Reset()
+	cw := NewCommandWriterWithOptions(&buf, CommandWriterOptions{MaxWidth: 10})
+	cw.Write([]byte("a very long line of output\n"))
+	cw.Close()
+	assertASCII(t, "CommandWriter", buf.String())
+	if !bytes.Contains(buf.Bytes(), []byte("...")) {
+		t.Errorf("expected ASCII ellipsis on truncation, got %q", buf.String())
+	}
+
+	buf.Reset()
+	spinner := NewLineSpinner(1)
+	spinner.w = &buf
+	spinner.tty = true
+	spinner.SetLine(0, "step %s")
+	spinner.Resolve(0, "ok")
+	spinner.Run()
+	assertASCII(t, "LineSpinner", buf.String())
+
+	buf.Reset()
+	spinTo(&buf, "task", func(*SpinStatus) error { return nil })
+	assertASCII(t, "Spin", buf.String())
+}
+
+func TestSetThemeLoader_RunsOnFirstRender(t *testing.T) {
+	defer SetTheme(UnicodeTheme)
+
+	loads := 0
+	SetThemeLoader(func() (Theme, error) {
+		loads++
+		return ASCIITheme, nil
+	})
+	if loads != 0 {
+		t.Fatalf("loader ran %d times before anything was rendered", loads)
+	}
+
+	var buf bytes.Buffer
+	Render(&buf, []Block{ProgressBlock{Message: "one"}, ProgressBlock{Message: "two"}})
+	if loads != 1 {
+		t.Errorf("loader ran %d times, want once", loads)
+	}
+	assertASCII(t, "Render", buf.String())
+
+	// SetTheme overrides a loader that hasn't run yet.
+	SetThemeLoader(func() (Theme, error) {
+		loads++
+		return ASCIITheme, nil
+	})
+	SetTheme(UnicodeTheme)
+	buf.Reset()
+	Render(&buf, []Block{ProgressBlock{Message: "three"}})
+	if loads != 1 || !strings.Contains(buf.String(), "›") {
+		t.Errorf("loader ran %d times, output %q; want the unicode theme without loading", loads, buf.String())
+	}
+}
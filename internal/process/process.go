@@ -0,0 +1,64 @@
+// Package process inspects the host processes cbox starts and records by
+// PID, such as the MCP, bridge and serve proxies, for the sandbox commands
+// and the daemon alike.
+package process
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// internalCommands are the hidden cbox commands its host processes run.
+var internalCommands = []string{"_mcp-proxy", "_bridge-proxy", "_serve-runner"}
+
+// Alive reports whether a process with the given PID exists.
+func Alive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return proc.Signal(syscall.Signal(0)) == nil
+}
+
+// Owned reports whether pid is still a process cbox started: the leader of
+// its own process group, running one of cbox's internal commands. A PID read
+// back from a state file may have been reused by an unrelated process after a
+// reboot or crash, and must not be signalled. When the command line can't be
+// read, the group check alone decides.
+func Owned(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	if pgid, err := syscall.Getpgid(pid); err != nil || pgid != pid {
+		return false
+	}
+	args, ok := commandLine(pid)
+	if !ok {
+		return true
+	}
+	return slices.ContainsFunc(args, func(arg string) bool {
+		return slices.Contains(internalCommands, arg)
+	})
+}
+
+// commandLine returns pid's arguments, from /proc where there is one and
+// from ps otherwise. Arguments containing spaces are split by ps, which
+// doesn't matter for matching a command name.
+func commandLine(pid int) ([]string, bool) {
+	if data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/cmdline"); err == nil {
+		return strings.Split(string(bytes.TrimRight(data, "\x00")), "\x00"), true
+	}
+	out, err := exec.Command("ps", "-o", "args=", "-p", strconv.Itoa(pid)).Output()
+	if err != nil {
+		return nil, false
+	}
+	return strings.Fields(string(out)), true
+}
@@ -0,0 +1,49 @@
+package process
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+)
+
+func TestAlive(t *testing.T) {
+	if !Alive(os.Getpid()) {
+		t.Error("Alive(self) = false, want true")
+	}
+	if Alive(0) {
+		t.Error("Alive(0) = true, want false")
+	}
+}
+
+// startGroupLeader starts a shell in its own process group, as cbox does
+// for its host processes. The shell's $0 is name.
+func startGroupLeader(t *testing.T, name string) int {
+	t.Helper()
+	cmd := exec.Command("sh", "-c", "sleep 60", name)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		cmd.Wait()
+	})
+	return cmd.Process.Pid
+}
+
+func TestOwned(t *testing.T) {
+	if pid := startGroupLeader(t, "_mcp-proxy"); !Owned(pid) {
+		t.Error("Owned(cbox proxy) = false, want true")
+	}
+	if pid := startGroupLeader(t, "other"); Owned(pid) {
+		t.Error("Owned(unrelated group leader) = true, want false")
+	}
+	// The test binary runs no cbox command.
+	if Owned(os.Getpid()) {
+		t.Error("Owned(self) = true, want false")
+	}
+	if Owned(0) {
+		t.Error("Owned(0) = true, want false")
+	}
+}
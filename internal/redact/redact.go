@@ -0,0 +1,132 @@
+// Package redact keeps secret values, such as those resolved from
+// env_commands, out of cbox's output and the logs its proxies write.
+package redact
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// EnvVar passes the secrets to cbox's own child processes (the MCP server
+// and serve runner), which read it with FromEnv.
+const EnvVar = "CBOX_REDACT"
+
+// Mask replaces a secret wherever it appears.
+const Mask = "[redacted]"
+
+// minLen is the shortest value redacted. Shorter values ("1", "true") would
+// mask ordinary output without hiding anything worth hiding.
+const minLen = 4
+
+var (
+	mu      sync.RWMutex
+	secrets []string
+)
+
+// Add registers values to be redacted from now on.
+func Add(values ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, v := range values {
+		if len(v) >= minLen {
+			secrets = append(secrets, v)
+		}
+	}
+}
+
+// Any reports whether any secrets are registered.
+func Any() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return len(secrets) > 0
+}
+
+// String returns s with every registered secret replaced by Mask.
+func String(s string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, v := range secrets {
+		s = strings.ReplaceAll(s, v, Mask)
+	}
+	return s
+}
+
+// Env returns the environment entry that hands the registered secrets to a
+// child process, or nil if there are none.
+func Env() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	if len(secrets) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(secrets)
+	if err != nil {
+		return nil
+	}
+	return []string{EnvVar + "=" + string(data)}
+}
+
+// FromEnv registers the secrets passed by a parent with Env, and removes
+// them from the environment so commands this process runs don't inherit
+// them. It reports whether any were passed.
+func FromEnv() bool {
+	data, ok := os.LookupEnv(EnvVar)
+	if !ok {
+		return false
+	}
+	os.Unsetenv(EnvVar)
+	var values []string
+	if json.Unmarshal([]byte(data), &values) == nil {
+		Add(values...)
+	}
+	return true
+}
+
+// Writer redacts what is written through it. Output is passed on a line
+// at a time so a secret split across writes is still caught; Close
+// flushes an unterminated last line. With no secrets registered, writes
+// pass straight through.
+type Writer struct {
+	mu  sync.Mutex
+	w   io.Writer
+	buf []byte
+}
+
+// NewWriter returns a Writer that writes redacted lines to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+func (r *Writer) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.buf) == 0 && !Any() {
+		return r.w.Write(p)
+	}
+	r.buf = append(r.buf, p...)
+	i := strings.LastIndexByte(string(r.buf), '\n')
+	if i < 0 {
+		return len(p), nil
+	}
+	if _, err := io.WriteString(r.w, String(string(r.buf[:i+1]))); err != nil {
+		return 0, err
+	}
+	r.buf = r.buf[i+1:]
+	return len(p), nil
+}
+
+// Close writes any buffered partial line. It doesn't close the underlying
+// writer.
+func (r *Writer) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.buf) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(r.w, String(string(r.buf)))
+	r.buf = nil
+	return err
+}
@@ -0,0 +1,63 @@
+package redact
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+// reset clears the registered secrets when the test ends.
+func reset(t *testing.T) {
+	t.Helper()
+	t.Cleanup(func() {
+		mu.Lock()
+		secrets = nil
+		mu.Unlock()
+	})
+}
+
+func TestString(t *testing.T) {
+	reset(t)
+	Add("hunter22", "1")
+	if got := String("token=hunter22 retries=1"); got != "token=[redacted] retries=1" {
+		t.Errorf("String() = %q, want the secret masked and the short value kept", got)
+	}
+}
+
+func TestWriter_SecretSplitAcrossWrites(t *testing.T) {
+	reset(t)
+	Add("hunter22")
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Write([]byte("login with hun"))
+	w.Write([]byte("ter22\nnext "))
+	if got := buf.String(); got != "login with [redacted]\n" {
+		t.Errorf("after a complete line, wrote %q", got)
+	}
+	w.Close()
+	if got := buf.String(); got != "login with [redacted]\nnext " {
+		t.Errorf("after Close, wrote %q, want the partial line flushed", got)
+	}
+}
+
+func TestEnvRoundTrip(t *testing.T) {
+	reset(t)
+	Add("hunter22")
+	env := Env()
+	if len(env) != 1 {
+		t.Fatalf("Env() = %q", env)
+	}
+
+	mu.Lock()
+	secrets = nil
+	mu.Unlock()
+	name, value, _ := bytes.Cut([]byte(env[0]), []byte("="))
+	t.Setenv(string(name), string(value))
+	FromEnv()
+	if got := String("hunter22"); got != Mask {
+		t.Errorf("after FromEnv, String() = %q, want it masked", got)
+	}
+	if _, ok := os.LookupEnv(EnvVar); ok {
+		t.Errorf("%s is still set, so commands would inherit the secrets", EnvVar)
+	}
+}
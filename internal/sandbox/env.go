@@ -0,0 +1,82 @@
+package sandbox
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/richvanbergen/cbox/internal/config"
+	"github.com/richvanbergen/cbox/internal/output"
+	"github.com/richvanbergen/cbox/internal/redact"
+)
+
+// resolveEnvCommands runs each env_commands entry on the host in dir and
+// returns the trimmed stdout of each keyed by variable name. Values are only
+// held in memory; they are never written to disk or printed. Errors name the
+// variable and include the command's stderr, never its stdout.
+func resolveEnvCommands(commands map[string]string, dir string) (map[string]string, error) {
+	keys := make([]string, 0, len(commands))
+	for k := range commands {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	env := make(map[string]string, len(commands))
+	for _, key := range keys {
+		var stdout, stderr bytes.Buffer
+		cmd := exec.Command("sh", "-c", config.ShellCommand(commands[key]))
+		cmd.Dir = dir
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("env_commands %s: %s: %w", key, strings.TrimSpace(stderr.String()), err)
+		}
+		env[key] = strings.TrimRight(stdout.String(), "\r\n")
+	}
+	return env, nil
+}
+
+// redactSecrets masks resolved env_commands values in cbox's output, and in
+// the logs of the proxies it starts from here on.
+func redactSecrets(env map[string]string) {
+	for _, v := range env {
+		redact.Add(v)
+	}
+}
+
+// RedactEnvCommands resolves branch's env_commands and masks their values
+// in this process's output. Proxies the daemon restarts call it: they are
+// not handed the values, since those never go to the daemon.
+func RedactEnvCommands(projectDir, branch string) error {
+	cfg, err := config.LoadForBranch(projectDir, branch)
+	if err != nil {
+		return err
+	}
+	if len(cfg.EnvCommands) == 0 {
+		return nil
+	}
+	env, err := resolveEnvCommands(cfg.EnvCommands, projectDir)
+	if err != nil {
+		return err
+	}
+	redactSecrets(env)
+	return nil
+}
+
+// resolveSecretsForProxies resolves env_commands again when a proxy is
+// about to be restarted outside up, so its logs stay redacted. A failure
+// only costs the redaction, so it is a warning.
+func resolveSecretsForProxies(cfg *config.Config, dir string) {
+	if len(cfg.EnvCommands) == 0 || redact.Any() {
+		return
+	}
+	output.Progress("Resolving env_commands")
+	env, err := resolveEnvCommands(cfg.EnvCommands, dir)
+	if err != nil {
+		output.Warning("Restarted proxies will not redact env_commands values from their logs: %v", err)
+		return
+	}
+	redactSecrets(env)
+}
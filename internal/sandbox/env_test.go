@@ -0,0 +1,38 @@
+package sandbox
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResolveEnvCommands(t *testing.T) {
+	env, err := resolveEnvCommands(map[string]string{
+		"API_KEY":   "printf 'secret-value\\n'",
+		"MULTILINE": "printf 'a\\nb\\n'",
+	}, t.TempDir())
+	if err != nil {
+		t.Fatalf("resolveEnvCommands: %v", err)
+	}
+	if env["API_KEY"] != "secret-value" {
+		t.Errorf("API_KEY = %q, want %q", env["API_KEY"], "secret-value")
+	}
+	if env["MULTILINE"] != "a\nb" {
+		t.Errorf("MULTILINE = %q, want %q", env["MULTILINE"], "a\nb")
+	}
+}
+
+func TestResolveEnvCommands_FailureOmitsStdout(t *testing.T) {
+	_, err := resolveEnvCommands(map[string]string{
+		"TOKEN": "echo leaked-secret; echo 'vault locked' >&2; exit 1",
+	}, t.TempDir())
+	if err == nil {
+		t.Fatal("expected error from failing command")
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, "TOKEN") || !strings.Contains(msg, "vault locked") {
+		t.Errorf("error %q should name the variable and include stderr", msg)
+	}
+	if strings.Contains(msg, "leaked-secret") {
+		t.Errorf("error %q must not include command stdout", msg)
+	}
+}
@@ -0,0 +1,81 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/richvanbergen/cbox/internal/docker"
+	"github.com/richvanbergen/cbox/internal/worktree"
+)
+
+// Sentinel errors for common failure modes. Errors returned from this
+// package wrap them so callers can branch with errors.Is.
+var (
+	// ErrNoState means no sandbox state file exists for the branch.
+	ErrNoState = errors.New("no sandbox for branch")
+	// ErrContainerNotRunning means the sandbox exists but its container is stopped.
+	ErrContainerNotRunning = errors.New("sandbox container is not running")
+	// ErrNoServeConfig means the project has no [serve] section (or the
+	// requested serve command isn't configured).
+	ErrNoServeConfig = errors.New("no [serve] section configured")
+	// ErrServeNotRunning means there is no serve process for the sandbox.
+	ErrServeNotRunning = errors.New("no serve process running")
+	// ErrUnpushedCommits means clean refused to delete a branch with unpushed work.
+	ErrUnpushedCommits = errors.New("branch has unpushed commits")
+	// ErrChatInUse means another terminal is attached to the sandbox's
+	// interactive chat session.
+	ErrChatInUse = errors.New("a chat session is already open")
+	// ErrWorktreeMissing means the sandbox's worktree was deleted or is no
+	// longer a git worktree.
+	ErrWorktreeMissing = errors.New("sandbox worktree is missing")
+	// ErrDocker marks failures from the docker CLI, including a docker
+	// binary missing from the PATH.
+	ErrDocker = docker.ErrCLI
+)
+
+// DockerError wraps an error from a docker operation so it matches ErrDocker
+// while keeping the original message.
+type DockerError struct {
+	Err error
+}
+
+func (e *DockerError) Error() string { return e.Err.Error() }
+
+func (e *DockerError) Unwrap() error { return e.Err }
+
+func (e *DockerError) Is(target error) bool { return target == ErrDocker }
+
+// dockerErr wraps err as a DockerError. It returns nil for a nil err.
+func dockerErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &DockerError{Err: err}
+}
+
+// requireRunning returns ErrContainerNotRunning if the sandbox has been
+// stopped with down, or docker reports its container as stopped. If docker
+// can't be queried the check passes so the real docker error surfaces from
+// the command that follows.
+func requireRunning(state *State) error {
+	if state.Running {
+		running, err := docker.IsRunning(state.RuntimeContainer)
+		if err != nil || running {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s — start it with 'cbox up %s'", ErrContainerNotRunning, state.RuntimeContainer, state.Branch)
+}
+
+// requireWorktree returns ErrWorktreeMissing if the sandbox's worktree no
+// longer exists or isn't a git worktree, since the container's workspace
+// mount would then point at nothing useful.
+func requireWorktree(state *State) error {
+	if state.WorktreePath == "" || state.WorktreePath == state.ProjectDir {
+		return nil
+	}
+	if err := worktree.Verify(state.WorktreePath); err != nil {
+		return fmt.Errorf("%w: %v — run 'cbox clean %s' then 'cbox up %s'", ErrWorktreeMissing, err, state.Branch, state.Branch)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/richvanbergen/cbox/internal/config"
+)
+
+func TestLoadState_MissingIsErrNoState(t *testing.T) {
+	_, err := LoadState(t.TempDir(), "feature/missing")
+	if !errors.Is(err, ErrNoState) {
+		t.Fatalf("LoadState error = %v, want ErrNoState", err)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("LoadState error = %v, should still wrap os.ErrNotExist", err)
+	}
+}
+
+func TestServeStop_NotRunningIsErrServeNotRunning(t *testing.T) {
+	dir := t.TempDir()
+	if err := SaveState(dir, "main", &State{Branch: "main"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ServeStop(dir, "main"); !errors.Is(err, ErrServeNotRunning) {
+		t.Fatalf("ServeStop error = %v, want ErrServeNotRunning", err)
+	}
+}
+
+func TestServe_NoConfigIsErrNoServeConfig(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, config.ConfigFile), []byte("backend = \"claude\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveState(dir, "main", &State{Branch: "main"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Serve(dir, "main"); !errors.Is(err, ErrNoServeConfig) {
+		t.Fatalf("Serve error = %v, want ErrNoServeConfig", err)
+	}
+}
+
+func TestRequireRunning_StoppedSandbox(t *testing.T) {
+	state := &State{Branch: "main", RuntimeContainer: "cbox-test-main-claude", Running: false}
+	if err := requireRunning(state); !errors.Is(err, ErrContainerNotRunning) {
+		t.Fatalf("requireRunning error = %v, want ErrContainerNotRunning", err)
+	}
+}
+
+func TestDockerError(t *testing.T) {
+	inner := errors.New("exit status 125")
+	err := dockerErr(inner)
+	if !errors.Is(err, ErrDocker) {
+		t.Error("dockerErr should match ErrDocker")
+	}
+	if !errors.Is(err, inner) {
+		t.Error("dockerErr should unwrap to the original error")
+	}
+	if err.Error() != inner.Error() {
+		t.Errorf("message = %q, want %q", err.Error(), inner.Error())
+	}
+	if dockerErr(nil) != nil {
+		t.Error("dockerErr(nil) should be nil")
+	}
+}
@@ -0,0 +1,78 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/richvanbergen/cbox/internal/naming"
+)
+
+// promptHistoryLimit is the maximum number of prompts kept per branch.
+const promptHistoryLimit = 20
+
+func promptHistoryPath(projectDir, branch string) string {
+	safeBranch := naming.SafeBranch(branch)
+	return filepath.Join(projectDir, StateDir, "prompt-history-"+safeBranch+".json")
+}
+
+// LoadPromptHistory returns the recorded prompts for a branch, oldest first.
+// A missing history file is not an error.
+func LoadPromptHistory(projectDir, branch string) ([]string, error) {
+	data, err := os.ReadFile(promptHistoryPath(projectDir, branch))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading prompt history: %w", err)
+	}
+	var prompts []string
+	if err := json.Unmarshal(data, &prompts); err != nil {
+		return nil, fmt.Errorf("parsing prompt history: %w", err)
+	}
+	return prompts, nil
+}
+
+// LastPrompt returns the most recently recorded prompt for a branch.
+func LastPrompt(projectDir, branch string) (string, error) {
+	prompts, err := LoadPromptHistory(projectDir, branch)
+	if err != nil {
+		return "", err
+	}
+	if len(prompts) == 0 {
+		return "", fmt.Errorf("no prompt history for branch %q", branch)
+	}
+	return prompts[len(prompts)-1], nil
+}
+
+// recordPrompt appends prompt to the branch's history, dropping an identical
+// trailing entry and keeping at most promptHistoryLimit prompts.
+func recordPrompt(projectDir, branch, prompt string) error {
+	if strings.TrimSpace(prompt) == "" {
+		return nil
+	}
+	prompts, err := LoadPromptHistory(projectDir, branch)
+	if err != nil {
+		// Start fresh rather than refusing to record over a corrupt file.
+		prompts = nil
+	}
+	if n := len(prompts); n > 0 && prompts[n-1] == prompt {
+		prompts = prompts[:n-1]
+	}
+	prompts = append(prompts, prompt)
+	if len(prompts) > promptHistoryLimit {
+		prompts = prompts[len(prompts)-promptHistoryLimit:]
+	}
+
+	dir := filepath.Join(projectDir, StateDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("creating state dir: %w", err)
+	}
+	data, err := json.MarshalIndent(prompts, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling prompt history: %w", err)
+	}
+	return os.WriteFile(promptHistoryPath(projectDir, branch), data, 0600)
+}
@@ -0,0 +1,81 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestPromptHistory_RecordAndLoad(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := recordPrompt(dir, "feat/x", "first"); err != nil {
+		t.Fatalf("recordPrompt: %v", err)
+	}
+	if err := recordPrompt(dir, "feat/x", "second"); err != nil {
+		t.Fatalf("recordPrompt: %v", err)
+	}
+	// Repeating the last prompt should not create a duplicate entry.
+	if err := recordPrompt(dir, "feat/x", "second"); err != nil {
+		t.Fatalf("recordPrompt: %v", err)
+	}
+
+	prompts, err := LoadPromptHistory(dir, "feat/x")
+	if err != nil {
+		t.Fatalf("LoadPromptHistory: %v", err)
+	}
+	if len(prompts) != 2 || prompts[0] != "first" || prompts[1] != "second" {
+		t.Errorf("prompts = %v, want [first second]", prompts)
+	}
+
+	last, err := LastPrompt(dir, "feat/x")
+	if err != nil {
+		t.Fatalf("LastPrompt: %v", err)
+	}
+	if last != "second" {
+		t.Errorf("LastPrompt = %q, want %q", last, "second")
+	}
+
+	info, err := os.Stat(promptHistoryPath(dir, "feat/x"))
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("history file mode = %o, want 600", info.Mode().Perm())
+	}
+}
+
+func TestPromptHistory_Limit(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < promptHistoryLimit+5; i++ {
+		if err := recordPrompt(dir, "main", fmt.Sprintf("prompt %d", i)); err != nil {
+			t.Fatalf("recordPrompt: %v", err)
+		}
+	}
+
+	prompts, err := LoadPromptHistory(dir, "main")
+	if err != nil {
+		t.Fatalf("LoadPromptHistory: %v", err)
+	}
+	if len(prompts) != promptHistoryLimit {
+		t.Fatalf("len(prompts) = %d, want %d", len(prompts), promptHistoryLimit)
+	}
+	if prompts[0] != "prompt 5" {
+		t.Errorf("oldest prompt = %q, want %q", prompts[0], "prompt 5")
+	}
+}
+
+func TestPromptHistory_Missing(t *testing.T) {
+	dir := t.TempDir()
+
+	prompts, err := LoadPromptHistory(dir, "main")
+	if err != nil {
+		t.Fatalf("LoadPromptHistory: %v", err)
+	}
+	if len(prompts) != 0 {
+		t.Errorf("prompts = %v, want empty", prompts)
+	}
+	if _, err := LastPrompt(dir, "main"); err == nil {
+		t.Error("expected error from LastPrompt with no history")
+	}
+}
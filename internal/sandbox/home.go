@@ -0,0 +1,53 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/richvanbergen/cbox/internal/docker"
+	"github.com/richvanbergen/cbox/internal/naming"
+)
+
+// ProjectBashrc is the project's optional bashrc, relative to the project
+// directory. cbox shell sources it after the container user's own ~/.bashrc.
+const ProjectBashrc = StateDir + "/bashrc"
+
+// shellHomeDir returns the host directory mounted at docker.ShellHome for a
+// sandbox. It outlives the container so shell history survives recreation,
+// and is removed by Clean.
+func shellHomeDir(projectDir, branch string) string {
+	return filepath.Join(projectDir, StateDir, "home-"+naming.SafeBranch(branch))
+}
+
+// prepareShellHome creates the sandbox's shell directory and (re)writes the
+// rc file cbox shell uses, along with a copy of the project's bashrc. It is
+// run on up and before every shell so bashrc edits apply without a restart.
+// Up hands the directory to the container user (docker.ChownShellHome), so
+// once it exists only files already in it are rewritten.
+func prepareShellHome(projectDir, branch string) (string, error) {
+	dir := shellHomeDir(projectDir, branch)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("creating shell home: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, filepath.Base(docker.ShellRCFile)), []byte(docker.ShellRC()), 0644); err != nil {
+		return "", fmt.Errorf("writing shell rc file: %w", err)
+	}
+
+	projectRC := filepath.Join(dir, filepath.Base(docker.ShellProjectRC))
+	data, err := os.ReadFile(filepath.Join(projectDir, ProjectBashrc))
+	switch {
+	case err == nil:
+		if err := os.WriteFile(projectRC, data, 0644); err != nil {
+			return "", fmt.Errorf("copying %s: %w", ProjectBashrc, err)
+		}
+	case os.IsNotExist(err):
+		// Emptied rather than removed, so it can be recreated.
+		if err := os.WriteFile(projectRC, nil, 0644); err != nil {
+			return "", fmt.Errorf("clearing %s: %w", projectRC, err)
+		}
+	default:
+		return "", fmt.Errorf("reading %s: %w", ProjectBashrc, err)
+	}
+	return dir, nil
+}
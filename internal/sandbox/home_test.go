@@ -0,0 +1,45 @@
+package sandbox
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPrepareShellHome_CopiesProjectBashrc(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, StateDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ProjectBashrc), []byte("alias t='go test ./...'\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	home, err := prepareShellHome(dir, "feat/x")
+	if err != nil {
+		t.Fatalf("prepareShellHome: %v", err)
+	}
+	if home != filepath.Join(dir, ".cbox", "home-feat-x") {
+		t.Errorf("home = %s", home)
+	}
+	if info, err := os.Stat(home); err != nil || info.Mode().Perm()&0022 != 0 {
+		t.Errorf("home = %v, %v, want it writable only by its owner", info, err)
+	}
+	rc, err := os.ReadFile(filepath.Join(home, "bashrc"))
+	if err != nil || !strings.Contains(string(rc), "HISTFILE=/home/claude/.cbox/bash_history") {
+		t.Errorf("rc file = %q, %v", rc, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(home, "project.bashrc")); err != nil || !strings.Contains(string(data), "alias t=") {
+		t.Errorf("project.bashrc = %q, %v", data, err)
+	}
+
+	// Removing the project bashrc empties the copy on the next shell.
+	os.Remove(filepath.Join(dir, ProjectBashrc))
+	if _, err := prepareShellHome(dir, "feat/x"); err != nil {
+		t.Fatalf("prepareShellHome: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(home, "project.bashrc")); err != nil || len(data) != 0 {
+		t.Errorf("stale project.bashrc = %q, %v, want empty", data, err)
+	}
+}
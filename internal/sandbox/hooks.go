@@ -0,0 +1,39 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/richvanbergen/cbox/internal/config"
+	"github.com/richvanbergen/cbox/internal/output"
+)
+
+// runHook runs a pre_up/post_up command on the host in projectDir. It is
+// attached to the terminal so hooks can prompt (e.g. to unlock secrets).
+// $Branch and $Dir are passed as environment variables for the shell to
+// expand, so a branch name or path is never parsed as shell syntax.
+func runHook(name, command, projectDir, branch, dir string) error {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = projectDir
+	cmd.Env = append(os.Environ(), "Branch="+branch, "Dir="+dir)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s hook failed: %w", name, err)
+	}
+	return nil
+}
+
+// runPostUp runs the post_up hook once the sandbox is ready. The sandbox is
+// already usable at that point, so a failure is only a warning.
+func runPostUp(cfg *config.Config, projectDir, branch, dir string) {
+	if cfg.PostUp == "" {
+		return
+	}
+	output.Progress("Running post_up hook")
+	if err := runHook("post_up", cfg.PostUp, projectDir, branch, dir); err != nil {
+		output.Warning("%v", err)
+	}
+}
@@ -0,0 +1,39 @@
+package sandbox
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunHook(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := runHook("pre_up", "echo $Branch > out.txt", dir, "main", dir); err != nil {
+		t.Fatalf("runHook: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "out.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(data)) != "main" {
+		t.Errorf("hook wrote %q, want %q", data, "main")
+	}
+
+	// A branch name is data, never shell syntax.
+	if err := runHook("pre_up", `printf '%s' "$Branch" > out.txt`, dir, "x$(touch pwned);y", dir); err != nil {
+		t.Fatalf("runHook: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pwned")); err == nil {
+		t.Error("branch name was run as a command")
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "out.txt")); string(data) != "x$(touch pwned);y" {
+		t.Errorf("hook wrote %q, want the branch name verbatim", data)
+	}
+
+	err = runHook("pre_up", "exit 3", dir, "main", dir)
+	if err == nil || !strings.Contains(err.Error(), "pre_up hook failed") {
+		t.Errorf("runHook with failing command = %v, want pre_up hook error", err)
+	}
+}
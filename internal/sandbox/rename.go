@@ -0,0 +1,160 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/richvanbergen/cbox/internal/backend"
+	"github.com/richvanbergen/cbox/internal/docker"
+	"github.com/richvanbergen/cbox/internal/naming"
+	"github.com/richvanbergen/cbox/internal/output"
+	"github.com/richvanbergen/cbox/internal/worktree"
+)
+
+// Rename moves a sandbox to a new branch name. The git branch is renamed,
+// the worktree moved to the new branch's path with its uncommitted changes,
+// and the state file and per-branch files (shell history, prompt history,
+// command logs) re-keyed. A running sandbox is brought down first and up
+// again under the new name, so its container, network and injected MCP and
+// instruction config are recreated for the new branch and worktree path.
+// The container can't just be renamed: it bind-mounts the worktree's old
+// path. The agent's conversation history is copied across instead.
+func Rename(projectDir, oldBranch, newBranch string) error {
+	state, err := LoadState(projectDir, oldBranch)
+	if err != nil {
+		return err
+	}
+	if newBranch == oldBranch {
+		return fmt.Errorf("sandbox is already named %q", newBranch)
+	}
+	if err := worktree.CheckBranchName(newBranch); err != nil {
+		return err
+	}
+	if state.WorktreePath == "" || state.WorktreePath == state.ProjectDir {
+		return fmt.Errorf("sandbox %s runs in the project directory, not a worktree — rename the branch with git instead", oldBranch)
+	}
+	if err := requireWorktree(state); err != nil {
+		return err
+	}
+	if worktree.BranchExists(projectDir, newBranch) {
+		return fmt.Errorf("branch %q already exists", newBranch)
+	}
+	sameName := naming.SafeBranch(newBranch) == naming.SafeBranch(oldBranch)
+	newPath := worktree.WorktreePath(projectDir, newBranch)
+	if !sameName {
+		if _, err := os.Stat(stateFilePath(projectDir, newBranch)); err == nil {
+			return fmt.Errorf("a sandbox for %q already exists", newBranch)
+		}
+		if _, err := os.Stat(newPath); err == nil {
+			return fmt.Errorf("%s already exists", newPath)
+		}
+	}
+	rtBackend, err := backend.Get(backend.ParseName(state.Backend))
+	if err != nil {
+		return err
+	}
+
+	wasRunning := state.Running
+	// The sandbox comes back up with the report dir it was started with.
+	opts := UpOptions{ReportDir: state.ReportDir}
+	var history []byte
+	if wasRunning {
+		output.Progress("Recreating the container under the new name, since it mounts the worktree's old path")
+		if rtBackend.Name() == backend.Claude {
+			if history, err = docker.ExportConversations(state.RuntimeContainer); err != nil {
+				output.Warning("Conversation history will not carry over: %v", err)
+			}
+		}
+		if err := Down(projectDir, oldBranch); err != nil {
+			return err
+		}
+		if state, err = LoadState(projectDir, oldBranch); err != nil {
+			return restartAfter(err, wasRunning, projectDir, oldBranch, opts, history)
+		}
+	}
+
+	output.Progress("Renaming branch %s to %s", oldBranch, newBranch)
+	if err := worktree.RenameBranch(projectDir, oldBranch, newBranch); err != nil {
+		return restartAfter(err, wasRunning, projectDir, oldBranch, opts, history)
+	}
+	oldPath := state.WorktreePath
+	if !sameName {
+		output.Progress("Moving worktree to %s", newPath)
+		if err := worktree.Move(projectDir, oldPath, newPath); err != nil {
+			worktree.RenameBranch(projectDir, newBranch, oldBranch) //nolint:errcheck — best effort
+			return restartAfter(err, wasRunning, projectDir, oldBranch, opts, history)
+		}
+		for _, p := range []struct{ from, to string }{
+			{shellHomeDir(projectDir, oldBranch), shellHomeDir(projectDir, newBranch)},
+			{promptHistoryPath(projectDir, oldBranch), promptHistoryPath(projectDir, newBranch)},
+			{mcpLogDir(projectDir, oldBranch), mcpLogDir(projectDir, newBranch)},
+		} {
+			if err := os.Rename(p.from, p.to); err != nil && !os.IsNotExist(err) {
+				output.Warning("Could not move %s: %v", p.from, err)
+			}
+		}
+		// up writes the container's .git file afresh for the new name.
+		os.Remove(filepath.Join(projectDir, StateDir, "git", naming.SafeBranch(oldBranch)+".gitfile"))
+	}
+
+	projectName := filepath.Base(projectDir)
+	state.Branch = newBranch
+	state.WorktreePath = newPath
+	state.NetworkName = docker.NetworkName(projectName, newBranch)
+	state.RuntimeContainer = rtBackend.ContainerName(projectName, newBranch)
+	if state.ShellHome != "" {
+		state.ShellHome = shellHomeDir(projectDir, newBranch)
+	}
+	if err := SaveState(projectDir, newBranch, state); err != nil {
+		if !sameName {
+			worktree.Move(projectDir, newPath, oldPath) //nolint:errcheck — best effort
+		}
+		worktree.RenameBranch(projectDir, newBranch, oldBranch) //nolint:errcheck — best effort
+		return restartAfter(fmt.Errorf("saving state: %w", err), wasRunning, projectDir, oldBranch, opts, history)
+	}
+	if !sameName {
+		RemoveState(projectDir, oldBranch)
+	}
+	output.Success("Renamed %s to %s. Worktree at %s", oldBranch, newBranch, newPath)
+
+	if !wasRunning {
+		return nil
+	}
+	return upWithHistory(projectDir, newBranch, opts, history)
+}
+
+// restartAfter brings a sandbox that Rename took down back up under its old
+// name with opts and its conversation history, after the rename failed, and
+// returns err.
+func restartAfter(err error, wasRunning bool, projectDir, branch string, opts UpOptions, history []byte) error {
+	if !wasRunning {
+		return err
+	}
+	output.Warning("Rename failed, starting %s again", branch)
+	if upErr := upWithHistory(projectDir, branch, opts, history); upErr != nil {
+		return fmt.Errorf("%w (and restarting %s failed: %v)", err, branch, upErr)
+	}
+	return err
+}
+
+// upWithHistory brings a sandbox up and restores the conversation history
+// exported from its previous container.
+func upWithHistory(projectDir, branch string, opts UpOptions, history []byte) error {
+	if err := UpWithOptions(projectDir, branch, opts); err != nil {
+		return err
+	}
+	if len(history) == 0 {
+		return nil
+	}
+	state, err := LoadState(projectDir, branch)
+	if err != nil {
+		return err
+	}
+	if err := docker.ImportConversations(state.RuntimeContainer, history); err != nil {
+		output.Warning("Conversation history did not carry over: %v", err)
+		return nil
+	}
+	output.Text("Conversation history carried over — use 'cbox chat %s --continue' to pick it up.", branch)
+	return nil
+}
@@ -0,0 +1,114 @@
+package sandbox
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/richvanbergen/cbox/internal/worktree"
+)
+
+// renameRepo creates a project repository with a worktree for branch and a
+// stopped sandbox state pointing at it.
+func renameRepo(t *testing.T, branch string) (projectDir, wtPath string) {
+	t.Helper()
+	parent, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	projectDir = filepath.Join(parent, "app")
+	for _, args := range [][]string{
+		{"init", "-q", projectDir},
+		{"-C", projectDir, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "--allow-empty", "-m", "init"},
+	} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Skipf("git %v: %v: %s", args, err, out)
+		}
+	}
+	wtPath, err = worktree.Create(projectDir, branch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := &State{
+		Backend:          "claude",
+		RuntimeContainer: "cbox-app-" + branch + "-claude",
+		NetworkName:      "cbox-app-" + branch,
+		WorktreePath:     wtPath,
+		Branch:           branch,
+		ProjectDir:       projectDir,
+	}
+	if err := SaveState(projectDir, branch, state); err != nil {
+		t.Fatal(err)
+	}
+	return projectDir, wtPath
+}
+
+func TestRename_StoppedSandbox(t *testing.T) {
+	projectDir, oldPath := renameRepo(t, "scratch")
+	if err := os.WriteFile(filepath.Join(oldPath, "wip.txt"), []byte("uncommitted"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := recordPrompt(projectDir, "scratch", "fix the tests"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Rename(projectDir, "scratch", "feature/login"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+
+	newPath := worktree.WorktreePath(projectDir, "feature/login")
+	if data, err := os.ReadFile(filepath.Join(newPath, "wip.txt")); err != nil || string(data) != "uncommitted" {
+		t.Errorf("uncommitted file in moved worktree: %q, %v", data, err)
+	}
+	if branch, _ := worktree.CurrentBranch(newPath); branch != "feature/login" {
+		t.Errorf("worktree branch = %q, want feature/login", branch)
+	}
+	if worktree.BranchExists(projectDir, "scratch") {
+		t.Error("old branch still exists")
+	}
+	if _, err := LoadState(projectDir, "scratch"); err == nil {
+		t.Error("old state file still exists")
+	}
+	state, err := LoadState(projectDir, "feature/login")
+	if err != nil {
+		t.Fatalf("LoadState(new): %v", err)
+	}
+	if state.Branch != "feature/login" || state.WorktreePath != newPath || state.NetworkName != "cbox-app-feature-login" {
+		t.Errorf("state = %+v", state)
+	}
+	if prompt, err := LastPrompt(projectDir, "feature/login"); err != nil || prompt != "fix the tests" {
+		t.Errorf("prompt history not moved: %q, %v", prompt, err)
+	}
+}
+
+func TestRename_InvalidName(t *testing.T) {
+	projectDir, oldPath := renameRepo(t, "scratch")
+
+	err := Rename(projectDir, "scratch", "a..b")
+	if err == nil || !strings.Contains(err.Error(), "not a valid branch name") {
+		t.Fatalf("Rename() = %v, want invalid name error", err)
+	}
+	if _, err := os.Stat(oldPath); err != nil {
+		t.Errorf("worktree moved despite the refusal: %v", err)
+	}
+}
+
+func TestRename_TargetBranchExists(t *testing.T) {
+	projectDir, oldPath := renameRepo(t, "scratch")
+	if out, err := exec.Command("git", "-C", projectDir, "branch", "taken").CombinedOutput(); err != nil {
+		t.Fatalf("git branch: %v: %s", err, out)
+	}
+
+	err := Rename(projectDir, "scratch", "taken")
+	if err == nil || !strings.Contains(err.Error(), `branch "taken" already exists`) {
+		t.Fatalf("Rename() = %v, want branch exists error", err)
+	}
+	if _, err := os.Stat(oldPath); err != nil {
+		t.Errorf("worktree moved despite the refusal: %v", err)
+	}
+	if _, err := LoadState(projectDir, "scratch"); err != nil {
+		t.Errorf("state lost despite the refusal: %v", err)
+	}
+}
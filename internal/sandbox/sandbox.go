@@ -2,11 +2,16 @@ package sandbox
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -14,17 +19,23 @@ import (
 	"github.com/richvanbergen/cbox/internal/backend"
 	"github.com/richvanbergen/cbox/internal/bridge"
 	"github.com/richvanbergen/cbox/internal/config"
+	"github.com/richvanbergen/cbox/internal/daemon"
 	"github.com/richvanbergen/cbox/internal/docker"
+	"github.com/richvanbergen/cbox/internal/hostcmd"
+	"github.com/richvanbergen/cbox/internal/naming"
 	"github.com/richvanbergen/cbox/internal/output"
+	"github.com/richvanbergen/cbox/internal/process"
+	"github.com/richvanbergen/cbox/internal/redact"
 	"github.com/richvanbergen/cbox/internal/serve"
 	"github.com/richvanbergen/cbox/internal/worktree"
 )
 
 // UpOptions configures optional behavior for sandbox creation.
 type UpOptions struct {
-	Rebuild    bool
-	ReportDir  string // If set, enables the cbox_report MCP tool
-	NoWorktree bool   // If true, run in the current directory without creating a worktree
+	Rebuild       bool
+	ForceRecreate bool   // Recreate the container even if it is already running
+	ReportDir     string // If set, enables the cbox_report MCP tool
+	NoWorktree    bool   // If true, run in the current directory without creating a worktree
 }
 
 // Up creates a worktree, builds the runtime image, creates a network, and starts the backend container.
@@ -35,17 +46,114 @@ func Up(projectDir, branch string, rebuild bool) error {
 
 // UpWithOptions creates a sandbox with additional options.
 func UpWithOptions(projectDir, branch string, opts UpOptions) error {
-	cfg, err := config.Load(projectDir)
+	cfg, err := config.LoadForBranch(projectDir, branch)
 	if err != nil {
 		return err
 	}
+	if err := docker.CheckExtraRunArgs(cfg.DockerRunArgs); err != nil {
+		return fmt.Errorf("%w: docker_run_args: %v", config.ErrInvalid, err)
+	}
+	gpus, err := docker.GPUsFlag(cfg.GPUs)
+	if err != nil {
+		return fmt.Errorf("%w: %v", config.ErrInvalid, err)
+	}
+	if err := cfg.Resources.Check(); err != nil {
+		return fmt.Errorf("%w: %v", config.ErrInvalid, err)
+	}
+	if err := config.CheckMCPServers(cfg.MCPServers); err != nil {
+		return fmt.Errorf("%w: %v", config.ErrInvalid, err)
+	}
 	rtBackend, err := backend.Get(backend.ParseName(cfg.Backend))
 	if err != nil {
 		return err
 	}
 
+	if err := checkNameCollision(projectDir, branch); err != nil {
+		return err
+	}
+
 	projectName := filepath.Base(projectDir)
 
+	// $Dir in hooks is the directory the sandbox mounts; for pre_up the
+	// worktree may not exist yet.
+	hookDir := worktree.WorktreePath(projectDir, branch)
+	if current, _ := worktree.CurrentBranch(projectDir); opts.NoWorktree || branch == current {
+		hookDir = projectDir
+	}
+	if cfg.PreUp != "" {
+		output.Progress("Running pre_up hook")
+		if err := runHook("pre_up", cfg.PreUp, projectDir, branch, hookDir); err != nil {
+			return err
+		}
+	}
+
+	// Fast path: leave an already-running, up-to-date container alone so
+	// re-running up doesn't interrupt a live session.
+	if !opts.Rebuild && !opts.ForceRecreate {
+		if state, ok := reusableState(projectDir, branch); ok {
+			// States written before the fingerprint existed can't be
+			// compared, so they are trusted.
+			if state.RunConfig == "" || state.RunConfig == runConfigHash(cfg) {
+				resolveSupervised(projectDir, state)
+				refreshRunning(state, cfg, rtBackend)
+				if err := SaveState(projectDir, branch, state); err != nil {
+					output.Warning("Could not save state: %v", err)
+				}
+				runPostUp(cfg, projectDir, branch, hookDir)
+				output.Success("Sandbox already running. Use 'cbox chat %s' to start %s, or 'cbox up --force-recreate' to recreate it.", branch, rtBackend.DisplayName())
+				return nil
+			}
+			output.Progress("Container settings in cbox.toml have changed, recreating the container")
+		}
+	}
+
+	// With daemon = true, make sure a supervisor is running so the proxies
+	// started below are restarted if they die. Failing that, fall back to
+	// standalone processes.
+	if cfg.Daemon {
+		if err := daemon.Start(projectDir); err != nil {
+			output.Warning("Could not start daemon, proxies will run standalone: %v", err)
+		}
+	}
+
+	// Resolve env_commands up front so a locked password manager fails fast,
+	// before any resources are created.
+	var secretEnv map[string]string
+	if len(cfg.EnvCommands) > 0 {
+		output.Progress("Resolving env_commands")
+		secretEnv, err = resolveEnvCommands(cfg.EnvCommands, projectDir)
+		if err != nil {
+			return err
+		}
+		redactSecrets(secretEnv)
+	}
+
+	// A previous up may have left a container and processes behind (a
+	// stale state, or --force-recreate). Tear them down the same way down
+	// does, so a workspace volume's edits are synced back before its
+	// container goes.
+	runtimeContainerName := rtBackend.ContainerName(projectName, branch)
+	var prevNetwork, prevWorktree string
+	if prev, err := LoadState(projectDir, branch); err == nil {
+		prevNetwork, prevWorktree = prev.NetworkName, prev.WorktreePath
+		if status, _ := docker.ContainerStatus(prev.RuntimeContainer); prev.Running || status != "" {
+			stopRuntime(prev, projectDir, output.Progress, output.Warning)
+		}
+	}
+	// A workspace volume that outlived its container holds edits that
+	// could not be synced back; filling it from the worktree would
+	// overwrite them.
+	if cfg.Remote {
+		volume := docker.WorkspaceVolumeName(runtimeContainerName)
+		exists, err := docker.VolumeExists(volume)
+		if err != nil {
+			return dockerErr(err)
+		}
+		if exists {
+			return fmt.Errorf("workspace volume %s from an earlier run still exists and may hold edits that were not synced back — copy them out, then remove it with 'docker volume rm %s'", volume, volume)
+		}
+	}
+
 	// Capture the current branch as the source before any worktree operations.
 	sourceBranch, _ := worktree.CurrentBranch(projectDir)
 
@@ -63,24 +171,31 @@ func UpWithOptions(projectDir, branch string, opts UpOptions) error {
 		output.Progress("Starting sandbox for branch '%s' (no worktree)", branch)
 	} else {
 		output.Progress("Preparing worktree for branch '%s'", branch)
-		var err error
-		wtPath, err = worktree.Create(projectDir, branch)
-		if err != nil {
+		if info, err := os.Stat(prevWorktree); err == nil && info.IsDir() && prevWorktree != projectDir {
+			// Keep using a worktree created under an earlier naming scheme.
+			wtPath = prevWorktree
+		} else if wtPath, err = worktree.Create(projectDir, branch); err != nil {
 			return fmt.Errorf("creating worktree: %w", err)
 		}
 		worktreePath = wtPath
+		// Create reuses any existing directory, so make sure it is still a
+		// git worktree before it gets mounted into the container.
+		if err := worktree.Verify(wtPath); err != nil {
+			return fmt.Errorf("%w: %v — run 'cbox clean %s' then 'cbox up %s'", ErrWorktreeMissing, err, branch, branch)
+		}
 		output.Success("Worktree ready at %s", wtPath)
 
 		// Copy configured files into the new worktree
 		if len(cfg.CopyFiles) > 0 {
 			output.Progress("Copying files to worktree")
-			if err := worktree.CopyFiles(projectDir, wtPath, cfg.CopyFiles); err != nil {
+			copyOpts := worktree.CopyOptions{Ignore: worktree.NewIgnore(cfg.Ignore)}
+			if err := worktree.CopyFilesWithOptions(projectDir, wtPath, cfg.CopyFiles, copyOpts); err != nil {
 				return fmt.Errorf("copying files to worktree: %w", err)
 			}
 		}
 	}
 
-	safeBranch := strings.ReplaceAll(branch, "/", "-")
+	safeBranch := naming.SafeBranch(branch)
 
 	// Set up git mounts so the worktree link resolves inside the container.
 	// A worktree's .git file contains a gitdir reference using an absolute
@@ -106,12 +221,43 @@ func UpWithOptions(projectDir, branch string, opts UpOptions) error {
 
 	// 2. Create Docker network early so it's available for $Network in serve commands.
 	networkName := docker.NetworkName(projectName, branch)
-	output.Progress("Creating network %s", networkName)
-	if err := docker.CreateNetwork(networkName); err != nil {
-		return fmt.Errorf("creating network: %w", err)
+	if prevNetwork != "" && prevNetwork != networkName {
+		docker.RemoveOwnedNetwork(prevNetwork) // named under an earlier scheme
+	}
+	if err := step("Creating network "+networkName, func(func(string)) error {
+		return docker.CreateNetworkWithOptions(networkName, docker.NetworkOptions{
+			Project:  projectDir,
+			Branch:   branch,
+			Internal: cfg.EgressDenied(),
+			Owned:    networkName == prevNetwork,
+		})
+	}); err != nil {
+		return fmt.Errorf("creating network: %w", dockerErr(err))
 	}
 	cleanup.addNetwork(networkName)
 
+	// With egress denied the network has no route out; an egress proxy on
+	// both networks lets through only the allowed hosts.
+	var egressProxy string
+	if cfg.EgressDenied() {
+		egressProxy = docker.EgressProxyName(networkName)
+		output.Progress("Starting egress proxy %s", egressProxy)
+		if err := docker.StartEgressProxy(docker.EgressProxyOptions{
+			Name:       egressProxy,
+			Network:    networkName,
+			Image:      cfg.Network.ProxyImage,
+			AllowHosts: cfg.Network.AllowHosts,
+			Labels:     docker.ResourceLabels(projectDir, branch),
+		}); err != nil {
+			cleanup.run()
+			return fmt.Errorf("starting egress proxy: %w", dockerErr(err))
+		}
+		cleanup.addContainer(egressProxy)
+		if len(cfg.Ports) > 0 {
+			output.Warning("ports are not published from a network with egress denied")
+		}
+	}
+
 	// 3. Start serve process and Traefik proxy if [serve] is configured.
 	//    This runs early so a broken serve command fails fast before we spend
 	//    time building images and creating containers.
@@ -143,56 +289,63 @@ func UpWithOptions(projectDir, branch string, opts UpOptions) error {
 		cleanup.addProcess(servePID)
 		output.Text("  Serve process listening on port %d (log: .cbox/serve.log)", servePort)
 
-		proxyPort := cfg.Serve.ProxyPort
-		if proxyPort <= 0 {
-			proxyPort = 80
-		}
 		output.Progress("Ensuring Traefik proxy is running")
-		if err := serve.EnsureTraefik(projectDir, projectName, proxyPort); err != nil {
-			cleanup.run()
-			return fmt.Errorf("starting traefik: %w", err)
-		}
-
-		// When container-based routing is configured, connect both Traefik and
-		// the app container to the branch network so they can communicate.
-		var containerHost string
-		if cfg.Serve.Container != "" {
-			traefikName := serve.TraefikContainerName(projectName)
-			docker.NetworkConnect(networkName, traefikName)
-			// Derive the devcontainer name: <worktree-basename>_devcontainer-<service>-1
-			wtBase := filepath.Base(wtPath)
-			containerHost = wtBase + "_devcontainer-" + cfg.Serve.Container + "-1"
-			docker.NetworkConnect(networkName, containerHost)
-		}
-
-		if err := serve.AddRoute(projectDir, safeBranch, projectName, servePort, containerHost); err != nil {
-			cleanup.run()
-			return fmt.Errorf("adding traefik route: %w", err)
-		}
-		cleanup.addTraefikRoute(projectDir, safeBranch)
-		if proxyPort == 80 {
-			serveURL = fmt.Sprintf("http://%s.%s.dev.localhost", safeBranch, projectName)
+		proxyPort, err := ensureTraefik(projectDir, projectName, cfg.Serve)
+		if err != nil {
+			// Without Traefik the serve process is unreachable, so stop it
+			// and carry on without serve rather than failing the sandbox.
+			stopProcess(servePID)
+			cleanup.removeProcess(servePID)
+			servePID, servePort = 0, 0
+			output.Warning("Traefik could not start, serve is disabled: %v", err)
 		} else {
-			serveURL = fmt.Sprintf("http://%s.%s.dev.localhost:%d", safeBranch, projectName, proxyPort)
+			// When container-based routing is configured, connect both Traefik and
+			// the app container to the branch network so they can communicate.
+			var containerHost string
+			if cfg.Serve.Container != "" {
+				traefikName := serve.TraefikContainerName(projectName)
+				docker.NetworkConnect(networkName, traefikName)
+				// Derive the devcontainer name: <worktree-basename>_devcontainer-<service>-1
+				wtBase := filepath.Base(wtPath)
+				containerHost = wtBase + "_devcontainer-" + cfg.Serve.Container + "-1"
+				docker.NetworkConnect(networkName, containerHost)
+			}
+
+			if err := serve.AddRoute(projectDir, safeBranch, projectName, servePort, containerHost); err != nil {
+				cleanup.run()
+				return fmt.Errorf("adding traefik route: %w", err)
+			}
+			cleanup.addTraefikRoute(projectDir, safeBranch)
+			serveURL = serveURLFor(safeBranch, projectName, proxyPort)
+			output.Success("Serve URL: %s", serveURL)
 		}
-		output.Success("Serve URL: %s", serveURL)
 	}
 
 	// 4. Build runtime image
-	output.Progress("Building %s image", rtBackend.DisplayName())
-	buildOpts := docker.BuildOptions{NoCache: opts.Rebuild}
+	buildOpts := docker.BuildOptions{
+		NoCache: opts.Rebuild,
+		Labels:  map[string]string{docker.ProjectLabel: projectName},
+	}
+	if cfg.ClaudeVersion != "" {
+		buildOpts.BuildArgs = map[string]string{"CLAUDE_VERSION": cfg.ClaudeVersion}
+	}
 	if cfg.Dockerfile != "" {
 		buildOpts.ProjectDockerfile = filepath.Join(projectDir, cfg.Dockerfile)
 	}
-	runtimeImage, err := rtBackend.BuildImage(projectName, buildOpts)
+	var runtimeImage string
+	err = step("Building "+rtBackend.DisplayName()+" image", func(progress func(string)) error {
+		buildOpts.Progress = progress
+		image, err := rtBackend.BuildImage(projectName, buildOpts)
+		runtimeImage = image
+		return err
+	})
 	if err != nil {
 		cleanup.run()
-		return fmt.Errorf("building %s image: %w", rtBackend.Name(), err)
+		return fmt.Errorf("building %s image: %w", rtBackend.Name(), dockerErr(err))
 	}
 	output.Success("Built %s image %s", rtBackend.DisplayName(), runtimeImage)
 
-	// 5. Stop/remove existing backend container
-	runtimeContainerName := rtBackend.ContainerName(projectName, branch)
+	// 5. Stop/remove a container that no state file knew about
 	docker.StopAndRemove(runtimeContainerName)
 
 	// 6. Resolve env file path
@@ -227,7 +380,19 @@ func UpWithOptions(projectDir, branch string, opts UpOptions) error {
 	var mcpPID, mcpPort int
 	if len(cfg.HostCommands) > 0 || len(cfg.Commands) > 0 {
 		output.Progress("Starting MCP host command server")
-		mcpPID, mcpPort, err = startMCPProxy(projectDir, wtPath, branch, cfg.HostCommands, cfg.Commands, opts.ReportDir, servePort, time.Duration(cfg.CommandTimeout)*time.Second)
+		// Restart revives the proxy from state, possibly from another
+		// directory, so record where reports go as an absolute path.
+		if opts.ReportDir != "" {
+			if abs, err := filepath.Abs(opts.ReportDir); err == nil {
+				opts.ReportDir = abs
+			}
+		}
+		for _, name := range cfg.InContainer {
+			if _, ok := cfg.Commands[name]; !ok {
+				output.Warning("container_commands lists %q, which is not in [commands]", name)
+			}
+		}
+		mcpPID, mcpPort, err = startMCPProxy(projectDir, wtPath, branch, runtimeContainerName, cfg, opts.ReportDir, servePort, 0)
 		if err != nil {
 			output.Warning("MCP host command server failed: %v", err)
 		} else {
@@ -244,21 +409,47 @@ func UpWithOptions(projectDir, branch string, opts UpOptions) error {
 		NetworkName:    networkName,
 		GitMounts:      gitMounts,
 		EnvVars:        cfg.Env,
+		SecretEnv:      secretEnv,
 		EnvFile:        envFile,
 		BridgeMappings: bridgeMappings,
 		Ports:          cfg.Ports,
 		HostCommands:   cfg.HostCommands,
 		Commands:       cfg.Commands,
 		MCPPort:        mcpPort,
+		MCPServers:     mcpServers(cfg),
+		DockerRunArgs:  cfg.DockerRunArgs,
+		GPUs:           gpus,
+		Resources:      resourceLimits(cfg),
+	}
+	if egressProxy != "" {
+		runtimeSpec.ExtraEnv = docker.EgressProxyEnv(egressProxy)
+		runtimeSpec.EgressDenied = true
+		runtimeSpec.AllowHosts = cfg.Network.AllowHosts
+	}
+	if cfg.Remote {
+		runtimeSpec.WorkspaceVolume = docker.WorkspaceVolumeName(runtimeContainerName)
+	} else if home, err := prepareShellHome(projectDir, branch); err != nil {
+		output.Warning("Shell history will not persist: %v", err)
+	} else {
+		runtimeSpec.ShellHome = home
 	}
 	// 9. Start runtime container
-	output.Progress("Starting %s container %s", rtBackend.DisplayName(), runtimeContainerName)
-	runtimeContainerName, err = rtBackend.RunContainer(runtimeSpec, runtimeImage)
+	err = step(fmt.Sprintf("Starting %s container %s", rtBackend.DisplayName(), runtimeContainerName), func(progress func(string)) error {
+		runtimeSpec.Progress = progress
+		name, err := rtBackend.RunContainer(runtimeSpec, runtimeImage)
+		runtimeContainerName = name
+		return err
+	})
 	if err != nil {
 		cleanup.run()
-		return fmt.Errorf("starting %s container: %w", rtBackend.Name(), err)
+		return fmt.Errorf("starting %s container: %w", rtBackend.Name(), dockerErr(err))
 	}
 	cleanup.addContainer(runtimeContainerName)
+	if runtimeSpec.ShellHome != "" {
+		if err := docker.ChownShellHome(runtimeContainerName); err != nil {
+			output.Warning("Shell history will not persist: %v", err)
+		}
+	}
 
 	// 10. Inject backend instructions when required after startup.
 	output.Progress("Injecting %s instructions", rtBackend.DisplayName())
@@ -266,13 +457,39 @@ func UpWithOptions(projectDir, branch string, opts UpOptions) error {
 		output.Warning("Could not inject backend instructions: %v", err)
 	}
 
+	// A remote daemon has no access to the worktree, so copy it into the
+	// workspace volume. This runs after InjectInstructions because some
+	// backends write their instructions into the worktree.
+	if runtimeSpec.WorkspaceVolume != "" {
+		cleanup.addVolume(runtimeSpec.WorkspaceVolume)
+		output.Progress("Syncing worktree into volume %s", runtimeSpec.WorkspaceVolume)
+		if err := docker.SyncToContainerWithOptions(runtimeContainerName, wtPath, workspaceSyncOptions(cfg)); err != nil {
+			cleanup.run()
+			return fmt.Errorf("syncing worktree: %w", dockerErr(err))
+		}
+		if err := pushGit(runtimeContainerName, wtPath); err != nil {
+			output.Warning("git will not work in the container: %v", err)
+		}
+	}
+
 	// 11. Register MCP config inside the runtime when needed
-	if mcpPort > 0 {
+	if mcpPort > 0 || len(runtimeSpec.MCPServers) > 0 {
 		output.Progress("Registering MCP config for %s", rtBackend.DisplayName())
-		if err := rtBackend.RegisterMCP(runtimeContainerName, mcpPort); err != nil {
+		if err := rtBackend.RegisterMCP(runtimeContainerName, mcpPort, runtimeSpec.MCPServers); err != nil {
 			output.Warning("Could not inject MCP config: %v", err)
 		}
 	}
+	var mcpProbeError string
+	if mcpPort > 0 {
+		mcpProbeError = probeMCP(runtimeContainerName, mcpPort)
+	}
+
+	// Record the agent CLI version so bug reports can tell cbox changes
+	// apart from changes in the CLI baked into the image.
+	agentVersion, err := rtBackend.Version(runtimeContainerName)
+	if err != nil {
+		output.Warning("Could not read %s version: %v", rtBackend.DisplayName(), err)
+	}
 
 	// 12. Save state — all resources created successfully, disarm rollback
 	state := &State{
@@ -283,6 +500,7 @@ func UpWithOptions(projectDir, branch string, opts UpOptions) error {
 		Branch:           branch,
 		SourceBranch:     sourceBranch,
 		RuntimeImage:     runtimeImage,
+		AgentVersion:     agentVersion,
 		ProjectDir:       projectDir,
 		Running:          true,
 		Ports:            cfg.Ports,
@@ -290,9 +508,20 @@ func UpWithOptions(projectDir, branch string, opts UpOptions) error {
 		BridgeMappings:   bridgeMappings,
 		MCPProxyPID:      mcpPID,
 		MCPProxyPort:     mcpPort,
+		MCPProbeError:    mcpProbeError,
+		ReportDir:        opts.ReportDir,
 		ServePID:         servePID,
 		ServePort:        servePort,
 		ServeURL:         serveURL,
+		WorkspaceVolume:  runtimeSpec.WorkspaceVolume,
+		ShellHome:        runtimeSpec.ShellHome,
+		EgressProxy:      egressProxy,
+		AllowHosts:       runtimeSpec.AllowHosts,
+		GPUs:             gpus,
+		RunConfig:        runConfigHash(cfg),
+	}
+	if !runtimeSpec.Resources.IsZero() {
+		state.Resources = &runtimeSpec.Resources
 	}
 	if err := SaveState(projectDir, branch, state); err != nil {
 		cleanup.run()
@@ -300,39 +529,206 @@ func UpWithOptions(projectDir, branch string, opts UpOptions) error {
 	}
 	cleanup.disarm()
 
+	// Hand the proxies to the daemon, if one is running. This happens only
+	// once startup has succeeded so rollback never races a restart.
+	if mcpPID > 0 {
+		args, _ := mcpProxyArgs(projectDir, wtPath, branch, runtimeContainerName, cfg, opts.ReportDir, servePort)
+		args = append(args, "--port", fmt.Sprintf("%d", mcpPort))
+		supervise(projectDir, mcpEntryName(branch), mcpPID, args, filepath.Join(mcpLogDir(projectDir, branch), "mcp-proxy.log"))
+	}
+	if servePID > 0 {
+		superviseServe(projectDir, branch, cfg.Serve.Command, servePID, servePort, wtPath, networkName)
+	}
+
+	runPostUp(cfg, projectDir, branch, hookDir)
+
 	output.Success("Sandbox is running! Use 'cbox chat %s' to start %s.", branch, rtBackend.DisplayName())
 	return nil
 }
 
-// Down stops the container and removes the network.
-func Down(projectDir, branch string) error {
+// reusableState returns the saved state for branch when its container is
+// running and was created from the current build of its image.
+func reusableState(projectDir, branch string) (*State, bool) {
 	state, err := LoadState(projectDir, branch)
+	if err != nil || !state.Running || state.RuntimeImage == "" {
+		return nil, false
+	}
+	if running, err := docker.IsRunning(state.RuntimeContainer); err != nil || !running {
+		return nil, false
+	}
+	containerImage, err := docker.ContainerImageID(state.RuntimeContainer)
 	if err != nil {
-		return err
+		return nil, false
+	}
+	currentImage, err := docker.ImageID(state.RuntimeImage)
+	if err != nil || currentImage != containerImage {
+		return nil, false
 	}
+	return state, true
+}
 
-	// Stop bridge proxy if running
-	if state.BridgeProxyPID > 0 {
-		output.Progress("Stopping Chrome bridge proxy")
-		stopBridgeProxy(state.BridgeProxyPID)
+// step runs one stage of up. On a terminal it runs under a spinner, with
+// progress showing the latest line of docker's output beside it; otherwise
+// it prints msg and progress is nil, so docker's output streams as usual.
+func step(msg string, fn func(progress func(line string)) error) error {
+	if !output.Interactive() {
+		output.Progress("%s", msg)
+		return fn(nil)
 	}
+	return output.SpinWithStatus(msg, func(s *output.SpinStatus) error {
+		return fn(func(line string) { s.Update("%s", line) })
+	})
+}
+
+// runConfigHash fingerprints the settings that are fixed when the runtime
+// container and its MCP server are started, so a running container can be
+// checked against the current config. The MCP server's command set and
+// limits count, since the instructions refreshed into a running container
+// describe the commands in cbox.toml, not the ones the server serves.
+func runConfigHash(cfg *config.Config) string {
+	data, _ := json.Marshal(struct {
+		Backend         string
+		Env             []string
+		EnvCommands     map[string]string
+		EnvFile         string
+		Browser         bool
+		Ports           []string
+		DockerRunArgs   []string
+		GPUs            string
+		Remote          bool
+		Network         *config.NetworkConfig
+		Resources       *config.ResourcesConfig
+		Commands        map[string]string
+		HostCommands    []string
+		InContainer     []string
+		CommandTimeout  int
+		CommandTimeouts map[string]int
+		MaxConcurrent   int
+	}{
+		string(backend.ParseName(cfg.Backend)), cfg.Env, cfg.EnvCommands, cfg.EnvFile, cfg.Browser, cfg.Ports, cfg.DockerRunArgs, cfg.GPUs, cfg.Remote, cfg.Network, cfg.Resources,
+		cfg.Commands, cfg.HostCommands, cfg.InContainer, cfg.CommandTimeout, cfg.CommandTimeouts, cfg.MaxConcurrent,
+	})
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("%x", sum[:8])
+}
 
-	// Stop MCP proxy if running
-	if state.MCPProxyPID > 0 {
-		output.Progress("Stopping MCP host command server")
-		stopProcess(state.MCPProxyPID)
+// refreshRunning re-injects backend instructions and MCP config into a
+// running container, since they may have been lost or changed since it
+// started. Failures are warnings: the sandbox itself is still usable.
+func refreshRunning(state *State, cfg *config.Config, rtBackend backend.Backend) {
+	spec := backend.RuntimeSpec{
+		ProjectDir:     state.ProjectDir,
+		ProjectName:    filepath.Base(state.ProjectDir),
+		Branch:         state.Branch,
+		WorktreePath:   state.WorktreePath,
+		NetworkName:    state.NetworkName,
+		EnvVars:        cfg.Env,
+		BridgeMappings: state.BridgeMappings,
+		Ports:          state.Ports,
+		HostCommands:   cfg.HostCommands,
+		Commands:       cfg.Commands,
+		MCPPort:        state.MCPProxyPort,
+		// The egress and GPU notes describe the container as created,
+		// not cbox.toml as it is now.
+		EgressDenied: state.EgressProxy != "",
+		AllowHosts:   state.AllowHosts,
+		GPUs:         state.GPUs,
+	}
+	if state.RunConfig == "" {
+		// Written before these were recorded.
+		spec.GPUs, _ = docker.GPUsFlag(cfg.GPUs)
+		if spec.EgressDenied && cfg.Network != nil {
+			spec.AllowHosts = cfg.Network.AllowHosts
+		}
+	}
+	if err := rtBackend.InjectInstructions(state.RuntimeContainer, spec); err != nil {
+		output.Warning("Could not inject backend instructions: %v", err)
 	}
 
-	// Stop serve process and clean up Traefik route
-	stopServe(state, projectDir)
+	mcpPort := state.MCPProxyPort
+	if mcpPort > 0 && !process.Alive(state.MCPProxyPID) {
+		output.Warning("MCP host command server (PID %d) is not running — use 'cbox up --force-recreate' to restart it", state.MCPProxyPID)
+		mcpPort = 0
+	}
+	if servers := mcpServers(cfg); mcpPort > 0 || len(servers) > 0 {
+		if err := rtBackend.RegisterMCP(state.RuntimeContainer, mcpPort, servers); err != nil {
+			output.Warning("Could not inject MCP config: %v", err)
+		}
+	}
+	if mcpPort > 0 {
+		state.MCPProbeError = probeMCP(state.RuntimeContainer, mcpPort)
+	}
+}
+
+// mcpServers converts the mcp_servers config for the backend.
+func mcpServers(cfg *config.Config) []docker.MCPServer {
+	var servers []docker.MCPServer
+	for _, s := range cfg.MCPServers {
+		servers = append(servers, docker.MCPServer{
+			Name:      s.Name,
+			Transport: s.Transport,
+			URL:       s.URL,
+			Headers:   expandHeaders(s.Headers),
+		})
+	}
+	return servers
+}
 
-	output.Progress("Stopping container %s", state.RuntimeContainer)
-	if err := docker.StopAndRemove(state.RuntimeContainer); err != nil {
-		output.Warning("Could not remove container: %v", err)
+// expandHeaders expands environment references in MCP server headers. The
+// config keeps them unexpanded so tokens only exist in the registration.
+func expandHeaders(headers map[string]string) map[string]string {
+	if len(headers) == 0 {
+		return nil
 	}
+	expanded := make(map[string]string, len(headers))
+	for k, v := range headers {
+		expanded[k] = config.ExpandEnv(v)
+	}
+	return expanded
+}
 
-	output.Progress("Removing network %s", state.NetworkName)
-	docker.RemoveNetwork(state.NetworkName)
+// resourceLimits converts the [resources] config for the backend.
+func resourceLimits(cfg *config.Config) docker.Resources {
+	r := cfg.Resources
+	if r == nil {
+		return docker.Resources{}
+	}
+	return docker.Resources{Memory: r.Memory, CPUs: r.CPUs, PidsLimit: r.PidsLimit}
+}
+
+// probeMCP checks that the container can reach the host MCP server and
+// explains the likely causes if it can't. It returns the failure, or "" when
+// the server is reachable, for recording in state.
+func probeMCP(container string, port int) string {
+	err := docker.ProbeHost(container, port, "/healthz")
+	if err == nil {
+		return ""
+	}
+	if errors.Is(err, docker.ErrNoProbeTool) {
+		output.Warning("Skipped checking the host MCP server: %v.", err)
+		return ""
+	}
+	output.Warning("The container can't reach the host MCP server (%v), so host commands will not work.", err)
+	output.Text("  Check that a host firewall isn't blocking port %d from the docker network.", port)
+	return err.Error()
+}
+
+// Down stops the container and removes the network.
+func Down(projectDir, branch string) error {
+	return down(projectDir, branch, output.Progress, output.Warning, output.Success)
+}
+
+func down(projectDir, branch string, progress, warning, success func(string, ...any)) error {
+	state, err := LoadState(projectDir, branch)
+	if err != nil {
+		return err
+	}
+
+	// Stop host processes, serve, and the container concurrently
+	stopRuntime(state, projectDir, progress, warning)
+
+	progress("Removing network %s", state.NetworkName)
+	docker.RemoveOwnedNetwork(state.NetworkName)
 
 	// Mark as not running but preserve state so `clean` can still find the worktree
 	state.Running = false
@@ -341,91 +737,689 @@ func Down(projectDir, branch string) error {
 	state.BridgeMappings = nil
 	state.MCPProxyPID = 0
 	state.MCPProxyPort = 0
+	state.MCPProbeError = ""
 	state.ServePID = 0
 	state.ServePort = 0
 	state.ServeURL = ""
+	state.EgressProxy = ""
 	if err := SaveState(projectDir, branch, state); err != nil {
 		return fmt.Errorf("saving state: %w", err)
 	}
 
-	output.Success("Container stopped. Worktree preserved at %s", state.WorktreePath)
+	success("Container stopped. Worktree preserved at %s", state.WorktreePath)
 	return nil
 }
 
-// Chat launches the configured backend interactively in the runtime container.
-func Chat(projectDir, branch string, chrome bool, initialPrompt string, resume bool) error {
+// Restart restarts a sandbox's runtime container in place, without
+// rebuilding the image or touching the worktree. docker restart keeps the
+// container's filesystem, so the conversation history survives. Proxies
+// that have died are started again on their previous ports, and the
+// backend's instructions and MCP config are re-injected. If the container
+// settings in cbox.toml have changed since it was created, the container is
+// recreated as up would, with the conversation history copied across.
+func Restart(projectDir, branch string) error {
 	state, err := LoadState(projectDir, branch)
 	if err != nil {
 		return err
 	}
+	if err := requireWorktree(state); err != nil {
+		return err
+	}
+	status, err := docker.ContainerStatus(state.RuntimeContainer)
+	if err != nil {
+		return err
+	}
+	// Recreating keeps the sandbox where it is: in the project dir if
+	// it was started there, with its report dir.
+	opts := UpOptions{
+		ReportDir:  state.ReportDir,
+		NoWorktree: state.WorktreePath == projectDir,
+	}
+	// docker restart also starts a stopped container. One that is gone is
+	// recreated, unless down removed it on purpose.
+	switch {
+	case status == "" && !state.Running:
+		return fmt.Errorf("%w: %s — start it with 'cbox up %s'", ErrContainerNotRunning, state.RuntimeContainer, branch)
+	case status == "":
+		output.Progress("Container %s no longer exists, recreating it", state.RuntimeContainer)
+		return UpWithOptions(projectDir, branch, opts)
+	}
+	state.Running = true
+	cfg, err := config.LoadForBranch(projectDir, branch)
+	if err != nil {
+		return err
+	}
 	rtBackend, err := backend.Get(backend.ParseName(state.Backend))
 	if err != nil {
 		return err
 	}
-	return rtBackend.Chat(state.RuntimeContainer, backend.ChatOptions{
+
+	if state.RunConfig != "" && state.RunConfig != runConfigHash(cfg) {
+		output.Progress("Container settings in cbox.toml have changed, recreating the container")
+		var history []byte
+		if rtBackend.Name() == backend.Claude {
+			if history, err = docker.ExportConversations(state.RuntimeContainer); err != nil {
+				output.Warning("Conversation history will not carry over: %v", err)
+			}
+		}
+		return upWithHistory(projectDir, branch, opts, history)
+	}
+
+	output.Progress("Restarting container %s", state.RuntimeContainer)
+	if err := docker.Restart(state.RuntimeContainer); err != nil {
+		return err
+	}
+
+	// A running daemon restarts the proxies itself, so only revive them
+	// when there is none; otherwise record the PIDs it runs them under.
+	supervised := daemon.Running(projectDir)
+	if supervised {
+		resolveSupervised(projectDir, state)
+	}
+	if state.MCPProxyPort > 0 && !supervised && !process.Alive(state.MCPProxyPID) {
+		resolveSecretsForProxies(cfg, projectDir)
+		output.Progress("Restarting MCP host command server")
+		pid, port, err := startMCPProxy(projectDir, state.WorktreePath, branch, state.RuntimeContainer, cfg, state.ReportDir, state.ServePort, state.MCPProxyPort)
+		if err != nil {
+			output.Warning("MCP host command server failed: %v", err)
+		} else {
+			state.MCPProxyPID, state.MCPProxyPort = pid, port
+			output.Text("  MCP server listening on port %d", port)
+		}
+	}
+	// The bridge's ports are baked into the container's environment and
+	// can't be chosen, so a new bridge would be unreachable.
+	if state.BridgeProxyPID > 0 && !process.Alive(state.BridgeProxyPID) {
+		output.Warning("Chrome bridge proxy (PID %d) is not running — use 'cbox up --force-recreate' to restart it", state.BridgeProxyPID)
+	}
+
+	refreshRunning(state, cfg, rtBackend)
+	if err := SaveState(projectDir, branch, state); err != nil {
+		return fmt.Errorf("saving state: %w", err)
+	}
+
+	if state.ServePID > 0 && !supervised && !process.Alive(state.ServePID) {
+		if err := ServeRestart(projectDir, branch); err != nil {
+			output.Warning("Could not restart the serve process: %v", err)
+		}
+	}
+
+	output.Success("Container %s restarted.", state.RuntimeContainer)
+	if has, err := rtBackend.HasConversationHistory(state.RuntimeContainer); err == nil && has {
+		output.Text("Conversation history preserved — use 'cbox chat %s --continue' to pick it up.", branch)
+	}
+	return nil
+}
+
+// ChatOptions configures optional behavior for interactive chat sessions.
+type ChatOptions struct {
+	Chrome        bool
+	InitialPrompt string
+	Resume        bool
+	Model         string // Model override (defaults to model config)
+	Dir           string // Subdirectory of the worktree to start in (defaults to chat_dir config)
+	Force         bool   // Join the session even if another terminal is attached to it
+}
+
+// Chat launches the configured backend interactively in the runtime container.
+func Chat(projectDir, branch string, chrome bool, initialPrompt string, resume bool) error {
+	return ChatWithOptions(projectDir, branch, ChatOptions{
 		Chrome:        chrome,
 		InitialPrompt: initialPrompt,
 		Resume:        resume,
 	})
 }
 
+// ChatWithOptions launches the configured backend interactively with additional options.
+func ChatWithOptions(projectDir, branch string, opts ChatOptions) error {
+	state, err := LoadState(projectDir, branch)
+	if err != nil {
+		return err
+	}
+	if err := requireRunning(state); err != nil {
+		return err
+	}
+	if err := requireWorktree(state); err != nil {
+		return err
+	}
+	rtBackend, err := backend.Get(backend.ParseName(state.Backend))
+	if err != nil {
+		return err
+	}
+	// Chats share one tmux session, so a second chat joins the first instead
+	// of starting another agent. Rejoining a detached session is the normal
+	// way back in; joining one another terminal is typing into needs Force.
+	if running, clients := rtBackend.ChatSessionClients(state.RuntimeContainer); running {
+		if clients > 0 && !opts.Force {
+			return fmt.Errorf("%w in %s (%d terminal(s) attached) — watch it with 'cbox attach %s --read-only', or pass --force to join it",
+				ErrChatInUse, state.RuntimeContainer, clients, branch)
+		}
+		// ExecInSession says it is attaching rather than starting.
+		if opts.Resume || opts.InitialPrompt != "" || opts.Model != "" || opts.Dir != "" {
+			output.Warning("The running session keeps its own settings; --continue, --model, --dir and the initial prompt are ignored")
+		}
+	}
+	// A recreated container has no conversation to continue, and resuming
+	// would open a blank session, so start a fresh one with the initial
+	// prompt instead. If history can't be checked, resume as asked.
+	if opts.Resume {
+		if has, err := rtBackend.HasConversationHistory(state.RuntimeContainer); err == nil && !has {
+			output.Warning("No conversation to resume in %s (was the container recreated?) — starting a new session", state.RuntimeContainer)
+			opts.Resume = false
+		}
+	}
+	var forwardEnv []string
+	var systemPrompt string
+	dir := opts.Dir
+	model := opts.Model
+	if cfg, cfgErr := config.LoadForBranch(projectDir, branch); cfgErr == nil {
+		forwardEnv = cfg.ForwardEnv
+		systemPrompt = chatSystemPrompt(projectDir, cfg.SystemPrompt, rtBackend)
+		if dir == "" {
+			dir = cfg.ChatDir
+		}
+		if model == "" {
+			model = cfg.Model
+		}
+		if cfg.PromptHistory && opts.InitialPrompt != "" {
+			if err := recordPrompt(projectDir, branch, opts.InitialPrompt); err != nil {
+				output.Warning("Could not record prompt history: %v", err)
+			}
+		}
+	}
+	workdir, err := resolveWorkdir(state.WorktreePath, dir)
+	if err != nil {
+		return err
+	}
+	return rtBackend.Chat(state.RuntimeContainer, backend.ChatOptions{
+		Chrome:        opts.Chrome,
+		InitialPrompt: opts.InitialPrompt,
+		Resume:        opts.Resume,
+		Model:         model,
+		SystemPrompt:  systemPrompt,
+		Workdir:       workdir,
+		ForwardEnv:    forwardEnv,
+	})
+}
+
+// chatSystemPrompt resolves the chat_system_prompt config. A value naming a
+// file (relative to the project directory) is replaced by the file's
+// contents; anything else is the prompt text itself. A value that looks like
+// a path but names no file is dropped with a warning rather than sent as the
+// prompt. Backends without a system prompt option get a warning and an empty
+// prompt.
+func chatSystemPrompt(projectDir, value string, rtBackend backend.Backend) string {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return ""
+	}
+	if rtBackend.Name() != backend.Claude {
+		output.Warning("chat_system_prompt is not supported by the %s backend and is ignored", rtBackend.DisplayName())
+		return ""
+	}
+	if strings.Contains(value, "\n") {
+		return value
+	}
+	path := value
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(projectDir, path)
+	}
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return strings.TrimSpace(string(data))
+	}
+	if looksLikePath(value) {
+		output.Warning("chat_system_prompt file %s could not be read and is ignored: %v", value, err)
+		return ""
+	}
+	return value
+}
+
+// looksLikePath reports whether a single-line chat_system_prompt value is
+// meant as a file: no spaces, and a directory separator or file extension.
+func looksLikePath(value string) bool {
+	if strings.ContainsAny(value, " \t") {
+		return false
+	}
+	return strings.ContainsRune(value, '/') || len(filepath.Ext(value)) > 1
+}
+
+// PromptOptions configures a one-shot backend prompt.
+type PromptOptions struct {
+	Prompt       string
+	OutputFormat string
+	Model        string // Model override (defaults to model config)
+}
+
 // ChatPrompt runs a one-shot backend prompt in the runtime container.
 func ChatPrompt(projectDir, branch, prompt, outputFormat string) error {
+	return ChatPromptWithOptions(projectDir, branch, PromptOptions{
+		Prompt:       prompt,
+		OutputFormat: outputFormat,
+	})
+}
+
+// ChatPromptWithOptions runs a one-shot backend prompt with additional options.
+func ChatPromptWithOptions(projectDir, branch string, opts PromptOptions) error {
 	state, err := LoadState(projectDir, branch)
 	if err != nil {
 		return err
 	}
+	if err := requireRunning(state); err != nil {
+		return err
+	}
+	if err := requireWorktree(state); err != nil {
+		return err
+	}
 	rtBackend, err := backend.Get(backend.ParseName(state.Backend))
 	if err != nil {
 		return err
 	}
-	return rtBackend.ChatPrompt(state.RuntimeContainer, prompt, outputFormat)
+	if running, _ := rtBackend.ChatSessionClients(state.RuntimeContainer); running {
+		output.Warning("An interactive chat is running in %s; this prompt runs as a separate session on the same files", state.RuntimeContainer)
+	}
+	model := opts.Model
+	var systemPrompt string
+	if cfg, cfgErr := config.LoadForBranch(projectDir, branch); cfgErr == nil {
+		systemPrompt = chatSystemPrompt(projectDir, cfg.SystemPrompt, rtBackend)
+		if model == "" {
+			model = cfg.Model
+		}
+		if cfg.PromptHistory {
+			if err := recordPrompt(projectDir, branch, opts.Prompt); err != nil {
+				output.Warning("Could not record prompt history: %v", err)
+			}
+		}
+	}
+	promptOpts := backend.PromptOptions{
+		Prompt:       opts.Prompt,
+		OutputFormat: opts.OutputFormat,
+		Model:        model,
+		SystemPrompt: systemPrompt,
+	}
+	// Copy the output to the prompt log so cbox logs can watch a headless
+	// run from another terminal.
+	stdout := io.Writer(os.Stdout)
+	if logFile, err := openPromptLog(projectDir, branch, opts.Prompt); err != nil {
+		output.Warning("Could not open the prompt log: %v", err)
+	} else {
+		defer logFile.Close()
+		stdout = io.MultiWriter(os.Stdout, logFile)
+	}
+	promptOpts.Stdout = stdout
+	if opts.OutputFormat != "stream-json" {
+		return rtBackend.ChatPrompt(state.RuntimeContainer, promptOpts)
+	}
+
+	// With stream-json, tally tool calls as they stream past and finish
+	// with a one-line summary of what the run did. The summary goes to
+	// stderr so stdout stays valid JSON lines for whatever consumes it.
+	summary := output.NewToolSummary()
+	promptOpts.Stdout = summary.Tee(stdout)
+	start := time.Now()
+	err = rtBackend.ChatPrompt(state.RuntimeContainer, promptOpts)
+	fmt.Fprintln(output.ErrWriter(), summary.Line(time.Since(start)))
+	return err
+}
+
+// HasConversationHistory checks if the backend has any conversation history for the sandbox on the given branch.
+func HasConversationHistory(projectDir, branch string) (bool, error) {
+	state, err := LoadState(projectDir, branch)
+	if err != nil {
+		return false, err
+	}
+	rtBackend, err := backend.Get(backend.ParseName(state.Backend))
+	if err != nil {
+		return false, err
+	}
+	return rtBackend.HasConversationHistory(state.RuntimeContainer)
+}
+
+// ShellOptions configures optional behavior for debugging shells.
+type ShellOptions struct {
+	Dir  string // Subdirectory of the worktree to start in (defaults to chat_dir config)
+	Root bool   // Open the shell as root instead of the backend's user
+}
+
+// Shell opens an interactive shell in the runtime container.
+func Shell(projectDir, branch string) error {
+	return ShellWithOptions(projectDir, branch, ShellOptions{})
+}
+
+// ShellWithOptions opens an interactive shell with additional options.
+func ShellWithOptions(projectDir, branch string, opts ShellOptions) error {
+	state, err := LoadState(projectDir, branch)
+	if err != nil {
+		return err
+	}
+	if err := requireRunning(state); err != nil {
+		return err
+	}
+	if err := requireWorktree(state); err != nil {
+		return err
+	}
+	rtBackend, err := backend.Get(backend.ParseName(state.Backend))
+	if err != nil {
+		return err
+	}
+	var forwardEnv []string
+	dir := opts.Dir
+	if cfg, cfgErr := config.LoadForBranch(projectDir, branch); cfgErr == nil {
+		forwardEnv = cfg.ForwardEnv
+		if dir == "" {
+			dir = cfg.ChatDir
+		}
+	}
+	workdir, err := resolveWorkdir(state.WorktreePath, dir)
+	if err != nil {
+		return err
+	}
+	if opts.Root {
+		output.Warning("Root shell: changes outside /workspace are lost when the container is recreated (cbox up --rebuild or --force-recreate)")
+	}
+	shellOpts := backend.ShellOptions{
+		Workdir:    workdir,
+		ForwardEnv: forwardEnv,
+		Root:       opts.Root,
+	}
+	// Containers started before shell homes existed have nothing mounted
+	// at docker.ShellHome and keep the plain bash startup.
+	if state.ShellHome != "" {
+		if _, err := prepareShellHome(projectDir, branch); err != nil {
+			output.Warning("%v", err)
+		} else {
+			shellOpts.RCFile = docker.ShellRCFile
+		}
+	}
+	return rtBackend.Shell(state.RuntimeContainer, shellOpts)
+}
+
+// SyncOptions configures the direction of a workspace sync.
+type SyncOptions struct {
+	Push bool // Copy the worktree into the container instead of back out of it
+}
+
+// SyncWithOptions copies files between the worktree and the workspace volume
+// of a sandbox created with remote = true: by default the volume back into
+// the worktree.
+func SyncWithOptions(projectDir, branch string, opts SyncOptions) error {
+	state, err := LoadState(projectDir, branch)
+	if err != nil {
+		return err
+	}
+	if state.WorkspaceVolume == "" {
+		return fmt.Errorf("sandbox %q bind-mounts its worktree, so there is nothing to sync (set remote = true to use a workspace volume)", branch)
+	}
+	if err := requireWorktree(state); err != nil {
+		return err
+	}
+	cfg, err := config.LoadForBranch(projectDir, branch)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	syncOpts := workspaceSyncOptions(cfg)
+
+	if opts.Push {
+		output.Progress("Syncing %s into %s", state.WorktreePath, state.WorkspaceVolume)
+		if err := docker.SyncToContainerWithOptions(state.RuntimeContainer, state.WorktreePath, syncOpts); err != nil {
+			return err
+		}
+	} else {
+		output.Progress("Syncing %s into %s", state.WorkspaceVolume, state.WorktreePath)
+		if err := docker.SyncFromContainerWithOptions(state.RuntimeContainer, state.WorktreePath, syncOpts); err != nil {
+			return err
+		}
+		if err := pullGit(state.RuntimeContainer, state.WorktreePath, state.Branch); err != nil {
+			output.Warning("Could not bring back commits made in the container: %v", err)
+		}
+	}
+	output.Success("Workspace synced")
+	return nil
+}
+
+// workspaceSyncOptions leaves the project's ignore patterns out of remote
+// workspace syncs in both directions, along with .git: the worktree's .git
+// link file is replaced in the volume by a standalone repository (see
+// pushGit), and commits come back through pullGit.
+func workspaceSyncOptions(cfg *config.Config) docker.SyncOptions {
+	ignore := worktree.NewIgnore(cfg.Ignore)
+	return docker.SyncOptions{Skip: func(rel string, isDir bool) bool {
+		return rel == ".git" || ignore.Match(rel, isDir)
+	}}
+}
+
+// pushGit gives a workspace volume a standalone copy of the worktree's
+// repository at /workspace/.git, so git works in the container.
+func pushGit(container, wtPath string) error {
+	commonDir, adminDir, err := worktree.GitDirs(wtPath)
+	if err != nil {
+		return err
+	}
+	return docker.SyncGitToContainer(container, commonDir, adminDir)
+}
+
+// pullGit brings commits made in a workspace volume's repository back to
+// the worktree's branch. It only fast-forwards, so commits made on the host
+// in the meantime are never discarded.
+func pullGit(container, wtPath, branch string) error {
+	tmp, err := os.MkdirTemp("", "cbox-git-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if err := docker.SyncGitFromContainer(container, tmp); err != nil {
+		return err
+	}
+	if _, err := worktree.FastForward(wtPath, tmp, branch); err != nil {
+		return err
+	}
+	return nil
+}
+
+// AttachOptions configures optional behavior for AttachWithOptions.
+type AttachOptions struct {
+	ReadOnly bool // Watch the session without sending input
+}
+
+// AttachWithOptions reconnects to the interactive chat session running in a
+// sandbox.
+func AttachWithOptions(projectDir, branch string, opts AttachOptions) error {
+	state, err := LoadState(projectDir, branch)
+	if err != nil {
+		return err
+	}
+	if err := requireRunning(state); err != nil {
+		return err
+	}
+	if err := requireWorktree(state); err != nil {
+		return err
+	}
+	rtBackend, err := backend.Get(backend.ParseName(state.Backend))
+	if err != nil {
+		return err
+	}
+	var forwardEnv []string
+	if cfg, cfgErr := config.LoadForBranch(projectDir, branch); cfgErr == nil {
+		forwardEnv = cfg.ForwardEnv
+	}
+	if err := rtBackend.Attach(state.RuntimeContainer, backend.ShellOptions{ForwardEnv: forwardEnv, ReadOnly: opts.ReadOnly}); err != nil {
+		return fmt.Errorf("%w — start one with 'cbox chat %s'", err, branch)
+	}
+	return nil
+}
+
+// resolveWorkdir maps a worktree-relative subdirectory to its path inside the
+// container (under /workspace). It returns "" when subdir is empty so the
+// image's default working directory is used. The subdirectory must exist in
+// the host worktree, and one that leaves it is an error.
+func resolveWorkdir(worktreePath, subdir string) (string, error) {
+	subdir = strings.TrimSpace(subdir)
+	if subdir == "/workspace" || strings.HasPrefix(subdir, "/workspace/") {
+		subdir = strings.TrimPrefix(strings.TrimPrefix(subdir, "/workspace"), "/")
+	}
+	if subdir == "" {
+		return "", nil
+	}
+	if !filepath.IsLocal(subdir) {
+		return "", fmt.Errorf("directory %q is outside the worktree", subdir)
+	}
+	rel := filepath.Clean(subdir)
+	if rel == "." {
+		return "", nil
+	}
+	info, err := os.Stat(filepath.Join(worktreePath, rel))
+	if err != nil || !info.IsDir() {
+		return "", fmt.Errorf("directory %q does not exist in worktree %s", rel, worktreePath)
+	}
+	return "/workspace/" + filepath.ToSlash(rel), nil
+}
+
+// InfoOptions configures optional behavior for Info.
+type InfoOptions struct {
+	JSON bool // Print the state and container status as JSON
+}
+
+// Info prints the current sandbox state.
+func Info(projectDir, branch string) error {
+	return InfoWithOptions(projectDir, branch, InfoOptions{})
+}
+
+// InfoWithOptions prints a sandbox's details with additional options.
+func InfoWithOptions(projectDir, branch string, opts InfoOptions) error {
+	state, err := LoadState(projectDir, branch)
+	if err != nil {
+		return err
+	}
+	resolveSupervised(projectDir, state)
+	if opts.JSON {
+		return output.JSON(StatusOf(state))
+	}
+
+	output.Text("Branch:           %s", state.Branch)
+	output.Text("Backend:          %s", state.Backend)
+	output.Text("Worktree:         %s", state.WorktreePath)
+	if state.WorktreePath != "" {
+		if d, err := worktree.UpstreamDivergence(state.WorktreePath); err == nil && d != nil {
+			output.Text("Upstream:         %s (%s)", d.Upstream, d)
+		}
+	}
+	if state.WorkspaceVolume != "" {
+		output.Text("Workspace volume: %s", state.WorkspaceVolume)
+	}
+	output.Text("Runtime container: %s", state.RuntimeContainer)
+	output.Text("Status:           %s", reconcileRunning(branch, state))
+	if state.AgentVersion != "" {
+		output.Text("Agent version:    %s", state.AgentVersion)
+	}
+	output.Text("Network:          %s", state.NetworkName)
+	if state.EgressProxy != "" {
+		output.Text("Egress proxy:     %s (egress denied except network.allow_hosts)", state.EgressProxy)
+	}
+	if state.MCPProxyPort > 0 {
+		if state.MCPProbeError != "" {
+			output.Text("MCP server:       port %d, unreachable from container (%s)", state.MCPProxyPort, state.MCPProbeError)
+		} else {
+			output.Text("MCP server:       port %d", state.MCPProxyPort)
+		}
+	}
+	if len(state.Ports) > 0 {
+		output.Text("Ports:            %s", strings.Join(state.Ports, ", "))
+	}
+	if state.Resources != nil {
+		output.Text("Resources:        %s", state.Resources)
+	}
+	if state.ServeURL != "" {
+		output.Text("Serve URL:        %s", state.ServeURL)
+	}
+	if state.ServePID > 0 {
+		output.Text("Serve PID:        %d", state.ServePID)
+	}
+	if state.ServePort > 0 {
+		output.Text("Serve port:       %d", state.ServePort)
+		output.Text("Serve env:        PORT=%d", state.ServePort)
+	}
+	return nil
 }
 
-// HasConversationHistory checks if the backend has any conversation history for the sandbox on the given branch.
-func HasConversationHistory(projectDir, branch string) (bool, error) {
-	state, err := LoadState(projectDir, branch)
+// reconcileRunning compares the stored Running flag with the container's
+// actual docker status and returns the status to display. When they
+// disagree it warns. The state file is left alone: info is a read path, and
+// Restart relies on Running to know the sandbox is meant to be up.
+func reconcileRunning(branch string, state *State) string {
+	status, err := docker.ContainerStatus(state.RuntimeContainer)
 	if err != nil {
-		return false, err
+		return fmt.Sprintf("unknown (%v)", err)
 	}
-	rtBackend, err := backend.Get(backend.ParseName(state.Backend))
-	if err != nil {
-		return false, err
+	running := status == "running"
+	if status == "" {
+		status = "container not found"
 	}
-	return rtBackend.HasConversationHistory(state.RuntimeContainer)
+
+	switch {
+	case state.Running && !running:
+		output.Warning("State says running but container %s is %s — run 'cbox restart %s' or 'cbox clean %s'",
+			state.RuntimeContainer, status, branch, branch)
+	case !state.Running && running:
+		output.Warning("State says stopped but container %s is running — run 'cbox down %s' to stop it",
+			state.RuntimeContainer, branch)
+	}
+	return status
 }
 
-// Shell opens an interactive shell in the runtime container.
-func Shell(projectDir, branch string) error {
+// Environment describes a sandbox for the cbox_env MCP tool. It is read
+// fresh from the state file on each call; host and project commands are
+// filled in by the MCP server itself.
+func Environment(projectDir, branch string) hostcmd.Environment {
+	env := hostcmd.Environment{Branch: branch}
 	state, err := LoadState(projectDir, branch)
 	if err != nil {
-		return err
+		return env
 	}
-	rtBackend, err := backend.Get(backend.ParseName(state.Backend))
-	if err != nil {
-		return err
+	env.Ports = state.Ports
+	env.ServeURL = state.ServeURL
+	env.ServePort = state.ServePort
+	if runtimes, err := docker.ContainerRuntimes(state.RuntimeContainer); err == nil {
+		env.Runtimes = runtimes
 	}
-	return rtBackend.Shell(state.RuntimeContainer)
+	return env
 }
 
-// Info prints the current sandbox state.
-func Info(projectDir, branch string) error {
+// ServeStatus reports whether the serve process for a sandbox is alive and
+// whether Traefik still has a route for it.
+func ServeStatus(projectDir, branch string) error {
 	state, err := LoadState(projectDir, branch)
 	if err != nil {
 		return err
 	}
 
-	output.Text("Branch:           %s", state.Branch)
-	output.Text("Backend:          %s", state.Backend)
-	output.Text("Worktree:         %s", state.WorktreePath)
-	output.Text("Runtime container: %s", state.RuntimeContainer)
-	output.Text("Network:          %s", state.NetworkName)
-	if len(state.Ports) > 0 {
-		output.Text("Ports:            %s", strings.Join(state.Ports, ", "))
+	if state.ServePID == 0 {
+		output.Text("Serve process:    not started")
+		return nil
+	}
+	resolveSupervised(projectDir, state)
+
+	safeBranch := naming.SafeBranch(branch)
+	alive := process.Alive(state.ServePID)
+	routed := serve.HasRoute(projectDir, safeBranch)
+
+	if alive {
+		output.Text("Serve process:    running (PID %d)", state.ServePID)
+	} else {
+		output.Text("Serve process:    not running (stale PID %d)", state.ServePID)
 	}
+	output.Text("Serve port:       %d", state.ServePort)
 	if state.ServeURL != "" {
 		output.Text("Serve URL:        %s", state.ServeURL)
 	}
+	if routed {
+		output.Text("Traefik route:    present")
+	} else {
+		output.Text("Traefik route:    missing")
+	}
+
+	if !alive && routed {
+		output.Warning("Route exists but the serve process is gone — run 'cbox serve restart %s'", branch)
+	}
 	return nil
 }
 
@@ -440,20 +1434,25 @@ func Serve(projectDir, branch string) error {
 		return fmt.Errorf("serve process already running (PID %d, URL %s)", state.ServePID, state.ServeURL)
 	}
 
-	cfg, err := config.Load(projectDir)
+	cfg, err := config.LoadForBranch(projectDir, branch)
 	if err != nil {
 		return err
 	}
 
 	if cfg.Serve == nil || cfg.Serve.Command == "" {
-		return fmt.Errorf("no [serve] section configured in %s", config.ConfigFile)
+		return fmt.Errorf("%w in %s", ErrNoServeConfig, config.ConfigFile)
 	}
 
 	projectName := filepath.Base(projectDir)
-	safeBranch := strings.ReplaceAll(branch, "/", "-")
+	safeBranch := naming.SafeBranch(branch)
 
 	networkName := docker.NetworkName(projectName, branch)
-	docker.CreateNetwork(networkName)
+	docker.CreateNetworkWithOptions(networkName, docker.NetworkOptions{
+		Project:  projectDir,
+		Branch:   branch,
+		Internal: state.EgressProxy != "",
+		Owned:    networkName == state.NetworkName,
+	})
 
 	// Run [serve] lifecycle commands before starting the serve process.
 	if cfg.Serve.Up != "" {
@@ -469,6 +1468,7 @@ func Serve(projectDir, branch string) error {
 		}
 	}
 
+	resolveSecretsForProxies(cfg, projectDir)
 	output.Progress("Starting serve process")
 	servePID, servePort, err := startServeProcess(cfg.Serve.Command, cfg.Serve.Port, state.WorktreePath, networkName, safeBranch)
 	if err != nil {
@@ -476,14 +1476,11 @@ func Serve(projectDir, branch string) error {
 	}
 	output.Text("  Serve process listening on port %d (log: .cbox/serve.log)", servePort)
 
-	proxyPort := cfg.Serve.ProxyPort
-	if proxyPort <= 0 {
-		proxyPort = 80
-	}
-
 	output.Progress("Ensuring Traefik proxy is running")
-	if err := serve.EnsureTraefik(projectDir, projectName, proxyPort); err != nil {
-		return fmt.Errorf("starting traefik: %w", err)
+	proxyPort, err := ensureTraefik(projectDir, projectName, cfg.Serve)
+	if err != nil {
+		stopProcess(servePID)
+		return fmt.Errorf("traefik could not start, serve is disabled: %w", err)
 	}
 
 	var containerHost string
@@ -499,12 +1496,7 @@ func Serve(projectDir, branch string) error {
 		return fmt.Errorf("adding traefik route: %w", err)
 	}
 
-	var serveURL string
-	if proxyPort == 80 {
-		serveURL = fmt.Sprintf("http://%s.%s.dev.localhost", safeBranch, projectName)
-	} else {
-		serveURL = fmt.Sprintf("http://%s.%s.dev.localhost:%d", safeBranch, projectName, proxyPort)
-	}
+	serveURL := serveURLFor(safeBranch, projectName, proxyPort)
 
 	state.ServePID = servePID
 	state.ServePort = servePort
@@ -512,6 +1504,7 @@ func Serve(projectDir, branch string) error {
 	if err := SaveState(projectDir, branch, state); err != nil {
 		return fmt.Errorf("saving state: %w", err)
 	}
+	superviseServe(projectDir, branch, cfg.Serve.Command, servePID, servePort, state.WorktreePath, networkName)
 
 	output.Success("Serve URL: %s", serveURL)
 	return nil
@@ -523,9 +1516,162 @@ func ServeLogPath(projectDir, branch string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	return filepath.Join(filepath.Dir(state.WorktreePath), ".cbox", "serve.log"), nil
+	return serveLogPath(state.WorktreePath), nil
+}
+
+// LogsOptions configures LogsWithOptions.
+type LogsOptions struct {
+	Follow bool
+	Tail   string // lines from the end to show; empty shows everything
+	Since  string // only runs started since: a duration (10m) or an RFC3339 time
+}
+
+// LogsWithOptions shows the output of a sandbox's headless prompts (chat -p),
+// which ChatPromptWithOptions copies to the sandbox's prompt log. The
+// container's own output is only its idle PID 1, so there is nothing to
+// show there.
+func LogsWithOptions(projectDir, branch string, opts LogsOptions) error {
+	tail := -1
+	if opts.Tail != "" {
+		n, err := strconv.Atoi(opts.Tail)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid --tail %q: want a number of lines", opts.Tail)
+		}
+		// tail -n +N means "from line N", so pass the plain number on.
+		tail, opts.Tail = n, strconv.Itoa(n)
+	}
+	if _, err := LoadState(projectDir, branch); err != nil {
+		return err
+	}
+	logPath := promptLogPath(projectDir, branch)
+	if _, err := os.Stat(logPath); os.IsNotExist(err) {
+		return fmt.Errorf("no prompt output for sandbox %s yet; cbox logs shows the output of 'cbox chat -p' runs", branch)
+	}
+	if opts.Since == "" {
+		cmd := exec.Command("tail", logsTailArgs(logPath, opts)...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		return cmd.Run()
+	}
+
+	since, err := parseSince(opts.Since, time.Now())
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		return err
+	}
+	runs := data[promptRunsSince(data, since):]
+	if tail >= 0 {
+		runs = lastLines(runs, tail)
+	}
+	os.Stdout.Write(runs)
+	if !opts.Follow {
+		return nil
+	}
+	// Carry on from the end of what was read, so nothing written since is
+	// missed or repeated.
+	cmd := exec.Command("tail", "-c", "+"+strconv.Itoa(len(data)+1), "-f", logPath)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// parseSince reads a --since value as docker does: a duration before now
+// (10m, 2h) or a point in time (RFC3339, or a date).
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --since %q: want a duration like 10m or a time like 2006-01-02T15:04:05Z", s)
+}
+
+// promptRunsSince returns the offset in a prompt log of the first run whose
+// header is dated at or after since, or the log's length if there is none.
+func promptRunsSince(data []byte, since time.Time) int {
+	for off := 0; off < len(data); {
+		line := data[off:]
+		if i := bytes.IndexByte(line, '\n'); i >= 0 {
+			line = line[:i+1]
+		}
+		if rest, ok := bytes.CutPrefix(line, []byte("=== ")); ok {
+			stamp, _, _ := bytes.Cut(rest, []byte(" "))
+			if t, err := time.Parse(time.RFC3339, string(stamp)); err == nil && !t.Before(since) {
+				return off
+			}
+		}
+		off += len(line)
+	}
+	return len(data)
+}
+
+// lastLines returns the last n lines of data.
+func lastLines(data []byte, n int) []byte {
+	if n == 0 {
+		return nil
+	}
+	end := len(data)
+	if end > 0 && data[end-1] == '\n' {
+		end--
+	}
+	for i := end - 1; i >= 0; i-- {
+		if data[i] == '\n' {
+			if n--; n == 0 {
+				return data[i+1:]
+			}
+		}
+	}
+	return data
+}
+
+func logsTailArgs(logPath string, opts LogsOptions) []string {
+	lines := "+1"
+	if opts.Tail != "" {
+		lines = opts.Tail
+	}
+	args := []string{"-n", lines}
+	if opts.Follow {
+		args = append(args, "-f")
+	}
+	return append(args, logPath)
+}
+
+// promptLogPath returns the file headless prompt output is copied to.
+func promptLogPath(projectDir, branch string) string {
+	return filepath.Join(mcpLogDir(projectDir, branch), "prompt.log")
+}
+
+// openPromptLog opens the prompt log for appending and writes a header for
+// a new run. A log past maxPromptLogSize is started afresh.
+func openPromptLog(projectDir, branch, prompt string) (*os.File, error) {
+	logPath := promptLogPath(projectDir, branch)
+	if err := os.MkdirAll(filepath.Dir(logPath), 0755); err != nil {
+		return nil, err
+	}
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if info, err := os.Stat(logPath); err == nil && info.Size() > maxPromptLogSize {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(logPath, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+	first, _, _ := strings.Cut(prompt, "\n")
+	fmt.Fprintf(f, "=== %s chat -p %q\n", time.Now().Format(time.RFC3339), first)
+	return f, nil
 }
 
+// maxPromptLogSize is the size past which the prompt log is truncated when
+// the next prompt starts.
+const maxPromptLogSize = 10 << 20
+
 // ServeStop stops the serve process and removes the Traefik route for a sandbox.
 func ServeStop(projectDir, branch string) error {
 	state, err := LoadState(projectDir, branch)
@@ -534,10 +1680,10 @@ func ServeStop(projectDir, branch string) error {
 	}
 
 	if state.ServePID == 0 && state.ServeURL == "" {
-		return fmt.Errorf("no serve process running for branch %q", branch)
+		return fmt.Errorf("%w for branch %q", ErrServeNotRunning, branch)
 	}
 
-	stopServe(state, projectDir)
+	stopServe(state, projectDir, output.Progress, output.Warning)
 
 	state.ServePID = 0
 	state.ServePort = 0
@@ -550,6 +1696,93 @@ func ServeStop(projectDir, branch string) error {
 	return nil
 }
 
+// ServeRestart stops and restarts the serve process for a sandbox without
+// touching the container. When no fixed port is configured the previous port
+// is reused so the existing Traefik route stays valid; the route is rewritten
+// either way in case the port or container target changed.
+func ServeRestart(projectDir, branch string) error {
+	state, err := LoadState(projectDir, branch)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadForBranch(projectDir, branch)
+	if err != nil {
+		return err
+	}
+
+	if cfg.Serve == nil || cfg.Serve.Command == "" {
+		return fmt.Errorf("%w in %s", ErrNoServeConfig, config.ConfigFile)
+	}
+
+	// Nothing running yet — a restart is just a start.
+	if state.ServePID == 0 && state.ServeURL == "" {
+		return Serve(projectDir, branch)
+	}
+
+	if state.ServePID > 0 {
+		output.Progress("Stopping serve process")
+		stopProcess(unsupervise(projectDir, serveEntryName(branch), state.ServePID))
+	}
+
+	projectName := filepath.Base(projectDir)
+	safeBranch := naming.SafeBranch(branch)
+	networkName := docker.NetworkName(projectName, branch)
+
+	port := cfg.Serve.Port
+	if port <= 0 {
+		port = state.ServePort
+	}
+
+	// The old process is gone, so if the new one doesn't take its place
+	// the route would point at a dead port: remove it, as serve stop does.
+	stopped := func(err error) error {
+		state.ServePID = 0
+		stopServe(state, projectDir, output.Progress, output.Warning)
+		state.ServePort = 0
+		state.ServeURL = ""
+		if saveErr := SaveState(projectDir, branch, state); saveErr != nil {
+			return fmt.Errorf("%w (and saving state failed: %v)", err, saveErr)
+		}
+		return err
+	}
+
+	resolveSecretsForProxies(cfg, projectDir)
+	output.Progress("Starting serve process")
+	servePID, servePort, err := startServeProcess(cfg.Serve.Command, port, state.WorktreePath, networkName, safeBranch)
+	if err != nil {
+		return stopped(fmt.Errorf("starting serve process: %w", err))
+	}
+	output.Text("  Serve process listening on port %d (log: .cbox/serve.log)", servePort)
+
+	if state.ServeURL != "" {
+		var containerHost string
+		if cfg.Serve.Container != "" {
+			containerHost = filepath.Base(state.WorktreePath) + "_devcontainer-" + cfg.Serve.Container + "-1"
+		}
+		if err := serve.AddRoute(projectDir, safeBranch, projectName, servePort, containerHost); err != nil {
+			stopProcess(servePID)
+			return stopped(fmt.Errorf("updating traefik route: %w", err))
+		}
+	}
+
+	state.ServePID = servePID
+	state.ServePort = servePort
+	if err := SaveState(projectDir, branch, state); err != nil {
+		return fmt.Errorf("saving state: %w", err)
+	}
+	if state.ServeURL != "" {
+		superviseServe(projectDir, branch, cfg.Serve.Command, servePID, servePort, state.WorktreePath, networkName)
+	}
+
+	if state.ServeURL != "" {
+		output.Success("Serve process restarted. Serve URL: %s", state.ServeURL)
+	} else {
+		output.Success("Serve process restarted.")
+	}
+	return nil
+}
+
 // ServeClean runs the [serve] clean lifecycle command for a sandbox.
 // This is used to tear down resources created by the serve setup (e.g. a branch database).
 func ServeClean(projectDir, branch string) error {
@@ -558,16 +1791,16 @@ func ServeClean(projectDir, branch string) error {
 		return err
 	}
 
-	cfg, err := config.Load(projectDir)
+	cfg, err := config.LoadForBranch(projectDir, branch)
 	if err != nil {
 		return err
 	}
 
 	if cfg.Serve == nil || cfg.Serve.Clean == "" {
-		return fmt.Errorf("no [serve] clean command configured in %s", config.ConfigFile)
+		return fmt.Errorf("%w: no clean command in %s", ErrNoServeConfig, config.ConfigFile)
 	}
 
-	safeBranch := strings.ReplaceAll(branch, "/", "-")
+	safeBranch := naming.SafeBranch(branch)
 	networkName := docker.NetworkName(filepath.Base(projectDir), branch)
 
 	output.Progress("Running serve clean command")
@@ -617,29 +1850,17 @@ func CleanWithOptions(projectDir, branch string, opts CleanOptions) error {
 	// Skipped when no worktree was used (we won't delete the branch).
 	if state.WorktreePath != "" && state.WorktreePath != state.ProjectDir && !opts.KeepBranch && !opts.Force {
 		if unpushed, err := worktree.HasUnpushedCommits(state.ProjectDir, state.Branch); err == nil && unpushed {
-			return fmt.Errorf("branch '%s' has unpushed commits — use --keep-branch to preserve it or --force to delete anyway", state.Branch)
+			return fmt.Errorf("%w: '%s' — use --keep-branch to preserve it or --force to delete anyway", ErrUnpushedCommits, state.Branch)
 		}
 	}
 
-	// Stop bridge proxy if running
-	if state.BridgeProxyPID > 0 {
-		progress("Stopping Chrome bridge proxy")
-		stopBridgeProxy(state.BridgeProxyPID)
-	}
-
-	// Stop MCP proxy if running
-	if state.MCPProxyPID > 0 {
-		progress("Stopping MCP host command server")
-		stopProcess(state.MCPProxyPID)
-	}
-
-	// Stop serve process and clean up Traefik route
-	stopServe(state, projectDir)
+	// Stop host processes, serve, and the container concurrently
+	stopRuntime(state, projectDir, progress, warning)
 
 	// Run [serve] clean lifecycle command if configured (e.g. drop branch database)
-	cfg, cfgErr := config.Load(projectDir)
+	cfg, cfgErr := config.LoadForBranch(projectDir, branch)
 	if cfgErr == nil && cfg.Serve != nil && cfg.Serve.Clean != "" {
-		safeBranch := strings.ReplaceAll(branch, "/", "-")
+		safeBranch := naming.SafeBranch(branch)
 		networkName := docker.NetworkName(filepath.Base(projectDir), branch)
 		progress("Running serve clean command")
 		if err := runServeLifecycleCommand(cfg.Serve.Clean, state.WorktreePath, networkName, safeBranch); err != nil {
@@ -647,18 +1868,9 @@ func CleanWithOptions(projectDir, branch string, opts CleanOptions) error {
 		}
 	}
 
-	// Always attempt to stop and remove the container. The Running flag in
-	// the state file can be stale (e.g. after a crash or if Down was called
-	// but the container was restarted). StopAndRemove is safe to call even
-	// when the container is already gone.
-	progress("Stopping container %s", state.RuntimeContainer)
-	if err := docker.StopAndRemove(state.RuntimeContainer); err != nil {
-		warning("Could not remove container: %v", err)
-	}
-
 	// Remove network (safe to call even if already removed)
 	progress("Removing network %s", state.NetworkName)
-	docker.RemoveNetwork(state.NetworkName)
+	docker.RemoveOwnedNetwork(state.NetworkName)
 
 	// Remove worktree and branch (skipped when sandbox was started without a worktree)
 	if state.WorktreePath != "" && state.WorktreePath != state.ProjectDir {
@@ -672,6 +1884,11 @@ func CleanWithOptions(projectDir, branch string, opts CleanOptions) error {
 		}
 	}
 
+	home := shellHomeDir(projectDir, branch)
+	if err := os.RemoveAll(home); err != nil {
+		warning("Could not remove shell history at %s: %v", home, err)
+	}
+	os.RemoveAll(mcpLogDir(projectDir, branch))
 	RemoveState(projectDir, branch)
 	if state.WorktreePath != "" && state.WorktreePath != state.ProjectDir && opts.KeepBranch {
 		success("Sandbox cleaned up. Branch '%s' preserved.", state.Branch)
@@ -723,63 +1940,73 @@ func startBridgeProxy(socketDir string) (int, []bridge.ProxyMapping, error) {
 }
 
 // stopBridgeProxy sends SIGTERM to the bridge proxy process.
-func stopBridgeProxy(pid int) {
-	stopProcess(pid)
+func stopBridgeProxy(pid int, warning func(string, ...any)) {
+	stopProcessReporting(pid, warning)
 }
 
-// stopProcess sends SIGTERM to a process and waits for it to exit.
+// stopWait is how long stopProcess gives a process to exit after SIGTERM
+// before killing it.
+const stopWait = 5 * time.Second
+
+// stopProcess sends SIGTERM to a process and waits for it to exit, killing
+// it if it is still running after stopWait. The PIDs it is given come from
+// state files, so a PID that no longer belongs to a cbox process (reused
+// after a reboot or crash) is left alone.
 func stopProcess(pid int) {
-	proc, err := os.FindProcess(pid)
-	if err != nil {
+	stopProcessReporting(pid, output.Warning)
+}
+
+// stopProcessReporting is stopProcess with the warning function as a
+// parameter, so teardown can attribute warnings to its sandbox.
+func stopProcessReporting(pid int, warning func(string, ...any)) {
+	if pid <= 0 || !process.Alive(pid) {
+		return
+	}
+	if !process.Owned(pid) {
+		warning("PID %d is no longer a cbox process; leaving it alone", pid)
 		return
 	}
-	proc.Signal(syscall.SIGTERM)
-	proc.Wait()
+	if !stopProcessWithin(pid, stopWait) {
+		warning("Process %d is still running after SIGKILL", pid)
+	}
+}
+
+// stopProcessWithin is stopProcess with the SIGTERM grace period as a
+// parameter. It reports whether the process is gone.
+func stopProcessWithin(pid int, grace time.Duration) bool {
+	if pid <= 0 {
+		return true
+	}
+	proc, err := os.FindProcess(pid)
+	if err != nil || proc.Signal(syscall.SIGTERM) != nil {
+		return true // already gone
+	}
+	exited := processExit(proc)
+	if exited(grace) {
+		return true
+	}
+	signalGroup(pid, syscall.SIGKILL)
+	return exited(killWait)
 }
 
 // startMCPProxy launches `cbox _mcp-proxy` as a background process.
 // It reads the JSON output from the process's stdout and returns its PID and port.
-func startMCPProxy(projectDir, worktreePath, branch string, hostCommands []string, namedCommands map[string]string, reportDir string, servePort int, commandTimeout time.Duration) (int, int, error) {
+func startMCPProxy(projectDir, worktreePath, branch, container string, cfg *config.Config, reportDir string, servePort, port int) (int, int, error) {
 	selfPath, err := os.Executable()
 	if err != nil {
 		return 0, 0, fmt.Errorf("finding executable: %w", err)
 	}
 
-	args := []string{"_mcp-proxy", "--worktree", worktreePath}
-
-	// Store logs in the project .cbox dir, keyed by branch, so they're
-	// outside the worktree volume mount.
-	safeBranch := strings.ReplaceAll(branch, "/", "-")
-	logDir := filepath.Join(projectDir, ".cbox", "logs", safeBranch)
-	args = append(args, "--log-dir", logDir)
-
-	// Pass named commands as JSON via --commands flag, substituting $Port
-	if len(namedCommands) > 0 {
-		resolved := make(map[string]string, len(namedCommands))
-		for name, expr := range namedCommands {
-			resolved[name] = strings.ReplaceAll(expr, "$Port", fmt.Sprintf("%d", servePort))
-		}
-		cmdJSON, err := json.Marshal(resolved)
-		if err != nil {
-			return 0, 0, fmt.Errorf("marshaling commands: %w", err)
-		}
-		args = append(args, "--commands", string(cmdJSON))
-	}
-
-	// Pass report dir if set
-	if reportDir != "" {
-		args = append(args, "--report-dir", reportDir)
+	args, err := mcpProxyArgs(projectDir, worktreePath, branch, container, cfg, reportDir, servePort)
+	if err != nil {
+		return 0, 0, err
 	}
-
-	// Pass command timeout if set
-	if commandTimeout > 0 {
-		args = append(args, "--command-timeout", commandTimeout.String())
+	if port > 0 {
+		args = append(args, "--port", fmt.Sprintf("%d", port))
 	}
 
-	// Host commands are passed as positional args
-	args = append(args, hostCommands...)
-
 	cmd := exec.Command(selfPath, args...)
+	cmd.Env = append(os.Environ(), redact.Env()...)
 	cmd.Stderr = os.Stderr
 
 	stdout, err := cmd.StdoutPipe()
@@ -814,6 +2041,66 @@ func startMCPProxy(projectDir, worktreePath, branch string, hostCommands []strin
 	return cmd.Process.Pid, output.Port, nil
 }
 
+// mcpProxyArgs builds the `cbox _mcp-proxy` arguments for a sandbox.
+func mcpProxyArgs(projectDir, worktreePath, branch, container string, cfg *config.Config, reportDir string, servePort int) ([]string, error) {
+	args := []string{"_mcp-proxy", "--worktree", worktreePath, "--project", projectDir, "--branch", branch}
+
+	// Store logs in the project .cbox dir, keyed by branch, so they're
+	// outside the worktree volume mount.
+	args = append(args, "--log-dir", mcpLogDir(projectDir, branch))
+
+	// Pass named commands as JSON via --commands flag, substituting $Port
+	if len(cfg.Commands) > 0 {
+		resolved := make(map[string]string, len(cfg.Commands))
+		for name, expr := range cfg.Commands {
+			resolved[name] = strings.ReplaceAll(expr, "$Port", fmt.Sprintf("%d", servePort))
+		}
+		cmdJSON, err := json.Marshal(resolved)
+		if err != nil {
+			return nil, fmt.Errorf("marshaling commands: %w", err)
+		}
+		args = append(args, "--commands", string(cmdJSON))
+	}
+
+	// Pass report dir if set
+	if reportDir != "" {
+		args = append(args, "--report-dir", reportDir)
+	}
+
+	// Pass command timeout if set
+	if cfg.CommandTimeout > 0 {
+		timeout := time.Duration(cfg.CommandTimeout) * time.Second
+		args = append(args, "--command-timeout", timeout.String())
+	}
+	if len(cfg.CommandTimeouts) > 0 {
+		timeouts := make(map[string]string, len(cfg.CommandTimeouts))
+		for name, seconds := range cfg.CommandTimeouts {
+			timeouts[name] = (time.Duration(seconds) * time.Second).String()
+		}
+		timeoutsJSON, err := json.Marshal(timeouts)
+		if err != nil {
+			return nil, fmt.Errorf("marshaling command timeouts: %w", err)
+		}
+		args = append(args, "--command-timeouts", string(timeoutsJSON))
+	}
+
+	if cfg.MaxConcurrent > 0 {
+		args = append(args, "--max-concurrent", fmt.Sprintf("%d", cfg.MaxConcurrent))
+	}
+
+	if len(cfg.InContainer) > 0 {
+		args = append(args, "--container", container, "--container-commands", strings.Join(cfg.InContainer, ","))
+	}
+
+	// Host commands are passed as positional args
+	return append(args, cfg.HostCommands...), nil
+}
+
+// mcpLogDir returns the directory the MCP server writes command logs to.
+func mcpLogDir(projectDir, branch string) string {
+	return filepath.Join(projectDir, ".cbox", "logs", naming.SafeBranch(branch))
+}
+
 // startServeProcess launches `cbox _serve-runner` as a background process.
 // It reads the JSON output from the process's stdout and returns its PID and port.
 func startServeProcess(command string, fixedPort int, dir string, network string, branch string) (int, int, error) {
@@ -822,24 +2109,18 @@ func startServeProcess(command string, fixedPort int, dir string, network string
 		return 0, 0, fmt.Errorf("finding executable: %w", err)
 	}
 
-	args := []string{"_serve-runner", "--command", command, "--port", fmt.Sprintf("%d", fixedPort), "--dir", dir}
-	if network != "" {
-		args = append(args, "--network", network)
-	}
-	if branch != "" {
-		args = append(args, "--branch", branch)
-	}
+	args := serveRunnerArgs(command, fixedPort, dir, network, branch)
 
 	// Write serve output to a log file so it doesn't flood the terminal.
-	logDir := filepath.Join(filepath.Dir(dir), ".cbox")
-	os.MkdirAll(logDir, 0755)
-	logPath := filepath.Join(logDir, "serve.log")
+	logPath := serveLogPath(dir)
+	os.MkdirAll(filepath.Dir(logPath), 0755)
 	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 	if err != nil {
 		return 0, 0, fmt.Errorf("creating serve log: %w", err)
 	}
 
 	cmd := exec.Command(selfPath, args...)
+	cmd.Env = append(os.Environ(), redact.Env()...)
 	cmd.Stderr = logFile
 
 	stdout, err := cmd.StdoutPipe()
@@ -877,6 +2158,23 @@ func startServeProcess(command string, fixedPort int, dir string, network string
 	return cmd.Process.Pid, result.Port, nil
 }
 
+// serveRunnerArgs builds the `cbox _serve-runner` arguments for a serve command.
+func serveRunnerArgs(command string, port int, dir, network, branch string) []string {
+	args := []string{"_serve-runner", "--command", command, "--port", fmt.Sprintf("%d", port), "--dir", dir}
+	if network != "" {
+		args = append(args, "--network", network)
+	}
+	if branch != "" {
+		args = append(args, "--branch", branch)
+	}
+	return args
+}
+
+// serveLogPath returns the serve log file for a worktree.
+func serveLogPath(worktreePath string) string {
+	return filepath.Join(filepath.Dir(worktreePath), ".cbox", "serve.log")
+}
+
 // runServeLifecycleCommand runs a shell command synchronously before the serve
 // process starts. It substitutes $Network so commands can reference the Docker
 // network. Output goes to the serve log file.
@@ -897,13 +2195,23 @@ type rollback struct {
 	disarmed      bool
 	networks      []string
 	containers    []string
+	volumes       []string
 	pids          []int
 	traefikRoutes []struct{ projectDir, safeBranch string }
 }
 
-func (r *rollback) addNetwork(name string)    { r.networks = append(r.networks, name) }
-func (r *rollback) addContainer(name string)   { r.containers = append(r.containers, name) }
-func (r *rollback) addProcess(pid int)         { r.pids = append(r.pids, pid) }
+func (r *rollback) addNetwork(name string)   { r.networks = append(r.networks, name) }
+func (r *rollback) addContainer(name string) { r.containers = append(r.containers, name) }
+func (r *rollback) addVolume(name string)    { r.volumes = append(r.volumes, name) }
+func (r *rollback) addProcess(pid int)       { r.pids = append(r.pids, pid) }
+func (r *rollback) removeProcess(pid int) {
+	for i, p := range r.pids {
+		if p == pid {
+			r.pids = append(r.pids[:i], r.pids[i+1:]...)
+			return
+		}
+	}
+}
 func (r *rollback) addTraefikRoute(projectDir, safeBranch string) {
 	r.traefikRoutes = append(r.traefikRoutes, struct{ projectDir, safeBranch string }{projectDir, safeBranch})
 }
@@ -927,29 +2235,55 @@ func (r *rollback) run() {
 	for _, name := range r.containers {
 		docker.StopAndRemove(name)
 	}
+	for _, name := range r.volumes {
+		docker.RemoveVolume(name)
+	}
 	for _, name := range r.networks {
 		docker.RemoveNetwork(name)
 	}
 }
 
+// ensureTraefik starts the shared Traefik proxy and returns its host port,
+// noting when an unconfigured proxy port had to fall back from 80.
+func ensureTraefik(projectDir, projectName string, sc *config.ServeConfig) (int, error) {
+	proxyPort, err := serve.EnsureTraefik(projectDir, projectName, sc.ProxyPort, sc.TraefikImage)
+	if err != nil {
+		return 0, err
+	}
+	if sc.ProxyPort <= 0 && proxyPort != 80 {
+		output.Text("  Port 80 is unavailable, Traefik is listening on %d (set serve.proxy_port to choose a port)", proxyPort)
+	}
+	return proxyPort, nil
+}
+
+// serveURLFor returns the Traefik URL for a branch, omitting the default
+// HTTP port.
+func serveURLFor(safeBranch, projectName string, proxyPort int) string {
+	if proxyPort == 80 {
+		return fmt.Sprintf("http://%s.%s.dev.localhost", safeBranch, projectName)
+	}
+	return fmt.Sprintf("http://%s.%s.dev.localhost:%d", safeBranch, projectName, proxyPort)
+}
+
 // stopServe stops the serve process and cleans up the Traefik route.
 // If no routes remain, the Traefik container is stopped.
-func stopServe(state *State, projectDir string) {
+func stopServe(state *State, projectDir string, progress, warning func(string, ...any)) {
 	if state.ServePID > 0 {
-		output.Progress("Stopping serve process")
-		stopProcess(state.ServePID)
+		progress("Stopping serve process")
+		stopProcessReporting(unsupervise(projectDir, serveEntryName(state.Branch), state.ServePID), warning)
 	}
 
 	if state.ServeURL != "" {
-		safeBranch := strings.ReplaceAll(state.Branch, "/", "-")
+		safeBranch := naming.SafeBranch(state.Branch)
 		projectName := filepath.Base(state.ProjectDir)
 
-		output.Progress("Removing Traefik route")
+		progress("Removing Traefik route")
 		serve.RemoveRoute(projectDir, safeBranch)
 
 		hasRoutes, _ := serve.HasRoutes(projectDir)
 		if !hasRoutes {
-			output.Progress("No routes remaining, stopping Traefik proxy")
+			progress("No routes remaining, stopping Traefik proxy")
+			unsupervise(projectDir, traefikEntryName, 0)
 			serve.StopTraefik(projectName)
 		}
 	}
@@ -4,7 +4,14 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/richvanbergen/cbox/internal/backend"
+	"github.com/richvanbergen/cbox/internal/config"
+	"github.com/richvanbergen/cbox/internal/daemon"
+	"github.com/richvanbergen/cbox/internal/docker"
 )
 
 // TestCleanAttemptsDockerCleanupRegardlessOfRunningFlag verifies that Clean
@@ -163,3 +170,257 @@ func TestLoadState_NormalizesLegacyClaudeFields(t *testing.T) {
 		t.Fatalf("RuntimeImage = %q, want %q", loaded.RuntimeImage, "cbox:test")
 	}
 }
+
+func TestLoadState_MigratesLegacyName(t *testing.T) {
+	dir := t.TempDir()
+	branch := "fix/issue#12"
+	stateDir := filepath.Join(dir, StateDir)
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	legacy := filepath.Join(stateDir, "fix-issue#12.state.json")
+	data := []byte(`{"branch": "fix/issue#12", "runtime_container": "cbox-app-fix-issue#12-claude", "network_name": "cbox-app-fix-issue#12"}`)
+	if err := os.WriteFile(legacy, data, 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	loaded, err := LoadState(dir, branch)
+	if err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+	if loaded.RuntimeContainer != "cbox-app-fix-issue#12-claude" {
+		t.Errorf("RuntimeContainer = %q, want the legacy name kept", loaded.RuntimeContainer)
+	}
+	if _, err := os.Stat(filepath.Join(stateDir, "fix-issue-12.state.json")); err != nil {
+		t.Errorf("state not moved to the current name: %v", err)
+	}
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Errorf("legacy state file still present: %v", err)
+	}
+}
+
+func TestResolveWorkdir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "packages", "api"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		subdir  string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{"/workspace", "", false},
+		{"packages/api", "/workspace/packages/api", false},
+		{"/workspace/packages/api", "/workspace/packages/api", false},
+		{"packages/../packages/api/", "/workspace/packages/api", false},
+		{".", "", false},
+		{"../packages", "", true},
+		{"/workspace/../packages", "", true},
+		{"/etc", "", true},
+		{"missing", "", true},
+	}
+	for _, tt := range tests {
+		got, err := resolveWorkdir(dir, tt.subdir)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("resolveWorkdir(%q) error = %v, wantErr %v", tt.subdir, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("resolveWorkdir(%q) = %q, want %q", tt.subdir, got, tt.want)
+		}
+	}
+}
+
+func TestApplySupervised(t *testing.T) {
+	state := &State{Branch: "feat/x", MCPProxyPID: 100, ServePID: 200}
+	statuses := []daemon.Status{
+		{Name: "feat-x/mcp", PID: 101},
+		{Name: "feat-x/serve", PID: 200},
+		{Name: "other/mcp", PID: 300},
+		{Name: "traefik", Container: "cbox-app-traefik"},
+	}
+	if !applySupervised(statuses, state) {
+		t.Error("applySupervised() = false, want true for a restarted MCP server")
+	}
+	if state.MCPProxyPID != 101 || state.ServePID != 200 {
+		t.Errorf("PIDs = mcp %d, serve %d, want 101, 200", state.MCPProxyPID, state.ServePID)
+	}
+	if applySupervised(statuses, state) {
+		t.Error("applySupervised() = true with nothing to change")
+	}
+}
+
+func TestRunConfigHash(t *testing.T) {
+	base := &config.Config{Env: []string{"A=1"}, Model: "opus"}
+	same := &config.Config{Env: []string{"A=1"}, Model: "sonnet", Backend: "claude", ChatDir: "web"}
+	if runConfigHash(base) != runConfigHash(same) {
+		t.Error("settings applied to a running container changed the hash")
+	}
+	for name, cfg := range map[string]*config.Config{
+		"gpus":            {Env: []string{"A=1"}, GPUs: "all"},
+		"egress":          {Env: []string{"A=1"}, Network: &config.NetworkConfig{Egress: "deny"}},
+		"resources":       {Env: []string{"A=1"}, Resources: &config.ResourcesConfig{Memory: "4g"}},
+		"docker_run_args": {Env: []string{"A=1"}, DockerRunArgs: []string{"--shm-size=1g"}},
+		"env_commands":    {Env: []string{"A=1"}, EnvCommands: map[string]string{"TOKEN": "op read op://vault/token"}},
+		"backend":         {Env: []string{"A=1"}, Backend: "cursor"},
+		"commands":        {Env: []string{"A=1"}, Commands: map[string]string{"test": "go test"}},
+		"host_commands":   {Env: []string{"A=1"}, HostCommands: []string{"git"}},
+		"container_cmds":  {Env: []string{"A=1"}, InContainer: []string{"test"}},
+		"command_timeout": {Env: []string{"A=1"}, CommandTimeout: 600},
+		"timeouts":        {Env: []string{"A=1"}, CommandTimeouts: map[string]int{"test": 600}},
+		"max_concurrent":  {Env: []string{"A=1"}, MaxConcurrent: 2},
+	} {
+		if runConfigHash(base) == runConfigHash(cfg) {
+			t.Errorf("changing %s did not change the hash", name)
+		}
+	}
+}
+
+func TestChatSystemPrompt(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "docs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "docs", "prompt.md"), []byte("Follow docs/STYLE.md.\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	claude := backend.ClaudeBackend{}
+
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"", ""},
+		{"docs/prompt.md", "Follow docs/STYLE.md."},
+		{"Always run the tests.", "Always run the tests."},
+		{"docs/missing.md", ""},
+		{"AGENTS.md", ""},
+		{"Keep changes small.", "Keep changes small."},
+		{"Terse.", "Terse."},
+	}
+	for _, tt := range tests {
+		if got := chatSystemPrompt(dir, tt.value, claude); got != tt.want {
+			t.Errorf("chatSystemPrompt(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+	if got := chatSystemPrompt(dir, "Always run the tests.", backend.CursorBackend{}); got != "" {
+		t.Errorf("cursor backend got %q, want it ignored", got)
+	}
+}
+
+func TestOpenPromptLog(t *testing.T) {
+	dir := t.TempDir()
+	for _, prompt := range []string{"fix the tests\nthen lint", "second"} {
+		f, err := openPromptLog(dir, "feat/x", prompt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString("done\n")
+		f.Close()
+	}
+	data, err := os.ReadFile(promptLogPath(dir, "feat/x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(data)
+	for _, want := range []string{`chat -p "fix the tests"`, `chat -p "second"`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("prompt log = %q, want a header with %s", got, want)
+		}
+	}
+	if strings.Contains(got, "then lint") {
+		t.Errorf("prompt log = %q, want only the prompt's first line in the header", got)
+	}
+}
+
+func TestLogsTailArgs(t *testing.T) {
+	if got := strings.Join(logsTailArgs("p.log", LogsOptions{}), " "); got != "-n +1 p.log" {
+		t.Errorf("logsTailArgs() = %q, want all lines", got)
+	}
+	if got := strings.Join(logsTailArgs("p.log", LogsOptions{Follow: true, Tail: "100"}), " "); got != "-n 100 -f p.log" {
+		t.Errorf("logsTailArgs() = %q, want %q", got, "-n 100 -f p.log")
+	}
+}
+
+func TestLogsRejectsBadTail(t *testing.T) {
+	for _, tail := range []string{"-5", "ten", "1.5"} {
+		err := LogsWithOptions(t.TempDir(), "main", LogsOptions{Tail: tail})
+		if err == nil || !strings.Contains(err.Error(), "invalid --tail") {
+			t.Errorf("LogsWithOptions(tail %q) error = %v, want invalid --tail", tail, err)
+		}
+	}
+}
+
+func TestPromptRunsSince(t *testing.T) {
+	log := "=== 2024-01-02T10:00:00Z chat -p \"first\"\nold output\n" +
+		"=== 2024-01-02T11:00:00Z chat -p \"second\"\nnew output\n"
+	tests := []struct {
+		since string
+		want  string
+	}{
+		{"2024-01-02T09:00:00Z", log},
+		{"2024-01-02T10:30:00Z", "=== 2024-01-02T11:00:00Z chat -p \"second\"\nnew output\n"},
+		{"2024-01-02T12:00:00Z", ""},
+	}
+	for _, tt := range tests {
+		since, err := time.Parse(time.RFC3339, tt.since)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := log[promptRunsSince([]byte(log), since):]; got != tt.want {
+			t.Errorf("since %s: got %q, want %q", tt.since, got, tt.want)
+		}
+	}
+	if got := string(lastLines([]byte(log), 1)); got != "new output\n" {
+		t.Errorf("lastLines(1) = %q, want the last line", got)
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"10m", now.Add(-10 * time.Minute)},
+		{"2024-01-02T11:00:00Z", time.Date(2024, 1, 2, 11, 0, 0, 0, time.UTC)},
+		{"2024-01-01", time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)},
+	}
+	for _, tt := range tests {
+		got, err := parseSince(tt.in, now)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("parseSince(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+	if _, err := parseSince("yesterday", now); err == nil {
+		t.Error("parseSince(yesterday) succeeded, want an error")
+	}
+}
+
+// TestCommandSecretsStayOnHost verifies that a ${VAR} in a named command
+// reaches neither the agent's CLAUDE.md nor the MCP proxy's command line.
+func TestCommandSecretsStayOnHost(t *testing.T) {
+	t.Setenv("CBOX_TEST_DEPLOY_TOKEN", "s3cret")
+	dir := t.TempDir()
+	content := "[commands]\ndeploy = \"./deploy.sh --token ${CBOX_TEST_DEPLOY_TOKEN}\"\n"
+	if err := os.WriteFile(filepath.Join(dir, config.ConfigFile), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if md := docker.BuildClaudeMD(nil, cfg.Commands, nil); strings.Contains(md, "s3cret") {
+		t.Errorf("CLAUDE.md contains the expanded token:\n%s", md)
+	}
+	args, err := mcpProxyArgs(dir, dir, "main", "c", cfg, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if joined := strings.Join(args, " "); strings.Contains(joined, "s3cret") {
+		t.Errorf("MCP proxy args contain the expanded token: %s", joined)
+	}
+}
@@ -5,10 +5,12 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"strings"
 
 	"github.com/richvanbergen/cbox/internal/backend"
 	"github.com/richvanbergen/cbox/internal/bridge"
+	"github.com/richvanbergen/cbox/internal/docker"
+	"github.com/richvanbergen/cbox/internal/naming"
+	"github.com/richvanbergen/cbox/internal/worktree"
 )
 
 const StateDir = ".cbox"
@@ -20,16 +22,26 @@ type State struct {
 	WorktreePath     string                `json:"worktree_path"`
 	Branch           string                `json:"branch"`
 	RuntimeImage     string                `json:"runtime_image,omitempty"`
+	AgentVersion     string                `json:"agent_version,omitempty"`
 	ProjectDir       string                `json:"project_dir"`
 	Running          bool                  `json:"running"`
 	BridgeProxyPID   int                   `json:"bridge_proxy_pid,omitempty"`
 	BridgeMappings   []bridge.ProxyMapping `json:"bridge_mappings,omitempty"`
 	MCPProxyPID      int                   `json:"mcp_proxy_pid,omitempty"`
 	MCPProxyPort     int                   `json:"mcp_proxy_port,omitempty"`
+	MCPProbeError    string                `json:"mcp_probe_error,omitempty"`
+	ReportDir        string                `json:"report_dir,omitempty"` // --report-dir the MCP proxy was started with
 	Ports            []string              `json:"ports,omitempty"`
 	ServePID         int                   `json:"serve_pid,omitempty"`
 	ServePort        int                   `json:"serve_port,omitempty"`
 	ServeURL         string                `json:"serve_url,omitempty"`
+	WorkspaceVolume  string                `json:"workspace_volume,omitempty"`
+	EgressProxy      string                `json:"egress_proxy,omitempty"`
+	AllowHosts       []string              `json:"allow_hosts,omitempty"`
+	GPUs             string                `json:"gpus,omitempty"`
+	ShellHome        string                `json:"shell_home,omitempty"`
+	Resources        *docker.Resources     `json:"resources,omitempty"`
+	RunConfig        string                `json:"run_config,omitempty"` // see runConfigHash
 
 	SourceBranch string `json:"source_branch,omitempty"`
 
@@ -37,26 +49,98 @@ type State struct {
 	ClaudeImage     string `json:"claude_image,omitempty"`
 }
 
+// Status is a sandbox's state together with its container's live docker
+// status, for machine-readable output.
+type Status struct {
+	*State
+	ContainerStatus  string `json:"container_status"` // "running", "exited", ...; "" if the container is gone
+	ContainerRunning bool   `json:"container_running"`
+}
+
+// StatusOf looks up the docker status of state's container. If docker
+// can't be asked, the status is "unknown".
+func StatusOf(state *State) Status {
+	status, err := docker.ContainerStatus(state.RuntimeContainer)
+	if err != nil {
+		status = "unknown"
+	}
+	return Status{State: state, ContainerStatus: status, ContainerRunning: status == "running"}
+}
+
 func stateFilePath(projectDir, branch string) string {
-	safeBranch := strings.ReplaceAll(branch, "/", "-")
+	safeBranch := naming.SafeBranch(branch)
 	return filepath.Join(projectDir, StateDir, safeBranch+".state.json")
 }
 
+// legacyStateFilePath is where cbox kept branch's state before names went
+// through naming.SafeBranch.
+func legacyStateFilePath(projectDir, branch string) string {
+	return filepath.Join(projectDir, StateDir, naming.LegacySafeBranch(branch)+".state.json")
+}
+
+// LoadState reads branch's state. A state file still under its legacy name
+// is moved to the current one; the state keeps the container, network and
+// worktree names it was created with, so those are found as before.
 func LoadState(projectDir, branch string) (*State, error) {
 	path := stateFilePath(projectDir, branch)
 	data, err := os.ReadFile(path)
+	legacy := legacyStateFilePath(projectDir, branch)
+	fromLegacy := false
+	if os.IsNotExist(err) && legacy != path {
+		if legacyData, legacyErr := os.ReadFile(legacy); legacyErr == nil {
+			data, err, fromLegacy = legacyData, nil, true
+		}
+	}
 	if err != nil {
-		return nil, fmt.Errorf("no sandbox for branch %q (missing %s): %w", branch, path, err)
+		return nil, fmt.Errorf("%w %q (missing %s): %w", ErrNoState, branch, path, err)
 	}
 
 	var s State
 	if err := json.Unmarshal(data, &s); err != nil {
 		return nil, fmt.Errorf("parsing state: %w", err)
 	}
+	// Another branch with the same safe name owns this sandbox; acting on
+	// it would tear down or clean that branch's sandbox instead. This is
+	// checked before a legacy file is moved, since it may be that branch's.
+	if s.Branch != "" && s.Branch != branch {
+		return nil, nameTakenError(branch, s.Branch)
+	}
+	if fromLegacy {
+		os.Rename(legacy, path) //nolint:errcheck — read from the legacy path again next time
+	}
 	s.Normalize()
 	return &s, nil
 }
 
+// checkNameCollision returns an error if a different branch already owns the
+// safe name that branch maps to (e.g. "feature/x" and "feature-x"), since the
+// two would otherwise share a state file, worktree, container and network.
+func checkNameCollision(projectDir, branch string) error {
+	data, err := os.ReadFile(stateFilePath(projectDir, branch))
+	if err == nil {
+		var s State
+		if json.Unmarshal(data, &s) == nil && s.Branch != "" && s.Branch != branch {
+			return nameTakenError(branch, s.Branch)
+		}
+	}
+
+	wtPath := worktree.WorktreePath(projectDir, branch)
+	if info, err := os.Stat(wtPath); err == nil && info.IsDir() {
+		existing, err := worktree.CurrentBranch(wtPath)
+		if err == nil && existing != "HEAD" && existing != branch {
+			return fmt.Errorf("branch %q maps to worktree %s, which has branch %q checked out; pick a different branch name",
+				branch, wtPath, existing)
+		}
+	}
+	return nil
+}
+
+// nameTakenError reports that branch's sandbox name belongs to owner.
+func nameTakenError(branch, owner string) error {
+	return fmt.Errorf("branch %q maps to sandbox name %q, which is already used by branch %q; clean that sandbox first or pick a different branch name",
+		branch, naming.SafeBranch(branch), owner)
+}
+
 func SaveState(projectDir, branch string, s *State) error {
 	dir := filepath.Join(projectDir, StateDir)
 	if err := os.MkdirAll(dir, 0755); err != nil {
@@ -82,26 +166,49 @@ func RemoveState(projectDir, branch string) error {
 }
 
 func ListStates(projectDir string) ([]*State, error) {
+	states, _, err := ListStatesWithWarnings(projectDir)
+	return states, err
+}
+
+// ListStatesWithWarnings returns every readable sandbox state in the project.
+// Files that can't be read or parsed are skipped and described in the
+// returned warnings so one bad file doesn't hide the other sandboxes.
+func ListStatesWithWarnings(projectDir string) ([]*State, []string, error) {
 	pattern := filepath.Join(projectDir, StateDir, "*.state.json")
 	matches, err := filepath.Glob(pattern)
 	if err != nil {
-		return nil, fmt.Errorf("globbing state files: %w", err)
+		return nil, nil, fmt.Errorf("globbing state files: %w", err)
 	}
 
 	var states []*State
+	var warnings []string
 	for _, m := range matches {
+		name := filepath.Base(m)
+		if name == ".state.json" {
+			continue
+		}
+		info, err := os.Stat(m)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
 		data, err := os.ReadFile(m)
 		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("skipping %s: %v", name, err))
 			continue
 		}
 		var s State
 		if err := json.Unmarshal(data, &s); err != nil {
+			warnings = append(warnings, fmt.Sprintf("skipping %s: invalid state: %v", name, err))
+			continue
+		}
+		if s.Branch == "" {
+			warnings = append(warnings, fmt.Sprintf("skipping %s: no branch recorded", name))
 			continue
 		}
 		s.Normalize()
 		states = append(states, &s)
 	}
-	return states, nil
+	return states, warnings, nil
 }
 
 func (s *State) Normalize() {
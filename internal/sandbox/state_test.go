@@ -0,0 +1,136 @@
+package sandbox
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/richvanbergen/cbox/internal/naming"
+)
+
+func TestListStatesWithWarnings_SkipsBadFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := SaveState(dir, "feature/auth", &State{Branch: "feature/auth"}); err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+	if err := SaveState(dir, "main", &State{Branch: "main"}); err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+
+	stateDir := filepath.Join(dir, StateDir)
+	files := map[string]string{
+		"corrupt.state.json":       "{not json",
+		"empty.state.json":         "{}",
+		"serve.log":                "log output",
+		"prompt-history-main.json": "[]",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(stateDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	states, warnings, err := ListStatesWithWarnings(dir)
+	if err != nil {
+		t.Fatalf("ListStatesWithWarnings: %v", err)
+	}
+
+	if len(states) != 2 {
+		t.Fatalf("got %d states, want 2", len(states))
+	}
+	branches := map[string]bool{}
+	for _, s := range states {
+		branches[s.Branch] = true
+	}
+	if !branches["feature/auth"] || !branches["main"] {
+		t.Errorf("branches = %v, want feature/auth and main", branches)
+	}
+
+	if len(warnings) != 2 {
+		t.Fatalf("got %d warnings, want 2: %v", len(warnings), warnings)
+	}
+	joined := strings.Join(warnings, "\n")
+	for _, name := range []string{"corrupt.state.json", "empty.state.json"} {
+		if !strings.Contains(joined, name) {
+			t.Errorf("warnings %v do not mention %s", warnings, name)
+		}
+	}
+}
+
+func TestListStates_NoStateDir(t *testing.T) {
+	states, err := ListStates(t.TempDir())
+	if err != nil {
+		t.Fatalf("ListStates: %v", err)
+	}
+	if len(states) != 0 {
+		t.Errorf("got %d states, want 0", len(states))
+	}
+}
+
+func TestCheckNameCollision(t *testing.T) {
+	dir := t.TempDir()
+
+	if naming.SafeBranch("feature/x") != naming.SafeBranch("feature-x") {
+		t.Fatal("test assumes feature/x and feature-x share a safe name")
+	}
+
+	if err := checkNameCollision(dir, "feature/x"); err != nil {
+		t.Fatalf("unexpected error with no existing sandbox: %v", err)
+	}
+
+	if err := SaveState(dir, "feature/x", &State{Branch: "feature/x"}); err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+
+	// Re-running up for the same branch is fine.
+	if err := checkNameCollision(dir, "feature/x"); err != nil {
+		t.Errorf("unexpected error for the owning branch: %v", err)
+	}
+
+	err := checkNameCollision(dir, "feature-x")
+	if err == nil {
+		t.Fatal("expected collision error for feature-x")
+	}
+	if !strings.Contains(err.Error(), "feature/x") {
+		t.Errorf("error %q should name the existing branch", err)
+	}
+}
+
+func TestLoadState_LeavesAnotherBranchesLegacyState(t *testing.T) {
+	dir := t.TempDir()
+	// Both branches' legacy name is feature-x@1.
+	legacy := legacyStateFilePath(dir, "feature/x@1")
+	if err := os.MkdirAll(filepath.Dir(legacy), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(legacy, []byte(`{"branch": "feature/x@1"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadState(dir, "feature-x@1"); err == nil {
+		t.Fatal("LoadState(feature-x@1) loaded feature/x@1's state")
+	}
+	if _, err := os.Stat(legacy); err != nil {
+		t.Errorf("feature/x@1's legacy state file was moved: %v", err)
+	}
+	if _, err := LoadState(dir, "feature/x@1"); err != nil {
+		t.Errorf("LoadState for the owning branch: %v", err)
+	}
+}
+
+func TestLoadState_RefusesAnotherBranchesSandbox(t *testing.T) {
+	dir := t.TempDir()
+	if err := SaveState(dir, "feature/x", &State{Branch: "feature/x"}); err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+
+	if _, err := LoadState(dir, "feature/x"); err != nil {
+		t.Errorf("LoadState for the owning branch: %v", err)
+	}
+	_, err := LoadState(dir, "feature-x")
+	if err == nil || !strings.Contains(err.Error(), `already used by branch "feature/x"`) {
+		t.Errorf("LoadState(feature-x) error = %v, want a collision naming feature/x", err)
+	}
+}
@@ -0,0 +1,173 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/richvanbergen/cbox/internal/daemon"
+	"github.com/richvanbergen/cbox/internal/naming"
+	"github.com/richvanbergen/cbox/internal/output"
+	"github.com/richvanbergen/cbox/internal/serve"
+)
+
+// mcpEntryName and serveEntryName name a sandbox's processes in the daemon.
+func mcpEntryName(branch string) string   { return naming.SafeBranch(branch) + "/mcp" }
+func serveEntryName(branch string) string { return naming.SafeBranch(branch) + "/serve" }
+
+// traefikEntryName names the project's shared Traefik container in the daemon.
+const traefikEntryName = "traefik"
+
+// supervise registers a running cbox subprocess with the project's daemon so
+// it is restarted if it dies. args must reproduce the process exactly,
+// including its port, since the container was configured to reach it there.
+// The env_commands values it redacts are not sent: a restarted process
+// resolves them again itself (see RedactEnvCommands). Without a daemon this
+// is a no-op and the process runs standalone.
+func supervise(projectDir, name string, pid int, args []string, logPath string) {
+	if !daemon.Running(projectDir) {
+		return
+	}
+	selfPath, err := os.Executable()
+	if err != nil {
+		output.Warning("Could not register %s with the daemon: %v", name, err)
+		return
+	}
+	entry := daemon.Entry{
+		Name:    name,
+		PID:     pid,
+		Command: append([]string{selfPath}, args...),
+		LogPath: logPath,
+	}
+	if err := daemon.Register(projectDir, entry); err != nil {
+		output.Warning("Could not register %s with the daemon: %v", name, err)
+	}
+}
+
+// superviseServe registers a sandbox's serve process, and the Traefik
+// container routing to it, with the project's daemon.
+func superviseServe(projectDir, branch, command string, pid, port int, worktreePath, network string) {
+	args := serveRunnerArgs(command, port, worktreePath, network, naming.SafeBranch(branch))
+	args = append(args, "--project", projectDir, "--sandbox", branch)
+	supervise(projectDir, serveEntryName(branch), pid, args, serveLogPath(worktreePath))
+
+	if !daemon.Running(projectDir) {
+		return
+	}
+	entry := daemon.Entry{Name: traefikEntryName, Container: serve.TraefikContainerName(filepath.Base(projectDir))}
+	if err := daemon.Register(projectDir, entry); err != nil {
+		output.Warning("Could not register Traefik with the daemon: %v", err)
+	}
+}
+
+// unsupervise deregisters name from the daemon and returns the PID to stop:
+// the daemon's current PID if it restarted the process, otherwise pid.
+func unsupervise(projectDir, name string, pid int) int {
+	current, err := daemon.Deregister(projectDir, name)
+	if err != nil || current == 0 {
+		return pid
+	}
+	return current
+}
+
+// resolveSupervised replaces state's MCP and serve PIDs with the ones the
+// daemon runs them under, which change each time it restarts them. It
+// reports whether any changed. Without a daemon state is left as is.
+func resolveSupervised(projectDir string, state *State) bool {
+	statuses, err := daemon.List(projectDir)
+	if err != nil {
+		return false
+	}
+	return applySupervised(statuses, state)
+}
+
+func applySupervised(statuses []daemon.Status, state *State) bool {
+	changed := false
+	for _, st := range statuses {
+		if st.PID == 0 {
+			continue
+		}
+		var pid *int
+		switch st.Name {
+		case mcpEntryName(state.Branch):
+			pid = &state.MCPProxyPID
+		case serveEntryName(state.Branch):
+			pid = &state.ServePID
+		default:
+			continue
+		}
+		if *pid > 0 && *pid != st.PID {
+			*pid = st.PID
+			changed = true
+		}
+	}
+	return changed
+}
+
+// DaemonStart starts the project's supervisor daemon in the background.
+// Sandboxes started afterwards register their proxies with it.
+func DaemonStart(projectDir string) error {
+	if daemon.Running(projectDir) {
+		output.Text("Daemon already running.")
+		return nil
+	}
+	if err := daemon.Start(projectDir); err != nil {
+		return err
+	}
+	output.Success("Daemon started (log: %s)", daemon.LogPath(projectDir))
+	return nil
+}
+
+// DaemonStop stops the project's daemon. Supervised proxies keep running
+// standalone; the PIDs of any the daemon restarted are written to their
+// sandbox's state first, so down and clean can still stop them.
+func DaemonStop(projectDir string) error {
+	statuses, err := daemon.List(projectDir)
+	if err != nil {
+		output.Text("Daemon is not running.")
+		return nil
+	}
+	states, err := ListStates(projectDir)
+	if err != nil {
+		return err
+	}
+	for _, state := range states {
+		if !applySupervised(statuses, state) {
+			continue
+		}
+		if err := SaveState(projectDir, state.Branch, state); err != nil {
+			output.Warning("Could not record %s's restarted proxy PIDs: %v", state.Branch, err)
+		}
+	}
+	if err := daemon.Shutdown(projectDir); err != nil {
+		return err
+	}
+	output.Success("Daemon stopped. Supervised proxies keep running, but are no longer restarted if they die.")
+	return nil
+}
+
+// DaemonStatus lists everything the project's daemon supervises.
+func DaemonStatus(projectDir string) error {
+	statuses, err := daemon.List(projectDir)
+	if err != nil {
+		output.Text("Daemon is not running (proxies run standalone).")
+		return nil
+	}
+	output.Text("Daemon running (socket: %s)", daemon.SocketPath(projectDir))
+	if len(statuses) == 0 {
+		output.Text("  Nothing supervised yet.")
+		return nil
+	}
+	for _, st := range statuses {
+		state := "running"
+		if !st.Running {
+			state = "down"
+		}
+		target := fmt.Sprintf("PID %d", st.PID)
+		if st.Container != "" {
+			target = st.Container
+		}
+		output.Text("  %-24s %-8s %-28s restarts: %d", st.Name, state, target, st.Restarts)
+	}
+	return nil
+}
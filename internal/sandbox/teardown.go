@@ -0,0 +1,358 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/richvanbergen/cbox/internal/config"
+	"github.com/richvanbergen/cbox/internal/docker"
+	"github.com/richvanbergen/cbox/internal/naming"
+	"github.com/richvanbergen/cbox/internal/output"
+	"github.com/richvanbergen/cbox/internal/process"
+	"github.com/richvanbergen/cbox/internal/serve"
+)
+
+// parallel runs fns concurrently and waits for all of them to return.
+func parallel(fns ...func()) {
+	var wg sync.WaitGroup
+	for _, fn := range fns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn()
+		}()
+	}
+	wg.Wait()
+}
+
+// bounded runs fn for each item with at most n running at once, and waits
+// for all of them to return.
+func bounded[T any](items []T, n int, fn func(T)) {
+	sem := make(chan struct{}, n)
+	var wg sync.WaitGroup
+	for _, item := range items {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			fn(item)
+		}()
+	}
+	wg.Wait()
+}
+
+// downAllWorkers is how many sandboxes DownAll takes down at once. Each
+// one already stops its own processes and container concurrently, so a
+// small pool is enough without swamping the docker daemon.
+const downAllWorkers = 4
+
+// DownAll stops every running sandbox in the project, downAllWorkers at a
+// time. Their messages interleave, so each is prefixed with its branch. A
+// sandbox that fails doesn't stop the others; the failures are returned
+// together.
+func DownAll(projectDir string) error {
+	states, err := ListStates(projectDir)
+	if err != nil {
+		return err
+	}
+	var running []*State
+	for _, s := range states {
+		if s.Running {
+			running = append(running, s)
+		}
+	}
+	if len(running) == 0 {
+		output.Success("No running sandboxes")
+		return nil
+	}
+
+	var mu sync.Mutex
+	var errs []error
+	bounded(running, downAllWorkers, func(s *State) {
+		tag := func(fn func(string, ...any)) func(string, ...any) {
+			return func(format string, args ...any) {
+				fn("%s: "+format, append([]any{s.Branch}, args...)...)
+			}
+		}
+		if err := down(projectDir, s.Branch, tag(output.Progress), tag(output.Warning), tag(output.Success)); err != nil {
+			mu.Lock()
+			errs = append(errs, fmt.Errorf("%s: %w", s.Branch, err))
+			mu.Unlock()
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// stopRuntime tears down everything a running sandbox holds except its
+// network and worktree. The bridge proxy, MCP server, serve process and
+// container don't depend on each other, so they are stopped concurrently
+// rather than waiting on a slow docker stop one at a time. Progress and
+// warning messages name the step they belong to.
+func stopRuntime(state *State, projectDir string, progress, warning func(string, ...any)) {
+	// A volume-backed workspace holds the only copy of the agent's edits,
+	// so bring them home before the container goes away. The volume is
+	// kept if that fails so nothing is lost.
+	// A container that is already gone (after down) has nothing to sync,
+	// and its volume was dealt with then.
+	removeVolume := false
+	status, _ := docker.ContainerStatus(state.RuntimeContainer)
+	if state.WorkspaceVolume != "" && status != "" {
+		progress("Syncing workspace back to %s", state.WorktreePath)
+		cfg, err := config.LoadForBranch(projectDir, state.Branch)
+		if err != nil {
+			cfg = &config.Config{}
+		}
+		if err := docker.SyncFromContainerWithOptions(state.RuntimeContainer, state.WorktreePath, workspaceSyncOptions(cfg)); err != nil {
+			warning("Could not sync workspace, keeping volume %s: %v", state.WorkspaceVolume, err)
+		} else if err := pullGit(state.RuntimeContainer, state.WorktreePath, state.Branch); err != nil {
+			warning("Could not bring back commits made in the container, keeping volume %s: %v", state.WorkspaceVolume, err)
+		} else {
+			removeVolume = true
+		}
+	}
+
+	var steps []func()
+
+	if state.BridgeProxyPID > 0 {
+		progress("Stopping Chrome bridge proxy")
+		steps = append(steps, func() { stopBridgeProxy(state.BridgeProxyPID, warning) })
+	}
+	if state.MCPProxyPID > 0 {
+		progress("Stopping MCP host command server")
+		pid := unsupervise(projectDir, mcpEntryName(state.Branch), state.MCPProxyPID)
+		steps = append(steps, func() { stopProcessReporting(pid, warning) })
+	}
+	steps = append(steps, func() { stopServe(state, projectDir, progress, warning) })
+	if state.EgressProxy != "" {
+		progress("Stopping egress proxy %s", state.EgressProxy)
+		steps = append(steps, func() {
+			if err := docker.StopAndRemove(state.EgressProxy); err != nil {
+				warning("Could not remove egress proxy %s: %v", state.EgressProxy, err)
+			}
+		})
+	}
+
+	// Always attempt to stop and remove the container. The Running flag in
+	// the state file can be stale (e.g. after a crash or if Down was called
+	// but the container was restarted). StopAndRemove is safe to call even
+	// when the container is already gone.
+	progress("Stopping container %s", state.RuntimeContainer)
+	steps = append(steps, func() {
+		// The shell home belongs to the container user while it runs; give
+		// it back so clean can remove it from the host.
+		if state.ShellHome != "" && status == "running" {
+			if err := docker.ReleaseShellHome(state.RuntimeContainer, os.Getuid(), os.Getgid()); err != nil {
+				warning("Could not hand %s back to you: %v", state.ShellHome, err)
+			}
+		}
+		if err := docker.StopAndRemove(state.RuntimeContainer); err != nil {
+			warning("Could not remove container %s: %v", state.RuntimeContainer, err)
+			return
+		}
+		if removeVolume {
+			if err := docker.RemoveVolume(state.WorkspaceVolume); err != nil {
+				warning("Could not remove volume %s: %v", state.WorkspaceVolume, err)
+			}
+		}
+	})
+
+	parallel(steps...)
+}
+
+// killWait bounds how long Kill waits for a SIGKILLed process to go away.
+const killWait = 5 * time.Second
+
+// Kill force-removes a sandbox that the graceful Down/Clean paths can't get
+// through: host processes are SIGKILLed, containers are removed with docker
+// rm -f, and the state file is deleted. The worktree and branch are kept, and
+// a remote workspace volume is left in place without syncing it back.
+func Kill(projectDir, branch string) error {
+	state, err := LoadState(projectDir, branch)
+	if err != nil {
+		return err
+	}
+
+	var steps []func()
+	for _, p := range []struct {
+		what string
+		pid  int
+	}{
+		{"Chrome bridge proxy", state.BridgeProxyPID},
+		{"MCP host command server", unsupervise(projectDir, mcpEntryName(state.Branch), state.MCPProxyPID)},
+		{"serve process", unsupervise(projectDir, serveEntryName(state.Branch), state.ServePID)},
+	} {
+		if p.pid <= 0 || !process.Alive(p.pid) {
+			continue
+		}
+		if !process.Owned(p.pid) {
+			output.Warning("PID %d of the %s now belongs to another process; leaving it alone", p.pid, p.what)
+			continue
+		}
+		output.Progress("Killing %s (PID %d)", p.what, p.pid)
+		steps = append(steps, func() {
+			if !killProcess(p.pid, killWait) {
+				output.Warning("%s (PID %d) is still running after SIGKILL", p.what, p.pid)
+			}
+		})
+	}
+	for _, name := range []string{state.RuntimeContainer, state.EgressProxy} {
+		if name == "" {
+			continue
+		}
+		output.Progress("Removing container %s", name)
+		steps = append(steps, func() {
+			if err := docker.ForceRemove(name); err != nil {
+				output.Warning("Could not remove container %s: %v", name, err)
+			}
+		})
+	}
+	parallel(steps...)
+
+	if state.ServeURL != "" {
+		serve.RemoveRoute(projectDir, naming.SafeBranch(state.Branch))
+	}
+	output.Progress("Removing network %s", state.NetworkName)
+	docker.RemoveOwnedNetwork(state.NetworkName)
+
+	if err := RemoveState(projectDir, branch); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("removing state: %w", err)
+	}
+	if state.WorkspaceVolume != "" {
+		output.Warning("Workspace volume %s was kept without syncing; remove it with 'docker volume rm %s'", state.WorkspaceVolume, state.WorkspaceVolume)
+	}
+	if state.WorktreePath != "" && state.WorktreePath != state.ProjectDir {
+		output.Success("Sandbox killed. Worktree kept at %s — 'cbox up %s' reuses it.", state.WorktreePath, state.Branch)
+		return nil
+	}
+	output.Success("Sandbox killed.")
+	return nil
+}
+
+// GCOptions configures GCWithOptions.
+type GCOptions struct {
+	DryRun bool // list orphans without removing them
+}
+
+// GCWithOptions removes the project's orphaned resources: labeled containers
+// and networks whose branch has no state file, e.g. after the .cbox directory
+// was deleted or an up was interrupted. Images and volumes are left alone.
+func GCWithOptions(projectDir string, opts GCOptions) error {
+	projectName := filepath.Base(projectDir)
+	containers, err := docker.ListLabeled("container", projectDir)
+	if err != nil {
+		return dockerErr(err)
+	}
+	networks, err := docker.ListLabeled("network", projectDir)
+	if err != nil {
+		return dockerErr(err)
+	}
+
+	orphaned := func(r docker.LabeledResource) bool {
+		if r.Branch == "" {
+			return false
+		}
+		_, err := os.Stat(stateFilePath(projectDir, r.Branch))
+		return os.IsNotExist(err)
+	}
+
+	found := 0
+	// Containers go first: a network can't be removed while one is attached.
+	for _, c := range containers {
+		if !orphaned(c) {
+			continue
+		}
+		found++
+		if opts.DryRun {
+			output.Text("container %s (branch %s)", c.Name, c.Branch)
+			continue
+		}
+		output.Progress("Removing container %s", c.Name)
+		if err := docker.ForceRemove(c.Name); err != nil {
+			output.Warning("Could not remove container %s: %v", c.Name, err)
+		}
+	}
+	for _, n := range networks {
+		if !orphaned(n) {
+			continue
+		}
+		found++
+		if opts.DryRun {
+			output.Text("network %s (branch %s)", n.Name, n.Branch)
+			continue
+		}
+		output.Progress("Removing network %s", n.Name)
+		docker.RemoveNetwork(n.Name)
+	}
+
+	switch {
+	case found == 0:
+		output.Success("No orphaned resources for %s", projectName)
+	case opts.DryRun:
+		output.Text("%d orphaned resource(s); run 'cbox gc' to remove them", found)
+	default:
+		output.Success("Removed %d orphaned resource(s)", found)
+	}
+	return nil
+}
+
+// killProcess SIGKILLs pid and its process group and waits up to timeout
+// for it to exit. It reports whether the process is gone.
+func killProcess(pid int, timeout time.Duration) bool {
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return true
+	}
+	exited := processExit(proc)
+	signalGroup(pid, syscall.SIGKILL)
+	return exited(timeout)
+}
+
+// signalGroup sends sig to pid and, since cbox starts its host processes as
+// group leaders, to the process group it leads. A process that doesn't lead
+// its group only gets the signal itself, so the group of whatever it belongs
+// to is never hit.
+func signalGroup(pid int, sig syscall.Signal) {
+	if pgid, err := syscall.Getpgid(pid); err == nil && pgid == pid {
+		syscall.Kill(-pid, sig)
+	}
+	syscall.Kill(pid, sig)
+}
+
+// processExit returns a function that waits up to a timeout for proc to
+// exit and reports whether it has. It can be called again after a timeout.
+// A child of this process is reaped with Wait; for a PID left by an earlier
+// cbox run Wait fails at once, so it falls back to polling the PID.
+func processExit(proc *os.Process) func(time.Duration) bool {
+	done := make(chan error, 1)
+	go func() {
+		_, err := proc.Wait()
+		done <- err
+	}()
+	notChild := false
+	return func(timeout time.Duration) bool {
+		if !notChild {
+			select {
+			case err := <-done:
+				if err == nil {
+					return true
+				}
+				notChild = true
+			case <-time.After(timeout):
+				return false
+			}
+		}
+		deadline := time.Now().Add(timeout)
+		for process.Alive(proc.Pid) {
+			if time.Now().After(deadline) {
+				return false
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		return true
+	}
+}
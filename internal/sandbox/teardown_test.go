@@ -0,0 +1,167 @@
+package sandbox
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/richvanbergen/cbox/internal/process"
+)
+
+func TestParallel_RunsAllAndWaits(t *testing.T) {
+	// Each step waits at a barrier until all three have started, which
+	// only happens if they run concurrently.
+	var arrived sync.WaitGroup
+	arrived.Add(3)
+	all := make(chan struct{})
+	go func() { arrived.Wait(); close(all) }()
+
+	var done, overlapped atomic.Int32
+	step := func() {
+		arrived.Done()
+		select {
+		case <-all:
+			overlapped.Add(1)
+		case <-time.After(5 * time.Second):
+		}
+		done.Add(1)
+	}
+	parallel(step, step, step)
+
+	if got := done.Load(); got != 3 {
+		t.Fatalf("completed %d steps, want 3", got)
+	}
+	if got := overlapped.Load(); got != 3 {
+		t.Errorf("%d of 3 steps saw the others running, expected them to run concurrently", got)
+	}
+}
+
+func TestParallel_NoSteps(t *testing.T) {
+	parallel()
+}
+
+func TestBounded_LimitsConcurrency(t *testing.T) {
+	var running, peak, done atomic.Int32
+	bounded(make([]int, 10), 3, func(int) {
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		running.Add(-1)
+		done.Add(1)
+	})
+
+	if got := done.Load(); got != 10 {
+		t.Fatalf("ran %d items, want 10", got)
+	}
+	if got := peak.Load(); got > 3 {
+		t.Errorf("%d items ran at once, want at most 3", got)
+	}
+}
+
+// startGroupLeader starts a shell in its own process group, as cbox does
+// for its host processes, and reaps it in the background so it doesn't
+// linger as a zombie once it exits.
+func startGroupLeader(t *testing.T, script string) int {
+	t.Helper()
+	cmd := exec.Command("sh", "-c", script)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	go cmd.Wait()
+	t.Cleanup(func() { syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) })
+	return cmd.Process.Pid
+}
+
+func TestKillProcess_KillsGroup(t *testing.T) {
+	pid := startGroupLeader(t, "sleep 60 & wait")
+
+	if !killProcess(pid, 5*time.Second) {
+		t.Fatal("process still running after killProcess")
+	}
+	// The sleep child shared the group and must be gone too, once init
+	// has reaped it.
+	deadline := time.Now().Add(5 * time.Second)
+	for syscall.Kill(-pid, 0) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("process group still has members")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestStopProcess_LeavesReusedPIDAlone(t *testing.T) {
+	// A group leader that isn't running a cbox command stands in for a PID
+	// the state file recorded before it was reused.
+	pid := startGroupLeader(t, "sleep 60")
+
+	stopProcess(pid)
+	if !process.Alive(pid) {
+		t.Error("stopProcess killed a process that isn't cbox's")
+	}
+}
+
+func TestStopProcess_KillsChildIgnoringSIGTERM(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "trap '' TERM; while :; do sleep 1; done")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) })
+	time.Sleep(100 * time.Millisecond) // let the trap be installed
+
+	start := time.Now()
+	if !stopProcessWithin(cmd.Process.Pid, 200*time.Millisecond) {
+		t.Fatal("process still running")
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("stop took %v", elapsed)
+	}
+}
+
+func TestStopProcess_NonChild(t *testing.T) {
+	// The sleep is started by a shell that exits straight away, so it is
+	// not our child and Wait can't be used on it.
+	out, err := exec.Command("sh", "-c", "(trap '' TERM; exec sleep 60) >/dev/null 2>&1 & echo $!").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(out)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { syscall.Kill(pid, syscall.SIGKILL) })
+
+	if !stopProcessWithin(pid, 200*time.Millisecond) {
+		t.Fatal("process still running")
+	}
+	if process.Alive(pid) {
+		t.Error("process.Alive reports the stopped process")
+	}
+}
+
+func TestStopProcess_ExitsOnSIGTERM(t *testing.T) {
+	cmd := exec.Command("sleep", "60")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cmd.Process.Kill() })
+
+	start := time.Now()
+	if !stopProcessWithin(cmd.Process.Pid, 5*time.Second) {
+		t.Fatal("process still running")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("SIGTERM should stop sleep at once, took %v", elapsed)
+	}
+}
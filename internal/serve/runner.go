@@ -10,6 +10,8 @@ import (
 	"strings"
 	"syscall"
 	"time"
+
+	"github.com/richvanbergen/cbox/internal/redact"
 )
 
 var extraPortRe = regexp.MustCompile(`\$Port(\d+)`)
@@ -42,10 +44,14 @@ func RunServeCommand(command string, fixedPort int, dir string, network string,
 	if dir != "" {
 		cmd.Dir = dir
 	}
+	cmd.Env = append(os.Environ(), fmt.Sprintf("PORT=%d", port))
 	// Child stdout goes to stderr to avoid corrupting the JSON port output
-	// on our stdout (which the parent process reads via pipe).
-	cmd.Stdout = os.Stderr
-	cmd.Stderr = os.Stderr
+	// on our stdout (which the parent process reads via pipe). stderr is
+	// the serve log, so env_commands values are masked on the way.
+	log := redact.NewWriter(os.Stderr)
+	defer log.Close()
+	cmd.Stdout = log
+	cmd.Stderr = log
 
 	if err := cmd.Start(); err != nil {
 		return fmt.Errorf("starting serve command: %w", err)
@@ -5,6 +5,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/richvanbergen/cbox/internal/docker"
@@ -12,6 +13,13 @@ import (
 
 const defaultProxyPort = 80
 
+// FallbackProxyPort is used when no proxy port is configured and port 80
+// can't be bound (already in use, or privileged on the host).
+const FallbackProxyPort = 8080
+
+// DefaultTraefikImage is the Traefik image used when none is configured.
+const DefaultTraefikImage = "traefik:v3"
+
 // TraefikContainerName returns the deterministic Traefik container name for a project.
 func TraefikContainerName(projectName string) string {
 	return "cbox-" + projectName + "-traefik"
@@ -22,24 +30,52 @@ func dynamicDir(projectDir string) string {
 	return filepath.Join(projectDir, ".cbox", "traefik", "dynamic")
 }
 
-// EnsureTraefik starts the Traefik container if it is not already running.
-func EnsureTraefik(projectDir, projectName string, proxyPort int) error {
-	if proxyPort <= 0 {
-		proxyPort = defaultProxyPort
+// EnsureTraefik starts the Traefik container if it is not already running
+// and returns the host port it is published on. A proxyPort of 0 means "not
+// configured": port 80 is tried first, falling back to FallbackProxyPort if
+// it can't be bound. An explicit proxyPort is used as-is. An empty image uses
+// DefaultTraefikImage. If the image is not available locally and cannot be
+// pulled (e.g. offline), a descriptive error is returned.
+func EnsureTraefik(projectDir, projectName string, proxyPort int, image string) (int, error) {
+	if image == "" {
+		image = DefaultTraefikImage
 	}
 
 	name := TraefikContainerName(projectName)
 
 	running, _ := docker.IsRunning(name)
 	if running {
-		return nil
+		if port := publishedPort(name); port > 0 {
+			return port, nil
+		}
+		if proxyPort <= 0 {
+			return defaultProxyPort, nil
+		}
+		return proxyPort, nil
 	}
 
 	dynDir := dynamicDir(projectDir)
 	if err := os.MkdirAll(dynDir, 0755); err != nil {
-		return fmt.Errorf("creating traefik dynamic dir: %w", err)
+		return 0, fmt.Errorf("creating traefik dynamic dir: %w", err)
+	}
+
+	if err := ensureImage(image); err != nil {
+		return 0, err
+	}
+
+	if proxyPort > 0 {
+		return proxyPort, runTraefik(name, image, dynDir, proxyPort)
+	}
+	err := runTraefik(name, image, dynDir, defaultProxyPort)
+	if err != nil && isBindError(err) {
+		return FallbackProxyPort, runTraefik(name, image, dynDir, FallbackProxyPort)
 	}
+	return defaultProxyPort, err
+}
 
+// runTraefik starts the Traefik container published on the given host port,
+// replacing any stale container of the same name.
+func runTraefik(name, image, dynDir string, proxyPort int) error {
 	// Remove any stale container first (stopped but not removed)
 	exec.Command("docker", "rm", "-f", name).Run()
 
@@ -48,18 +84,67 @@ func EnsureTraefik(projectDir, projectName string, proxyPort int) error {
 		"-p", fmt.Sprintf("%d:80", proxyPort),
 		"--add-host", "host.docker.internal:host-gateway",
 		"-v", dynDir+":/etc/traefik/dynamic",
-		"traefik:v3",
+		image,
 		"--entryPoints.web.address=:80",
 		"--providers.file.directory=/etc/traefik/dynamic",
 		"--providers.file.watch=true",
 	)
 	out, err := cmd.CombinedOutput()
 	if err != nil {
+		// The container is created even when publishing the port fails.
+		exec.Command("docker", "rm", "-f", name).Run()
 		return fmt.Errorf("starting traefik container: %s: %w", strings.TrimSpace(string(out)), err)
 	}
 	return nil
 }
 
+// isBindError reports whether a docker run failure was caused by the host
+// port being taken or privileged.
+func isBindError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "port is already allocated") ||
+		strings.Contains(msg, "address already in use") ||
+		(strings.Contains(msg, "bind") && strings.Contains(msg, "permission denied"))
+}
+
+// publishedPort returns the host port mapped to Traefik's port 80, or 0 if
+// it can't be determined.
+func publishedPort(name string) int {
+	out, err := exec.Command("docker", "port", name, "80/tcp").Output()
+	if err != nil {
+		return 0
+	}
+	return parsePublishedPort(string(out))
+}
+
+// parsePublishedPort extracts the host port from `docker port` output, which
+// has one "0.0.0.0:8080"-style line per address.
+func parsePublishedPort(out string) int {
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		i := strings.LastIndex(line, ":")
+		if i < 0 {
+			continue
+		}
+		if port, err := strconv.Atoi(strings.TrimSpace(line[i+1:])); err == nil {
+			return port
+		}
+	}
+	return 0
+}
+
+// ensureImage checks that image exists locally, pulling it if not.
+func ensureImage(image string) error {
+	if err := exec.Command("docker", "image", "inspect", image).Run(); err == nil {
+		return nil
+	}
+	out, err := exec.Command("docker", "pull", image).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("traefik image %s is not available locally and could not be pulled (offline? run 'docker pull %s' while online): %s: %w",
+			image, image, strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
+
 // AddRoute writes a Traefik dynamic config file that routes the given hostname
 // to a backend. If containerHost is non-empty, the route targets the container
 // directly on the Docker network. Otherwise it routes via host.docker.internal.
@@ -101,6 +186,12 @@ func RemoveRoute(projectDir, safeBranch string) error {
 	return err
 }
 
+// HasRoute reports whether a route file exists for the given branch.
+func HasRoute(projectDir, safeBranch string) bool {
+	_, err := os.Stat(filepath.Join(dynamicDir(projectDir), safeBranch+".yml"))
+	return err == nil
+}
+
 // HasRoutes checks if any .yml route files exist in the dynamic dir.
 func HasRoutes(projectDir string) (bool, error) {
 	pattern := filepath.Join(dynamicDir(projectDir), "*.yml")
@@ -1,6 +1,7 @@
 package serve
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"strings"
@@ -104,3 +105,47 @@ func TestHasRoutes(t *testing.T) {
 		t.Fatal("expected no routes after removal")
 	}
 }
+
+func TestHasRoute(t *testing.T) {
+	dir := t.TempDir()
+
+	if HasRoute(dir, "feature-auth") {
+		t.Fatal("expected no route initially")
+	}
+
+	if err := AddRoute(dir, "feature-auth", "myapp", 34567, ""); err != nil {
+		t.Fatalf("setup: %v", err)
+	}
+	if !HasRoute(dir, "feature-auth") {
+		t.Fatal("expected route to exist")
+	}
+	if HasRoute(dir, "feature-other") {
+		t.Fatal("expected no route for a different branch")
+	}
+}
+
+func TestIsBindError(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want bool
+	}{
+		{"Bind for 0.0.0.0:80 failed: port is already allocated", true},
+		{"listen tcp4 0.0.0.0:80: bind: address already in use", true},
+		{"listen tcp 0.0.0.0:80: bind: permission denied", true},
+		{"pull access denied for traefik", false},
+	}
+	for _, tt := range tests {
+		if got := isBindError(errors.New(tt.msg)); got != tt.want {
+			t.Errorf("isBindError(%q) = %v, want %v", tt.msg, got, tt.want)
+		}
+	}
+}
+
+func TestParsePublishedPort(t *testing.T) {
+	if got := parsePublishedPort("0.0.0.0:8080\n[::]:8080\n"); got != 8080 {
+		t.Errorf("parsePublishedPort = %d, want 8080", got)
+	}
+	if got := parsePublishedPort(""); got != 0 {
+		t.Errorf("parsePublishedPort(empty) = %d, want 0", got)
+	}
+}
@@ -0,0 +1,274 @@
+// Package update checks GitHub releases for newer cbox versions and replaces
+// the running binary with a release build.
+package update
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Repo is the GitHub repository releases are published to.
+const Repo = "richvanbergen/cbox"
+
+// apiBase is the GitHub API root. Tests point it at a local server.
+var apiBase = "https://api.github.com"
+
+// httpClient is used for all release requests.
+var httpClient = &http.Client{Timeout: 60 * time.Second}
+
+// Release is the subset of the GitHub release payload cbox needs.
+type Release struct {
+	TagName string  `json:"tag_name"`
+	Assets  []Asset `json:"assets"`
+}
+
+// Asset is a downloadable file attached to a release.
+type Asset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+// Latest fetches the most recent published release.
+func Latest() (*Release, error) {
+	url := fmt.Sprintf("%s/repos/%s/releases/latest", apiBase, Repo)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching latest release: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching latest release: %s", resp.Status)
+	}
+
+	var rel Release
+	if err := json.NewDecoder(resp.Body).Decode(&rel); err != nil {
+		return nil, fmt.Errorf("decoding release: %w", err)
+	}
+	if rel.TagName == "" {
+		return nil, fmt.Errorf("latest release has no tag")
+	}
+	return &rel, nil
+}
+
+// parseVersion parses "v1.2.3" or "1.2.3" into its numeric parts. It reports
+// false for anything else, such as "dev" or a commit hash.
+func parseVersion(v string) ([3]int, bool) {
+	var parts [3]int
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	fields := strings.Split(v, ".")
+	if len(fields) != 3 {
+		return parts, false
+	}
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 0 {
+			return parts, false
+		}
+		parts[i] = n
+	}
+	return parts, true
+}
+
+// IsRelease reports whether v is a release version that can be compared.
+func IsRelease(v string) bool {
+	_, ok := parseVersion(v)
+	return ok
+}
+
+// IsNewer reports whether latest is a higher version than current. It
+// returns false if either version can't be parsed.
+func IsNewer(current, latest string) bool {
+	c, ok := parseVersion(current)
+	if !ok {
+		return false
+	}
+	l, ok := parseVersion(latest)
+	if !ok {
+		return false
+	}
+	for i := range c {
+		if l[i] != c[i] {
+			return l[i] > c[i]
+		}
+	}
+	return false
+}
+
+// AssetName returns the release archive name for a version and platform,
+// matching the goreleaser name_template.
+func AssetName(version, goos, goarch string) string {
+	return fmt.Sprintf("cbox_%s_%s_%s.tar.gz", strings.TrimPrefix(version, "v"), goos, goarch)
+}
+
+func (r *Release) asset(name string) (Asset, bool) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return Asset{}, false
+}
+
+// Apply downloads the release archive for the current platform, verifies it
+// against the release checksums, and atomically replaces the executable at
+// exePath. The new binary is written to a temp file in the same directory
+// and renamed over the old one, so a failed update leaves the original in
+// place and the running process is unaffected.
+func Apply(rel *Release, exePath string) error {
+	return ApplyWithOptions(rel, exePath, ApplyOptions{})
+}
+
+// ApplyOptions configures optional behavior for Apply.
+type ApplyOptions struct {
+	// Status, if set, is called as the update moves through its phases.
+	Status func(format string, args ...any)
+}
+
+// ApplyWithOptions is Apply with progress reporting.
+func ApplyWithOptions(rel *Release, exePath string, opts ApplyOptions) error {
+	status := opts.Status
+	if status == nil {
+		status = func(string, ...any) {}
+	}
+
+	name := AssetName(rel.TagName, runtime.GOOS, runtime.GOARCH)
+	archive, ok := rel.asset(name)
+	if !ok {
+		return fmt.Errorf("release %s has no build for %s/%s", rel.TagName, runtime.GOOS, runtime.GOARCH)
+	}
+	sums, ok := rel.asset("checksums.txt")
+	if !ok {
+		return fmt.Errorf("release %s has no checksums.txt", rel.TagName)
+	}
+
+	exePath, err := filepath.EvalSymlinks(exePath)
+	if err != nil {
+		return fmt.Errorf("resolving executable: %w", err)
+	}
+	info, err := os.Stat(exePath)
+	if err != nil {
+		return fmt.Errorf("reading executable: %w", err)
+	}
+
+	status("fetching checksums")
+	wantSum, err := fetchChecksum(sums.URL, name)
+	if err != nil {
+		return err
+	}
+	status("downloading %s", name)
+	data, err := download(archive.URL)
+	if err != nil {
+		return err
+	}
+	status("verifying checksum")
+	gotSum := sha256.Sum256(data)
+	if hex.EncodeToString(gotSum[:]) != wantSum {
+		return fmt.Errorf("checksum mismatch for %s", name)
+	}
+
+	status("replacing binary")
+	binary, err := extractBinary(data, "cbox")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(exePath), ".cbox-update-*")
+	if err != nil {
+		if os.IsPermission(err) {
+			return fmt.Errorf("no permission to write to %s — re-run with sufficient privileges or reinstall with go install", filepath.Dir(exePath))
+		}
+		return fmt.Errorf("creating temp file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := tmp.Write(binary); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing update: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing update: %w", err)
+	}
+	if err := os.Chmod(tmpPath, info.Mode().Perm()|0111); err != nil {
+		return fmt.Errorf("setting permissions: %w", err)
+	}
+	if err := os.Rename(tmpPath, exePath); err != nil {
+		return fmt.Errorf("replacing %s: %w", exePath, err)
+	}
+	return nil
+}
+
+func download(url string) ([]byte, error) {
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("downloading %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("downloading %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// fetchChecksum returns the sha256 for name from a goreleaser checksums file.
+func fetchChecksum(url, name string) (string, error) {
+	data, err := download(url)
+	if err != nil {
+		return "", err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[1] == name {
+			return fields[0], nil
+		}
+	}
+	return "", fmt.Errorf("no checksum for %s", name)
+}
+
+// extractBinary returns the contents of the file called name from a tar.gz archive.
+func extractBinary(archive []byte, name string) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, fmt.Errorf("opening archive: %w", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading archive: %w", err)
+		}
+		if hdr.Typeflag == tar.TypeReg && filepath.Base(hdr.Name) == name {
+			return io.ReadAll(tr)
+		}
+	}
+	return nil, fmt.Errorf("archive does not contain %s", name)
+}
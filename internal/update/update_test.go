@@ -0,0 +1,160 @@
+package update
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestIsNewer(t *testing.T) {
+	tests := []struct {
+		current, latest string
+		want            bool
+	}{
+		{"v1.2.3", "v1.2.4", true},
+		{"v1.2.3", "v1.10.0", true},
+		{"1.2.3", "v2.0.0", true},
+		{"v1.2.3", "v1.2.3", false},
+		{"v1.3.0", "v1.2.9", false},
+		{"v1.2.3-rc1", "v1.2.3", false},
+		{"dev", "v1.0.0", false},
+		{"abc1234", "v1.0.0", false},
+	}
+	for _, tt := range tests {
+		if got := IsNewer(tt.current, tt.latest); got != tt.want {
+			t.Errorf("IsNewer(%q, %q) = %v, want %v", tt.current, tt.latest, got, tt.want)
+		}
+	}
+}
+
+func TestAssetName(t *testing.T) {
+	if got := AssetName("v1.4.0", "linux", "arm64"); got != "cbox_1.4.0_linux_arm64.tar.gz" {
+		t.Errorf("AssetName = %q", got)
+	}
+}
+
+func tarGz(t *testing.T, name string, content []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0755, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+// releaseServer serves a fake latest release whose archive contains binary.
+// If corrupt is true the published checksum doesn't match the archive.
+func releaseServer(t *testing.T, binary []byte, corrupt bool) *httptest.Server {
+	t.Helper()
+	archiveName := AssetName("v9.9.9", runtime.GOOS, runtime.GOARCH)
+	archive := tarGz(t, "cbox", binary)
+	sum := sha256.Sum256(archive)
+	checksum := hex.EncodeToString(sum[:])
+	if corrupt {
+		checksum = "0000"
+	}
+
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	mux.HandleFunc("/repos/"+Repo+"/releases/latest", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(Release{
+			TagName: "v9.9.9",
+			Assets: []Asset{
+				{Name: archiveName, URL: srv.URL + "/dl/archive"},
+				{Name: "checksums.txt", URL: srv.URL + "/dl/checksums"},
+			},
+		})
+	})
+	mux.HandleFunc("/dl/archive", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(archive)
+	})
+	mux.HandleFunc("/dl/checksums", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s  %s\n", checksum, archiveName)
+	})
+
+	old := apiBase
+	apiBase = srv.URL
+	t.Cleanup(func() {
+		apiBase = old
+		srv.Close()
+	})
+	return srv
+}
+
+func TestLatestAndApply(t *testing.T) {
+	releaseServer(t, []byte("new binary"), false)
+
+	rel, err := Latest()
+	if err != nil {
+		t.Fatalf("Latest: %v", err)
+	}
+	if rel.TagName != "v9.9.9" {
+		t.Fatalf("TagName = %q, want v9.9.9", rel.TagName)
+	}
+
+	exe := filepath.Join(t.TempDir(), "cbox")
+	if err := os.WriteFile(exe, []byte("old binary"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := Apply(rel, exe); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+
+	got, err := os.ReadFile(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "new binary" {
+		t.Errorf("executable = %q, want %q", got, "new binary")
+	}
+	info, err := os.Stat(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm()&0111 == 0 {
+		t.Errorf("mode = %v, want executable", info.Mode())
+	}
+}
+
+func TestApply_ChecksumMismatchKeepsOriginal(t *testing.T) {
+	releaseServer(t, []byte("new binary"), true)
+
+	rel, err := Latest()
+	if err != nil {
+		t.Fatalf("Latest: %v", err)
+	}
+
+	exe := filepath.Join(t.TempDir(), "cbox")
+	if err := os.WriteFile(exe, []byte("old binary"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := Apply(rel, exe); err == nil {
+		t.Fatal("expected checksum error")
+	}
+
+	got, _ := os.ReadFile(exe)
+	if string(got) != "old binary" {
+		t.Errorf("executable = %q, original should be untouched", got)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(exe))
+	if len(entries) != 1 {
+		t.Errorf("expected no leftover temp files, found %d entries", len(entries))
+	}
+}
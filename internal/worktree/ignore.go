@@ -0,0 +1,133 @@
+package worktree
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Ignore matches project-relative paths against gitignore-style patterns.
+// It supports the common subset of the gitignore syntax:
+//
+//   - blank lines and lines starting with "#" are skipped
+//   - a leading "!" re-includes paths excluded by an earlier pattern
+//   - a trailing "/" matches directories only
+//   - a pattern without a slash matches a name at any depth; one with a
+//     leading or inner slash is anchored to the project root
+//   - "*", "?" and "[...]" match within a path segment and "**" matches any
+//     number of segments
+//
+// As in git, once a directory is excluded nothing below it can be
+// re-included. A nil *Ignore matches nothing.
+type Ignore struct {
+	rules []ignoreRule
+}
+
+type ignoreRule struct {
+	segments []string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// NewIgnore compiles patterns into an Ignore. It returns nil when there are
+// no usable patterns.
+func NewIgnore(patterns []string) *Ignore {
+	var rules []ignoreRule
+	for _, p := range patterns {
+		p = strings.TrimSpace(p)
+		if p == "" || strings.HasPrefix(p, "#") {
+			continue
+		}
+		var r ignoreRule
+		if rest, ok := strings.CutPrefix(p, "!"); ok {
+			r.negate = true
+			p = rest
+		}
+		if rest, ok := strings.CutSuffix(p, "/"); ok {
+			r.dirOnly = true
+			p = rest
+		}
+		if rest, ok := strings.CutPrefix(p, "/"); ok {
+			r.anchored = true
+			p = rest
+		} else if strings.Contains(p, "/") {
+			r.anchored = true
+		}
+		if p == "" {
+			continue
+		}
+		r.segments = strings.Split(p, "/")
+		rules = append(rules, r)
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+	return &Ignore{rules: rules}
+}
+
+// Match reports whether rel (relative to the project root, using either
+// separator) is excluded. isDir tells whether rel itself is a directory.
+func (ig *Ignore) Match(rel string, isDir bool) bool {
+	if ig == nil {
+		return false
+	}
+	rel = filepath.ToSlash(filepath.Clean(rel))
+	if rel == "." || rel == "" {
+		return false
+	}
+	segments := strings.Split(rel, "/")
+	for i := 1; i < len(segments); i++ {
+		if ig.matchSegments(segments[:i], true) {
+			return true
+		}
+	}
+	return ig.matchSegments(segments, isDir)
+}
+
+// matchSegments applies every rule to one path; the last matching rule
+// decides.
+func (ig *Ignore) matchSegments(segments []string, isDir bool) bool {
+	excluded := false
+	for _, r := range ig.rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		if r.matches(segments) {
+			excluded = !r.negate
+		}
+	}
+	return excluded
+}
+
+func (r ignoreRule) matches(segments []string) bool {
+	if !r.anchored {
+		return len(r.segments) == 1 && matchSegment(r.segments[0], segments[len(segments)-1])
+	}
+	return matchGlob(r.segments, segments)
+}
+
+// matchGlob matches pattern segments against path segments, letting "**"
+// stand for zero or more whole segments.
+func matchGlob(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(segments); i++ {
+				if matchGlob(pattern[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segments) == 0 || !matchSegment(pattern[0], segments[0]) {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+	return len(segments) == 0
+}
+
+func matchSegment(pattern, name string) bool {
+	ok, err := path.Match(pattern, name)
+	return err == nil && ok
+}
@@ -0,0 +1,50 @@
+package worktree
+
+import "testing"
+
+func TestIgnore_Match(t *testing.T) {
+	ig := NewIgnore([]string{
+		"# build output",
+		"node_modules/",
+		"*.log",
+		"/dist",
+		"docs/**/*.pdf",
+		"!keep.log",
+	})
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"node_modules", true, true},
+		{"packages/web/node_modules", true, true},
+		{"packages/web/node_modules/react/index.js", false, true},
+		{"node_modules", false, false}, // dir-only pattern
+		{"app.log", false, true},
+		{"logs/app.log", false, true},
+		{"keep.log", false, false},
+		{"dist", true, true},
+		{"dist/bundle.js", false, true},
+		{"packages/dist", true, false}, // anchored to the root
+		{"docs/guide.pdf", false, true},
+		{"docs/a/b/guide.pdf", false, true},
+		{"docs/guide.md", false, false},
+		{"src/main.go", false, false},
+	}
+	for _, tt := range tests {
+		if got := ig.Match(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("Match(%q, %v) = %v, want %v", tt.path, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func TestIgnore_NilMatchesNothing(t *testing.T) {
+	var ig *Ignore
+	if ig.Match("node_modules", true) {
+		t.Error("nil Ignore should match nothing")
+	}
+	if NewIgnore([]string{"", "# comment"}) != nil {
+		t.Error("NewIgnore with no patterns should return nil")
+	}
+}
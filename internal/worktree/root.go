@@ -0,0 +1,67 @@
+package worktree
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ProjectRoot returns the project directory that dir belongs to, so cbox
+// can be run from anywhere inside a project or one of its worktrees.
+//
+// Inside a git repository the search starts from the main worktree: a path
+// inside a linked worktree (myproject--feat-x/src) maps to the same path in
+// the main one (myproject/src). From there it walks up to the nearest
+// directory containing one of configFiles, stopping at the repository root,
+// which is the answer if none is found. Outside git it walks up from dir to
+// the nearest directory with a config file. If there is none, dir itself is
+// returned.
+func ProjectRoot(dir string, configFiles ...string) string {
+	start, stop := dir, ""
+	if main, prefix, ok := mainWorktree(dir); ok {
+		start, stop = filepath.Join(main, filepath.FromSlash(prefix)), main
+	}
+
+	for d := start; ; d = filepath.Dir(d) {
+		for _, name := range configFiles {
+			if _, err := os.Stat(filepath.Join(d, name)); err == nil {
+				return d
+			}
+		}
+		if d == stop || d == filepath.Dir(d) {
+			break
+		}
+	}
+	if stop != "" {
+		return stop
+	}
+	return dir
+}
+
+// mainWorktree returns the top level of the main worktree of the repository
+// containing dir, and dir's path relative to its own worktree's top level.
+func mainWorktree(dir string) (main, prefix string, ok bool) {
+	cmd := exec.Command("git", "rev-parse", "--path-format=absolute", "--git-common-dir", "--show-toplevel", "--show-prefix")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", "", false
+	}
+	lines := strings.Split(strings.TrimRight(string(out), "\n"), "\n")
+	if len(lines) < 2 {
+		return "", "", false
+	}
+	commonDir, top := lines[0], lines[1]
+	if len(lines) > 2 {
+		prefix = lines[2]
+	}
+
+	// A linked worktree shares the main repository's .git directory. Any
+	// other layout (submodules, separate git dirs) is taken as it is.
+	main = top
+	if filepath.Base(commonDir) == ".git" {
+		main = filepath.Dir(commonDir)
+	}
+	return main, strings.TrimSuffix(prefix, "/"), true
+}
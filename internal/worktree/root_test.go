@@ -0,0 +1,116 @@
+package worktree
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// gitRepo creates a repository with one commit and returns its path with
+// symlinks resolved, matching what git reports.
+func gitRepo(t *testing.T) string {
+	t.Helper()
+	repo, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "--allow-empty", "-m", "init"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Skipf("git %v: %v: %s", args, err, out)
+		}
+	}
+	return repo
+}
+
+func mkdirs(t *testing.T, dir string) string {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func touch(t *testing.T, path string) {
+	t.Helper()
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProjectRoot_NestedDir(t *testing.T) {
+	repo := gitRepo(t)
+	touch(t, filepath.Join(repo, "cbox.toml"))
+	nested := mkdirs(t, filepath.Join(repo, "src", "pkg"))
+
+	if got := ProjectRoot(nested, "cbox.toml"); got != repo {
+		t.Errorf("ProjectRoot(%s) = %s, want %s", nested, got, repo)
+	}
+}
+
+func TestProjectRoot_NoConfigUsesRepoRoot(t *testing.T) {
+	repo := gitRepo(t)
+	nested := mkdirs(t, filepath.Join(repo, "src"))
+
+	if got := ProjectRoot(nested, "cbox.toml"); got != repo {
+		t.Errorf("ProjectRoot(%s) = %s, want %s", nested, got, repo)
+	}
+}
+
+func TestProjectRoot_SubprojectConfig(t *testing.T) {
+	repo := gitRepo(t)
+	touch(t, filepath.Join(repo, "cbox.toml"))
+	app := mkdirs(t, filepath.Join(repo, "apps", "web"))
+	touch(t, filepath.Join(app, "cbox.toml"))
+	nested := mkdirs(t, filepath.Join(app, "src"))
+
+	if got := ProjectRoot(nested, "cbox.toml"); got != app {
+		t.Errorf("ProjectRoot(%s) = %s, want %s", nested, got, app)
+	}
+}
+
+func TestProjectRoot_WorktreeResolvesToMainRepo(t *testing.T) {
+	repo := gitRepo(t)
+	touch(t, filepath.Join(repo, "cbox.toml"))
+	wt := repo + "--feat"
+	cmd := exec.Command("git", "worktree", "add", "-q", "-b", "feat", wt)
+	cmd.Dir = repo
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git worktree add: %v: %s", err, out)
+	}
+	t.Cleanup(func() { os.RemoveAll(wt) })
+	// The worktree has its own copy of the config, as a checked-in
+	// cbox.toml would.
+	touch(t, filepath.Join(wt, "cbox.toml"))
+	nested := mkdirs(t, filepath.Join(wt, "src"))
+
+	for _, dir := range []string{wt, nested} {
+		if got := ProjectRoot(dir, "cbox.toml"); got != repo {
+			t.Errorf("ProjectRoot(%s) = %s, want %s", dir, got, repo)
+		}
+	}
+}
+
+func TestProjectRoot_OutsideGit(t *testing.T) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GIT_CEILING_DIRECTORIES", filepath.Dir(root))
+	touch(t, filepath.Join(root, ".cbox.toml"))
+	nested := mkdirs(t, filepath.Join(root, "a", "b"))
+
+	if got := ProjectRoot(nested, "cbox.toml", ".cbox.toml"); got != root {
+		t.Errorf("ProjectRoot(%s) = %s, want %s", nested, got, root)
+	}
+
+	plain := t.TempDir()
+	if got := ProjectRoot(plain, "cbox.toml"); got != plain {
+		t.Errorf("ProjectRoot(%s) = %s, want the directory itself", plain, got)
+	}
+}
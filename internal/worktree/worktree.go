@@ -8,6 +8,9 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"syscall"
+
+	"github.com/richvanbergen/cbox/internal/naming"
 )
 
 // WorktreePath returns the path for a worktree based on the project dir and branch name.
@@ -15,7 +18,7 @@ import (
 func WorktreePath(projectDir, branch string) string {
 	base := filepath.Base(projectDir)
 	parent := filepath.Dir(projectDir)
-	safeBranch := strings.ReplaceAll(branch, "/", "-")
+	safeBranch := naming.SafeBranch(branch)
 	return filepath.Join(parent, base+"--"+safeBranch)
 }
 
@@ -109,6 +112,45 @@ func DeleteBranch(projectDir, branch string) error {
 	return nil
 }
 
+// BranchExists reports whether a local branch exists.
+func BranchExists(projectDir, branch string) bool {
+	cmd := exec.Command("git", "rev-parse", "--verify", "--quiet", "refs/heads/"+branch)
+	cmd.Dir = projectDir
+	return cmd.Run() == nil
+}
+
+// CheckBranchName returns an error if name is not a valid branch name,
+// e.g. "a..b" or "feature/".
+func CheckBranchName(name string) error {
+	if err := exec.Command("git", "check-ref-format", "--branch", name).Run(); err != nil {
+		return fmt.Errorf("%q is not a valid branch name", name)
+	}
+	return nil
+}
+
+// RenameBranch renames a local branch. Worktrees that have it checked out
+// follow the new name.
+func RenameBranch(projectDir, oldBranch, newBranch string) error {
+	cmd := exec.Command("git", "branch", "-m", oldBranch, newBranch)
+	cmd.Dir = projectDir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git branch -m: %s: %w", strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
+
+// Move moves a git worktree to a new path, keeping its uncommitted changes.
+func Move(projectDir, wtPath, newPath string) error {
+	cmd := exec.Command("git", "worktree", "move", wtPath, newPath)
+	cmd.Dir = projectDir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git worktree move: %s: %w", strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
+
 // HasUnpushedCommits returns true if the branch has commits that have not been
 // pushed to any remote. Uses --max-count=1 so it stops at the first hit.
 func HasUnpushedCommits(projectDir, branch string) (bool, error) {
@@ -121,28 +163,125 @@ func HasUnpushedCommits(projectDir, branch string) (bool, error) {
 	return strings.TrimSpace(string(out)) != "", nil
 }
 
+// Divergence describes how a branch differs from its upstream.
+type Divergence struct {
+	Upstream string // e.g. "origin/feat-x"
+	Ahead    int    // commits on the branch that the upstream lacks
+	Behind   int    // commits on the upstream that the branch lacks
+}
+
+func (d Divergence) String() string {
+	switch {
+	case d.Ahead == 0 && d.Behind == 0:
+		return "up to date"
+	case d.Behind == 0:
+		return fmt.Sprintf("ahead %d", d.Ahead)
+	case d.Ahead == 0:
+		return fmt.Sprintf("behind %d", d.Behind)
+	}
+	return fmt.Sprintf("ahead %d, behind %d", d.Ahead, d.Behind)
+}
+
+// UpstreamDivergence compares the branch checked out in dir with its
+// upstream, as of the last fetch. It returns nil when the branch has no
+// upstream.
+func UpstreamDivergence(dir string) (*Divergence, error) {
+	cmd := exec.Command("git", "rev-parse", "--abbrev-ref", "--symbolic-full-name", "@{upstream}")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, nil
+	}
+	d := &Divergence{Upstream: strings.TrimSpace(string(out))}
+
+	cmd = exec.Command("git", "rev-list", "--left-right", "--count", "@{upstream}...HEAD")
+	cmd.Dir = dir
+	out, err = cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("git rev-list: %s: %w", strings.TrimSpace(string(out)), err)
+	}
+	if _, err := fmt.Sscan(string(out), &d.Behind, &d.Ahead); err != nil {
+		return nil, fmt.Errorf("parsing git rev-list output %q: %w", strings.TrimSpace(string(out)), err)
+	}
+	return d, nil
+}
+
 // CopyFiles copies a list of files or directories from projectDir to wtPath.
-// Each pattern is relative to projectDir. Missing source files are silently
-// skipped so that optional entries like ".env" don't cause errors.
+// Each pattern is relative to projectDir and may contain glob wildcards
+// (e.g. "config/*.local.json"), in which case every match is copied to the
+// same relative path. Missing source files and patterns with no matches are
+// silently skipped so that optional entries like ".env" don't cause errors.
 func CopyFiles(projectDir, wtPath string, patterns []string) error {
+	return CopyFilesWithOptions(projectDir, wtPath, patterns, CopyOptions{})
+}
+
+// CopyOptions configures optional behavior for CopyFilesWithOptions.
+type CopyOptions struct {
+	Ignore *Ignore // Paths (relative to projectDir) to leave out of the copy
+}
+
+// CopyFilesWithOptions is CopyFiles with additional options.
+func CopyFilesWithOptions(projectDir, wtPath string, patterns []string, opts CopyOptions) error {
 	for _, pattern := range patterns {
-		src := filepath.Join(projectDir, pattern)
-		dst := filepath.Join(wtPath, pattern)
+		paths, err := expandCopyPattern(projectDir, pattern)
+		if err != nil {
+			return err
+		}
+		for _, rel := range paths {
+			if err := copyPath(projectDir, wtPath, rel, opts.Ignore); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
 
-		info, err := os.Stat(src)
+// expandCopyPattern resolves a copy_files pattern to the relative paths it
+// matches. Patterns without glob metacharacters are returned unchanged.
+func expandCopyPattern(projectDir, pattern string) ([]string, error) {
+	if !strings.ContainsAny(pattern, "*?[") {
+		return []string{pattern}, nil
+	}
+	matches, err := filepath.Glob(filepath.Join(projectDir, pattern))
+	if err != nil {
+		return nil, fmt.Errorf("invalid copy_files pattern %q: %w", pattern, err)
+	}
+	paths := make([]string, 0, len(matches))
+	for _, m := range matches {
+		rel, err := filepath.Rel(projectDir, m)
 		if err != nil {
-			// Source doesn't exist — skip silently.
-			continue
+			return nil, fmt.Errorf("resolving %s: %w", m, err)
 		}
+		paths = append(paths, rel)
+	}
+	return paths, nil
+}
 
-		if info.IsDir() {
-			if err := copyDir(src, dst); err != nil {
-				return fmt.Errorf("copying directory %s: %w", pattern, err)
-			}
-		} else {
-			if err := copyFile(src, dst); err != nil {
-				return fmt.Errorf("copying file %s: %w", pattern, err)
-			}
+// copyPath copies a single relative file or directory from projectDir to
+// wtPath, leaving out anything ig matches.
+func copyPath(projectDir, wtPath, rel string, ig *Ignore) error {
+	src := filepath.Join(projectDir, rel)
+	dst := filepath.Join(wtPath, rel)
+
+	info, err := os.Stat(src)
+	if err != nil {
+		// Source doesn't exist — skip silently.
+		return nil
+	}
+	if ig.Match(rel, info.IsDir()) {
+		return nil
+	}
+
+	if info.IsDir() {
+		skip := func(sub string, isDir bool) bool {
+			return ig.Match(filepath.Join(rel, sub), isDir)
+		}
+		if err := copyDir(src, dst, skip); err != nil {
+			return fmt.Errorf("copying directory %s: %w", rel, err)
+		}
+	} else {
+		if err := copyFile(src, dst); err != nil {
+			return fmt.Errorf("copying file %s: %w", rel, err)
 		}
 	}
 	return nil
@@ -164,6 +303,80 @@ func GitWorktreeName(wtPath string) (string, error) {
 	return filepath.Base(gitdir), nil
 }
 
+// GitDirs returns the absolute paths of a worktree's shared git directory
+// (the main repository's .git) and its own admin directory under
+// .git/worktrees/.
+func GitDirs(wtPath string) (commonDir, adminDir string, err error) {
+	cmd := exec.Command("git", "rev-parse", "--path-format=absolute", "--git-common-dir", "--git-dir")
+	cmd.Dir = wtPath
+	out, err := cmd.Output()
+	if err != nil {
+		return "", "", fmt.Errorf("git rev-parse in %s: %w", wtPath, err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) != 2 {
+		return "", "", fmt.Errorf("unexpected git rev-parse output: %q", out)
+	}
+	return lines[0], lines[1], nil
+}
+
+// FastForward moves branch, checked out in wtPath, to the same branch in
+// the repository at repo if that only adds commits, and reports whether it
+// moved. A branch in repo that is behind is left alone; one that has
+// diverged is an error, so no commits are discarded. The index is reset
+// to the new commit and the working tree is left alone: the caller has
+// already copied the files that go with it.
+func FastForward(wtPath, repo, branch string) (bool, error) {
+	git := func(args ...string) error {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = wtPath
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("git %s: %s: %w", args[0], strings.TrimSpace(string(out)), err)
+		}
+		return nil
+	}
+	if err := git("fetch", "--quiet", repo, "refs/heads/"+branch); err != nil {
+		return false, err
+	}
+	if git("merge-base", "--is-ancestor", "FETCH_HEAD", "HEAD") == nil {
+		return false, nil
+	}
+	if git("merge-base", "--is-ancestor", "HEAD", "FETCH_HEAD") != nil {
+		return false, fmt.Errorf("branch %s has diverged from the copy in %s", branch, repo)
+	}
+	if err := git("update-ref", "refs/heads/"+branch, "FETCH_HEAD"); err != nil {
+		return false, err
+	}
+	return true, git("reset", "--quiet")
+}
+
+// Verify checks that wtPath exists and is the top level of a git work tree.
+// It catches worktrees deleted or emptied outside of cbox, which docker
+// would otherwise mount as an empty directory.
+func Verify(wtPath string) error {
+	info, err := os.Stat(wtPath)
+	if err != nil {
+		return fmt.Errorf("worktree %s does not exist", wtPath)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("worktree %s is not a directory", wtPath)
+	}
+
+	cmd := exec.Command("git", "rev-parse", "--show-toplevel")
+	cmd.Dir = wtPath
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("%s is not a git worktree", wtPath)
+	}
+	top, _ := filepath.EvalSymlinks(strings.TrimSpace(string(out)))
+	want, _ := filepath.EvalSymlinks(wtPath)
+	if top != want {
+		return fmt.Errorf("%s is not a git worktree (inside %s)", wtPath, top)
+	}
+	return nil
+}
+
 // copyFile copies a single file from src to dst, preserving permissions.
 func copyFile(src, dst string) error {
 	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
@@ -187,12 +400,42 @@ func copyFile(src, dst string) error {
 	}
 	defer out.Close()
 
-	_, err = io.Copy(out, in)
-	return err
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+	preserveOwner(dst, srcInfo)
+	return nil
 }
 
-// copyDir recursively copies a directory tree from src to dst.
-func copyDir(src, dst string) error {
+// copySymlink recreates the symlink at src as dst, pointing at the same
+// target, rather than copying whatever the link resolves to.
+func copySymlink(src, dst string) error {
+	link, err := os.Readlink(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Symlink(link, dst)
+}
+
+// preserveOwner makes a best-effort attempt to give dst the same owner and
+// group as the source. Failures are ignored since unprivileged users can
+// only chown to themselves.
+func preserveOwner(dst string, srcInfo os.FileInfo) {
+	if st, ok := srcInfo.Sys().(*syscall.Stat_t); ok {
+		os.Lchown(dst, int(st.Uid), int(st.Gid))
+	}
+}
+
+// copyDir recursively copies a directory tree from src to dst. Symlinks are
+// recreated as links and special files (sockets, pipes, devices) are skipped,
+// as is anything skip reports for its path relative to src.
+func copyDir(src, dst string, skip func(rel string, isDir bool) bool) error {
 	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
@@ -202,10 +445,21 @@ func copyDir(src, dst string) error {
 		if err != nil {
 			return err
 		}
+		if rel != "." && skip(rel, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
 		target := filepath.Join(dst, rel)
 
-		if d.IsDir() {
+		switch {
+		case d.IsDir():
 			return os.MkdirAll(target, 0755)
+		case d.Type()&fs.ModeSymlink != 0:
+			return copySymlink(path, target)
+		case !d.Type().IsRegular():
+			return nil
 		}
 		return copyFile(path, target)
 	})
@@ -2,7 +2,9 @@ package worktree
 
 import (
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -213,3 +215,311 @@ func TestCopyFiles_EmptyList(t *testing.T) {
 		t.Fatalf("CopyFiles with empty: %v", err)
 	}
 }
+
+func TestCopyFiles_GlobStar(t *testing.T) {
+	src := t.TempDir()
+	dst := t.TempDir()
+
+	if err := os.MkdirAll(filepath.Join(src, "config"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"app.local.json", "db.local.json", "app.json"} {
+		if err := os.WriteFile(filepath.Join(src, "config", name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := CopyFiles(src, dst, []string{"config/*.local.json"}); err != nil {
+		t.Fatalf("CopyFiles: %v", err)
+	}
+
+	for _, name := range []string{"app.local.json", "db.local.json"} {
+		got, err := os.ReadFile(filepath.Join(dst, "config", name))
+		if err != nil {
+			t.Fatalf("reading %s: %v", name, err)
+		}
+		if string(got) != name {
+			t.Errorf("%s: got %q, want %q", name, string(got), name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dst, "config", "app.json")); !os.IsNotExist(err) {
+		t.Error("app.json should not have been copied")
+	}
+}
+
+func TestCopyFiles_GlobQuestionMark(t *testing.T) {
+	src := t.TempDir()
+	dst := t.TempDir()
+
+	for _, name := range []string{".env1", ".env2", ".env10"} {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := CopyFiles(src, dst, []string{".env?"}); err != nil {
+		t.Fatalf("CopyFiles: %v", err)
+	}
+
+	for _, name := range []string{".env1", ".env2"} {
+		if _, err := os.Stat(filepath.Join(dst, name)); err != nil {
+			t.Errorf("%s should have been copied: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dst, ".env10")); !os.IsNotExist(err) {
+		t.Error(".env10 should not have been copied")
+	}
+}
+
+func TestCopyFiles_GlobDirectories(t *testing.T) {
+	src := t.TempDir()
+	dst := t.TempDir()
+
+	for _, dir := range []string{"fixtures-a", "fixtures-b"} {
+		if err := os.MkdirAll(filepath.Join(src, "data", dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(src, "data", dir, "seed.sql"), []byte(dir), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := CopyFiles(src, dst, []string{"data/fixtures-*"}); err != nil {
+		t.Fatalf("CopyFiles: %v", err)
+	}
+
+	for _, dir := range []string{"fixtures-a", "fixtures-b"} {
+		got, err := os.ReadFile(filepath.Join(dst, "data", dir, "seed.sql"))
+		if err != nil {
+			t.Fatalf("reading %s/seed.sql: %v", dir, err)
+		}
+		if string(got) != dir {
+			t.Errorf("%s/seed.sql: got %q, want %q", dir, string(got), dir)
+		}
+	}
+}
+
+func TestCopyFiles_GlobNoMatchesSkipped(t *testing.T) {
+	src := t.TempDir()
+	dst := t.TempDir()
+
+	if err := CopyFiles(src, dst, []string{"*.missing"}); err != nil {
+		t.Fatalf("CopyFiles should skip globs with no matches: %v", err)
+	}
+}
+
+func TestCopyFiles_DirectoryWithSymlinkedFile(t *testing.T) {
+	src := t.TempDir()
+	dst := t.TempDir()
+
+	if err := os.MkdirAll(filepath.Join(src, "config"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "config", "base.json"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("base.json", filepath.Join(src, "config", "current.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := CopyFiles(src, dst, []string{"config"}); err != nil {
+		t.Fatalf("CopyFiles: %v", err)
+	}
+
+	link := filepath.Join(dst, "config", "current.json")
+	info, err := os.Lstat(link)
+	if err != nil {
+		t.Fatalf("lstat copied link: %v", err)
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		t.Fatal("expected current.json to be copied as a symlink")
+	}
+	target, err := os.Readlink(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != "base.json" {
+		t.Errorf("link target = %q, want %q", target, "base.json")
+	}
+}
+
+func TestCopyFiles_DirectoryWithSymlinkedDir(t *testing.T) {
+	src := t.TempDir()
+	dst := t.TempDir()
+
+	if err := os.MkdirAll(filepath.Join(src, "deps", "real"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "deps", "real", "index.js"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("real", filepath.Join(src, "deps", "linked")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := CopyFiles(src, dst, []string{"deps"}); err != nil {
+		t.Fatalf("CopyFiles: %v", err)
+	}
+
+	info, err := os.Lstat(filepath.Join(dst, "deps", "linked"))
+	if err != nil {
+		t.Fatalf("lstat copied link: %v", err)
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		t.Fatal("expected linked to be copied as a symlink, not a directory")
+	}
+	got, err := os.ReadFile(filepath.Join(dst, "deps", "linked", "index.js"))
+	if err != nil {
+		t.Fatalf("reading through copied link: %v", err)
+	}
+	if string(got) != "x" {
+		t.Errorf("got %q, want %q", string(got), "x")
+	}
+}
+
+func TestCopyFilesWithOptions_IgnoresDirectoryAndGlob(t *testing.T) {
+	src := t.TempDir()
+	dst := t.TempDir()
+
+	for _, f := range []string{
+		"app/main.js",
+		"app/debug.log",
+		"app/node_modules/react/index.js",
+	} {
+		path := filepath.Join(src, f)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opts := CopyOptions{Ignore: NewIgnore([]string{"node_modules/", "*.log"})}
+	if err := CopyFilesWithOptions(src, dst, []string{"app"}, opts); err != nil {
+		t.Fatalf("CopyFilesWithOptions: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dst, "app", "main.js")); err != nil {
+		t.Errorf("expected app/main.js to be copied: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "app", "node_modules")); !os.IsNotExist(err) {
+		t.Errorf("expected app/node_modules to be skipped, got err=%v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "app", "debug.log")); !os.IsNotExist(err) {
+		t.Errorf("expected app/debug.log to be skipped, got err=%v", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	repo := t.TempDir()
+	if out, err := exec.Command("git", "init", "-q", repo).CombinedOutput(); err != nil {
+		t.Skipf("git init: %v: %s", err, out)
+	}
+
+	if err := Verify(repo); err != nil {
+		t.Errorf("Verify(repo) = %v, want nil", err)
+	}
+
+	sub := filepath.Join(repo, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(sub); err == nil {
+		t.Error("Verify(subdirectory) = nil, want error")
+	}
+
+	if err := Verify(t.TempDir()); err == nil {
+		t.Error("Verify(plain directory) = nil, want error")
+	}
+
+	if err := Verify(filepath.Join(repo, "missing")); err == nil {
+		t.Error("Verify(missing) = nil, want error")
+	}
+}
+
+func TestUpstreamDivergence(t *testing.T) {
+	origin := gitRepo(t)
+	clone := filepath.Join(t.TempDir(), "clone")
+	git := func(dir string, args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	git(origin, "clone", "-q", origin, clone)
+
+	d, err := UpstreamDivergence(clone)
+	if err != nil || d == nil || d.String() != "up to date" {
+		t.Fatalf("fresh clone: %+v, %v", d, err)
+	}
+
+	git(clone, "commit", "-q", "--allow-empty", "-m", "local")
+	git(origin, "commit", "-q", "--allow-empty", "-m", "remote 1")
+	git(origin, "commit", "-q", "--allow-empty", "-m", "remote 2")
+	git(clone, "fetch", "-q")
+
+	d, err = UpstreamDivergence(clone)
+	if err != nil || d == nil {
+		t.Fatalf("UpstreamDivergence: %+v, %v", d, err)
+	}
+	if d.Ahead != 1 || d.Behind != 2 || d.String() != "ahead 1, behind 2" {
+		t.Errorf("divergence = %+v (%s), want ahead 1, behind 2", d, d)
+	}
+	if !strings.HasPrefix(d.Upstream, "origin/") {
+		t.Errorf("upstream = %q", d.Upstream)
+	}
+}
+
+func TestUpstreamDivergence_NoUpstream(t *testing.T) {
+	d, err := UpstreamDivergence(gitRepo(t))
+	if err != nil || d != nil {
+		t.Errorf("UpstreamDivergence = %+v, %v; want nil, nil", d, err)
+	}
+}
+
+func TestFastForward(t *testing.T) {
+	repo := gitRepo(t)
+	wt, err := Create(repo, "feat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	git := func(dir string, args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+
+	// A standalone copy of the repository, as in a workspace volume.
+	copyDir := filepath.Join(t.TempDir(), "copy")
+	git(repo, "clone", "-q", "--branch", "feat", repo, copyDir)
+	os.WriteFile(filepath.Join(copyDir, "new.txt"), []byte("new"), 0644)
+	git(copyDir, "add", "new.txt")
+	git(copyDir, "commit", "-q", "-m", "in container")
+	os.WriteFile(filepath.Join(wt, "new.txt"), []byte("new"), 0644)
+
+	moved, err := FastForward(wt, filepath.Join(copyDir, ".git"), "feat")
+	if err != nil || !moved {
+		t.Fatalf("FastForward() = %v, %v", moved, err)
+	}
+	cmd := exec.Command("git", "status", "--porcelain")
+	cmd.Dir = wt
+	if out, _ := cmd.Output(); len(out) != 0 {
+		t.Errorf("worktree not clean after fast-forward: %s", out)
+	}
+
+	git(wt, "commit", "-q", "--allow-empty", "-m", "on host")
+	if moved, err := FastForward(wt, filepath.Join(copyDir, ".git"), "feat"); err != nil || moved {
+		t.Errorf("copy behind the host: FastForward() = %v, %v, want no change", moved, err)
+	}
+
+	git(copyDir, "commit", "-q", "--allow-empty", "-m", "diverged")
+	if _, err := FastForward(wt, filepath.Join(copyDir, ".git"), "feat"); err == nil {
+		t.Error("FastForward over a host commit should fail rather than discard it")
+	}
+}